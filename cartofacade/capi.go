@@ -1,3 +1,5 @@
+//go:build !cartosim
+
 // Package cartofacade contains the api to call into CGO
 //
 //nolint:lll
@@ -15,6 +17,7 @@ import "C"
 
 import (
 	"errors"
+	"io"
 	"time"
 	"unsafe"
 )
@@ -24,95 +27,15 @@ type CartoLib struct {
 	value *C.viam_carto_lib
 }
 
-// CartoLibInterface describes the method signatures that CartoLib must implement
-type CartoLibInterface interface {
-	Terminate() error
-}
-
-// SlamMode represents the lidar configuration
-type SlamMode int64
-
-const (
-	// UnknownMode denotes an unknown slam mode
-	UnknownMode SlamMode = iota
-	// MappingMode denotes the slam algo is in mapping mode
-	MappingMode
-	// LocalizingMode denotes the slam algo is in localizing only mode
-	LocalizingMode
-	// UpdatingMode denotes the slam algo is in updating mode
-	UpdatingMode
-)
-
 // Carto holds the c type viam_carto
 type Carto struct {
 	value *C.viam_carto
 	SlamMode
 }
 
-// CartoInterface describes the method signatures that Carto must implement
-type CartoInterface interface {
-	start() error
-	stop() error
-	terminate() error
-	addLidarReading(string, []byte, time.Time) error
-	getPosition() (GetPosition, error)
-	getPointCloudMap() ([]byte, error)
-	getInternalState() ([]byte, error)
-}
-
-// GetPosition holds values returned from c to be processed later
-type GetPosition struct {
-	X float64
-	Y float64
-	Z float64
-
-	Real float64
-	Imag float64
-	Jmag float64
-	Kmag float64
-
-	ComponentReference string
-}
-
-// LidarConfig represents the lidar configuration
-type LidarConfig int64
-
-const (
-	// TwoD LidarConfig denotes a 2d lidar
-	TwoD LidarConfig = iota
-	// ThreeD LidarConfig denotes a 3d lidar
-	ThreeD
-)
-
-// CartoConfig contains config values from app
-type CartoConfig struct {
-	Camera             string
-	MovementSensor     string
-	MapRateSecond      int
-	DataDir            string
-	ComponentReference string
-	LidarConfig        LidarConfig
-
-	CloudStoryEnabled bool
-	EnableMapping     bool
-	ExistingMap       string
-}
-
-// CartoAlgoConfig contains config values from app
-type CartoAlgoConfig struct {
-	OptimizeOnStart      bool
-	OptimizeEveryNNodes  int
-	NumRangeData         int
-	MissingDataRayLength float32
-	MaxRange             float32
-	MinRange             float32
-	MaxSubmapsToKeep     int
-	FreshSubmapsCount    int
-	MinCoveredArea       float64
-	MinAddedSubmapsCount int
-	OccupiedSpaceWeight  float64
-	TranslationWeight    float64
-	RotationWeight       float64
+// CartoStream holds the c type viam_carto_stream
+type CartoStream struct {
+	value *C.viam_carto_stream
 }
 
 // NewLib calls viam_carto_lib_init and returns a pointer to a viam carto lib object.
@@ -285,6 +208,275 @@ func (vc *Carto) getInternalState() ([]byte, error) {
 	return interalState, nil
 }
 
+// GetPointCloudMapStream is a wrapper for viam_carto_get_point_cloud_map_stream
+func (vc *Carto) getPointCloudMapStream(opts MapOptions) (CartoStreamInterface, error) {
+	var pS *C.viam_carto_stream
+
+	vcmo := toMapOptions(opts)
+	status := C.viam_carto_get_point_cloud_map_stream(vc.value, &vcmo, &pS)
+
+	if err := toError(status); err != nil {
+		return nil, err
+	}
+
+	return &CartoStream{value: pS}, nil
+}
+
+// GetInternalStateStream is a wrapper for viam_carto_get_internal_state_stream
+func (vc *Carto) getInternalStateStream() (CartoStreamInterface, error) {
+	var pS *C.viam_carto_stream
+
+	status := C.viam_carto_get_internal_state_stream(vc.value, &pS)
+
+	if err := toError(status); err != nil {
+		return nil, err
+	}
+
+	return &CartoStream{value: pS}, nil
+}
+
+// Next is a wrapper for viam_carto_stream_next, it returns io.EOF once the whole stream has been read.
+func (s *CartoStream) next(maxChunkSizeBytes int) ([]byte, error) {
+	value := C.viam_carto_stream_next_response{}
+
+	status := C.viam_carto_stream_next(s.value, C.int(maxChunkSizeBytes), &value)
+
+	if err := toError(status); err != nil {
+		return nil, err
+	}
+
+	chunk := bstringToByteSlice(value.chunk)
+
+	status = C.viam_carto_stream_next_response_destroy(&value)
+	if err := toError(status); err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
+
+// Close is a wrapper for viam_carto_stream_destroy
+func (s *CartoStream) close() error {
+	status := C.viam_carto_stream_destroy(&s.value)
+	if err := toError(status); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetStats is a wrapper for viam_carto_get_stats
+func (vc *Carto) getStats() (GetStats, error) {
+	value := C.viam_carto_get_stats_response{}
+
+	status := C.viam_carto_get_stats(vc.value, &value)
+
+	if err := toError(status); err != nil {
+		return GetStats{}, err
+	}
+
+	return toGetStatsResponse(value), nil
+}
+
+// SaveMap is a wrapper for viam_carto_save_map
+func (vc *Carto) saveMap(path string, runFinalOptimization bool) (SaveMap, error) {
+	value := C.viam_carto_save_map_response{}
+
+	pathBstring := goStringToBstring(path)
+	defer C.bdestroy(pathBstring)
+
+	status := C.viam_carto_save_map(vc.value, pathBstring, C.bool(runFinalOptimization), &value)
+
+	if err := toError(status); err != nil {
+		return SaveMap{}, err
+	}
+
+	return SaveMap{Nodes: int(value.nodes)}, nil
+}
+
+// SetPose is a wrapper for viam_carto_set_pose
+func (vc *Carto) setPose(pose Pose2D) error {
+	value := toPose(pose)
+
+	status := C.viam_carto_set_pose(vc.value, &value)
+
+	if err := toError(status); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SwitchToLocalization is a wrapper for viam_carto_switch_to_localization, it returns the mode cartographer
+// is in after the call, which is set even if the call failed.
+func (vc *Carto) switchToLocalization() (SlamMode, error) {
+	status := C.viam_carto_switch_to_localization(vc.value)
+	vc.SlamMode = toSlamMode(vc.value.slam_mode)
+
+	if err := toError(status); err != nil {
+		return vc.SlamMode, err
+	}
+
+	return vc.SlamMode, nil
+}
+
+// Optimize is a wrapper for viam_carto_optimize
+func (vc *Carto) optimize() error {
+	status := C.viam_carto_optimize(vc.value)
+
+	if err := toError(status); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetOptimizationStatus is a wrapper for viam_carto_get_optimization_status
+func (vc *Carto) getOptimizationStatus() (OptimizationStatus, error) {
+	value := C.viam_carto_optimization_status_response{}
+
+	status := C.viam_carto_get_optimization_status(vc.value, &value)
+
+	if err := toError(status); err != nil {
+		return OptimizationStatus{}, err
+	}
+
+	return toOptimizationStatusResponse(value), nil
+}
+
+// GetMapRevision is a wrapper for viam_carto_get_map_revision
+func (vc *Carto) getMapRevision() (MapRevision, error) {
+	value := C.viam_carto_map_revision_response{}
+
+	status := C.viam_carto_get_map_revision(vc.value, &value)
+
+	if err := toError(status); err != nil {
+		return MapRevision{}, err
+	}
+
+	return toMapRevisionResponse(value), nil
+}
+
+// GetTrajectory is a wrapper for viam_carto_get_trajectory
+func (vc *Carto) getTrajectory(allTrajectories bool, everyNNodes int) ([]TrajectoryNode, error) {
+	value := C.viam_carto_get_trajectory_response{}
+
+	status := C.viam_carto_get_trajectory(vc.value, C.bool(allTrajectories), C.int(everyNNodes), &value)
+
+	if err := toError(status); err != nil {
+		return nil, err
+	}
+
+	nodes := toGetTrajectoryResponse(value)
+
+	if err := destroyGetTrajectoryResponse(&value); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// GetSubmaps is a wrapper for viam_carto_get_submaps
+func (vc *Carto) getSubmaps() ([]Submap, error) {
+	value := C.viam_carto_get_submaps_response{}
+
+	status := C.viam_carto_get_submaps(vc.value, &value)
+
+	if err := toError(status); err != nil {
+		return nil, err
+	}
+
+	submaps := toGetSubmapsResponse(value)
+
+	if err := destroyGetSubmapsResponse(&value); err != nil {
+		return nil, err
+	}
+
+	return submaps, nil
+}
+
+// GetConfig is a wrapper for viam_carto_get_config
+func (vc *Carto) getConfig() (GetConfig, error) {
+	value := C.viam_carto_get_config_response{}
+
+	status := C.viam_carto_get_config(vc.value, &value)
+
+	if err := toError(status); err != nil {
+		return GetConfig{}, err
+	}
+
+	getConfig := toGetConfigResponse(value)
+
+	if err := destroyGetConfigResponse(&value); err != nil {
+		return GetConfig{}, err
+	}
+
+	return getConfig, nil
+}
+
+// GetOccupancyGrid is a wrapper for viam_carto_get_occupancy_grid
+func (vc *Carto) getOccupancyGrid(opts MapOptions) (OccupancyGrid, error) {
+	value := C.viam_carto_get_occupancy_grid_response{}
+
+	vcmo := toMapOptions(opts)
+	status := C.viam_carto_get_occupancy_grid(vc.value, &vcmo, &value)
+
+	if err := toError(status); err != nil {
+		return OccupancyGrid{}, err
+	}
+
+	grid := toGetOccupancyGridResponse(value)
+
+	if err := destroyGetOccupancyGridResponse(&value); err != nil {
+		return OccupancyGrid{}, err
+	}
+
+	return grid, nil
+}
+
+// GetSubmapGrids is a wrapper for viam_carto_get_submap_grids
+func (vc *Carto) getSubmapGrids(ids []SubmapID) ([]SubmapGrid, error) {
+	value := C.viam_carto_get_submap_grids_response{}
+
+	cIDs := toSubmapIDs(ids)
+	var cIDsPtr *C.viam_carto_submap_id
+	if len(cIDs) > 0 {
+		cIDsPtr = &cIDs[0]
+	}
+	status := C.viam_carto_get_submap_grids(vc.value, cIDsPtr, C.int(len(cIDs)), &value)
+
+	if err := toError(status); err != nil {
+		return nil, err
+	}
+
+	grids := toGetSubmapGridsResponse(value)
+
+	if err := destroyGetSubmapGridsResponse(&value); err != nil {
+		return nil, err
+	}
+
+	return grids, nil
+}
+
+func destroyGetSubmapGridsResponse(value *C.viam_carto_get_submap_grids_response) error {
+	return toError(C.viam_carto_get_submap_grids_response_destroy(value))
+}
+
+func destroyGetOccupancyGridResponse(value *C.viam_carto_get_occupancy_grid_response) error {
+	return toError(C.viam_carto_get_occupancy_grid_response_destroy(value))
+}
+
+func destroyGetConfigResponse(value *C.viam_carto_get_config_response) error {
+	return toError(C.viam_carto_get_config_response_destroy(value))
+}
+
+func destroyGetSubmapsResponse(value *C.viam_carto_get_submaps_response) error {
+	return toError(C.viam_carto_get_submaps_response_destroy(value))
+}
+
+func destroyGetTrajectoryResponse(value *C.viam_carto_get_trajectory_response) error {
+	return toError(C.viam_carto_get_trajectory_response_destroy(value))
+}
+
 // this function is only used for testing purposes, but needs to be in this file as CGo is not supported in go test files
 func getTestGetPositionResponse() C.viam_carto_get_position_response {
 	gpr := C.viam_carto_get_position_response{}
@@ -304,6 +496,156 @@ func getTestGetPositionResponse() C.viam_carto_get_position_response {
 	return gpr
 }
 
+// this function is only used for testing purposes, but needs to be in this file as CGo is not supported in go test files
+func getTestGetStatsResponse(lastOptimizationTimeUnixMilli int64) C.viam_carto_get_stats_response {
+	gsr := C.viam_carto_get_stats_response{}
+
+	gsr.trajectories = C.int(2)
+	gsr.submaps_finished = C.int(10)
+	gsr.submaps_active = C.int(2)
+	gsr.nodes = C.int(300)
+	gsr.constraints = C.int(4000)
+	gsr.grid_bytes = C.int64_t(5000000)
+	gsr.last_optimization_time_unix_milli = C.int64_t(lastOptimizationTimeUnixMilli)
+
+	return gsr
+}
+
+func getTestMapRevisionResponse(changedAt time.Time) C.viam_carto_map_revision_response {
+	mrr := C.viam_carto_map_revision_response{}
+
+	mrr.revision = C.int64_t(7)
+	mrr.changed_unix_milli = C.int64_t(changedAt.UnixMilli())
+
+	return mrr
+}
+
+func getTestOptimizationStatusResponse() C.viam_carto_optimization_status_response {
+	osr := C.viam_carto_optimization_status_response{}
+
+	osr.running = C.bool(false)
+	osr.duration_milli = C.int64_t(1500)
+	osr.residual_before = C.double(12.5)
+	osr.residual_after = C.double(3.25)
+
+	return osr
+}
+
+// this function is only used for testing purposes, but needs to be in this file as CGo is not supported in go test files
+func getTestGetTrajectoryResponse(timestamp time.Time, nodesLen int) C.viam_carto_get_trajectory_response {
+	gtr := C.viam_carto_get_trajectory_response{}
+	if nodesLen == 0 {
+		return gtr
+	}
+
+	gtr.nodes = (*C.viam_carto_trajectory_node)(C.malloc(C.size_t(nodesLen) * C.sizeof_viam_carto_trajectory_node))
+	gtr.nodes_len = C.int(nodesLen)
+	nodes := unsafe.Slice(gtr.nodes, nodesLen)
+	for i := range nodes {
+		nodes[i] = C.viam_carto_trajectory_node{}
+		nodes[i].trajectory_id = C.int(1)
+		nodes[i].node_index = C.int(i * 10)
+		nodes[i].time_unix_milli = C.int64_t(timestamp.Add(time.Duration(i) * time.Second).UnixMilli())
+		nodes[i].x = C.double(100 * (i + 1))
+		nodes[i].y = C.double(200 * (i + 1))
+		nodes[i].z = C.double(0)
+		nodes[i].real = C.double(1)
+	}
+
+	return gtr
+}
+
+// this function is only used for testing purposes, but needs to be in this file as CGo is not supported in go test files
+func getTestGetSubmapsResponse(submapsLen int) C.viam_carto_get_submaps_response {
+	gsr := C.viam_carto_get_submaps_response{}
+	if submapsLen == 0 {
+		return gsr
+	}
+
+	gsr.submaps = (*C.viam_carto_submap)(C.malloc(C.size_t(submapsLen) * C.sizeof_viam_carto_submap))
+	gsr.submaps_len = C.int(submapsLen)
+	submaps := unsafe.Slice(gsr.submaps, submapsLen)
+	for i := range submaps {
+		submaps[i] = C.viam_carto_submap{}
+		submaps[i].trajectory_id = C.int(0)
+		submaps[i].submap_index = C.int(i)
+		submaps[i].version = C.int(90)
+		submaps[i].finished = C.bool(i == 0)
+		submaps[i].resolution_meters = C.double(0.05)
+		submaps[i].x = C.double(1000 * i)
+		submaps[i].y = C.double(500)
+		submaps[i].real = C.double(1)
+		submaps[i].min_x = C.double(1000*i - 2000)
+		submaps[i].min_y = C.double(-1500)
+		submaps[i].max_x = C.double(1000*i + 2000)
+		submaps[i].max_y = C.double(2500)
+	}
+
+	return gsr
+}
+
+// this function is only used for testing purposes, but needs to be in this file as CGo is not supported in go test files
+func getTestGetConfigResponse() C.viam_carto_get_config_response {
+	gcr := C.viam_carto_get_config_response{}
+
+	gcr.configuration_directory = goStringToBstring("/usr/local/share/cartographer/lua_files")
+	gcr.configuration_basename = goStringToBstring("mapping_new_map.lua")
+	gcr.optimize_every_n_nodes = C.int(3)
+	gcr.num_range_data = C.int(30)
+	gcr.missing_data_ray_length = C.float(25)
+	gcr.max_range = C.float(25)
+	gcr.min_range = C.float(0.5)
+	gcr.max_submaps_to_keep = C.int(3)
+	gcr.fresh_submaps_count = C.int(3)
+	gcr.min_covered_area = C.double(1)
+	gcr.min_added_submaps_count = C.int(1)
+	gcr.occupied_space_weight = C.double(20)
+	gcr.translation_weight = C.double(10)
+	gcr.rotation_weight = C.double(1)
+
+	return gcr
+}
+
+// this function is only used for testing purposes, but needs to be in this file as CGo is not supported in go test files
+func getTestGetOccupancyGridResponse() C.viam_carto_get_occupancy_grid_response {
+	ogr := C.viam_carto_get_occupancy_grid_response{}
+
+	ogr.width = C.int(3)
+	ogr.height = C.int(2)
+	ogr.resolution_meters = C.double(0.05)
+	ogr.origin_x = C.double(-0.075)
+	ogr.origin_y = C.double(-0.025)
+	ogr.cells = goStringToBstring(string([]byte{0, 50, 100, OccupancyGridUnknownCell, 0, 7}))
+
+	return ogr
+}
+
+// this function is only used for testing purposes, but needs to be in this file as CGo is not supported in go test files
+func getTestGetSubmapGridsResponse(gridsLen int) C.viam_carto_get_submap_grids_response {
+	sgr := C.viam_carto_get_submap_grids_response{}
+	if gridsLen == 0 {
+		return sgr
+	}
+
+	sgr.grids = (*C.viam_carto_submap_grid)(C.malloc(C.size_t(gridsLen) * C.sizeof_viam_carto_submap_grid))
+	sgr.grids_len = C.int(gridsLen)
+	grids := unsafe.Slice(sgr.grids, gridsLen)
+	for i := range grids {
+		grids[i] = C.viam_carto_submap_grid{}
+		grids[i].trajectory_id = C.int(0)
+		grids[i].submap_index = C.int(i)
+		grids[i].version = C.int(90 + i)
+		grids[i].width = C.int(2)
+		grids[i].height = C.int(1)
+		grids[i].resolution_meters = C.double(0.05)
+		grids[i].origin_x = C.double(-0.05)
+		grids[i].origin_y = C.double(-0.025)
+		grids[i].cells = goStringToBstring(string([]byte{byte(i), OccupancyGridUnknownCell}))
+	}
+
+	return sgr
+}
+
 func bstringToGoString(bstr C.bstring) string {
 	return C.GoStringN(C.bstr2cstr(bstr, 0), bstr.slen)
 }
@@ -343,9 +685,23 @@ func getConfig(cfg CartoConfig) (C.viam_carto_config, error) {
 	vcc.enable_mapping = C.bool(cfg.EnableMapping)
 	vcc.existing_map = goStringToBstring(cfg.ExistingMap)
 
+	if cfg.InitialPose != nil {
+		vcc.has_initial_pose = C.bool(true)
+		vcc.initial_pose = toPose(*cfg.InitialPose)
+	}
+	vcc.initial_internal_state = goStringToBstring(cfg.InitialInternalState)
+
 	return vcc, nil
 }
 
+func toPose(pose Pose2D) C.viam_carto_pose {
+	return C.viam_carto_pose{
+		x:     C.double(pose.X),
+		y:     C.double(pose.Y),
+		theta: C.double(pose.Theta),
+	}
+}
+
 func toAlgoConfig(acfg CartoAlgoConfig) C.viam_carto_algo_config {
 	vcac := C.viam_carto_algo_config{}
 	vcac.optimize_on_start = C.bool(acfg.OptimizeOnStart)
@@ -361,9 +717,48 @@ func toAlgoConfig(acfg CartoAlgoConfig) C.viam_carto_algo_config {
 	vcac.occupied_space_weight = C.double(acfg.OccupiedSpaceWeight)
 	vcac.translation_weight = C.double(acfg.TranslationWeight)
 	vcac.rotation_weight = C.double(acfg.RotationWeight)
+	vcac.map_resolution_meters = C.double(acfg.MapResolutionMeters)
+	vcac.max_map_points = C.int(acfg.MaxMapPoints)
 	return vcac
 }
 
+func toMapOptions(opts MapOptions) C.viam_carto_map_options {
+	vcmo := C.viam_carto_map_options{
+		resolution_meters: C.double(opts.ResolutionMeters),
+		max_points:        C.int(opts.MaxPoints),
+		format:            toPointCloudFormat(opts.Format),
+	}
+	if opts.Region != nil {
+		vcmo.has_region = C.bool(true)
+		vcmo.region = C.viam_carto_map_region{
+			min_x: C.double(opts.Region.MinX),
+			min_y: C.double(opts.Region.MinY),
+			max_x: C.double(opts.Region.MaxX),
+			max_y: C.double(opts.Region.MaxY),
+		}
+	}
+	return vcmo
+}
+
+// toPointCloudFormat returns the VIAM_CARTO_POINT_CLOUD_FORMAT_* of the format, unknown formats are passed as -1
+// so that they are rejected as invalid map options.
+func toPointCloudFormat(format PointCloudFormat) C.int {
+	switch format {
+	case PCDFormat:
+		return C.VIAM_CARTO_POINT_CLOUD_FORMAT_PCD
+	case PCDBinaryFormat:
+		return C.VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_BINARY
+	case PCDBinaryCompressedFormat:
+		return C.VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_BINARY_COMPRESSED
+	case PCDASCIIFormat:
+		return C.VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_ASCII
+	case PLYFormat:
+		return C.VIAM_CARTO_POINT_CLOUD_FORMAT_PLY
+	default:
+		return -1
+	}
+}
+
 func toGetPositionResponse(value C.viam_carto_get_position_response) GetPosition {
 	return GetPosition{
 		X: float64(value.x),
@@ -379,6 +774,160 @@ func toGetPositionResponse(value C.viam_carto_get_position_response) GetPosition
 	}
 }
 
+func toGetStatsResponse(value C.viam_carto_get_stats_response) GetStats {
+	getStats := GetStats{
+		Trajectories:    int(value.trajectories),
+		SubmapsFinished: int(value.submaps_finished),
+		SubmapsActive:   int(value.submaps_active),
+		Nodes:           int(value.nodes),
+		Constraints:     int(value.constraints),
+		GridBytes:       int64(value.grid_bytes),
+	}
+	if value.last_optimization_time_unix_milli != 0 {
+		getStats.LastOptimizationTime = time.UnixMilli(int64(value.last_optimization_time_unix_milli))
+	}
+	return getStats
+}
+
+func toOptimizationStatusResponse(value C.viam_carto_optimization_status_response) OptimizationStatus {
+	return OptimizationStatus{
+		Running:        bool(value.running),
+		Duration:       time.Duration(int64(value.duration_milli)) * time.Millisecond,
+		ResidualBefore: float64(value.residual_before),
+		ResidualAfter:  float64(value.residual_after),
+	}
+}
+
+func toMapRevisionResponse(value C.viam_carto_map_revision_response) MapRevision {
+	return MapRevision{
+		Revision:  int(value.revision),
+		ChangedAt: time.UnixMilli(int64(value.changed_unix_milli)).UTC(),
+	}
+}
+
+func toGetTrajectoryResponse(value C.viam_carto_get_trajectory_response) []TrajectoryNode {
+	if value.nodes == nil || value.nodes_len == 0 {
+		return []TrajectoryNode{}
+	}
+	cNodes := unsafe.Slice(value.nodes, int(value.nodes_len))
+	nodes := make([]TrajectoryNode, 0, len(cNodes))
+	for _, node := range cNodes {
+		nodes = append(nodes, TrajectoryNode{
+			TrajectoryID: int(node.trajectory_id),
+			NodeIndex:    int(node.node_index),
+			Time:         time.UnixMilli(int64(node.time_unix_milli)),
+
+			X: float64(node.x),
+			Y: float64(node.y),
+			Z: float64(node.z),
+
+			Real: float64(node.real),
+			Imag: float64(node.imag),
+			Jmag: float64(node.jmag),
+			Kmag: float64(node.kmag),
+		})
+	}
+	return nodes
+}
+
+func toGetConfigResponse(value C.viam_carto_get_config_response) GetConfig {
+	return GetConfig{
+		ConfigurationDirectory: bstringToGoString(value.configuration_directory),
+		ConfigurationBasename:  bstringToGoString(value.configuration_basename),
+
+		OptimizeEveryNNodes:  int(value.optimize_every_n_nodes),
+		NumRangeData:         int(value.num_range_data),
+		MissingDataRayLength: float32(value.missing_data_ray_length),
+		MaxRange:             float32(value.max_range),
+		MinRange:             float32(value.min_range),
+		MaxSubmapsToKeep:     int(value.max_submaps_to_keep),
+		FreshSubmapsCount:    int(value.fresh_submaps_count),
+		MinCoveredArea:       float64(value.min_covered_area),
+		MinAddedSubmapsCount: int(value.min_added_submaps_count),
+		OccupiedSpaceWeight:  float64(value.occupied_space_weight),
+		TranslationWeight:    float64(value.translation_weight),
+		RotationWeight:       float64(value.rotation_weight),
+	}
+}
+
+func toGetOccupancyGridResponse(value C.viam_carto_get_occupancy_grid_response) OccupancyGrid {
+	return OccupancyGrid{
+		Width:            int(value.width),
+		Height:           int(value.height),
+		ResolutionMeters: float64(value.resolution_meters),
+		OriginX:          float64(value.origin_x),
+		OriginY:          float64(value.origin_y),
+		Cells:            []byte(bstringToGoString(value.cells)),
+	}
+}
+
+func toSubmapIDs(ids []SubmapID) []C.viam_carto_submap_id {
+	cIDs := make([]C.viam_carto_submap_id, 0, len(ids))
+	for _, id := range ids {
+		cIDs = append(cIDs, C.viam_carto_submap_id{
+			trajectory_id: C.int(id.TrajectoryID),
+			submap_index:  C.int(id.SubmapIndex),
+		})
+	}
+	return cIDs
+}
+
+func toGetSubmapGridsResponse(value C.viam_carto_get_submap_grids_response) []SubmapGrid {
+	if value.grids == nil || value.grids_len == 0 {
+		return []SubmapGrid{}
+	}
+	cGrids := unsafe.Slice(value.grids, int(value.grids_len))
+	grids := make([]SubmapGrid, 0, len(cGrids))
+	for _, grid := range cGrids {
+		grids = append(grids, SubmapGrid{
+			TrajectoryID: int(grid.trajectory_id),
+			SubmapIndex:  int(grid.submap_index),
+			Version:      int(grid.version),
+			Grid: OccupancyGrid{
+				Width:            int(grid.width),
+				Height:           int(grid.height),
+				ResolutionMeters: float64(grid.resolution_meters),
+				OriginX:          float64(grid.origin_x),
+				OriginY:          float64(grid.origin_y),
+				Cells:            []byte(bstringToGoString(grid.cells)),
+			},
+		})
+	}
+	return grids
+}
+
+func toGetSubmapsResponse(value C.viam_carto_get_submaps_response) []Submap {
+	if value.submaps == nil || value.submaps_len == 0 {
+		return []Submap{}
+	}
+	cSubmaps := unsafe.Slice(value.submaps, int(value.submaps_len))
+	submaps := make([]Submap, 0, len(cSubmaps))
+	for _, submap := range cSubmaps {
+		submaps = append(submaps, Submap{
+			TrajectoryID:     int(submap.trajectory_id),
+			SubmapIndex:      int(submap.submap_index),
+			Version:          int(submap.version),
+			Finished:         bool(submap.finished),
+			ResolutionMeters: float64(submap.resolution_meters),
+
+			X: float64(submap.x),
+			Y: float64(submap.y),
+			Z: float64(submap.z),
+
+			Real: float64(submap.real),
+			Imag: float64(submap.imag),
+			Jmag: float64(submap.jmag),
+			Kmag: float64(submap.kmag),
+
+			MinX: float64(submap.min_x),
+			MinY: float64(submap.min_y),
+			MaxX: float64(submap.max_x),
+			MaxY: float64(submap.max_y),
+		})
+	}
+	return submaps
+}
+
 func toLidarReading(lidar string, readings []byte, timestamp time.Time) C.viam_carto_lidar_reading {
 	sr := C.viam_carto_lidar_reading{}
 	sensorCStr := C.CString(lidar)
@@ -461,6 +1010,54 @@ func toError(status C.int) error {
 		return errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
 	case C.VIAM_CARTO_NOT_IN_TERMINATABLE_STATE:
 		return errors.New("VIAM_CARTO_NOT_IN_TERMINATABLE_STATE")
+	case C.VIAM_CARTO_GET_STATS_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_GET_STATS_RESPONSE_INVALID")
+	case C.VIAM_CARTO_STREAM_INVALID:
+		return errors.New("VIAM_CARTO_STREAM_INVALID")
+	case C.VIAM_CARTO_STREAM_DONE:
+		return io.EOF
+	case C.VIAM_CARTO_STREAM_NEXT_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_STREAM_NEXT_RESPONSE_INVALID")
+	case C.VIAM_CARTO_STREAM_MAX_CHUNK_SIZE_INVALID:
+		return errors.New("VIAM_CARTO_STREAM_MAX_CHUNK_SIZE_INVALID")
+	case C.VIAM_CARTO_SAVE_MAP_PATH_INVALID:
+		return errors.New("VIAM_CARTO_SAVE_MAP_PATH_INVALID")
+	case C.VIAM_CARTO_SAVE_MAP_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_SAVE_MAP_RESPONSE_INVALID")
+	case C.VIAM_CARTO_SAVE_MAP_FILE_WRITE_IO_ERROR:
+		return errors.New("VIAM_CARTO_SAVE_MAP_FILE_WRITE_IO_ERROR")
+	case C.VIAM_CARTO_POSE_INVALID:
+		return errors.New("VIAM_CARTO_POSE_INVALID")
+	case C.VIAM_CARTO_NOT_IN_LOCALIZING_MODE:
+		return errors.New("VIAM_CARTO_NOT_IN_LOCALIZING_MODE")
+	case C.VIAM_CARTO_FROZEN_MAP_EMPTY:
+		return errors.New("VIAM_CARTO_FROZEN_MAP_EMPTY")
+	case C.VIAM_CARTO_OPTIMIZATION_IN_PROGRESS:
+		return errors.New("VIAM_CARTO_OPTIMIZATION_IN_PROGRESS")
+	case C.VIAM_CARTO_OPTIMIZATION_STATUS_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_OPTIMIZATION_STATUS_RESPONSE_INVALID")
+	case C.VIAM_CARTO_GET_TRAJECTORY_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_GET_TRAJECTORY_RESPONSE_INVALID")
+	case C.VIAM_CARTO_GET_TRAJECTORY_EVERY_N_NODES_INVALID:
+		return errors.New("VIAM_CARTO_GET_TRAJECTORY_EVERY_N_NODES_INVALID")
+	case C.VIAM_CARTO_GET_SUBMAPS_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_GET_SUBMAPS_RESPONSE_INVALID")
+	case C.VIAM_CARTO_GET_CONFIG_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_GET_CONFIG_RESPONSE_INVALID")
+	case C.VIAM_CARTO_ALREADY_IN_LOCALIZING_MODE:
+		return errors.New("VIAM_CARTO_ALREADY_IN_LOCALIZING_MODE")
+	case C.VIAM_CARTO_GET_OCCUPANCY_GRID_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_GET_OCCUPANCY_GRID_RESPONSE_INVALID")
+	case C.VIAM_CARTO_OCCUPANCY_GRID_EMPTY:
+		return ErrOccupancyGridEmpty
+	case C.VIAM_CARTO_MAP_OPTIONS_INVALID:
+		return errors.New("VIAM_CARTO_MAP_OPTIONS_INVALID")
+	case C.VIAM_CARTO_GET_SUBMAP_GRIDS_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_GET_SUBMAP_GRIDS_RESPONSE_INVALID")
+	case C.VIAM_CARTO_SUBMAP_IDS_INVALID:
+		return errors.New("VIAM_CARTO_SUBMAP_IDS_INVALID")
+	case C.VIAM_CARTO_MAP_REVISION_RESPONSE_INVALID:
+		return errors.New("VIAM_CARTO_MAP_REVISION_RESPONSE_INVALID")
 	default:
 		return errors.New("status code unclassified")
 	}
@@ -22,13 +22,29 @@ func (cf *CartoLibMock) Terminate() error {
 // CartoMock represents a fake instance of cartofacade.
 type CartoMock struct {
 	Carto
-	StartFunc            func() error
-	StopFunc             func() error
-	TerminateFunc        func() error
-	AddLidarReadingFunc  func(string, []byte, time.Time) error
-	GetPositionFunc      func() (GetPosition, error)
-	GetPointCloudMapFunc func() ([]byte, error)
-	GetInternalStateFunc func() ([]byte, error)
+	StartFunc                func() error
+	StopFunc                 func() error
+	TerminateFunc            func() error
+	AddLidarReadingFunc      func(string, []byte, time.Time) error
+	GetPositionFunc          func() (GetPosition, error)
+	GetPointCloudMapFunc     func() ([]byte, error)
+	GetInternalStateFunc     func() ([]byte, error)
+	GetStatsFunc             func() (GetStats, error)
+	SaveMapFunc              func(string, bool) (SaveMap, error)
+	SetPoseFunc              func(Pose2D) error
+	SwitchToLocalizationFunc func() (SlamMode, error)
+
+	OptimizeFunc              func() error
+	GetOptimizationStatusFunc func() (OptimizationStatus, error)
+	GetMapRevisionFunc        func() (MapRevision, error)
+	GetTrajectoryFunc         func(allTrajectories bool, everyNNodes int) ([]TrajectoryNode, error)
+	GetSubmapsFunc            func() ([]Submap, error)
+	GetConfigFunc             func() (GetConfig, error)
+	GetOccupancyGridFunc      func(opts MapOptions) (OccupancyGrid, error)
+	GetSubmapGridsFunc        func(ids []SubmapID) ([]SubmapGrid, error)
+
+	GetPointCloudMapStreamFunc func(opts MapOptions) (CartoStreamInterface, error)
+	GetInternalStateStreamFunc func() (CartoStreamInterface, error)
 }
 
 // Start calls the injected StartFunc or the real version.
@@ -86,3 +102,138 @@ func (cf *CartoMock) getInternalState() ([]byte, error) {
 	}
 	return cf.GetInternalStateFunc()
 }
+
+// GetStats calls the injected GetStatsFunc or the real version.
+func (cf *CartoMock) getStats() (GetStats, error) {
+	if cf.GetStatsFunc == nil {
+		return cf.Carto.getStats()
+	}
+	return cf.GetStatsFunc()
+}
+
+// GetPointCloudMapStream calls the injected GetPointCloudMapStreamFunc or the real version.
+func (cf *CartoMock) getPointCloudMapStream(opts MapOptions) (CartoStreamInterface, error) {
+	if cf.GetPointCloudMapStreamFunc == nil {
+		return cf.Carto.getPointCloudMapStream(opts)
+	}
+	return cf.GetPointCloudMapStreamFunc(opts)
+}
+
+// GetInternalStateStream calls the injected GetInternalStateStreamFunc or the real version.
+func (cf *CartoMock) getInternalStateStream() (CartoStreamInterface, error) {
+	if cf.GetInternalStateStreamFunc == nil {
+		return cf.Carto.getInternalStateStream()
+	}
+	return cf.GetInternalStateStreamFunc()
+}
+
+// CartoStreamMock represents a fake instance of a carto stream.
+type CartoStreamMock struct {
+	CartoStream
+	NextFunc  func(maxChunkSizeBytes int) ([]byte, error)
+	CloseFunc func() error
+}
+
+// Next calls the injected NextFunc or the real version.
+func (s *CartoStreamMock) next(maxChunkSizeBytes int) ([]byte, error) {
+	if s.NextFunc == nil {
+		return s.CartoStream.next(maxChunkSizeBytes)
+	}
+	return s.NextFunc(maxChunkSizeBytes)
+}
+
+// Close calls the injected CloseFunc or the real version.
+func (s *CartoStreamMock) close() error {
+	if s.CloseFunc == nil {
+		return s.CartoStream.close()
+	}
+	return s.CloseFunc()
+}
+
+// SaveMap calls the injected SaveMapFunc or the real version.
+func (cf *CartoMock) saveMap(path string, runFinalOptimization bool) (SaveMap, error) {
+	if cf.SaveMapFunc == nil {
+		return cf.Carto.saveMap(path, runFinalOptimization)
+	}
+	return cf.SaveMapFunc(path, runFinalOptimization)
+}
+
+// SetPose calls the injected SetPoseFunc or the real version.
+func (cf *CartoMock) setPose(pose Pose2D) error {
+	if cf.SetPoseFunc == nil {
+		return cf.Carto.setPose(pose)
+	}
+	return cf.SetPoseFunc(pose)
+}
+
+// SwitchToLocalization calls the injected SwitchToLocalizationFunc or the real version.
+func (cf *CartoMock) switchToLocalization() (SlamMode, error) {
+	if cf.SwitchToLocalizationFunc == nil {
+		return cf.Carto.switchToLocalization()
+	}
+	return cf.SwitchToLocalizationFunc()
+}
+
+// Optimize calls the injected OptimizeFunc or the real version.
+func (cf *CartoMock) optimize() error {
+	if cf.OptimizeFunc == nil {
+		return cf.Carto.optimize()
+	}
+	return cf.OptimizeFunc()
+}
+
+// GetOptimizationStatus calls the injected GetOptimizationStatusFunc or the real version.
+func (cf *CartoMock) getOptimizationStatus() (OptimizationStatus, error) {
+	if cf.GetOptimizationStatusFunc == nil {
+		return cf.Carto.getOptimizationStatus()
+	}
+	return cf.GetOptimizationStatusFunc()
+}
+
+// GetMapRevision calls the injected GetMapRevisionFunc or the real version.
+func (cf *CartoMock) getMapRevision() (MapRevision, error) {
+	if cf.GetMapRevisionFunc == nil {
+		return cf.Carto.getMapRevision()
+	}
+	return cf.GetMapRevisionFunc()
+}
+
+// GetTrajectory calls the injected GetTrajectoryFunc or the real version.
+func (cf *CartoMock) getTrajectory(allTrajectories bool, everyNNodes int) ([]TrajectoryNode, error) {
+	if cf.GetTrajectoryFunc == nil {
+		return cf.Carto.getTrajectory(allTrajectories, everyNNodes)
+	}
+	return cf.GetTrajectoryFunc(allTrajectories, everyNNodes)
+}
+
+// GetSubmaps calls the injected GetSubmapsFunc or the real version.
+func (cf *CartoMock) getSubmaps() ([]Submap, error) {
+	if cf.GetSubmapsFunc == nil {
+		return cf.Carto.getSubmaps()
+	}
+	return cf.GetSubmapsFunc()
+}
+
+// GetConfig calls the injected GetConfigFunc or the real version.
+func (cf *CartoMock) getConfig() (GetConfig, error) {
+	if cf.GetConfigFunc == nil {
+		return cf.Carto.getConfig()
+	}
+	return cf.GetConfigFunc()
+}
+
+// GetOccupancyGrid calls the injected GetOccupancyGridFunc or the real version.
+func (cf *CartoMock) getOccupancyGrid(opts MapOptions) (OccupancyGrid, error) {
+	if cf.GetOccupancyGridFunc == nil {
+		return cf.Carto.getOccupancyGrid(opts)
+	}
+	return cf.GetOccupancyGridFunc(opts)
+}
+
+// GetSubmapGrids calls the injected GetSubmapGridsFunc or the real version.
+func (cf *CartoMock) getSubmapGrids(ids []SubmapID) ([]SubmapGrid, error) {
+	if cf.GetSubmapGridsFunc == nil {
+		return cf.Carto.getSubmapGrids(ids)
+	}
+	return cf.GetSubmapGridsFunc(ids)
+}
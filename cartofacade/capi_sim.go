@@ -0,0 +1,283 @@
+//go:build cartosim
+
+// Package cartofacade contains the api to call into CGO
+package cartofacade
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// CartoLib holds the state of the simulated carto library. It replaces the c type
+// viam_carto_lib when built with the cartosim build tag.
+type CartoLib struct {
+	minloglevel int
+	verbose     int
+	initialized bool
+}
+
+// Carto holds the simulated carto instance. It replaces the c type viam_carto
+// when built with the cartosim build tag.
+type Carto struct {
+	value *simCarto
+	SlamMode
+}
+
+// CartoStream holds a simulated export, it replaces the c type viam_carto_stream when built with the
+// cartosim build tag. Exports of the simulator are small, so they are held in memory.
+type CartoStream struct {
+	data   []byte
+	closed bool
+}
+
+// NewLib returns a simulated carto lib object, it mirrors viam_carto_lib_init.
+func NewLib(miniloglevel, verbose int) (CartoLib, error) {
+	return CartoLib{minloglevel: miniloglevel, verbose: verbose, initialized: true}, nil
+}
+
+// Terminate mirrors viam_carto_lib_terminate for the simulated carto lib.
+func (vcl *CartoLib) Terminate() error {
+	if !vcl.initialized {
+		return errors.New("VIAM_CARTO_LIB_INVALID")
+	}
+	vcl.initialized = false
+	return nil
+}
+
+// NewCarto returns a simulated carto object which has already been IO initialized, it mirrors viam_carto_init.
+// vcl is only an interface to facilitate testing & the only type vcl it is actually expected to have is a CartoLib
+func NewCarto(cfg CartoConfig, acfg CartoAlgoConfig, vcl CartoLibInterface) (Carto, error) {
+	if cfg.LidarConfig != TwoD && cfg.LidarConfig != ThreeD {
+		return Carto{}, errors.New("invalid lidar config value")
+	}
+	cl, ok := vcl.(*CartoLib)
+	if !ok {
+		return Carto{}, errors.New("cannot cast provided library to a CartoLib")
+	}
+	if !cl.initialized {
+		return Carto{}, errors.New("VIAM_CARTO_LIB_INVALID")
+	}
+
+	sc, err := newSimCarto(cfg, acfg)
+	if err != nil {
+		return Carto{}, err
+	}
+
+	if err := sc.ioInit(); err != nil {
+		return Carto{}, err
+	}
+
+	return Carto{value: sc, SlamMode: sc.slamMode}, nil
+}
+
+// Start mirrors viam_carto_start for the simulated carto object.
+func (vc *Carto) start() error {
+	if vc.value == nil {
+		return errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.start()
+}
+
+// Stop mirrors viam_carto_stop for the simulated carto object.
+func (vc *Carto) stop() error {
+	if vc.value == nil {
+		return errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.stop()
+}
+
+// Terminate mirrors viam_carto_terminate for the simulated carto object.
+func (vc *Carto) terminate() error {
+	if vc.value == nil {
+		return errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	if err := vc.value.terminate(); err != nil {
+		return err
+	}
+	vc.value = nil
+	return nil
+}
+
+// AddLidarReading mirrors viam_carto_add_lidar_reading for the simulated carto object.
+func (vc *Carto) addLidarReading(lidar string, readings []byte, timestamp time.Time) error {
+	if vc.value == nil {
+		return errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.addLidarReading(lidar, readings, timestamp)
+}
+
+// GetPosition mirrors viam_carto_get_position for the simulated carto object.
+func (vc *Carto) getPosition() (GetPosition, error) {
+	if vc.value == nil {
+		return GetPosition{}, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getPosition()
+}
+
+// GetPointCloudMap mirrors viam_carto_get_point_cloud_map for the simulated carto object.
+func (vc *Carto) getPointCloudMap() ([]byte, error) {
+	if vc.value == nil {
+		return nil, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getPointCloudMap(MapOptions{})
+}
+
+// GetInternalState mirrors viam_carto_get_internal_state for the simulated carto object.
+func (vc *Carto) getInternalState() ([]byte, error) {
+	if vc.value == nil {
+		return nil, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getInternalState()
+}
+
+// GetPointCloudMapStream mirrors viam_carto_get_point_cloud_map_stream for the simulated carto object.
+func (vc *Carto) getPointCloudMapStream(opts MapOptions) (CartoStreamInterface, error) {
+	if vc.value == nil {
+		return nil, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	pcd, err := vc.value.getPointCloudMap(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &CartoStream{data: pcd}, nil
+}
+
+// GetInternalStateStream mirrors viam_carto_get_internal_state_stream for the simulated carto object.
+func (vc *Carto) getInternalStateStream() (CartoStreamInterface, error) {
+	internalState, err := vc.getInternalState()
+	if err != nil {
+		return nil, err
+	}
+	return &CartoStream{data: internalState}, nil
+}
+
+// Next mirrors viam_carto_stream_next for the simulated stream, it returns io.EOF once the whole stream
+// has been read.
+func (s *CartoStream) next(maxChunkSizeBytes int) ([]byte, error) {
+	if s.closed {
+		return nil, errors.New("VIAM_CARTO_STREAM_INVALID")
+	}
+	if maxChunkSizeBytes <= 0 {
+		return nil, errors.New("VIAM_CARTO_STREAM_MAX_CHUNK_SIZE_INVALID")
+	}
+	if len(s.data) == 0 {
+		return nil, io.EOF
+	}
+	n := maxChunkSizeBytes
+	if n > len(s.data) {
+		n = len(s.data)
+	}
+	chunk := make([]byte, n)
+	copy(chunk, s.data)
+	s.data = s.data[n:]
+	return chunk, nil
+}
+
+// Close mirrors viam_carto_stream_destroy for the simulated stream.
+func (s *CartoStream) close() error {
+	if s.closed {
+		return errors.New("VIAM_CARTO_STREAM_INVALID")
+	}
+	s.closed = true
+	s.data = nil
+	return nil
+}
+
+// GetStats mirrors viam_carto_get_stats for the simulated carto object.
+func (vc *Carto) getStats() (GetStats, error) {
+	if vc.value == nil {
+		return GetStats{}, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getStats()
+}
+
+// SaveMap mirrors viam_carto_save_map for the simulated carto object.
+func (vc *Carto) saveMap(path string, runFinalOptimization bool) (SaveMap, error) {
+	if vc.value == nil {
+		return SaveMap{}, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.saveMap(path, runFinalOptimization)
+}
+
+// SetPose mirrors viam_carto_set_pose for the simulated carto object.
+func (vc *Carto) setPose(pose Pose2D) error {
+	if vc.value == nil {
+		return errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.setPose(pose)
+}
+
+// SwitchToLocalization mirrors viam_carto_switch_to_localization for the simulated carto object.
+func (vc *Carto) switchToLocalization() (SlamMode, error) {
+	if vc.value == nil {
+		return UnknownMode, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	err := vc.value.switchToLocalization()
+	vc.SlamMode = vc.value.slamMode
+	return vc.SlamMode, err
+}
+
+// Optimize mirrors viam_carto_optimize for the simulated carto object.
+func (vc *Carto) optimize() error {
+	if vc.value == nil {
+		return errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.optimize()
+}
+
+// GetOptimizationStatus mirrors viam_carto_get_optimization_status for the simulated carto object.
+func (vc *Carto) getOptimizationStatus() (OptimizationStatus, error) {
+	if vc.value == nil {
+		return OptimizationStatus{}, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getOptimizationStatus()
+}
+
+// GetMapRevision mirrors viam_carto_get_map_revision for the simulated carto object.
+func (vc *Carto) getMapRevision() (MapRevision, error) {
+	if vc.value == nil {
+		return MapRevision{}, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getMapRevision()
+}
+
+// GetSubmaps mirrors viam_carto_get_submaps for the simulated carto object.
+func (vc *Carto) getSubmaps() ([]Submap, error) {
+	if vc.value == nil {
+		return nil, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getSubmaps()
+}
+
+// GetConfig mirrors viam_carto_get_config for the simulated carto object.
+func (vc *Carto) getConfig() (GetConfig, error) {
+	if vc.value == nil {
+		return GetConfig{}, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getConfig()
+}
+
+// GetOccupancyGrid mirrors viam_carto_get_occupancy_grid for the simulated carto object.
+func (vc *Carto) getOccupancyGrid(opts MapOptions) (OccupancyGrid, error) {
+	if vc.value == nil {
+		return OccupancyGrid{}, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getOccupancyGrid(opts)
+}
+
+// GetSubmapGrids mirrors viam_carto_get_submap_grids for the simulated carto object.
+func (vc *Carto) getSubmapGrids(ids []SubmapID) ([]SubmapGrid, error) {
+	if vc.value == nil {
+		return nil, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getSubmapGrids(ids)
+}
+
+// GetTrajectory mirrors viam_carto_get_trajectory for the simulated carto object.
+func (vc *Carto) getTrajectory(allTrajectories bool, everyNNodes int) ([]TrajectoryNode, error) {
+	if vc.value == nil {
+		return nil, errors.New("VIAM_CARTO_VC_INVALID")
+	}
+	return vc.value.getTrajectory(allTrajectories, everyNNodes)
+}
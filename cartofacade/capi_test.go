@@ -1,9 +1,12 @@
+//go:build !cartosim
+
 package cartofacade
 
 import (
 	"bytes"
 	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -102,6 +105,19 @@ func TestGetConfig(t *testing.T) {
 
 		test.That(t, vcc.lidar_config, test.ShouldEqual, TwoD)
 	})
+
+	t.Run("config properly converted between C and go with an initial internal state", func(t *testing.T) {
+		cfg, dir, err := GetTestConfig("mysensor", "")
+		defer os.RemoveAll(dir)
+		test.That(t, err, test.ShouldBeNil)
+		cfg.InitialInternalState = filepath.Join(dir, "internal_state", "map_data.pbstream")
+
+		vcc, err := getConfig(cfg)
+		test.That(t, err, test.ShouldBeNil)
+
+		initialInternalState := bstringToGoString(vcc.initial_internal_state)
+		test.That(t, initialInternalState, test.ShouldResemble, cfg.InitialInternalState)
+	})
 }
 
 func TestGetPositionResponse(t *testing.T) {
@@ -120,6 +136,176 @@ func TestGetPositionResponse(t *testing.T) {
 	})
 }
 
+func TestGetStatsResponse(t *testing.T) {
+	t.Run("stats response properly converted between C and go", func(t *testing.T) {
+		timestamp := time.Date(2021, 8, 15, 14, 30, 45, 0, time.UTC)
+		holder := toGetStatsResponse(getTestGetStatsResponse(timestamp.UnixMilli()))
+		test.That(t, holder.Trajectories, test.ShouldEqual, 2)
+		test.That(t, holder.SubmapsFinished, test.ShouldEqual, 10)
+		test.That(t, holder.SubmapsActive, test.ShouldEqual, 2)
+		test.That(t, holder.Nodes, test.ShouldEqual, 300)
+		test.That(t, holder.Constraints, test.ShouldEqual, 4000)
+		test.That(t, holder.GridBytes, test.ShouldEqual, 5000000)
+		test.That(t, holder.LastOptimizationTime.Equal(timestamp), test.ShouldBeTrue)
+	})
+
+	t.Run("last optimization time is zero when no optimization has run", func(t *testing.T) {
+		holder := toGetStatsResponse(getTestGetStatsResponse(0))
+		test.That(t, holder.LastOptimizationTime.IsZero(), test.ShouldBeTrue)
+	})
+}
+
+func TestOptimizationStatusResponse(t *testing.T) {
+	t.Run("optimization status response properly converted between C and go", func(t *testing.T) {
+		holder := toOptimizationStatusResponse(getTestOptimizationStatusResponse())
+		test.That(t, holder.Running, test.ShouldBeFalse)
+		test.That(t, holder.Duration, test.ShouldEqual, 1500*time.Millisecond)
+		test.That(t, holder.ResidualBefore, test.ShouldEqual, 12.5)
+		test.That(t, holder.ResidualAfter, test.ShouldEqual, 3.25)
+	})
+}
+
+func TestMapRevisionResponse(t *testing.T) {
+	t.Run("map revision response properly converted between C and go", func(t *testing.T) {
+		changedAt := time.Date(2021, 8, 15, 14, 30, 45, 0, time.UTC)
+		holder := toMapRevisionResponse(getTestMapRevisionResponse(changedAt))
+		test.That(t, holder, test.ShouldResemble, MapRevision{Revision: 7, ChangedAt: changedAt})
+	})
+}
+
+func TestGetTrajectoryResponse(t *testing.T) {
+	t.Run("trajectory response properly converted between C and go", func(t *testing.T) {
+		timestamp := time.Date(2021, 8, 15, 14, 30, 45, 0, time.UTC)
+		gtr := getTestGetTrajectoryResponse(timestamp, 2)
+		holder := toGetTrajectoryResponse(gtr)
+		test.That(t, len(holder), test.ShouldEqual, 2)
+		for i, node := range holder {
+			test.That(t, node.TrajectoryID, test.ShouldEqual, 1)
+			test.That(t, node.NodeIndex, test.ShouldEqual, i*10)
+			test.That(t, node.Time.Equal(timestamp.Add(time.Duration(i)*time.Second)), test.ShouldBeTrue)
+			test.That(t, node.X, test.ShouldEqual, 100*(i+1))
+			test.That(t, node.Y, test.ShouldEqual, 200*(i+1))
+			test.That(t, node.Z, test.ShouldEqual, 0)
+			test.That(t, node.Real, test.ShouldEqual, 1)
+		}
+		test.That(t, destroyGetTrajectoryResponse(&gtr), test.ShouldBeNil)
+		test.That(t, gtr.nodes_len, test.ShouldEqual, 0)
+	})
+
+	t.Run("empty trajectory response is converted to an empty slice", func(t *testing.T) {
+		holder := toGetTrajectoryResponse(getTestGetTrajectoryResponse(time.Time{}, 0))
+		test.That(t, holder, test.ShouldResemble, []TrajectoryNode{})
+	})
+}
+
+func TestGetSubmapsResponse(t *testing.T) {
+	t.Run("submaps response properly converted between C and go", func(t *testing.T) {
+		gsr := getTestGetSubmapsResponse(2)
+		holder := toGetSubmapsResponse(gsr)
+		test.That(t, len(holder), test.ShouldEqual, 2)
+		for i, submap := range holder {
+			test.That(t, submap.TrajectoryID, test.ShouldEqual, 0)
+			test.That(t, submap.SubmapIndex, test.ShouldEqual, i)
+			test.That(t, submap.Version, test.ShouldEqual, 90)
+			test.That(t, submap.Finished, test.ShouldEqual, i == 0)
+			test.That(t, submap.ResolutionMeters, test.ShouldEqual, 0.05)
+			test.That(t, submap.X, test.ShouldEqual, 1000*i)
+			test.That(t, submap.Y, test.ShouldEqual, 500)
+			test.That(t, submap.Real, test.ShouldEqual, 1)
+			test.That(t, submap.MinX, test.ShouldEqual, 1000*i-2000)
+			test.That(t, submap.MinY, test.ShouldEqual, -1500)
+			test.That(t, submap.MaxX, test.ShouldEqual, 1000*i+2000)
+			test.That(t, submap.MaxY, test.ShouldEqual, 2500)
+		}
+		test.That(t, destroyGetSubmapsResponse(&gsr), test.ShouldBeNil)
+		test.That(t, gsr.submaps_len, test.ShouldEqual, 0)
+	})
+
+	t.Run("empty submaps response is converted to an empty slice", func(t *testing.T) {
+		holder := toGetSubmapsResponse(getTestGetSubmapsResponse(0))
+		test.That(t, holder, test.ShouldResemble, []Submap{})
+	})
+}
+
+func TestGetConfigResponse(t *testing.T) {
+	t.Run("config response properly converted between C and go", func(t *testing.T) {
+		gcr := getTestGetConfigResponse()
+		holder := toGetConfigResponse(gcr)
+		test.That(t, holder, test.ShouldResemble, GetConfig{
+			ConfigurationDirectory: "/usr/local/share/cartographer/lua_files",
+			ConfigurationBasename:  "mapping_new_map.lua",
+			OptimizeEveryNNodes:    3,
+			NumRangeData:           30,
+			MissingDataRayLength:   25,
+			MaxRange:               25,
+			MinRange:               0.5,
+			MaxSubmapsToKeep:       3,
+			FreshSubmapsCount:      3,
+			MinCoveredArea:         1,
+			MinAddedSubmapsCount:   1,
+			OccupiedSpaceWeight:    20,
+			TranslationWeight:      10,
+			RotationWeight:         1,
+		})
+		test.That(t, destroyGetConfigResponse(&gcr), test.ShouldBeNil)
+		test.That(t, gcr.configuration_directory, test.ShouldBeNil)
+		test.That(t, gcr.configuration_basename, test.ShouldBeNil)
+	})
+}
+
+func TestGetOccupancyGridResponse(t *testing.T) {
+	t.Run("occupancy grid response properly converted between C and go", func(t *testing.T) {
+		ogr := getTestGetOccupancyGridResponse()
+		holder := toGetOccupancyGridResponse(ogr)
+		test.That(t, holder, test.ShouldResemble, OccupancyGrid{
+			Width:            3,
+			Height:           2,
+			ResolutionMeters: 0.05,
+			OriginX:          -0.075,
+			OriginY:          -0.025,
+			Cells:            []byte{0, 50, 100, OccupancyGridUnknownCell, 0, 7},
+		})
+		test.That(t, destroyGetOccupancyGridResponse(&ogr), test.ShouldBeNil)
+		test.That(t, ogr.cells, test.ShouldBeNil)
+	})
+}
+
+func TestGetSubmapGridsResponse(t *testing.T) {
+	t.Run("submap grids response properly converted between C and go", func(t *testing.T) {
+		sgr := getTestGetSubmapGridsResponse(2)
+		holder := toGetSubmapGridsResponse(sgr)
+		test.That(t, len(holder), test.ShouldEqual, 2)
+		for i, grid := range holder {
+			test.That(t, grid, test.ShouldResemble, SubmapGrid{
+				SubmapIndex: i,
+				Version:     90 + i,
+				Grid: OccupancyGrid{
+					Width:            2,
+					Height:           1,
+					ResolutionMeters: 0.05,
+					OriginX:          -0.05,
+					OriginY:          -0.025,
+					Cells:            []byte{byte(i), OccupancyGridUnknownCell},
+				},
+			})
+		}
+		test.That(t, destroyGetSubmapGridsResponse(&sgr), test.ShouldBeNil)
+		test.That(t, sgr.grids_len, test.ShouldEqual, 0)
+	})
+
+	t.Run("empty submap grids response is converted to an empty slice", func(t *testing.T) {
+		holder := toGetSubmapGridsResponse(getTestGetSubmapGridsResponse(0))
+		test.That(t, holder, test.ShouldResemble, []SubmapGrid{})
+	})
+
+	t.Run("submap ids are converted to C", func(t *testing.T) {
+		cIDs := toSubmapIDs([]SubmapID{{TrajectoryID: 1, SubmapIndex: 2}})
+		test.That(t, len(cIDs), test.ShouldEqual, 1)
+		test.That(t, int(cIDs[0].trajectory_id), test.ShouldEqual, 1)
+		test.That(t, int(cIDs[0].submap_index), test.ShouldEqual, 2)
+	})
+}
+
 func TestToSensorReading(t *testing.T) {
 	t.Run("lidar reading properly converted between c and go", func(t *testing.T) {
 		timestamp := time.Date(2021, 8, 15, 14, 30, 45, 100, time.UTC)
@@ -195,6 +381,30 @@ func TestCGoAPI(t *testing.T) {
 		test.That(t, len(internalState), test.ShouldBeGreaterThan, 0)
 		lastInternalState := internalState
 
+		// test getStats before sensor data is added
+		stats, err := vc.getStats()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, stats.Nodes, test.ShouldEqual, 0)
+		test.That(t, stats.SubmapsFinished+stats.SubmapsActive, test.ShouldEqual, 0)
+		test.That(t, stats.LastOptimizationTime.IsZero(), test.ShouldBeTrue)
+
+		// test getTrajectory before sensor data is added
+		nodes, err := vc.getTrajectory(true, 1)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, nodes, test.ShouldBeEmpty)
+		_, err = vc.getTrajectory(false, 0)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_GET_TRAJECTORY_EVERY_N_NODES_INVALID"))
+
+		// test getSubmaps before sensor data is added
+		submaps, err := vc.getSubmaps()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, submaps, test.ShouldBeEmpty)
+
+		// test saveMap before sensor data is added
+		saved, err := vc.saveMap(filepath.Join(dir, "saved_map.pbstream"), true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, saved.Nodes, test.ShouldEqual, 0)
+
 		// test invalid addLidarReading: not in sensor list
 		// PATRICIA TODO: #242
 		timestamp := time.Date(2021, 8, 15, 14, 30, 45, 100, time.UTC)
@@ -0,0 +1,352 @@
+package cartofacade
+
+import "time"
+
+// CartoLibInterface describes the method signatures that CartoLib must implement
+type CartoLibInterface interface {
+	Terminate() error
+}
+
+// SlamMode represents the lidar configuration
+type SlamMode int64
+
+const (
+	// UnknownMode denotes an unknown slam mode
+	UnknownMode SlamMode = iota
+	// MappingMode denotes the slam algo is in mapping mode
+	MappingMode
+	// LocalizingMode denotes the slam algo is in localizing only mode
+	LocalizingMode
+	// UpdatingMode denotes the slam algo is in updating mode
+	UpdatingMode
+)
+
+// String returns the name of the slam mode.
+func (m SlamMode) String() string {
+	switch m {
+	case MappingMode:
+		return "mapping"
+	case LocalizingMode:
+		return "localizing"
+	case UpdatingMode:
+		return "updating"
+	default:
+		return "unknown"
+	}
+}
+
+// PointCloudFormat is the format the pointcloud map is written in.
+type PointCloudFormat int
+
+const (
+	// PCDFormat is a binary pcd with the probability (0 - 100) that each point is occupied written to its rgb
+	// field, as the RDK expects
+	PCDFormat PointCloudFormat = iota
+	// PCDBinaryFormat is a binary pcd with the probability written to a probability field
+	PCDBinaryFormat
+	// PCDBinaryCompressedFormat is a binary_compressed pcd with the probability written to a probability field
+	PCDBinaryCompressedFormat
+	// PCDASCIIFormat is an ascii pcd with the probability written to a probability field
+	PCDASCIIFormat
+	// PLYFormat is a binary little endian ply with the probability written to a probability property
+	PLYFormat
+)
+
+// PointCloudFormats are the formats the pointcloud map may be written in.
+var PointCloudFormats = []PointCloudFormat{PCDFormat, PCDBinaryFormat, PCDBinaryCompressedFormat, PCDASCIIFormat, PLYFormat}
+
+// String returns the name of the pointcloud format.
+func (f PointCloudFormat) String() string {
+	switch f {
+	case PCDFormat:
+		return "pcd"
+	case PCDBinaryFormat:
+		return "pcd_binary"
+	case PCDBinaryCompressedFormat:
+		return "pcd_binary_compressed"
+	case PCDASCIIFormat:
+		return "pcd_ascii"
+	case PLYFormat:
+		return "ply"
+	default:
+		return "unknown"
+	}
+}
+
+// CartoInterface describes the method signatures that Carto must implement
+type CartoInterface interface {
+	start() error
+	stop() error
+	terminate() error
+	addLidarReading(string, []byte, time.Time) error
+	getPosition() (GetPosition, error)
+	getPointCloudMap() ([]byte, error)
+	getInternalState() ([]byte, error)
+	getPointCloudMapStream(opts MapOptions) (CartoStreamInterface, error)
+	getInternalStateStream() (CartoStreamInterface, error)
+	getStats() (GetStats, error)
+	saveMap(path string, runFinalOptimization bool) (SaveMap, error)
+	setPose(pose Pose2D) error
+	switchToLocalization() (SlamMode, error)
+	optimize() error
+	getOptimizationStatus() (OptimizationStatus, error)
+	getMapRevision() (MapRevision, error)
+	getTrajectory(allTrajectories bool, everyNNodes int) ([]TrajectoryNode, error)
+	getSubmaps() ([]Submap, error)
+	getConfig() (GetConfig, error)
+	getOccupancyGrid(opts MapOptions) (OccupancyGrid, error)
+	getSubmapGrids(ids []SubmapID) ([]SubmapGrid, error)
+}
+
+// CartoStreamInterface describes the method signatures that CartoStream must implement
+type CartoStreamInterface interface {
+	next(maxChunkSizeBytes int) ([]byte, error)
+	close() error
+}
+
+// GetPosition holds values returned from c to be processed later
+type GetPosition struct {
+	X float64
+	Y float64
+	Z float64
+
+	Real float64
+	Imag float64
+	Jmag float64
+	Kmag float64
+
+	ComponentReference string
+}
+
+// GetStats holds the size of cartographer's pose graph & grids returned from c
+type GetStats struct {
+	Trajectories    int
+	SubmapsFinished int
+	SubmapsActive   int
+	Nodes           int
+	Constraints     int
+	// GridBytes is the approximate number of bytes held by the grids of all submaps
+	GridBytes int64
+	// LastOptimizationTime is the zero time if no optimization has run yet
+	LastOptimizationTime time.Time
+}
+
+// SaveMap holds values returned from c after saving the map
+type SaveMap struct {
+	// Nodes is the number of trajectory nodes in the saved map
+	Nodes int
+}
+
+// OptimizationStatus holds the status of the final optimization returned from c
+type OptimizationStatus struct {
+	Running bool
+	// Duration is the duration of the last optimization, 0 if none has finished yet
+	Duration time.Duration
+	// ResidualBefore & ResidualAfter are the sums of the squared weighted errors of the pose graph
+	// constraints before & after the last optimization
+	ResidualBefore float64
+	ResidualAfter  float64
+}
+
+// TrajectoryNode holds the optimized global pose of a trajectory node returned from c
+type TrajectoryNode struct {
+	TrajectoryID int
+	// NodeIndex is the index of the node within its trajectory
+	NodeIndex int
+	Time      time.Time
+
+	// X, Y & Z are in millimeters from the origin of the map
+	X float64
+	Y float64
+	Z float64
+
+	Real float64
+	Imag float64
+	Jmag float64
+	Kmag float64
+}
+
+// Submap holds the state of a submap returned from c
+type Submap struct {
+	TrajectoryID int
+	// SubmapIndex is the index of the submap within its trajectory
+	SubmapIndex int
+	// Version is the number of range data inserted into the submap
+	Version          int
+	Finished         bool
+	ResolutionMeters float64
+
+	// X, Y & Z are in millimeters from the origin of the map
+	X float64
+	Y float64
+	Z float64
+
+	Real float64
+	Imag float64
+	Jmag float64
+	Kmag float64
+
+	// MinX, MinY, MaxX & MaxY bound the known cells of the submap in the frame of the map, in millimeters
+	MinX float64
+	MinY float64
+	MaxX float64
+	MaxY float64
+}
+
+// SubmapID identifies a submap.
+type SubmapID struct {
+	TrajectoryID int
+	// SubmapIndex is the index of the submap within its trajectory
+	SubmapIndex int
+}
+
+// SubmapGrid holds the grid of a submap returned from c. The grid is in the frame of the submap, so that it stays
+// valid when an optimization moves the submap, & is placed in the frame of the map with the pose of the Submap.
+type SubmapGrid struct {
+	TrajectoryID int
+	SubmapIndex  int
+	// Version is the number of range data inserted into the submap when it was painted
+	Version int
+	Grid    OccupancyGrid
+}
+
+// GetConfig holds the lua configuration loaded by cartographer & the values its map builder was built with,
+// after the lua configuration was overwritten by the CartoAlgoConfig, returned from c
+type GetConfig struct {
+	ConfigurationDirectory string
+	ConfigurationBasename  string
+
+	OptimizeEveryNNodes  int
+	NumRangeData         int
+	MissingDataRayLength float32
+	MaxRange             float32
+	MinRange             float32
+	MaxSubmapsToKeep     int
+	FreshSubmapsCount    int
+	MinCoveredArea       float64
+	MinAddedSubmapsCount int
+	OccupiedSpaceWeight  float64
+	TranslationWeight    float64
+	RotationWeight       float64
+}
+
+const (
+	// DefaultMapResolutionMeters is the side of a point of the painted map in meters, unless configured otherwise.
+	DefaultMapResolutionMeters = 0.05
+	// MinMapResolutionMeters is the finest resolution the map may be painted at in meters.
+	MinMapResolutionMeters = 0.005
+)
+
+// MapOptions overwrite how the map is painted for a single request, zero values fall back to the
+// MapResolutionMeters & MaxMapPoints of the CartoAlgoConfig.
+type MapOptions struct {
+	// ResolutionMeters is the side of a point of the painted map in meters
+	ResolutionMeters float64
+	// MaxPoints is the most points of the pointcloud map or cells of the occupancy grid the painted map
+	// may have, the resolution is coarsened until the map fits
+	MaxPoints int
+	// Region limits the painted map to the submaps intersecting it & the cells whose centers lie within it,
+	// the whole map is painted if it is nil
+	Region *MapRegion
+	// Format is the format the pointcloud map is written in, it doesn't change how the map is painted
+	Format PointCloudFormat
+}
+
+// MapRegion is a region of the map in millimeters, in the same frame as GetPosition.
+type MapRegion struct {
+	MinX float64
+	MinY float64
+	MaxX float64
+	MaxY float64
+}
+
+// Contains returns whether the point, in millimeters, lies within the region.
+func (r MapRegion) Contains(x, y float64) bool {
+	return x >= r.MinX && x <= r.MaxX && y >= r.MinY && y <= r.MaxY
+}
+
+// Intersects returns whether the region shares any point with the other one.
+func (r MapRegion) Intersects(other MapRegion) bool {
+	return r.MinX <= other.MaxX && other.MinX <= r.MaxX && r.MinY <= other.MaxY && other.MinY <= r.MaxY
+}
+
+// OccupancyGridUnknownCell is the value of the cells of an OccupancyGrid which were never observed.
+const OccupancyGridUnknownCell = 255
+
+// OccupancyGrid holds the painted map as a grid of the probability that each cell is occupied, returned from c.
+// It is in the same frame as GetPosition.
+type OccupancyGrid struct {
+	Width            int
+	Height           int
+	ResolutionMeters float64
+	// OriginX & OriginY are the position of the lower left corner of the bottom left cell, in meters
+	OriginX float64
+	OriginY float64
+	// Cells holds Width * Height cells in row major order starting with the top row, each the probability
+	// (0 - 100) that the cell is occupied or OccupancyGridUnknownCell
+	Cells []byte
+}
+
+// LidarConfig represents the lidar configuration
+type LidarConfig int64
+
+const (
+	// TwoD LidarConfig denotes a 2d lidar
+	TwoD LidarConfig = iota
+	// ThreeD LidarConfig denotes a 3d lidar
+	ThreeD
+)
+
+// CartoConfig contains config values from app
+type CartoConfig struct {
+	Camera             string
+	MovementSensor     string
+	MapRateSecond      int
+	DataDir            string
+	ComponentReference string
+	LidarConfig        LidarConfig
+
+	CloudStoryEnabled bool
+	EnableMapping     bool
+	ExistingMap       string
+
+	// InitialPose is the pose the localization trajectory starts at, rather than the origin of the map.
+	// It is only used in localizing mode.
+	InitialPose *Pose2D
+
+	// InitialInternalState is the internal state of a mapping session which is continued in mapping mode,
+	// rather than being updated as an existing map, e.g. when restarting with new algo config values.
+	InitialInternalState string
+}
+
+// Pose2D is a pose in the frame of the map.
+type Pose2D struct {
+	// X is in millimeters
+	X float64
+	// Y is in millimeters
+	Y float64
+	// Theta is in degrees counterclockwise from the x axis
+	Theta float64
+}
+
+// CartoAlgoConfig contains config values from app
+type CartoAlgoConfig struct {
+	OptimizeOnStart      bool
+	OptimizeEveryNNodes  int
+	NumRangeData         int
+	MissingDataRayLength float32
+	MaxRange             float32
+	MinRange             float32
+	MaxSubmapsToKeep     int
+	FreshSubmapsCount    int
+	MinCoveredArea       float64
+	MinAddedSubmapsCount int
+	OccupiedSpaceWeight  float64
+	TranslationWeight    float64
+	RotationWeight       float64
+	// MapResolutionMeters is the side of a point of the painted map in meters, DefaultMapResolutionMeters if 0
+	MapResolutionMeters float64
+	// MaxMapPoints is the most points the painted map may have before it is painted at a coarser
+	// resolution, unlimited if 0
+	MaxMapPoints int
+}
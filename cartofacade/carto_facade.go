@@ -5,9 +5,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
+	"go.opencensus.io/stats"
+	"go.opencensus.io/trace"
 	"go.uber.org/multierr"
 )
 
@@ -16,6 +19,12 @@ var emptyRequestParams = map[RequestParamType]interface{}{}
 // ErrUnableToAcquireLock is the error returned from AddLidarReading when lock can't be acquired.
 var ErrUnableToAcquireLock = errors.New("VIAM_CARTO_UNABLE_TO_ACQUIRE_LOCK")
 
+// ErrOccupancyGridEmpty is the error returned from GetOccupancyGrid when there is no submap to paint yet.
+var ErrOccupancyGridEmpty = errors.New("VIAM_CARTO_OCCUPANCY_GRID_EMPTY")
+
+// optimizationPollInterval is how often Optimize checks whether the optimization has finished.
+const optimizationPollInterval = 100 * time.Millisecond
+
 // Initialize calls into the cartofacade C code.
 func (cf *CartoFacade) Initialize(ctx context.Context, timeout time.Duration, activeBackgroundWorkers *sync.WaitGroup) (SlamMode, error) {
 	cf.startCGoroutine(ctx, activeBackgroundWorkers)
@@ -131,6 +140,311 @@ func (cf *CartoFacade) GetPointCloudMap(ctx context.Context, timeout time.Durati
 	return pointCloud, nil
 }
 
+// Stream is an export of the cartofacade which is read in chunks through the cartofacade goroutine, so that
+// the export is never held in memory as a whole. A Stream must not be used concurrently. It is closed once
+// Next has returned io.EOF, otherwise it must be closed by the caller.
+type Stream interface {
+	// Next returns the next chunk of at most maxChunkSizeBytes bytes or io.EOF once the whole export has been read.
+	Next(ctx context.Context, timeout time.Duration, maxChunkSizeBytes int) ([]byte, error)
+	// Close frees the export, it is a no-op if the stream is already closed.
+	Close(ctx context.Context, timeout time.Duration) error
+}
+
+// cartoStream is the Stream returned by the cartofacade.
+type cartoStream struct {
+	cf     *CartoFacade
+	stream CartoStreamInterface
+	closed bool
+}
+
+// Next calls into the cartofacade C code.
+func (s *cartoStream) Next(ctx context.Context, timeout time.Duration, maxChunkSizeBytes int) ([]byte, error) {
+	if s.closed {
+		return nil, io.EOF
+	}
+	requestParams := map[RequestParamType]interface{}{
+		stream:       s.stream,
+		maxChunkSize: maxChunkSizeBytes,
+	}
+
+	untyped, err := s.cf.request(ctx, streamNext, requestParams, timeout)
+	if errors.Is(err, io.EOF) {
+		return nil, multierr.Combine(err, s.Close(ctx, timeout))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, ok := untyped.([]byte)
+	if !ok {
+		return nil, errors.New("unable to cast response from cartofacade to a byte slice")
+	}
+
+	return chunk, nil
+}
+
+// Close calls into the cartofacade C code.
+func (s *cartoStream) Close(ctx context.Context, timeout time.Duration) error {
+	if s.closed {
+		return nil
+	}
+	requestParams := map[RequestParamType]interface{}{
+		stream: s.stream,
+	}
+
+	if _, err := s.cf.request(ctx, streamClose, requestParams, timeout); err != nil {
+		return err
+	}
+	s.closed = true
+
+	return nil
+}
+
+// GetPointCloudMapStream calls into the cartofacade C code. The returned stream yields the same pcd as
+// GetPointCloudMap when opts is the zero value, otherwise the map is painted with the given options.
+func (cf *CartoFacade) GetPointCloudMapStream(ctx context.Context, timeout time.Duration, opts MapOptions) (Stream, error) {
+	requestParams := map[RequestParamType]interface{}{
+		mapOptions: opts,
+	}
+	return cf.requestStream(ctx, pointCloudMapStream, requestParams, timeout)
+}
+
+// GetInternalStateStream calls into the cartofacade C code. The returned stream yields the same pbstream as
+// GetInternalState.
+func (cf *CartoFacade) GetInternalStateStream(ctx context.Context, timeout time.Duration) (Stream, error) {
+	return cf.requestStream(ctx, internalStateStream, emptyRequestParams, timeout)
+}
+
+func (cf *CartoFacade) requestStream(
+	ctx context.Context,
+	requestType RequestType,
+	requestParams map[RequestParamType]interface{},
+	timeout time.Duration,
+) (Stream, error) {
+	untyped, err := cf.request(ctx, requestType, requestParams, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := untyped.(CartoStreamInterface)
+	if !ok {
+		return nil, errors.New("unable to cast response from cartofacade to a carto stream")
+	}
+
+	return &cartoStream{cf: cf, stream: s}, nil
+}
+
+// State returns the current lifecycle state of the cartofacade.
+func (cf *CartoFacade) State() State {
+	return cf.lifecycle.get()
+}
+
+// QueueWait returns the percentiles of how long the most recent requests waited for the cartofacade
+// goroutine to pick them up, which grows when requests are queued behind slow calls into C.
+func (cf *CartoFacade) QueueWait() QueueWait {
+	return cf.queueWaits.queueWait()
+}
+
+// OnStateTransition registers a handler which is called from the cartofacade goroutine after every
+// lifecycle state transition. The handler must not make requests to the cartofacade.
+func (cf *CartoFacade) OnStateTransition(handler func(StateTransition)) {
+	cf.lifecycle.setOnTransition(handler)
+}
+
+// GetStats calls into the cartofacade C code.
+func (cf *CartoFacade) GetStats(ctx context.Context, timeout time.Duration) (GetStats, error) {
+	untyped, err := cf.request(ctx, statistics, emptyRequestParams, timeout)
+	if err != nil {
+		return GetStats{}, err
+	}
+
+	getStats, ok := untyped.(GetStats)
+	if !ok {
+		return GetStats{}, errors.New("unable to cast response from cartofacade to a stats struct")
+	}
+
+	return getStats, nil
+}
+
+// SaveMap calls into the cartofacade C code. Saving the map with a final optimization holds up all
+// other requests until the optimization is done, so the timeout should account for it.
+func (cf *CartoFacade) SaveMap(
+	ctx context.Context,
+	timeout time.Duration,
+	path string,
+	runFinalOptimization bool,
+) (SaveMap, error) {
+	requestParams := map[RequestParamType]interface{}{
+		mapPath:           path,
+		finalOptimization: runFinalOptimization,
+	}
+
+	untyped, err := cf.request(ctx, saveMap, requestParams, timeout)
+	if err != nil {
+		return SaveMap{}, err
+	}
+
+	saveMap, ok := untyped.(SaveMap)
+	if !ok {
+		return SaveMap{}, errors.New("unable to cast response from cartofacade to a save map struct")
+	}
+
+	return saveMap, nil
+}
+
+// SetPose calls into the cartofacade C code.
+func (cf *CartoFacade) SetPose(ctx context.Context, timeout time.Duration, pose Pose2D) error {
+	requestParams := map[RequestParamType]interface{}{
+		initialPose: pose,
+	}
+
+	_, err := cf.request(ctx, setPose, requestParams, timeout)
+	return err
+}
+
+// SwitchToLocalization calls into the cartofacade C code. It freezes the map without optimizing it, so callers
+// should optimize the map with Optimize first. It returns the mode cartographer is in afterwards, which is set
+// even if switching failed, or UnknownMode if the request never reached cartographer.
+func (cf *CartoFacade) SwitchToLocalization(ctx context.Context, timeout time.Duration) (SlamMode, error) {
+	untyped, err := cf.request(ctx, switchToLocalization, emptyRequestParams, timeout)
+	if untyped == nil {
+		return UnknownMode, err
+	}
+
+	slamMode, ok := untyped.(SlamMode)
+	if !ok {
+		return UnknownMode, errors.New("unable to cast response from cartofacade to a slam mode")
+	}
+
+	return slamMode, err
+}
+
+// GetTrajectory calls into the cartofacade C code.
+func (cf *CartoFacade) GetTrajectory(
+	ctx context.Context,
+	timeout time.Duration,
+	allTrajectories bool,
+	everyNNodes int,
+) ([]TrajectoryNode, error) {
+	requestParams := map[RequestParamType]interface{}{
+		trajectories:   allTrajectories,
+		nodeDownsample: everyNNodes,
+	}
+
+	untyped, err := cf.request(ctx, getTrajectory, requestParams, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, ok := untyped.([]TrajectoryNode)
+	if !ok {
+		return nil, errors.New("unable to cast response from cartofacade to a slice of trajectory nodes")
+	}
+
+	return nodes, nil
+}
+
+// GetSubmaps calls into the cartofacade C code.
+func (cf *CartoFacade) GetSubmaps(ctx context.Context, timeout time.Duration) ([]Submap, error) {
+	untyped, err := cf.request(ctx, getSubmaps, emptyRequestParams, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	submaps, ok := untyped.([]Submap)
+	if !ok {
+		return nil, errors.New("unable to cast response from cartofacade to a slice of submaps")
+	}
+
+	return submaps, nil
+}
+
+// GetConfig calls into the cartofacade C code.
+func (cf *CartoFacade) GetConfig(ctx context.Context, timeout time.Duration) (GetConfig, error) {
+	untyped, err := cf.request(ctx, configuration, emptyRequestParams, timeout)
+	if err != nil {
+		return GetConfig{}, err
+	}
+
+	getConfig, ok := untyped.(GetConfig)
+	if !ok {
+		return GetConfig{}, errors.New("unable to cast response from cartofacade to a get config response")
+	}
+
+	return getConfig, nil
+}
+
+// GetOccupancyGrid calls into the cartofacade C code. The map is painted with the given options, the
+// resolution of the returned grid is coarser than requested if it would otherwise exceed opts.MaxPoints cells.
+func (cf *CartoFacade) GetOccupancyGrid(ctx context.Context, timeout time.Duration, opts MapOptions) (OccupancyGrid, error) {
+	requestParams := map[RequestParamType]interface{}{
+		mapOptions: opts,
+	}
+	untyped, err := cf.request(ctx, occupancyGrid, requestParams, timeout)
+	if err != nil {
+		return OccupancyGrid{}, err
+	}
+
+	grid, ok := untyped.(OccupancyGrid)
+	if !ok {
+		return OccupancyGrid{}, errors.New("unable to cast response from cartofacade to an occupancy grid")
+	}
+
+	return grid, nil
+}
+
+// GetSubmapGrids calls into the cartofacade C code. Each grid is painted in the frame of its submap, submaps
+// which don't exist are left out.
+func (cf *CartoFacade) GetSubmapGrids(ctx context.Context, timeout time.Duration, ids []SubmapID) ([]SubmapGrid, error) {
+	requestParams := map[RequestParamType]interface{}{
+		submapIDs: ids,
+	}
+	untyped, err := cf.request(ctx, submapGrids, requestParams, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	grids, ok := untyped.([]SubmapGrid)
+	if !ok {
+		return nil, errors.New("unable to cast response from cartofacade to a slice of submap grids")
+	}
+
+	return grids, nil
+}
+
+// Optimize calls into the cartofacade C code. It starts a final optimization, which runs in the background,
+// & waits for it to finish by polling its status. Each request only holds up the other requests for as long
+// as it takes to start the optimization or to read its status, so lidar readings keep being added while
+// the optimization runs. The timeout applies to each request, the wait is bounded by ctx.
+func (cf *CartoFacade) Optimize(ctx context.Context, timeout time.Duration) (OptimizationStatus, error) {
+	if _, err := cf.request(ctx, optimize, emptyRequestParams, timeout); err != nil {
+		return OptimizationStatus{}, err
+	}
+
+	ticker := time.NewTicker(optimizationPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return OptimizationStatus{}, ctx.Err()
+		case <-ticker.C:
+		}
+
+		untyped, err := cf.request(ctx, optimizationStatus, emptyRequestParams, timeout)
+		if err != nil {
+			return OptimizationStatus{}, err
+		}
+
+		status, ok := untyped.(OptimizationStatus)
+		if !ok {
+			return OptimizationStatus{}, errors.New("unable to cast response from cartofacade to an optimization status struct")
+		}
+		if !status.Running {
+			return status, nil
+		}
+	}
+}
+
 // RequestType defines the carto C API call that is being made.
 type RequestType int64
 
@@ -151,8 +465,92 @@ const (
 	internalState
 	// pointCloudMap represents the viam_carto_get_point_cloud_map in c.
 	pointCloudMap
+	// statistics represents the viam_carto_get_stats call in c.
+	statistics
+	// pointCloudMapStream represents the viam_carto_get_point_cloud_map_stream call in c.
+	pointCloudMapStream
+	// internalStateStream represents the viam_carto_get_internal_state_stream call in c.
+	internalStateStream
+	// streamNext represents the viam_carto_stream_next call in c.
+	streamNext
+	// streamClose represents the viam_carto_stream_destroy call in c.
+	streamClose
+	// saveMap represents the viam_carto_save_map call in c.
+	saveMap
+	// setPose represents the viam_carto_set_pose call in c.
+	setPose
+	// switchToLocalization represents the viam_carto_switch_to_localization call in c.
+	switchToLocalization
+	// optimize represents the viam_carto_optimize call in c.
+	optimize
+	// optimizationStatus represents the viam_carto_get_optimization_status call in c.
+	optimizationStatus
+	// getTrajectory represents the viam_carto_get_trajectory call in c.
+	getTrajectory
+	// getSubmaps represents the viam_carto_get_submaps call in c.
+	getSubmaps
+	// configuration represents the viam_carto_get_config call in c.
+	configuration
+	// occupancyGrid represents the viam_carto_get_occupancy_grid call in c.
+	occupancyGrid
+	// submapGrids represents the viam_carto_get_submap_grids call in c.
+	submapGrids
 )
 
+// String returns the name of the carto C API call, it is used to label the request's spans & metrics.
+func (r RequestType) String() string {
+	switch r {
+	case initialize:
+		return "initialize"
+	case start:
+		return "start"
+	case stop:
+		return "stop"
+	case terminate:
+		return "terminate"
+	case addLidarReading:
+		return "add_lidar_reading"
+	case position:
+		return "position"
+	case internalState:
+		return "internal_state"
+	case pointCloudMap:
+		return "point_cloud_map"
+	case statistics:
+		return "stats"
+	case pointCloudMapStream:
+		return "point_cloud_map_stream"
+	case internalStateStream:
+		return "internal_state_stream"
+	case streamNext:
+		return "stream_next"
+	case streamClose:
+		return "stream_close"
+	case saveMap:
+		return "save_map"
+	case setPose:
+		return "set_pose"
+	case switchToLocalization:
+		return "switch_to_localization"
+	case optimize:
+		return "optimize"
+	case optimizationStatus:
+		return "optimization_status"
+	case getTrajectory:
+		return "get_trajectory"
+	case getSubmaps:
+		return "get_submaps"
+	case configuration:
+		return "get_config"
+	case occupancyGrid:
+		return "get_occupancy_grid"
+	case submapGrids:
+		return "get_submap_grids"
+	default:
+		return "unknown"
+	}
+}
+
 // RequestParamType defines the type being provided as input to the work.
 type RequestParamType int64
 
@@ -163,12 +561,34 @@ const (
 	reading
 	// timestamp represents the timestamp input into c funcs.
 	timestamp
+	// stream represents a carto stream input into c funcs.
+	stream
+	// maxChunkSize represents the max chunk size in bytes input into c funcs.
+	maxChunkSize
+	// mapPath represents the path of a map file input into c funcs.
+	mapPath
+	// finalOptimization represents whether to run a final optimization input into c funcs.
+	finalOptimization
+	// initialPose represents the pose a trajectory starts at input into c funcs.
+	initialPose
+	// trajectories represents whether to return all trajectories rather than the active one input into c funcs.
+	trajectories
+	// nodeDownsample represents the number of nodes to advance between returned nodes input into c funcs.
+	nodeDownsample
+	// mapOptions represents the options the map is painted with input into c funcs.
+	mapOptions
+	// submapIDs represents the ids of the submaps input into c funcs.
+	submapIDs
 )
 
 // Response defines the result of one piece of work that can be put on the result channel.
 type Response struct {
 	result interface{}
 	err    error
+	// deliverySpan & doneAt track the time it takes for the response to be received. The span is ended by
+	// whoever sees the response last, the caller or the cartofacade goroutine if the request timed out.
+	deliverySpan *trace.Span
+	doneAt       time.Time
 }
 
 /*
@@ -181,6 +601,9 @@ type CartoFacade struct {
 	cartoConfig     CartoConfig
 	cartoAlgoConfig CartoAlgoConfig
 	requestChan     chan Request
+	lifecycle       *lifecycle
+	queueWaits      *latencyWindow
+	mapRevision     *mapRevision
 }
 
 // RequestInterface defines the functionality of a Request.
@@ -238,10 +661,71 @@ type Interface interface {
 		ctx context.Context,
 		timeout time.Duration,
 	) ([]byte, error)
+	GetPointCloudMapStream(
+		ctx context.Context,
+		timeout time.Duration,
+		opts MapOptions,
+	) (Stream, error)
+	GetInternalStateStream(
+		ctx context.Context,
+		timeout time.Duration,
+	) (Stream, error)
+	GetStats(
+		ctx context.Context,
+		timeout time.Duration,
+	) (GetStats, error)
+	SaveMap(
+		ctx context.Context,
+		timeout time.Duration,
+		path string,
+		runFinalOptimization bool,
+	) (SaveMap, error)
+	SetPose(
+		ctx context.Context,
+		timeout time.Duration,
+		pose Pose2D,
+	) error
+	SwitchToLocalization(
+		ctx context.Context,
+		timeout time.Duration,
+	) (SlamMode, error)
+	Optimize(
+		ctx context.Context,
+		timeout time.Duration,
+	) (OptimizationStatus, error)
+	GetTrajectory(
+		ctx context.Context,
+		timeout time.Duration,
+		allTrajectories bool,
+		everyNNodes int,
+	) ([]TrajectoryNode, error)
+	GetSubmaps(
+		ctx context.Context,
+		timeout time.Duration,
+	) ([]Submap, error)
+	GetConfig(
+		ctx context.Context,
+		timeout time.Duration,
+	) (GetConfig, error)
+	GetOccupancyGrid(
+		ctx context.Context,
+		timeout time.Duration,
+		opts MapOptions,
+	) (OccupancyGrid, error)
+	GetSubmapGrids(
+		ctx context.Context,
+		timeout time.Duration,
+		ids []SubmapID,
+	) ([]SubmapGrid, error)
+	MapRevision() (MapRevision, error)
+	State() State
+	QueueWait() QueueWait
 }
 
 // Request defines all of the necessary pieces to call into the CGo API.
 type Request struct {
+	// ctx carries the request's span & metric tags, it is not used for cancellation.
+	ctx           context.Context
 	responseChan  chan Response
 	requestType   RequestType
 	requestParams map[RequestParamType]interface{}
@@ -255,6 +739,9 @@ func New(cartoLib CartoLibInterface, cartoCfg CartoConfig, cartoAlgoCfg CartoAlg
 		cartoConfig:     cartoCfg,
 		cartoAlgoConfig: cartoAlgoCfg,
 		requestChan:     make(chan Request),
+		lifecycle:       &lifecycle{},
+		queueWaits:      newLatencyWindow(queueWaitWindowSize),
+		mapRevision:     &mapRevision{revision: MapRevision{ChangedAt: time.Now().UTC()}},
 	}
 }
 
@@ -269,8 +756,13 @@ func (r *Request) doWork(
 	case start:
 		return nil, cf.carto.start()
 	case stop:
+		// MapRevision reads the revision of a started carto without the cartofacade goroutine
+		cf.mapRevision.mu.Lock()
+		defer cf.mapRevision.mu.Unlock()
 		return nil, cf.carto.stop()
 	case terminate:
+		cf.mapRevision.mu.Lock()
+		defer cf.mapRevision.mu.Unlock()
 		return nil, cf.carto.terminate()
 	case addLidarReading:
 		lidar, ok := r.requestParams[lidar].(string)
@@ -295,11 +787,97 @@ func (r *Request) doWork(
 		return cf.carto.getInternalState()
 	case pointCloudMap:
 		return cf.carto.getPointCloudMap()
+	case statistics:
+		return cf.carto.getStats()
+	case pointCloudMapStream:
+		opts, ok := r.requestParams[mapOptions].(MapOptions)
+		if !ok {
+			return nil, errors.New("could not cast inputted map options to MapOptions")
+		}
+
+		return cf.carto.getPointCloudMapStream(opts)
+	case internalStateStream:
+		return cf.carto.getInternalStateStream()
+	case streamNext:
+		stream, ok := r.requestParams[stream].(CartoStreamInterface)
+		if !ok {
+			return nil, errors.New("could not cast inputted stream to a carto stream")
+		}
+
+		maxChunkSize, ok := r.requestParams[maxChunkSize].(int)
+		if !ok {
+			return nil, errors.New("could not cast inputted max chunk size to int")
+		}
+
+		return stream.next(maxChunkSize)
+	case streamClose:
+		stream, ok := r.requestParams[stream].(CartoStreamInterface)
+		if !ok {
+			return nil, errors.New("could not cast inputted stream to a carto stream")
+		}
+
+		return nil, stream.close()
+	case saveMap:
+		path, ok := r.requestParams[mapPath].(string)
+		if !ok {
+			return nil, errors.New("could not cast inputted map path to string")
+		}
+
+		runFinalOptimization, ok := r.requestParams[finalOptimization].(bool)
+		if !ok {
+			return nil, errors.New("could not cast inputted final optimization to bool")
+		}
+
+		return cf.carto.saveMap(path, runFinalOptimization)
+	case setPose:
+		pose, ok := r.requestParams[initialPose].(Pose2D)
+		if !ok {
+			return nil, errors.New("could not cast inputted pose to a Pose2D")
+		}
+
+		return nil, cf.carto.setPose(pose)
+	case switchToLocalization:
+		return cf.carto.switchToLocalization()
+	case optimize:
+		return nil, cf.carto.optimize()
+	case optimizationStatus:
+		return cf.carto.getOptimizationStatus()
+	case getTrajectory:
+		allTrajectories, ok := r.requestParams[trajectories].(bool)
+		if !ok {
+			return nil, errors.New("could not cast inputted trajectories to bool")
+		}
+
+		everyNNodes, ok := r.requestParams[nodeDownsample].(int)
+		if !ok {
+			return nil, errors.New("could not cast inputted node downsample to int")
+		}
+
+		return cf.carto.getTrajectory(allTrajectories, everyNNodes)
+	case getSubmaps:
+		return cf.carto.getSubmaps()
+	case configuration:
+		return cf.carto.getConfig()
+	case occupancyGrid:
+		opts, ok := r.requestParams[mapOptions].(MapOptions)
+		if !ok {
+			return nil, errors.New("could not cast inputted map options to MapOptions")
+		}
+
+		return cf.carto.getOccupancyGrid(opts)
+	case submapGrids:
+		ids, ok := r.requestParams[submapIDs].([]SubmapID)
+		if !ok {
+			return nil, errors.New("could not cast inputted submap ids to []SubmapID")
+		}
+
+		return cf.carto.getSubmapGrids(ids)
 	}
 	return nil, fmt.Errorf("no worktype found for: %v", r.requestType)
 }
 
 // request wraps calls into C. This function requires the caller to know which RequestTypes requires casting to which response values.
+// The time spent waiting for the C goroutine, executing in C & delivering the response are each traced & recorded as metrics.
 func (cf *CartoFacade) request(
 	ctxParent context.Context,
 	requestType RequestType,
@@ -309,24 +887,49 @@ func (cf *CartoFacade) request(
 	ctx, cancel := context.WithTimeout(ctxParent, timeout)
 	defer cancel()
 
+	ctx, span := trace.StartSpan(ctx, "cartofacade::CartoFacade::request")
+	defer span.End()
+	span.AddAttributes(trace.StringAttribute("request_type", requestType.String()))
+	ctx = withRequestTypeTag(ctx, requestType)
+
 	req := Request{
+		ctx:           ctx,
 		responseChan:  make(chan Response, 1),
 		requestType:   requestType,
 		requestParams: inputs,
 	}
 
 	// wait until work can call into C (and timeout if needed)
+	enqueueStart := time.Now()
+	_, enqueueSpan := trace.StartSpan(ctx, "cartofacade::CartoFacade::request::enqueue")
 	select {
 	case cf.requestChan <- req:
+		enqueueSpan.End()
+		stats.Record(ctx, enqueueWaitMs.M(msSince(enqueueStart)))
+		cf.queueWaits.record(time.Since(enqueueStart))
 		select {
 		case response := <-req.responseChan:
+			response.deliverySpan.End()
+			stats.Record(ctx, deliveryMs.M(msSince(response.doneAt)))
 			return response.result, response.err
 		case <-ctx.Done():
+			// the response may have been delivered along with the timeout, its delivery span is ended here
+			// & otherwise by the cartofacade goroutine, which sees that the request timed out
+			select {
+			case response := <-req.responseChan:
+				response.deliverySpan.End()
+			default:
+			}
 			msg := "timeout reading from cartographer"
+			span.SetStatus(trace.Status{Code: trace.StatusCodeDeadlineExceeded, Message: msg})
 			return nil, multierr.Combine(errors.New(msg), ctx.Err())
 		}
 	case <-ctx.Done():
+		enqueueSpan.End()
+		stats.Record(ctx, enqueueWaitMs.M(msSince(enqueueStart)))
+		cf.queueWaits.record(time.Since(enqueueStart))
 		msg := "timeout writing to cartographer"
+		span.SetStatus(trace.Status{Code: trace.StatusCodeDeadlineExceeded, Message: msg})
 		return nil, multierr.Combine(errors.New(msg), ctx.Err())
 	}
 }
@@ -342,8 +945,29 @@ func (cf *CartoFacade) startCGoroutine(ctx context.Context, activeBackgroundWork
 			case <-ctx.Done():
 				return
 			case workToDo := <-cf.requestChan:
+				// reject requests which are invalid in the current state without calling into C
+				if err := cf.lifecycle.check(workToDo.requestType); err != nil {
+					workToDo.responseChan <- Response{err: err, doneAt: time.Now()}
+					continue
+				}
+
+				_, executionSpan := trace.StartSpan(workToDo.ctx, "cartofacade::CartoFacade::doWork")
+				executionStart := time.Now()
 				result, err := workToDo.doWork(cf)
-				workToDo.responseChan <- Response{result: result, err: err}
+				if err == nil {
+					cf.lifecycle.transition(workToDo.requestType)
+				}
+				// recorded here rather than by the caller so that requests which timed out are still recorded
+				stats.Record(workToDo.ctx, executionMs.M(msSince(executionStart)))
+				executionSpan.End()
+
+				_, deliverySpan := trace.StartSpan(workToDo.ctx, "cartofacade::CartoFacade::request::deliver")
+				workToDo.responseChan <- Response{result: result, err: err, deliverySpan: deliverySpan, doneAt: time.Now()}
+				// the caller may have timed out before the response was delivered, in which case nobody else ends
+				// the span. Ending a span twice is a no-op.
+				if workToDo.ctx.Err() != nil {
+					deliverySpan.End()
+				}
 			}
 		}
 	}()
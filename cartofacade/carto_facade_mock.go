@@ -70,6 +70,65 @@ type Mock struct {
 		ctx context.Context,
 		timeout time.Duration,
 	) ([]byte, error)
+	GetPointCloudMapStreamFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+		opts MapOptions,
+	) (Stream, error)
+	GetInternalStateStreamFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+	) (Stream, error)
+	GetStatsFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+	) (GetStats, error)
+	SaveMapFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+		path string,
+		runFinalOptimization bool,
+	) (SaveMap, error)
+	SetPoseFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+		pose Pose2D,
+	) error
+	SwitchToLocalizationFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+	) (SlamMode, error)
+	OptimizeFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+	) (OptimizationStatus, error)
+	GetTrajectoryFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+		allTrajectories bool,
+		everyNNodes int,
+	) ([]TrajectoryNode, error)
+	GetSubmapsFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+	) ([]Submap, error)
+	GetConfigFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+	) (GetConfig, error)
+	GetOccupancyGridFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+		opts MapOptions,
+	) (OccupancyGrid, error)
+	GetSubmapGridsFunc func(
+		ctx context.Context,
+		timeout time.Duration,
+		ids []SubmapID,
+	) ([]SubmapGrid, error)
+	MapRevisionFunc func() (MapRevision, error)
+	StateFunc       func() State
+	QueueWaitFunc   func() QueueWait
 }
 
 // request calls the injected requestFunc or the real version.
@@ -187,3 +246,167 @@ func (cf *Mock) GetPointCloudMap(
 	}
 	return cf.GetPointCloudMapFunc(ctx, timeout)
 }
+
+// GetPointCloudMapStream calls the injected GetPointCloudMapStreamFunc or the real version.
+func (cf *Mock) GetPointCloudMapStream(
+	ctx context.Context,
+	timeout time.Duration,
+	opts MapOptions,
+) (Stream, error) {
+	if cf.GetPointCloudMapStreamFunc == nil {
+		return cf.CartoFacade.GetPointCloudMapStream(ctx, timeout, opts)
+	}
+	return cf.GetPointCloudMapStreamFunc(ctx, timeout, opts)
+}
+
+// GetInternalStateStream calls the injected GetInternalStateStreamFunc or the real version.
+func (cf *Mock) GetInternalStateStream(
+	ctx context.Context,
+	timeout time.Duration,
+) (Stream, error) {
+	if cf.GetInternalStateStreamFunc == nil {
+		return cf.CartoFacade.GetInternalStateStream(ctx, timeout)
+	}
+	return cf.GetInternalStateStreamFunc(ctx, timeout)
+}
+
+// GetStats calls the injected GetStatsFunc or the real version.
+func (cf *Mock) GetStats(
+	ctx context.Context,
+	timeout time.Duration,
+) (GetStats, error) {
+	if cf.GetStatsFunc == nil {
+		return cf.CartoFacade.GetStats(ctx, timeout)
+	}
+	return cf.GetStatsFunc(ctx, timeout)
+}
+
+// SaveMap calls the injected SaveMapFunc or the real version.
+func (cf *Mock) SaveMap(
+	ctx context.Context,
+	timeout time.Duration,
+	path string,
+	runFinalOptimization bool,
+) (SaveMap, error) {
+	if cf.SaveMapFunc == nil {
+		return cf.CartoFacade.SaveMap(ctx, timeout, path, runFinalOptimization)
+	}
+	return cf.SaveMapFunc(ctx, timeout, path, runFinalOptimization)
+}
+
+// SetPose calls the injected SetPoseFunc or the real version.
+func (cf *Mock) SetPose(
+	ctx context.Context,
+	timeout time.Duration,
+	pose Pose2D,
+) error {
+	if cf.SetPoseFunc == nil {
+		return cf.CartoFacade.SetPose(ctx, timeout, pose)
+	}
+	return cf.SetPoseFunc(ctx, timeout, pose)
+}
+
+// SwitchToLocalization calls the injected SwitchToLocalizationFunc or the real version.
+func (cf *Mock) SwitchToLocalization(
+	ctx context.Context,
+	timeout time.Duration,
+) (SlamMode, error) {
+	if cf.SwitchToLocalizationFunc == nil {
+		return cf.CartoFacade.SwitchToLocalization(ctx, timeout)
+	}
+	return cf.SwitchToLocalizationFunc(ctx, timeout)
+}
+
+// Optimize calls the injected OptimizeFunc or the real version.
+func (cf *Mock) Optimize(
+	ctx context.Context,
+	timeout time.Duration,
+) (OptimizationStatus, error) {
+	if cf.OptimizeFunc == nil {
+		return cf.CartoFacade.Optimize(ctx, timeout)
+	}
+	return cf.OptimizeFunc(ctx, timeout)
+}
+
+// GetTrajectory calls the injected GetTrajectoryFunc or the real version.
+func (cf *Mock) GetTrajectory(
+	ctx context.Context,
+	timeout time.Duration,
+	allTrajectories bool,
+	everyNNodes int,
+) ([]TrajectoryNode, error) {
+	if cf.GetTrajectoryFunc == nil {
+		return cf.CartoFacade.GetTrajectory(ctx, timeout, allTrajectories, everyNNodes)
+	}
+	return cf.GetTrajectoryFunc(ctx, timeout, allTrajectories, everyNNodes)
+}
+
+// GetSubmaps calls the injected GetSubmapsFunc or the real version.
+func (cf *Mock) GetSubmaps(
+	ctx context.Context,
+	timeout time.Duration,
+) ([]Submap, error) {
+	if cf.GetSubmapsFunc == nil {
+		return cf.CartoFacade.GetSubmaps(ctx, timeout)
+	}
+	return cf.GetSubmapsFunc(ctx, timeout)
+}
+
+// GetConfig calls the injected GetConfigFunc or the real version.
+func (cf *Mock) GetConfig(
+	ctx context.Context,
+	timeout time.Duration,
+) (GetConfig, error) {
+	if cf.GetConfigFunc == nil {
+		return cf.CartoFacade.GetConfig(ctx, timeout)
+	}
+	return cf.GetConfigFunc(ctx, timeout)
+}
+
+// GetOccupancyGrid calls the injected GetOccupancyGridFunc or the real version.
+func (cf *Mock) GetOccupancyGrid(
+	ctx context.Context,
+	timeout time.Duration,
+	opts MapOptions,
+) (OccupancyGrid, error) {
+	if cf.GetOccupancyGridFunc == nil {
+		return cf.CartoFacade.GetOccupancyGrid(ctx, timeout, opts)
+	}
+	return cf.GetOccupancyGridFunc(ctx, timeout, opts)
+}
+
+// GetSubmapGrids calls the injected GetSubmapGridsFunc or the real version.
+func (cf *Mock) GetSubmapGrids(
+	ctx context.Context,
+	timeout time.Duration,
+	ids []SubmapID,
+) ([]SubmapGrid, error) {
+	if cf.GetSubmapGridsFunc == nil {
+		return cf.CartoFacade.GetSubmapGrids(ctx, timeout, ids)
+	}
+	return cf.GetSubmapGridsFunc(ctx, timeout, ids)
+}
+
+// MapRevision calls the injected MapRevisionFunc or the real version.
+func (cf *Mock) MapRevision() (MapRevision, error) {
+	if cf.MapRevisionFunc == nil {
+		return cf.CartoFacade.MapRevision()
+	}
+	return cf.MapRevisionFunc()
+}
+
+// State calls the injected StateFunc or the real version.
+func (cf *Mock) State() State {
+	if cf.StateFunc == nil {
+		return cf.CartoFacade.State()
+	}
+	return cf.StateFunc()
+}
+
+// QueueWait calls the injected QueueWaitFunc or the real version.
+func (cf *Mock) QueueWait() QueueWait {
+	if cf.QueueWaitFunc == nil {
+		return cf.CartoFacade.QueueWait()
+	}
+	return cf.QueueWaitFunc()
+}
@@ -5,11 +5,14 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"os"
 	"sync"
 	"testing"
 	"time"
 
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
 	"go.uber.org/multierr"
 	"go.viam.com/rdk/pointcloud"
 	"go.viam.com/test"
@@ -40,6 +43,7 @@ func TestRequest(t *testing.T) {
 
 		cf := New(&cartoLib, config, algoConfig)
 		cf.carto = &carto
+		cf.lifecycle.set(StartedState)
 		cf.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
 		res, err := cf.request(cancelCtx, position, map[RequestParamType]interface{}{}, 5*time.Second)
@@ -67,6 +71,7 @@ func TestRequest(t *testing.T) {
 
 		cf := New(&cartoLib, config, algoConfig)
 		cf.carto = &carto
+		cf.lifecycle.set(IOInitializedState)
 		cf.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
 		_, err = cf.request(cancelCtx, start, map[RequestParamType]interface{}{}, 5*time.Second)
@@ -93,6 +98,7 @@ func TestRequest(t *testing.T) {
 
 		cf := New(&cartoLib, config, algoConfig)
 		cf.carto = &carto
+		cf.lifecycle.set(IOInitializedState)
 		cf.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 		cancelFunc()
 		activeBackgroundWorkers.Wait()
@@ -122,13 +128,67 @@ func TestRequest(t *testing.T) {
 
 		cf := New(&cartoLib, config, algoConfig)
 		cf.carto = &carto
+		cf.lifecycle.set(IOInitializedState)
 		cf.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
+		spans := &spanRecorder{}
+		trace.RegisterExporter(spans)
+		defer trace.UnregisterExporter(spans)
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+		defer trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)})
+
 		_, err = cf.request(cancelCtx, start, map[RequestParamType]interface{}{}, 10*time.Millisecond)
 		test.That(t, err, test.ShouldBeError)
 		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
 		test.That(t, err, test.ShouldResemble, expectedErr)
 
+		cancelFunc()
+		activeBackgroundWorkers.Wait()
+		// the delivery span of the response nobody received is still ended
+		test.That(t, spans.ended("cartofacade::CartoFacade::request::deliver"), test.ShouldEqual, 1)
+	})
+
+	t.Run("test request latencies are recorded per request type", func(t *testing.T) {
+		test.That(t, view.Register(RequestViews...), test.ShouldBeNil)
+		defer view.Unregister(RequestViews...)
+
+		cancelCtx, cancelFunc := context.WithCancel(context.Background())
+		activeBackgroundWorkers := sync.WaitGroup{}
+
+		config, dir, err := GetTestConfig("mysensor", "")
+		defer os.RemoveAll(dir)
+		test.That(t, err, test.ShouldBeNil)
+
+		algoConfig := GetTestAlgoConfig()
+		carto := CartoMock{}
+		carto.GetPositionFunc = func() (GetPosition, error) {
+			time.Sleep(20 * time.Millisecond)
+			return GetPosition{}, nil
+		}
+
+		cf := New(&cartoLib, config, algoConfig)
+		cf.carto = &carto
+		cf.lifecycle.set(StartedState)
+		cf.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+		_, err = cf.request(cancelCtx, position, map[RequestParamType]interface{}{}, 5*time.Second)
+		test.That(t, err, test.ShouldBeNil)
+
+		for _, v := range RequestViews {
+			rows, err := view.RetrieveData(v.Name)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, len(rows), test.ShouldEqual, 1)
+			test.That(t, rows[0].Tags[0].Value, test.ShouldEqual, "position")
+			dist, ok := rows[0].Data.(*view.DistributionData)
+			test.That(t, ok, test.ShouldBeTrue)
+			test.That(t, dist.Count, test.ShouldEqual, 1)
+			if v.Name == executionMs.Name() {
+				test.That(t, dist.Mean, test.ShouldBeGreaterThanOrEqualTo, 20)
+			}
+		}
+		// the queue wait is kept regardless of whether the views are registered
+		test.That(t, cf.QueueWait().Count, test.ShouldEqual, 1)
+
 		cancelFunc()
 		activeBackgroundWorkers.Wait()
 	})
@@ -137,6 +197,33 @@ func TestRequest(t *testing.T) {
 	test.That(t, err, test.ShouldBeNil)
 }
 
+func TestQueueWait(t *testing.T) {
+	t.Run("no requests have waited", func(t *testing.T) {
+		test.That(t, newLatencyWindow(10).queueWait(), test.ShouldResemble, QueueWait{})
+		var w *latencyWindow
+		w.record(time.Second)
+		test.That(t, w.queueWait(), test.ShouldResemble, QueueWait{})
+	})
+
+	t.Run("percentiles are computed over the most recent requests", func(t *testing.T) {
+		w := newLatencyWindow(100)
+		// the oldest requests are dropped from the window
+		for i := 0; i < 50; i++ {
+			w.record(time.Hour)
+		}
+		for i := 100; i >= 1; i-- {
+			w.record(time.Duration(i) * time.Millisecond)
+		}
+		test.That(t, w.queueWait(), test.ShouldResemble, QueueWait{
+			Count: 100,
+			P50:   50 * time.Millisecond,
+			P90:   90 * time.Millisecond,
+			P99:   99 * time.Millisecond,
+			Max:   100 * time.Millisecond,
+		})
+	})
+}
+
 func TestInitialize(t *testing.T) {
 	lib, err := NewLib(1, 1)
 	test.That(t, err, test.ShouldBeNil)
@@ -182,6 +269,7 @@ func TestStart(t *testing.T) {
 		return nil
 	}
 	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(IOInitializedState)
 	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
 	t.Run("testing Start", func(t *testing.T) {
@@ -193,6 +281,7 @@ func TestStart(t *testing.T) {
 			return errors.New("test error 1")
 		}
 		cartoFacade.carto = &carto
+		cartoFacade.lifecycle.set(IOInitializedState)
 
 		// returns error
 		err = cartoFacade.Start(cancelCtx, 5*time.Second)
@@ -233,6 +322,7 @@ func TestStop(t *testing.T) {
 		return nil
 	}
 	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
 	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
 	t.Run("testing Stop", func(t *testing.T) {
@@ -244,6 +334,7 @@ func TestStop(t *testing.T) {
 			return errors.New("test error 2")
 		}
 		cartoFacade.carto = &carto
+		cartoFacade.lifecycle.set(StartedState)
 
 		// returns error
 		err = cartoFacade.Stop(cancelCtx, 5*time.Second)
@@ -284,6 +375,7 @@ func TestTerminate(t *testing.T) {
 		return nil
 	}
 	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(IOInitializedState)
 	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
 	t.Run("testing Terminate", func(t *testing.T) {
@@ -295,6 +387,7 @@ func TestTerminate(t *testing.T) {
 			return errors.New("test error 3")
 		}
 		cartoFacade.carto = &carto
+		cartoFacade.lifecycle.set(IOInitializedState)
 
 		// returns error
 		err = cartoFacade.Terminate(cancelCtx, 5*time.Second)
@@ -335,6 +428,7 @@ func TestAddSensorReading(t *testing.T) {
 		return nil
 	}
 	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
 	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
 	t.Run("testing AddLidarReading", func(t *testing.T) {
@@ -398,6 +492,7 @@ func TestGetPosition(t *testing.T) {
 		return pos, nil
 	}
 	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
 	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
 	t.Run("testing GetPosition", func(t *testing.T) {
@@ -453,6 +548,7 @@ func TestGetInternalState(t *testing.T) {
 		return internalState, nil
 	}
 	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
 	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
 	t.Run("testing GetInternalState", func(t *testing.T) {
@@ -506,6 +602,7 @@ func TestGetPointCloudMap(t *testing.T) {
 		return internalState, nil
 	}
 	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
 	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
 
 	t.Run("testing GetPointCloudMap", func(t *testing.T) {
@@ -540,3 +637,989 @@ func TestGetPointCloudMap(t *testing.T) {
 	cancelFunc()
 	activeBackgroundWorkers.Wait()
 }
+
+func TestGetStreams(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	// newStream returns a carto stream which yields data in chunks & counts how often it has been closed
+	newStream := func(data []byte, closed *int) *CartoStreamMock {
+		s := &CartoStreamMock{}
+		s.NextFunc = func(maxChunkSizeBytes int) ([]byte, error) {
+			if len(data) == 0 {
+				return nil, io.EOF
+			}
+			n := maxChunkSizeBytes
+			if n > len(data) {
+				n = len(data)
+			}
+			chunk := data[:n]
+			data = data[n:]
+			return chunk, nil
+		}
+		s.CloseFunc = func() error {
+			*closed++
+			return nil
+		}
+		return s
+	}
+
+	getStreams := map[string]struct {
+		get func(ctx context.Context, timeout time.Duration) (Stream, error)
+		set func(func() (CartoStreamInterface, error))
+	}{
+		"GetPointCloudMapStream": {
+			get: func(ctx context.Context, timeout time.Duration) (Stream, error) {
+				return cartoFacade.GetPointCloudMapStream(ctx, timeout, MapOptions{})
+			},
+			set: func(f func() (CartoStreamInterface, error)) {
+				carto.GetPointCloudMapStreamFunc = func(opts MapOptions) (CartoStreamInterface, error) { return f() }
+			},
+		},
+		"GetInternalStateStream": {
+			get: cartoFacade.GetInternalStateStream,
+			set: func(f func() (CartoStreamInterface, error)) { carto.GetInternalStateStreamFunc = f },
+		},
+	}
+
+	for name, getStream := range getStreams {
+		t.Run(name+" yields chunks & closes the stream once it has been read", func(t *testing.T) {
+			closed := 0
+			getStream.set(func() (CartoStreamInterface, error) {
+				return newStream([]byte("hello!"), &closed), nil
+			})
+
+			s, err := getStream.get(cancelCtx, 5*time.Second)
+			test.That(t, err, test.ShouldBeNil)
+
+			chunk, err := s.Next(cancelCtx, 5*time.Second, 4)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, chunk, test.ShouldResemble, []byte("hell"))
+
+			chunk, err = s.Next(cancelCtx, 5*time.Second, 4)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, chunk, test.ShouldResemble, []byte("o!"))
+			test.That(t, closed, test.ShouldEqual, 0)
+
+			_, err = s.Next(cancelCtx, 5*time.Second, 4)
+			test.That(t, err, test.ShouldBeError, io.EOF)
+			test.That(t, closed, test.ShouldEqual, 1)
+
+			// a closed stream doesn't call into C anymore
+			_, err = s.Next(cancelCtx, 5*time.Second, 4)
+			test.That(t, err, test.ShouldBeError, io.EOF)
+			test.That(t, s.Close(cancelCtx, 5*time.Second), test.ShouldBeNil)
+			test.That(t, closed, test.ShouldEqual, 1)
+		})
+
+		t.Run(name+" returns errors", func(t *testing.T) {
+			getStream.set(func() (CartoStreamInterface, error) {
+				return nil, errors.New("test error 8")
+			})
+
+			_, err := getStream.get(cancelCtx, 5*time.Second)
+			test.That(t, err, test.ShouldBeError, errors.New("test error 8"))
+
+			closed := 0
+			failing := newStream([]byte("hello!"), &closed)
+			failing.NextFunc = func(maxChunkSizeBytes int) ([]byte, error) {
+				return nil, errors.New("test error 9")
+			}
+			getStream.set(func() (CartoStreamInterface, error) {
+				return failing, nil
+			})
+
+			s, err := getStream.get(cancelCtx, 5*time.Second)
+			test.That(t, err, test.ShouldBeNil)
+			_, err = s.Next(cancelCtx, 5*time.Second, 4)
+			test.That(t, err, test.ShouldBeError, errors.New("test error 9"))
+
+			// the stream is left open to be closed by the caller
+			test.That(t, closed, test.ShouldEqual, 0)
+			test.That(t, s.Close(cancelCtx, 5*time.Second), test.ShouldBeNil)
+			test.That(t, closed, test.ShouldEqual, 1)
+		})
+
+		t.Run(name+" can only be created when started but can be read after stopping", func(t *testing.T) {
+			closed := 0
+			getStream.set(func() (CartoStreamInterface, error) {
+				return newStream([]byte("hello!"), &closed), nil
+			})
+
+			s, err := getStream.get(cancelCtx, 5*time.Second)
+			test.That(t, err, test.ShouldBeNil)
+
+			cartoFacade.lifecycle.set(IOInitializedState)
+			defer cartoFacade.lifecycle.set(StartedState)
+
+			_, err = getStream.get(cancelCtx, 5*time.Second)
+			test.That(t, err, test.ShouldBeError, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE"))
+
+			chunk, err := s.Next(cancelCtx, 5*time.Second, 16)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, chunk, test.ShouldResemble, []byte("hello!"))
+			test.That(t, s.Close(cancelCtx, 5*time.Second), test.ShouldBeNil)
+			test.That(t, closed, test.ShouldEqual, 1)
+		})
+	}
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestGetStats(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	carto.GetStatsFunc = func() (GetStats, error) {
+		return GetStats{Trajectories: 1, Nodes: 2, Constraints: 3}, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing GetStats", func(t *testing.T) {
+		// success case
+		stats, err := cartoFacade.GetStats(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, stats, test.ShouldResemble, GetStats{Trajectories: 1, Nodes: 2, Constraints: 3})
+
+		carto.GetStatsFunc = func() (GetStats, error) {
+			return GetStats{}, errors.New("test error 8")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error
+		_, err = cartoFacade.GetStats(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 8"))
+
+		carto.GetStatsFunc = func() (GetStats, error) {
+			time.Sleep(50 * time.Millisecond)
+			return GetStats{}, nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		_, err = cartoFacade.GetStats(cancelCtx, 1*time.Millisecond)
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestSaveMap(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	var savedPath string
+	var savedWithFinalOptimization bool
+	carto.SaveMapFunc = func(path string, runFinalOptimization bool) (SaveMap, error) {
+		savedPath = path
+		savedWithFinalOptimization = runFinalOptimization
+		return SaveMap{Nodes: 42}, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing SaveMap", func(t *testing.T) {
+		// success case
+		saved, err := cartoFacade.SaveMap(cancelCtx, 5*time.Second, "some/path.pbstream", true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, saved, test.ShouldResemble, SaveMap{Nodes: 42})
+		test.That(t, savedPath, test.ShouldEqual, "some/path.pbstream")
+		test.That(t, savedWithFinalOptimization, test.ShouldBeTrue)
+
+		carto.SaveMapFunc = func(path string, runFinalOptimization bool) (SaveMap, error) {
+			return SaveMap{}, errors.New("test error 9")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error
+		_, err = cartoFacade.SaveMap(cancelCtx, 5*time.Second, "some/path.pbstream", false)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 9"))
+
+		carto.SaveMapFunc = func(path string, runFinalOptimization bool) (SaveMap, error) {
+			time.Sleep(50 * time.Millisecond)
+			return SaveMap{}, nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		_, err = cartoFacade.SaveMap(cancelCtx, 1*time.Millisecond, "some/path.pbstream", false)
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestSetPose(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	var setPose Pose2D
+	carto.SetPoseFunc = func(pose Pose2D) error {
+		setPose = pose
+		return nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing SetPose", func(t *testing.T) {
+		// success case
+		err := cartoFacade.SetPose(cancelCtx, 5*time.Second, Pose2D{X: 1, Y: 2, Theta: 3})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, setPose, test.ShouldResemble, Pose2D{X: 1, Y: 2, Theta: 3})
+
+		carto.SetPoseFunc = func(pose Pose2D) error {
+			return errors.New("test error 10")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error
+		err = cartoFacade.SetPose(cancelCtx, 5*time.Second, Pose2D{})
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 10"))
+
+		carto.SetPoseFunc = func(pose Pose2D) error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		err = cartoFacade.SetPose(cancelCtx, 1*time.Millisecond, Pose2D{})
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestSwitchToLocalization(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	switched := false
+	carto.SwitchToLocalizationFunc = func() (SlamMode, error) {
+		switched = true
+		return LocalizingMode, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing SwitchToLocalization", func(t *testing.T) {
+		// success case
+		slamMode, err := cartoFacade.SwitchToLocalization(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, slamMode, test.ShouldEqual, LocalizingMode)
+		test.That(t, switched, test.ShouldBeTrue)
+
+		carto.SwitchToLocalizationFunc = func() (SlamMode, error) {
+			return MappingMode, errors.New("test error 16")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error along with the mode cartographer is in
+		slamMode, err = cartoFacade.SwitchToLocalization(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 16"))
+		test.That(t, slamMode, test.ShouldEqual, MappingMode)
+
+		// returns the mode if switching failed after freezing the map
+		carto.SwitchToLocalizationFunc = func() (SlamMode, error) {
+			return LocalizingMode, errors.New("test error 17")
+		}
+		slamMode, err = cartoFacade.SwitchToLocalization(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 17"))
+		test.That(t, slamMode, test.ShouldEqual, LocalizingMode)
+
+		carto.SwitchToLocalizationFunc = func() (SlamMode, error) {
+			time.Sleep(50 * time.Millisecond)
+			return LocalizingMode, nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		slamMode, err = cartoFacade.SwitchToLocalization(cancelCtx, 1*time.Millisecond)
+		test.That(t, slamMode, test.ShouldEqual, UnknownMode)
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestOptimize(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	carto.OptimizeFunc = func() error {
+		return nil
+	}
+	statusCalls := 0
+	carto.GetOptimizationStatusFunc = func() (OptimizationStatus, error) {
+		statusCalls++
+		if statusCalls < 3 {
+			return OptimizationStatus{Running: true}, nil
+		}
+		return OptimizationStatus{Duration: time.Second, ResidualBefore: 2, ResidualAfter: 1}, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing Optimize", func(t *testing.T) {
+		// success case, waits until the optimization is no longer running
+		status, err := cartoFacade.Optimize(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, statusCalls, test.ShouldEqual, 3)
+		test.That(t, status, test.ShouldResemble, OptimizationStatus{Duration: time.Second, ResidualBefore: 2, ResidualAfter: 1})
+
+		carto.OptimizeFunc = func() error {
+			return errors.New("test error 11")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error when the optimization can't be started
+		_, err = cartoFacade.Optimize(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 11"))
+
+		carto.OptimizeFunc = func() error {
+			return nil
+		}
+		carto.GetOptimizationStatusFunc = func() (OptimizationStatus, error) {
+			return OptimizationStatus{}, errors.New("test error 12")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error when the status can't be read
+		_, err = cartoFacade.Optimize(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 12"))
+
+		carto.GetOptimizationStatusFunc = func() (OptimizationStatus, error) {
+			return OptimizationStatus{Running: true}, nil
+		}
+		cartoFacade.carto = &carto
+
+		// returns the context error when the optimization doesn't finish in time
+		ctx, cancel := context.WithTimeout(cancelCtx, 300*time.Millisecond)
+		defer cancel()
+		_, err = cartoFacade.Optimize(ctx, 5*time.Second)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, errors.Is(err, context.DeadlineExceeded), test.ShouldBeTrue)
+		// wait for a status request which may still be in flight, as requests are handled in order
+		_, err = cartoFacade.request(cancelCtx, optimizationStatus, emptyRequestParams, 5*time.Second)
+		test.That(t, err, test.ShouldBeNil)
+
+		carto.OptimizeFunc = func() error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		_, err = cartoFacade.Optimize(cancelCtx, 1*time.Millisecond)
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestGetTrajectory(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	timestamp := time.Date(2021, 8, 15, 14, 30, 45, 0, time.UTC)
+	expectedNodes := []TrajectoryNode{
+		{TrajectoryID: 1, NodeIndex: 0, Time: timestamp, X: 100, Y: 200, Real: 1},
+		{TrajectoryID: 1, NodeIndex: 5, Time: timestamp.Add(time.Second), X: 300, Y: 400, Real: 1},
+	}
+	var requestedAllTrajectories bool
+	var requestedEveryNNodes int
+	carto.GetTrajectoryFunc = func(allTrajectories bool, everyNNodes int) ([]TrajectoryNode, error) {
+		requestedAllTrajectories = allTrajectories
+		requestedEveryNNodes = everyNNodes
+		return expectedNodes, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing GetTrajectory", func(t *testing.T) {
+		// success case
+		nodes, err := cartoFacade.GetTrajectory(cancelCtx, 5*time.Second, true, 5)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, nodes, test.ShouldResemble, expectedNodes)
+		test.That(t, requestedAllTrajectories, test.ShouldBeTrue)
+		test.That(t, requestedEveryNNodes, test.ShouldEqual, 5)
+
+		carto.GetTrajectoryFunc = func(allTrajectories bool, everyNNodes int) ([]TrajectoryNode, error) {
+			return nil, errors.New("test error 13")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error
+		_, err = cartoFacade.GetTrajectory(cancelCtx, 5*time.Second, false, 1)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 13"))
+
+		carto.GetTrajectoryFunc = func(allTrajectories bool, everyNNodes int) ([]TrajectoryNode, error) {
+			time.Sleep(50 * time.Millisecond)
+			return expectedNodes, nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		_, err = cartoFacade.GetTrajectory(cancelCtx, 1*time.Millisecond, false, 1)
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestGetSubmaps(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	expectedSubmaps := []Submap{
+		{SubmapIndex: 0, Version: 180, Finished: true, ResolutionMeters: 0.05, Real: 1, MinX: -1000, MinY: -1000, MaxX: 1000, MaxY: 1000},
+		{SubmapIndex: 1, Version: 90, ResolutionMeters: 0.05, X: 500, Real: 1, MinX: -500, MinY: -1000, MaxX: 1500, MaxY: 1000},
+	}
+	carto.GetSubmapsFunc = func() ([]Submap, error) {
+		return expectedSubmaps, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing GetSubmaps", func(t *testing.T) {
+		// success case
+		submaps, err := cartoFacade.GetSubmaps(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, submaps, test.ShouldResemble, expectedSubmaps)
+
+		carto.GetSubmapsFunc = func() ([]Submap, error) {
+			return nil, errors.New("test error 14")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error
+		_, err = cartoFacade.GetSubmaps(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 14"))
+
+		carto.GetSubmapsFunc = func() ([]Submap, error) {
+			time.Sleep(50 * time.Millisecond)
+			return expectedSubmaps, nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		_, err = cartoFacade.GetSubmaps(cancelCtx, 1*time.Millisecond)
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestGetOccupancyGrid(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	expectedGrid := OccupancyGrid{
+		Width:            2,
+		Height:           1,
+		ResolutionMeters: 0.05,
+		OriginX:          -0.025,
+		OriginY:          -0.025,
+		Cells:            []byte{0, OccupancyGridUnknownCell},
+	}
+	expectedOpts := MapOptions{ResolutionMeters: 0.1, MaxPoints: 100}
+	var receivedOpts MapOptions
+	carto.GetOccupancyGridFunc = func(opts MapOptions) (OccupancyGrid, error) {
+		receivedOpts = opts
+		return expectedGrid, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing GetOccupancyGrid", func(t *testing.T) {
+		// success case
+		grid, err := cartoFacade.GetOccupancyGrid(cancelCtx, 5*time.Second, expectedOpts)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, grid, test.ShouldResemble, expectedGrid)
+		test.That(t, receivedOpts, test.ShouldResemble, expectedOpts)
+
+		carto.GetOccupancyGridFunc = func(opts MapOptions) (OccupancyGrid, error) {
+			return OccupancyGrid{}, errors.New("test error 17")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error
+		_, err = cartoFacade.GetOccupancyGrid(cancelCtx, 5*time.Second, MapOptions{})
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 17"))
+
+		carto.GetOccupancyGridFunc = func(opts MapOptions) (OccupancyGrid, error) {
+			time.Sleep(50 * time.Millisecond)
+			return expectedGrid, nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		_, err = cartoFacade.GetOccupancyGrid(cancelCtx, 1*time.Millisecond, MapOptions{})
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestGetSubmapGrids(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	expectedGrids := []SubmapGrid{
+		{
+			SubmapIndex: 1,
+			Version:     90,
+			Grid: OccupancyGrid{
+				Width:            2,
+				Height:           1,
+				ResolutionMeters: 0.05,
+				OriginX:          -0.025,
+				OriginY:          -0.025,
+				Cells:            []byte{0, OccupancyGridUnknownCell},
+			},
+		},
+	}
+	expectedIDs := []SubmapID{{SubmapIndex: 1}, {TrajectoryID: 1}}
+	var receivedIDs []SubmapID
+	carto.GetSubmapGridsFunc = func(ids []SubmapID) ([]SubmapGrid, error) {
+		receivedIDs = ids
+		return expectedGrids, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing GetSubmapGrids", func(t *testing.T) {
+		// success case
+		grids, err := cartoFacade.GetSubmapGrids(cancelCtx, 5*time.Second, expectedIDs)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, grids, test.ShouldResemble, expectedGrids)
+		test.That(t, receivedIDs, test.ShouldResemble, expectedIDs)
+
+		carto.GetSubmapGridsFunc = func(ids []SubmapID) ([]SubmapGrid, error) {
+			return nil, errors.New("test error 18")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error
+		_, err = cartoFacade.GetSubmapGrids(cancelCtx, 5*time.Second, expectedIDs)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 18"))
+
+		carto.GetSubmapGridsFunc = func(ids []SubmapID) ([]SubmapGrid, error) {
+			time.Sleep(50 * time.Millisecond)
+			return expectedGrids, nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		_, err = cartoFacade.GetSubmapGrids(cancelCtx, 1*time.Millisecond, expectedIDs)
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestMapRevision(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	var mu sync.Mutex
+	cartoRevision := MapRevision{Revision: 1, ChangedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	reads := 0
+	carto.GetMapRevisionFunc = func() (MapRevision, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reads++
+		return cartoRevision, nil
+	}
+	setCartoRevision := func(revision MapRevision) {
+		mu.Lock()
+		defer mu.Unlock()
+		cartoRevision = revision
+	}
+	carto.AddLidarReadingFunc = func(name string, reading []byte, timestamp time.Time) error {
+		return nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing MapRevision", func(t *testing.T) {
+		// until carto is started, the revision is when the cartofacade was created
+		initial, err := cartoFacade.MapRevision()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, initial.Revision, test.ShouldEqual, 0)
+		test.That(t, initial.ChangedAt.IsZero(), test.ShouldBeFalse)
+		test.That(t, reads, test.ShouldEqual, 0)
+		cartoFacade.lifecycle.set(StartedState)
+
+		// adding a lidar reading doesn't read the revision
+		err = cartoFacade.AddLidarReading(cancelCtx, 5*time.Second, "mysensor", []byte("reading"), time.Now())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, reads, test.ShouldEqual, 0)
+
+		// the revision is read from carto when it is asked for
+		revision, err := cartoFacade.MapRevision()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, revision, test.ShouldResemble, cartoRevision)
+		optimized := MapRevision{Revision: 2, ChangedAt: time.Date(2023, 1, 1, 0, 1, 0, 0, time.UTC)}
+		setCartoRevision(optimized)
+		revision, err = cartoFacade.MapRevision()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, revision, test.ShouldResemble, optimized)
+		test.That(t, reads, test.ShouldEqual, 2)
+
+		// a lidar reading succeeds even if the revision can't be read, which returns the last revision read
+		carto.GetMapRevisionFunc = func() (MapRevision, error) {
+			return MapRevision{}, errors.New("test error 20")
+		}
+		err = cartoFacade.AddLidarReading(cancelCtx, 5*time.Second, "mysensor", []byte("reading"), time.Now())
+		test.That(t, err, test.ShouldBeNil)
+		revision, err = cartoFacade.MapRevision()
+		test.That(t, err, test.ShouldResemble, errors.New("test error 20"))
+		test.That(t, revision, test.ShouldResemble, optimized)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestGetConfigRequest(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	carto := CartoMock{}
+	expectedConfig := GetConfig{
+		ConfigurationDirectory: "lua_files",
+		ConfigurationBasename:  "mapping_new_map.lua",
+		OptimizeEveryNNodes:    3,
+		NumRangeData:           100,
+		MaxRange:               25,
+	}
+	carto.GetConfigFunc = func() (GetConfig, error) {
+		return expectedConfig, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(StartedState)
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("testing GetConfig", func(t *testing.T) {
+		// success case
+		getConfig, err := cartoFacade.GetConfig(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, getConfig, test.ShouldResemble, expectedConfig)
+
+		carto.GetConfigFunc = func() (GetConfig, error) {
+			return GetConfig{}, errors.New("test error 15")
+		}
+		cartoFacade.carto = &carto
+
+		// returns error
+		_, err = cartoFacade.GetConfig(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeError)
+		test.That(t, err, test.ShouldResemble, errors.New("test error 15"))
+
+		carto.GetConfigFunc = func() (GetConfig, error) {
+			time.Sleep(50 * time.Millisecond)
+			return expectedConfig, nil
+		}
+		cartoFacade.carto = &carto
+
+		// times out
+		_, err = cartoFacade.GetConfig(cancelCtx, 1*time.Millisecond)
+		test.That(t, err, test.ShouldBeError)
+		expectedErr := multierr.Combine(errors.New(timeoutErrMessage), context.DeadlineExceeded)
+		test.That(t, err, test.ShouldResemble, expectedErr)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+func TestState(t *testing.T) {
+	lib := CartoLibMock{}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	activeBackgroundWorkers := sync.WaitGroup{}
+
+	cfg, dir, err := GetTestConfig("mysensor", "")
+	algoCfg := GetTestAlgoConfig()
+	test.That(t, err, test.ShouldBeNil)
+	defer os.RemoveAll(dir)
+
+	cartoFacade := New(&lib, cfg, algoCfg)
+	test.That(t, cartoFacade.State(), test.ShouldEqual, UninitializedState)
+
+	calls := 0
+	carto := CartoMock{}
+	carto.StartFunc = func() error {
+		calls++
+		return nil
+	}
+	carto.StopFunc = func() error {
+		calls++
+		return nil
+	}
+	carto.TerminateFunc = func() error {
+		calls++
+		return nil
+	}
+	carto.GetPositionFunc = func() (GetPosition, error) {
+		calls++
+		return GetPosition{}, nil
+	}
+	carto.GetSubmapGridsFunc = func(ids []SubmapID) ([]SubmapGrid, error) {
+		calls++
+		return nil, nil
+	}
+	cartoFacade.carto = &carto
+	cartoFacade.lifecycle.set(IOInitializedState)
+
+	var transitions []StateTransition
+	cartoFacade.OnStateTransition(func(transition StateTransition) {
+		transitions = append(transitions, transition)
+	})
+	cartoFacade.startCGoroutine(cancelCtx, &activeBackgroundWorkers)
+
+	t.Run("invalid requests are rejected without calling into carto", func(t *testing.T) {
+		_, err := cartoFacade.GetPosition(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE"))
+		err = cartoFacade.Stop(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE"))
+		_, err = cartoFacade.GetSubmapGrids(cancelCtx, 5*time.Second, []SubmapID{{SubmapIndex: 1}})
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE"))
+		test.That(t, calls, test.ShouldEqual, 0)
+		test.That(t, cartoFacade.State(), test.ShouldEqual, IOInitializedState)
+	})
+
+	t.Run("failed requests don't change the state", func(t *testing.T) {
+		carto.StartFunc = func() error {
+			return errors.New("test error")
+		}
+		err := cartoFacade.Start(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldResemble, errors.New("test error"))
+		test.That(t, cartoFacade.State(), test.ShouldEqual, IOInitializedState)
+		carto.StartFunc = func() error {
+			calls++
+			return nil
+		}
+	})
+
+	t.Run("successful requests transition the state", func(t *testing.T) {
+		test.That(t, cartoFacade.Start(cancelCtx, 5*time.Second), test.ShouldBeNil)
+		test.That(t, cartoFacade.State(), test.ShouldEqual, StartedState)
+
+		err := cartoFacade.Start(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_IO_INITIALIZED_STATE"))
+		err = cartoFacade.Terminate(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_TERMINATABLE_STATE"))
+
+		_, err = cartoFacade.GetPosition(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cartoFacade.State(), test.ShouldEqual, StartedState)
+
+		test.That(t, cartoFacade.Stop(cancelCtx, 5*time.Second), test.ShouldBeNil)
+		test.That(t, cartoFacade.State(), test.ShouldEqual, IOInitializedState)
+		test.That(t, cartoFacade.Terminate(cancelCtx, 5*time.Second), test.ShouldBeNil)
+		test.That(t, cartoFacade.State(), test.ShouldEqual, TerminatedState)
+
+		err = cartoFacade.Start(cancelCtx, 5*time.Second)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_IO_INITIALIZED_STATE"))
+		test.That(t, calls, test.ShouldEqual, 4)
+
+		test.That(t, len(transitions), test.ShouldEqual, 3)
+		test.That(t, transitions[0].From, test.ShouldEqual, IOInitializedState)
+		test.That(t, transitions[0].To, test.ShouldEqual, StartedState)
+		test.That(t, transitions[0].RequestType, test.ShouldEqual, start)
+		test.That(t, transitions[1].From, test.ShouldEqual, StartedState)
+		test.That(t, transitions[1].To, test.ShouldEqual, IOInitializedState)
+		test.That(t, transitions[1].RequestType, test.ShouldEqual, stop)
+		test.That(t, transitions[2].From, test.ShouldEqual, IOInitializedState)
+		test.That(t, transitions[2].To, test.ShouldEqual, TerminatedState)
+		test.That(t, transitions[2].RequestType, test.ShouldEqual, terminate)
+	})
+
+	cancelFunc()
+	activeBackgroundWorkers.Wait()
+}
+
+// spanRecorder counts the spans which were ended by name.
+type spanRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *spanRecorder) ExportSpan(s *trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names = append(r.names, s.Name)
+}
+
+func (r *spanRecorder) ended(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, n := range r.names {
+		if n == name {
+			count++
+		}
+	}
+	return count
+}
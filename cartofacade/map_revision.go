@@ -0,0 +1,40 @@
+package cartofacade
+
+import (
+	"sync"
+	"time"
+)
+
+// MapRevision identifies a state of the painted map. The painted map only depends on the submaps, so cartographer
+// increments Revision whenever range data is inserted into the submaps or an optimization moves them.
+type MapRevision struct {
+	Revision int
+	// ChangedAt is when cartographer incremented Revision
+	ChangedAt time.Time
+}
+
+// mapRevision holds the last MapRevision read from cartographer. It is shared by the copies of a CartoFacade.
+// mu is also held while the cartofacade goroutine stops or terminates carto, so that MapRevision never reads
+// from a carto which is being freed.
+type mapRevision struct {
+	mu       sync.Mutex
+	revision MapRevision
+}
+
+// MapRevision returns the revision of the map, which it reads from cartographer without waiting for the
+// cartofacade goroutine or the map builder, so that it is cheap enough to be called for every GetLatestMapInfo.
+// Until carto is started, or if reading it fails, it returns the last revision read, which is revision 0 changed
+// when the CartoFacade was created if none was read yet. The error of reading it is returned alongside.
+func (cf *CartoFacade) MapRevision() (MapRevision, error) {
+	cf.mapRevision.mu.Lock()
+	defer cf.mapRevision.mu.Unlock()
+	if cf.lifecycle.get() != StartedState {
+		return cf.mapRevision.revision, nil
+	}
+	revision, err := cf.carto.getMapRevision()
+	if err != nil {
+		return cf.mapRevision.revision, err
+	}
+	cf.mapRevision.revision = revision
+	return revision, nil
+}
@@ -0,0 +1,144 @@
+package cartofacade
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	// requestTypeKey tags the request metrics with the RequestType of the request.
+	requestTypeKey = tag.MustNewKey("request_type")
+
+	enqueueWaitMs = stats.Float64(
+		"cartofacade/request/enqueue_wait",
+		"time a request waited for the cartofacade goroutine to pick it up",
+		stats.UnitMilliseconds,
+	)
+	executionMs = stats.Float64(
+		"cartofacade/request/execution",
+		"time a request spent executing in the carto C API",
+		stats.UnitMilliseconds,
+	)
+	deliveryMs = stats.Float64(
+		"cartofacade/request/delivery",
+		"time between a request finishing in the carto C API and the caller receiving the response",
+		stats.UnitMilliseconds,
+	)
+
+	requestLatencyDistribution = view.Distribution(0, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000)
+
+	// RequestViews are the opencensus views of the latency breakdown of cartofacade requests, labeled by RequestType.
+	// They need to be registered with view.Register for the latencies to be aggregated.
+	RequestViews = []*view.View{
+		{
+			Name:        enqueueWaitMs.Name(),
+			Description: enqueueWaitMs.Description(),
+			Measure:     enqueueWaitMs,
+			TagKeys:     []tag.Key{requestTypeKey},
+			Aggregation: requestLatencyDistribution,
+		},
+		{
+			Name:        executionMs.Name(),
+			Description: executionMs.Description(),
+			Measure:     executionMs,
+			TagKeys:     []tag.Key{requestTypeKey},
+			Aggregation: requestLatencyDistribution,
+		},
+		{
+			Name:        deliveryMs.Name(),
+			Description: deliveryMs.Description(),
+			Measure:     deliveryMs,
+			TagKeys:     []tag.Key{requestTypeKey},
+			Aggregation: requestLatencyDistribution,
+		},
+	}
+)
+
+// withRequestTypeTag returns a context which tags the recorded metrics with the request type.
+func withRequestTypeTag(ctx context.Context, requestType RequestType) context.Context {
+	taggedCtx, err := tag.New(ctx, tag.Upsert(requestTypeKey, requestType.String()))
+	if err != nil {
+		return ctx
+	}
+	return taggedCtx
+}
+
+func msSince(t time.Time) float64 {
+	return DurationMs(time.Since(t))
+}
+
+// DurationMs returns a duration in fractional milliseconds, the unit the request latencies are recorded in.
+func DurationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// queueWaitWindowSize is the number of most recent requests the queue wait percentiles are computed over.
+const queueWaitWindowSize = 1024
+
+// QueueWait summarizes how long the most recent requests waited for the cartofacade goroutine to pick them up.
+type QueueWait struct {
+	// Count is the number of requests the percentiles are computed over
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// latencyWindow keeps the latencies of the most recent requests. Unlike the opencensus views, it is
+// always recorded, so that the percentiles can be returned without a registered exporter.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, size)}
+}
+
+func (w *latencyWindow) record(d time.Duration) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < cap(w.samples) {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+}
+
+func (w *latencyWindow) queueWait() QueueWait {
+	if w == nil {
+		return QueueWait{}
+	}
+	w.mu.Lock()
+	sorted := append([]time.Duration{}, w.samples...)
+	w.mu.Unlock()
+	if len(sorted) == 0 {
+		return QueueWait{}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	// nearest rank percentile
+	percentile := func(p int) time.Duration {
+		rank := (p*len(sorted) + 99) / 100
+		return sorted[rank-1]
+	}
+	return QueueWait{
+		Count: len(sorted),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
@@ -0,0 +1,1266 @@
+//go:build cartosim
+
+package cartofacade
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/r3"
+	"go.viam.com/rdk/pointcloud"
+)
+
+/*
+The simulated carto backend is a pure go stand in for the cartographer C++ library. It exists so the
+cartofacade, sensorprocess & the slam service can be tested end to end without cgo. It:
+ 1. tracks the same lifecycle states & slam mode detection as the C++ CartoFacade
+ 2. matches each lidar scan against an occupancy grid using a point to point ICP (falling back to
+    dead reckoning from the last pose when the grid is too sparse to match against)
+ 3. renders the occupancy grid as a pointcloud map, by default a binary PCD with probabilities written to the
+    color field
+ 4. serializes the occupancy grid as its internal state, which it saves into data_dir on map_rate_sec
+*/
+
+const (
+	// simResolutionMeters is the resolution of the grid scans are inserted into, which is the
+	// DefaultMapResolutionMeters the map is painted at.
+	simResolutionMeters = DefaultMapResolutionMeters
+	// simMaxMapCoarsenings mirrors maxMapCoarsenings in carto_facade.h.
+	simMaxMapCoarsenings = 8
+	// log odds updates applied to a grid cell per observation.
+	simLogOddsHit  = 0.85
+	simLogOddsMiss = -0.4
+	simLogOddsMin  = -4.0
+	simLogOddsMax  = 4.0
+	// ICP tuning.
+	simICPIterations         = 15
+	simICPSearchRadiusCells  = 4
+	simICPMinCorrespondences = 10
+	simICPConvergedMeters    = 1e-4
+	simICPConvergedRadians   = 1e-4
+	// simInternalStateMagic prefixes the internal state so that files
+	// which were not written by the simulator can be detected.
+	simInternalStateMagic = "VIAM_CARTO_SIM_INTERNAL_STATE_V1"
+	// simInternalStateTimeFormat mirrors time_format in io.h.
+	simInternalStateTimeFormat = "2006-01-02T15:04:05.0000Z"
+)
+
+type simState int
+
+const (
+	simInitialized simState = iota
+	simIOInitialized
+	simStarted
+)
+
+type simCell struct {
+	X int
+	Y int
+}
+
+type simPoint struct {
+	x float64
+	y float64
+}
+
+type simPose struct {
+	X     float64
+	Y     float64
+	Theta float64
+}
+
+// transform returns p, which is in the frame of the pose, in the map frame.
+func (p simPose) transform(pt simPoint) simPoint {
+	sin, cos := math.Sincos(p.Theta)
+	return simPoint{
+		x: p.X + cos*pt.x - sin*pt.y,
+		y: p.Y + sin*pt.x + cos*pt.y,
+	}
+}
+
+// simNode mirrors a trajectory node, which cartographer adds to the pose graph for each scan.
+type simNode struct {
+	Pose simPose
+	Time time.Time
+}
+
+// simMap is the serialized internal state of the simulator.
+type simMap struct {
+	Resolution   float64
+	Cells        map[simCell]float64
+	Pose         simPose
+	NumScans     int
+	Trajectories [][]simNode
+}
+
+type simCarto struct {
+	cfg                 CartoConfig
+	algoCfg             CartoAlgoConfig
+	slamMode            SlamMode
+	state               simState
+	pathToInternalState string
+
+	// mapMu guards everything below it & mirrors the map_builder_mutex in the C++ CartoFacade.
+	mapMu    sync.Mutex
+	cells    map[simCell]float64
+	pose     simPose
+	numScans int
+	// trajectories holds the nodes of each trajectory, the last one is the active trajectory
+	trajectories [][]simNode
+
+	// revisionMu guards revision & mirrors the map_revision_mutex of the C++ MapBuilder, so that the revision can
+	// be read while mapMu is held.
+	revisionMu sync.Mutex
+	revision   MapRevision
+
+	saveStop    chan struct{}
+	saveWorkers sync.WaitGroup
+}
+
+// newSimCarto mirrors from_viam_carto_config & the CartoFacade constructor.
+func newSimCarto(cfg CartoConfig, acfg CartoAlgoConfig) (*simCarto, error) {
+	if !cfg.CloudStoryEnabled {
+		if cfg.DataDir == "" {
+			return nil, errors.New("VIAM_CARTO_DATA_DIR_NOT_PROVIDED")
+		}
+		if cfg.MapRateSecond < 0 {
+			return nil, errors.New("VIAM_CARTO_MAP_RATE_SEC_INVALID")
+		}
+	}
+	if cfg.Camera == "" {
+		return nil, errors.New("VIAM_CARTO_COMPONENT_REFERENCE_INVALID")
+	}
+	if cfg.InitialPose != nil {
+		if _, err := simPoseFromPose2D(*cfg.InitialPose); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := simResolveMapOptions(acfg, MapOptions{}); err != nil {
+		return nil, err
+	}
+
+	sc := &simCarto{
+		cfg:                 cfg,
+		algoCfg:             acfg,
+		state:               simInitialized,
+		pathToInternalState: filepath.Join(cfg.DataDir, "internal_state"),
+		cells:               map[simCell]float64{},
+		trajectories:        [][]simNode{{}},
+	}
+	// building the map builder is the first revision of the map
+	sc.incrementMapRevision()
+	return sc, nil
+}
+
+// ioInit mirrors CartoFacade::IOInit.
+func (sc *simCarto) ioInit() error {
+	if sc.state != simInitialized {
+		return errors.New("VIAM_CARTO_NOT_IN_INITIALIZED_STATE")
+	}
+
+	var err error
+	if sc.cfg.InitialInternalState != "" {
+		// continuing a mapping session doesn't depend on the apriori maps
+		if !sc.cfg.CloudStoryEnabled {
+			if err := os.MkdirAll(sc.pathToInternalState, 0o774); err != nil {
+				return errors.New("VIAM_CARTO_DATA_DIR_FILE_SYSTEM_ERROR")
+			}
+		}
+		sc.slamMode = MappingMode
+	} else if sc.cfg.CloudStoryEnabled {
+		sc.slamMode, err = simDetermineSlamModeCloudStoryEnabled(sc.cfg.ExistingMap, sc.cfg.EnableMapping)
+		if err != nil {
+			return err
+		}
+	} else {
+		if info, statErr := os.Stat(filepath.Join(sc.cfg.DataDir, "data")); statErr == nil && info.IsDir() {
+			return errors.New("VIAM_CARTO_DATA_DIR_INVALID_DEPRECATED_STRUCTURE")
+		}
+		if err := os.MkdirAll(sc.pathToInternalState, 0o774); err != nil {
+			return errors.New("VIAM_CARTO_DATA_DIR_FILE_SYSTEM_ERROR")
+		}
+		sc.slamMode, err = simDetermineSlamMode(sc.pathToInternalState, sc.cfg.MapRateSecond)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sc.slamMode == UpdatingMode || sc.slamMode == LocalizingMode {
+		filename := sc.cfg.ExistingMap
+		if !sc.cfg.CloudStoryEnabled {
+			filename, err = simLatestInternalStateFilename(sc.pathToInternalState)
+			if err != nil {
+				return err
+			}
+		}
+		if err := sc.loadInternalState(filename); err != nil {
+			return err
+		}
+	}
+
+	if sc.cfg.InitialInternalState != "" {
+		if err := sc.loadInternalState(sc.cfg.InitialInternalState); err != nil {
+			return err
+		}
+	}
+
+	if sc.slamMode == LocalizingMode && sc.cfg.InitialPose != nil {
+		if err := sc.startAt(*sc.cfg.InitialPose); err != nil {
+			return err
+		}
+	}
+
+	sc.state = simIOInitialized
+	return nil
+}
+
+// simDetermineSlamMode mirrors determine_slam_mode.
+func simDetermineSlamMode(pathToInternalState string, mapRateSec int) (SlamMode, error) {
+	entries, err := os.ReadDir(pathToInternalState)
+	if err != nil {
+		return UnknownMode, errors.New("VIAM_CARTO_DATA_DIR_FILE_SYSTEM_ERROR")
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".pbstream") {
+			if mapRateSec == 0 {
+				return LocalizingMode, nil
+			}
+			return UpdatingMode, nil
+		}
+	}
+	if mapRateSec == 0 {
+		return UnknownMode, errors.New("VIAM_CARTO_SLAM_MODE_INVALID")
+	}
+	return MappingMode, nil
+}
+
+// simDetermineSlamModeCloudStoryEnabled mirrors determine_slam_mode_cloud_story_enabled.
+func simDetermineSlamModeCloudStoryEnabled(existingMap string, enableMapping bool) (SlamMode, error) {
+	if existingMap != "" {
+		if !enableMapping {
+			return LocalizingMode, nil
+		}
+		return UpdatingMode, nil
+	}
+	if !enableMapping {
+		return UnknownMode, errors.New("VIAM_CARTO_SLAM_MODE_INVALID")
+	}
+	return MappingMode, nil
+}
+
+// simLatestInternalStateFilename mirrors get_latest_internal_state_filename.
+func simLatestInternalStateFilename(pathToInternalState string) (string, error) {
+	entries, err := os.ReadDir(pathToInternalState)
+	if err != nil {
+		return "", errors.New("VIAM_CARTO_DATA_DIR_FILE_SYSTEM_ERROR")
+	}
+	// os.ReadDir returns the entries sorted by filename
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.Contains(entries[i].Name(), ".pbstream") {
+			return filepath.Join(pathToInternalState, entries[i].Name()), nil
+		}
+	}
+	return "", errors.New("VIAM_CARTO_GET_INTERNAL_STATE_FILE_READ_IO_ERROR")
+}
+
+func (sc *simCarto) start() error {
+	if sc.state != simIOInitialized {
+		return errors.New("VIAM_CARTO_NOT_IN_IO_INITIALIZED_STATE")
+	}
+	sc.state = simStarted
+	if !sc.cfg.CloudStoryEnabled && sc.cfg.MapRateSecond != 0 {
+		sc.startSaveInternalState()
+	}
+	return nil
+}
+
+func (sc *simCarto) stop() error {
+	if sc.state != simStarted {
+		return errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	sc.state = simIOInitialized
+	if sc.saveStop != nil {
+		close(sc.saveStop)
+		sc.saveWorkers.Wait()
+		sc.saveStop = nil
+	}
+	return nil
+}
+
+func (sc *simCarto) terminate() error {
+	if sc.state != simInitialized && sc.state != simIOInitialized {
+		return errors.New("VIAM_CARTO_NOT_IN_TERMINATABLE_STATE")
+	}
+	return nil
+}
+
+// startSaveInternalState mirrors CartoFacade::SaveInternalStateOnInterval, including saving the
+// final internal state when stopped.
+func (sc *simCarto) startSaveInternalState() {
+	sc.saveStop = make(chan struct{})
+	sc.saveWorkers.Add(1)
+	go func(stop chan struct{}) {
+		defer sc.saveWorkers.Done()
+		ticker := time.NewTicker(time.Duration(sc.cfg.MapRateSecond) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				sc.saveInternalStateWithTimestamp(time.Now())
+				return
+			case t := <-ticker.C:
+				sc.saveInternalStateWithTimestamp(t)
+			}
+		}
+	}(sc.saveStop)
+}
+
+func (sc *simCarto) saveInternalStateWithTimestamp(t time.Time) {
+	filename := filepath.Join(sc.pathToInternalState, "map_data_"+t.UTC().Format(simInternalStateTimeFormat)+".pbstream")
+	internalState, err := sc.serialize()
+	if err != nil {
+		return
+	}
+	//nolint:gosec
+	_ = os.WriteFile(filename, internalState, 0o644)
+}
+
+func (sc *simCarto) addLidarReading(lidar string, reading []byte, timestamp time.Time) error {
+	if sc.state != simStarted {
+		return errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	if lidar != sc.cfg.Camera {
+		return errors.New("VIAM_CARTO_SENSOR_NOT_IN_SENSOR_LIST")
+	}
+	if len(reading) == 0 {
+		return errors.New("VIAM_CARTO_LIDAR_READING_EMPTY")
+	}
+	points, err := simPointsFromPCD(reading)
+	if err != nil {
+		return errors.New("VIAM_CARTO_LIDAR_READING_INVALID")
+	}
+
+	if !sc.mapMu.TryLock() {
+		return ErrUnableToAcquireLock
+	}
+	defer sc.mapMu.Unlock()
+
+	sc.pose = sc.matchScan(points)
+	// the map is frozen in localizing mode
+	if sc.slamMode != LocalizingMode {
+		sc.insertScan(points)
+		sc.incrementMapRevision()
+	}
+	sc.numScans++
+	active := len(sc.trajectories) - 1
+	sc.trajectories[active] = append(sc.trajectories[active], simNode{Pose: sc.pose, Time: timestamp})
+	return nil
+}
+
+// simPointsFromPCD parses a lidar reading into 2D points in meters, in the frame of the lidar.
+func simPointsFromPCD(reading []byte) ([]simPoint, error) {
+	pc, err := pointcloud.ReadPCD(bytes.NewReader(reading))
+	if err != nil {
+		return nil, err
+	}
+	if pc.Size() == 0 {
+		return nil, errors.New("lidar reading has no points")
+	}
+	points := make([]simPoint, 0, pc.Size())
+	pc.Iterate(0, 0, func(p r3.Vector, d pointcloud.Data) bool {
+		// rdk uses millimeters
+		points = append(points, simPoint{x: p.X / 1000, y: p.Y / 1000})
+		return true
+	})
+	return points, nil
+}
+
+// matchScan estimates the pose of the lidar by matching the scan against the occupied
+// cells of the grid using point to point ICP, seeded with the last known pose. If the grid is too
+// sparse to match against, the last known pose is kept (dead reckoning without odometry).
+func (sc *simCarto) matchScan(points []simPoint) simPose {
+	pose := sc.pose
+	if sc.numScans == 0 {
+		return pose
+	}
+
+	for i := 0; i < simICPIterations; i++ {
+		var src, dst []simPoint
+		for _, p := range sc.inRange(points) {
+			w := pose.transform(p)
+			nearest, ok := sc.nearestOccupied(w)
+			if !ok {
+				continue
+			}
+			src = append(src, w)
+			dst = append(dst, nearest)
+		}
+		if len(src) < simICPMinCorrespondences {
+			break
+		}
+
+		dTheta, dX, dY := simAlign(src, dst)
+		sin, cos := math.Sincos(dTheta)
+		pose = simPose{
+			X:     cos*pose.X - sin*pose.Y + dX,
+			Y:     sin*pose.X + cos*pose.Y + dY,
+			Theta: math.Remainder(pose.Theta+dTheta, 2*math.Pi),
+		}
+		if math.Abs(dTheta) < simICPConvergedRadians && math.Hypot(dX, dY) < simICPConvergedMeters {
+			break
+		}
+	}
+	return pose
+}
+
+// simAlign returns the rigid transform (rotation followed by translation) which best maps src onto dst
+// in the least squares sense.
+func simAlign(src, dst []simPoint) (float64, float64, float64) {
+	var srcMean, dstMean simPoint
+	for i := range src {
+		srcMean.x += src[i].x
+		srcMean.y += src[i].y
+		dstMean.x += dst[i].x
+		dstMean.y += dst[i].y
+	}
+	n := float64(len(src))
+	srcMean = simPoint{x: srcMean.x / n, y: srcMean.y / n}
+	dstMean = simPoint{x: dstMean.x / n, y: dstMean.y / n}
+
+	var sDot, sCross float64
+	for i := range src {
+		sx, sy := src[i].x-srcMean.x, src[i].y-srcMean.y
+		dx, dy := dst[i].x-dstMean.x, dst[i].y-dstMean.y
+		sDot += sx*dx + sy*dy
+		sCross += sx*dy - sy*dx
+	}
+	theta := math.Atan2(sCross, sDot)
+	sin, cos := math.Sincos(theta)
+	return theta, dstMean.x - (cos*srcMean.x - sin*srcMean.y), dstMean.y - (sin*srcMean.x + cos*srcMean.y)
+}
+
+// inRange returns the points that are within the configured min & max range.
+func (sc *simCarto) inRange(points []simPoint) []simPoint {
+	inRange := make([]simPoint, 0, len(points))
+	for _, p := range points {
+		r := math.Hypot(p.x, p.y)
+		if r < float64(sc.algoCfg.MinRange) || r > float64(sc.algoCfg.MaxRange) {
+			continue
+		}
+		inRange = append(inRange, p)
+	}
+	return inRange
+}
+
+// nearestOccupied returns the center of the closest occupied cell within the ICP search radius of p.
+func (sc *simCarto) nearestOccupied(p simPoint) (simPoint, bool) {
+	center := simCellAt(p)
+	best := simPoint{}
+	bestDist := math.Inf(1)
+	for dy := -simICPSearchRadiusCells; dy <= simICPSearchRadiusCells; dy++ {
+		for dx := -simICPSearchRadiusCells; dx <= simICPSearchRadiusCells; dx++ {
+			c := simCell{X: center.X + dx, Y: center.Y + dy}
+			if sc.cells[c] <= 0 {
+				continue
+			}
+			cp := c.center()
+			if d := math.Hypot(cp.x-p.x, cp.y-p.y); d < bestDist {
+				best, bestDist = cp, d
+			}
+		}
+	}
+	return best, !math.IsInf(bestDist, 1)
+}
+
+// insertScan ray traces each point from the current pose, marking cells along the ray as free
+// & the cell of the point as occupied. Points beyond max range are traced as misses
+// up to missing_data_ray_length.
+func (sc *simCarto) insertScan(points []simPoint) {
+	origin := simCellAt(simPoint{x: sc.pose.X, y: sc.pose.Y})
+	hits := map[simCell]bool{}
+	misses := map[simCell]bool{}
+	for _, p := range points {
+		r := math.Hypot(p.x, p.y)
+		if r < float64(sc.algoCfg.MinRange) {
+			continue
+		}
+		hit := true
+		if r > float64(sc.algoCfg.MaxRange) {
+			scale := float64(sc.algoCfg.MissingDataRayLength) / r
+			p = simPoint{x: p.x * scale, y: p.y * scale}
+			hit = false
+		}
+		end := simCellAt(sc.pose.transform(p))
+		simTraceRay(origin, end, func(c simCell) { misses[c] = true })
+		if hit {
+			hits[end] = true
+		} else {
+			misses[end] = true
+		}
+	}
+	for c := range misses {
+		if !hits[c] {
+			sc.cells[c] = math.Max(simLogOddsMin, sc.cells[c]+simLogOddsMiss)
+		}
+	}
+	for c := range hits {
+		sc.cells[c] = math.Min(simLogOddsMax, sc.cells[c]+simLogOddsHit)
+	}
+}
+
+// simTraceRay calls fn on every cell between start (inclusive) & end (exclusive) using bresenham's line algorithm.
+func simTraceRay(start, end simCell, fn func(simCell)) {
+	dx := simAbs(end.X - start.X)
+	dy := -simAbs(end.Y - start.Y)
+	sx, sy := 1, 1
+	if start.X > end.X {
+		sx = -1
+	}
+	if start.Y > end.Y {
+		sy = -1
+	}
+	e := dx + dy
+	x, y := start.X, start.Y
+	for x != end.X || y != end.Y {
+		fn(simCell{X: x, Y: y})
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y += sy
+		}
+	}
+}
+
+func simAbs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func simCellAt(p simPoint) simCell {
+	return simCell{X: int(math.Round(p.x / simResolutionMeters)), Y: int(math.Round(p.y / simResolutionMeters))}
+}
+
+func (c simCell) center() simPoint {
+	return simPoint{x: float64(c.X) * simResolutionMeters, y: float64(c.Y) * simResolutionMeters}
+}
+
+func simProbability(logOdds float64) float64 {
+	return 1 - 1/(1+math.Exp(logOdds))
+}
+
+func (sc *simCarto) getPosition() (GetPosition, error) {
+	if sc.state != simStarted {
+		return GetPosition{}, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	sc.mapMu.Lock()
+	pose := sc.pose
+	sc.mapMu.Unlock()
+
+	sin, cos := math.Sincos(pose.Theta / 2)
+	return GetPosition{
+		X:                  pose.X * 1000,
+		Y:                  pose.Y * 1000,
+		Z:                  0,
+		Real:               cos,
+		Imag:               0,
+		Jmag:               0,
+		Kmag:               sin,
+		ComponentReference: sc.cfg.Camera,
+	}, nil
+}
+
+// simPaintedMap mirrors PaintedMap: the cells of the map painted at resolutionMeters.
+type simPaintedMap struct {
+	cells            map[simCell]float64
+	resolutionMeters float64
+}
+
+// simResolveMapOptions mirrors CartoFacade::ResolveMapOptions.
+func simResolveMapOptions(acfg CartoAlgoConfig, opts MapOptions) (MapOptions, error) {
+	resolved := MapOptions{
+		ResolutionMeters: acfg.MapResolutionMeters,
+		MaxPoints:        acfg.MaxMapPoints,
+		Region:           opts.Region,
+		Format:           opts.Format,
+	}
+	if opts.ResolutionMeters != 0 {
+		resolved.ResolutionMeters = opts.ResolutionMeters
+	}
+	if opts.MaxPoints != 0 {
+		resolved.MaxPoints = opts.MaxPoints
+	}
+	if resolved.ResolutionMeters == 0 {
+		resolved.ResolutionMeters = DefaultMapResolutionMeters
+	}
+	if !(resolved.ResolutionMeters >= MinMapResolutionMeters) || resolved.MaxPoints < 0 {
+		return MapOptions{}, errors.New("VIAM_CARTO_MAP_OPTIONS_INVALID")
+	}
+	if r := resolved.Region; r != nil && !(r.MinX < r.MaxX && r.MinY < r.MaxY) {
+		return MapOptions{}, errors.New("VIAM_CARTO_MAP_OPTIONS_INVALID")
+	}
+	if resolved.Format < PCDFormat || resolved.Format > PLYFormat {
+		return MapOptions{}, errors.New("VIAM_CARTO_MAP_OPTIONS_INVALID")
+	}
+	return resolved, nil
+}
+
+// paintMap mirrors CartoFacade::PaintMap: the cells within opts.Region are repainted at a coarser resolution
+// while the map has more than opts.MaxPoints occupied cells, or cells if countCells is set. mapMu must be held.
+func (sc *simCarto) paintMap(opts MapOptions, countCells bool) simPaintedMap {
+	resolutionMeters := opts.ResolutionMeters
+	for coarsenings := 0; ; coarsenings++ {
+		painted := simPaintedMap{
+			cells:            simPaintCells(sc.cells, resolutionMeters, opts.Region),
+			resolutionMeters: resolutionMeters,
+		}
+		if opts.MaxPoints == 0 {
+			return painted
+		}
+		points := painted.countPoints()
+		if countCells {
+			points = painted.countCells()
+		}
+		if points <= opts.MaxPoints || coarsenings == simMaxMapCoarsenings {
+			return painted
+		}
+		resolutionMeters *= math.Max(1.1, math.Sqrt(float64(points)/float64(opts.MaxPoints)))
+	}
+}
+
+// simPaintCells paints the grid cells at resolutionMeters. Each cell covers the pixels whose centers lie
+// within it, or its nearest pixel if it covers none, & a pixel covered by several cells takes the most
+// occupied of them. Only the pixels whose centers lie within the region are painted if it is not nil.
+func simPaintCells(cells map[simCell]float64, resolutionMeters float64, region *MapRegion) map[simCell]float64 {
+	painted := make(map[simCell]float64, len(cells))
+	pixels := func(center float64) (int, int) {
+		first := int(math.Ceil((center - simResolutionMeters/2) / resolutionMeters))
+		last := int(math.Ceil((center+simResolutionMeters/2)/resolutionMeters)) - 1
+		if first > last {
+			first = int(math.Round(center / resolutionMeters))
+			last = first
+		}
+		return first, last
+	}
+	for c, logOdds := range cells {
+		center := c.center()
+		firstX, lastX := pixels(center.x)
+		firstY, lastY := pixels(center.y)
+		for x := firstX; x <= lastX; x++ {
+			for y := firstY; y <= lastY; y++ {
+				pixel := simCell{X: x, Y: y}
+				if region != nil && !region.Contains(float64(x)*resolutionMeters*1000, float64(y)*resolutionMeters*1000) {
+					continue
+				}
+				if current, ok := painted[pixel]; !ok || logOdds > current {
+					painted[pixel] = logOdds
+				}
+			}
+		}
+	}
+	return painted
+}
+
+// bounds returns the smallest & largest painted pixel indices.
+func (m simPaintedMap) bounds() (minX, minY, maxX, maxY int) {
+	minX, minY = math.MaxInt, math.MaxInt
+	maxX, maxY = math.MinInt, math.MinInt
+	for c := range m.cells {
+		if c.X < minX {
+			minX = c.X
+		}
+		if c.Y < minY {
+			minY = c.Y
+		}
+		if c.X > maxX {
+			maxX = c.X
+		}
+		if c.Y > maxY {
+			maxY = c.Y
+		}
+	}
+	return minX, minY, maxX, maxY
+}
+
+// countPoints mirrors count_painted_map_points.
+func (m simPaintedMap) countPoints() int {
+	points := 0
+	for _, logOdds := range m.cells {
+		if logOdds > 0 {
+			points++
+		}
+	}
+	return points
+}
+
+// countCells mirrors count_painted_map_cells.
+func (m simPaintedMap) countCells() int {
+	if len(m.cells) == 0 {
+		return 0
+	}
+	minX, minY, maxX, maxY := m.bounds()
+	return (maxX - minX + 1) * (maxY - minY + 1)
+}
+
+// getPointCloudMap mirrors GetLatestSampledPointCloudMapString: occupied cells of the map painted with opts
+// are written as points with their probability (0 - 100) in opts.Format, see simWritePoint.
+func (sc *simCarto) getPointCloudMap(opts MapOptions) ([]byte, error) {
+	if sc.state != simStarted {
+		return nil, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	resolved, err := simResolveMapOptions(sc.algoCfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	sc.mapMu.Lock()
+	painted := sc.paintMap(resolved, false)
+	sc.mapMu.Unlock()
+	occupied := make([]simCell, 0, len(painted.cells))
+	probs := make(map[simCell]int, len(painted.cells))
+	for c, logOdds := range painted.cells {
+		if logOdds <= 0 {
+			continue
+		}
+		occupied = append(occupied, c)
+		probs[c] = int(math.Round(100 * simProbability(logOdds)))
+	}
+
+	if len(occupied) == 0 {
+		return nil, errors.New("VIAM_CARTO_POINTCLOUD_MAP_EMPTY")
+	}
+
+	// write the points in raster order, as the painted map is
+	sort.Slice(occupied, func(i, j int) bool {
+		if occupied[i].Y != occupied[j].Y {
+			return occupied[i].Y > occupied[j].Y
+		}
+		return occupied[i].X < occupied[j].X
+	})
+
+	pointsFormat := resolved.Format
+	if pointsFormat == PCDBinaryCompressedFormat {
+		pointsFormat = PCDBinaryFormat
+	}
+	var points bytes.Buffer
+	for _, c := range occupied {
+		x := float32(float64(c.X) * painted.resolutionMeters)
+		y := float32(float64(c.Y) * painted.resolutionMeters)
+		simWritePoint(&points, pointsFormat, x, y, 0, probs[c])
+	}
+
+	buf := bytes.NewBufferString(simPointCloudHeader(resolved.Format, len(occupied)))
+	if resolved.Format == PCDBinaryCompressedFormat {
+		buf.Write(simPCDBinaryCompressedData(points.Bytes(), len(occupied)))
+	} else {
+		buf.Write(points.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// simPointCloudHeader mirrors util::point_cloud_header.
+func simPointCloudHeader(format PointCloudFormat, numPoints int) string {
+	if format == PLYFormat {
+		return fmt.Sprintf(
+			"ply\n"+
+				"format binary_little_endian 1.0\n"+
+				"element vertex %d\n"+
+				"property float x\n"+
+				"property float y\n"+
+				"property float z\n"+
+				"property uchar probability\n"+
+				"end_header\n", numPoints)
+	}
+	fields, size, typ, data := "x y z probability", "4 4 4 1", "F F F U", "binary"
+	switch format {
+	case PCDFormat:
+		fields, size, typ = "x y z rgb", "4 4 4 4", "F F F I"
+	case PCDBinaryCompressedFormat:
+		data = "binary_compressed"
+	case PCDASCIIFormat:
+		data = "ascii"
+	}
+	return fmt.Sprintf(
+		"VERSION .7\n"+
+			"FIELDS %s\n"+
+			"SIZE %s\n"+
+			"TYPE %s\n"+
+			"COUNT 1 1 1 1\n"+
+			"WIDTH %d\n"+
+			"HEIGHT 1\n"+
+			"VIEWPOINT 0 0 0 1 0 0 0\n"+
+			"POINTS %d\n"+
+			"DATA %s\n", fields, size, typ, numPoints, numPoints, data)
+}
+
+// simWritePoint mirrors util::write_point: PCDFormat writes the probability to the rgb field, the binary
+// formats share the layout of their points & PCDBinaryCompressedFormat points are written as PCDBinaryFormat
+// points, which simPCDBinaryCompressedData then compresses.
+func simWritePoint(buf *bytes.Buffer, format PointCloudFormat, x, y, z float32, probability int) {
+	if format == PCDASCIIFormat {
+		fmt.Fprintf(buf, "%.6f %.6f %.6f %d\n", x, y, z, probability)
+		return
+	}
+	for _, v := range []float32{x, y, z} {
+		buf.Write(binary.LittleEndian.AppendUint32(nil, math.Float32bits(v)))
+	}
+	if format == PCDFormat {
+		buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(probability)))
+		return
+	}
+	buf.WriteByte(byte(probability))
+}
+
+// simPCDBinaryCompressedData mirrors util::pcd_binary_compressed_data: the values of each field of the
+// PCDBinaryFormat points are written one after the other & compressed with simLZFCompress.
+func simPCDBinaryCompressedData(points []byte, numPoints int) []byte {
+	const pointSize = 3*4 + 1
+	fields := make([]byte, 0, len(points))
+	offset := 0
+	for _, fieldSize := range []int{4, 4, 4, 1} {
+		for i := 0; i < numPoints; i++ {
+			start := i*pointSize + offset
+			fields = append(fields, points[start:start+fieldSize]...)
+		}
+		offset += fieldSize
+	}
+	compressed := simLZFCompress(fields)
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(compressed)))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(fields)))
+	return append(data, compressed...)
+}
+
+// simLZFCompress compresses in into the LZF format pcl::lzfCompress writes: runs of up to 32 literal bytes
+// prefixed by their length - 1 & back references of 3 to 264 bytes to the previous 8192 bytes.
+func simLZFCompress(in []byte) []byte {
+	const (
+		hashLog   = 14
+		maxLit    = 1 << 5
+		maxOffset = 1 << 13
+		maxRef    = (1 << 8) + (1 << 3)
+	)
+	out := make([]byte, 0, len(in)+len(in)/maxLit+1)
+	// table holds the last position + 1 of each hashed triple of bytes
+	table := make([]int, 1<<hashLog)
+	literalStart := 0
+	flushLiterals := func(end int) {
+		for literalStart < end {
+			n := end - literalStart
+			if n > maxLit {
+				n = maxLit
+			}
+			out = append(out, byte(n-1))
+			out = append(out, in[literalStart:literalStart+n]...)
+			literalStart += n
+		}
+	}
+	for i := 0; i+2 < len(in); {
+		h := (uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])) * 2654435761 >> (32 - hashLog)
+		ref := table[h] - 1
+		table[h] = i + 1
+		if ref < 0 || i-ref > maxOffset || !bytes.Equal(in[ref:ref+3], in[i:i+3]) {
+			i++
+			continue
+		}
+		n := 3
+		for n < maxRef && i+n < len(in) && in[ref+n] == in[i+n] {
+			n++
+		}
+		flushLiterals(i)
+		offset, length := i-ref-1, n-2
+		if length < 7 {
+			out = append(out, byte(length<<5|offset>>8))
+		} else {
+			out = append(out, byte(7<<5|offset>>8), byte(length-7))
+		}
+		out = append(out, byte(offset))
+		i += n
+		literalStart = i
+	}
+	flushLiterals(len(in))
+	return out
+}
+
+func (sc *simCarto) getInternalState() ([]byte, error) {
+	if sc.state != simStarted {
+		return nil, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	internalState, err := sc.serialize()
+	if err != nil {
+		return nil, errors.New("VIAM_CARTO_GET_INTERNAL_STATE_FILE_WRITE_IO_ERROR")
+	}
+	return internalState, nil
+}
+
+// getStats mirrors CartoFacade::GetStats. The simulator has a single trajectory whose nodes are all inserted
+// into a single submap, which is never finished nor optimized.
+func (sc *simCarto) getStats() (GetStats, error) {
+	if sc.state != simStarted {
+		return GetStats{}, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	sc.mapMu.Lock()
+	defer sc.mapMu.Unlock()
+	stats := GetStats{
+		Trajectories: 1,
+		Nodes:        sc.numScans,
+		Constraints:  sc.numScans,
+		// cartographer grids store one uint16 per cell
+		GridBytes: int64(2 * len(sc.cells)),
+	}
+	if len(sc.cells) > 0 {
+		stats.SubmapsActive = 1
+	}
+	return stats, nil
+}
+
+// saveMap mirrors CartoFacade::SaveMap. The simulator never optimizes, so runFinalOptimization is ignored.
+func (sc *simCarto) saveMap(path string, runFinalOptimization bool) (SaveMap, error) {
+	if sc.state != simStarted {
+		return SaveMap{}, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	if path == "" {
+		return SaveMap{}, errors.New("VIAM_CARTO_SAVE_MAP_PATH_INVALID")
+	}
+	internalState, err := sc.serialize()
+	if err != nil {
+		return SaveMap{}, errors.New("VIAM_CARTO_SAVE_MAP_FILE_WRITE_IO_ERROR")
+	}
+	if err := os.WriteFile(path, internalState, 0o644); err != nil {
+		return SaveMap{}, errors.New("VIAM_CARTO_SAVE_MAP_FILE_WRITE_IO_ERROR")
+	}
+	sc.mapMu.Lock()
+	defer sc.mapMu.Unlock()
+	return SaveMap{Nodes: sc.numScans}, nil
+}
+
+// setPose mirrors CartoFacade::SetPose.
+func (sc *simCarto) setPose(pose Pose2D) error {
+	if sc.state != simStarted {
+		return errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	if sc.slamMode != LocalizingMode {
+		return errors.New("VIAM_CARTO_NOT_IN_LOCALIZING_MODE")
+	}
+	return sc.startAt(pose)
+}
+
+// switchToLocalization mirrors CartoFacade::SwitchToLocalization. The simulator has no pose graph to freeze,
+// localizing already stops scans from being added to the map.
+func (sc *simCarto) switchToLocalization() error {
+	if sc.state != simStarted {
+		return errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	if sc.slamMode == LocalizingMode {
+		return errors.New("VIAM_CARTO_ALREADY_IN_LOCALIZING_MODE")
+	}
+	sc.mapMu.Lock()
+	defer sc.mapMu.Unlock()
+	if len(sc.trajectories[len(sc.trajectories)-1]) == 0 {
+		return errors.New("VIAM_CARTO_FROZEN_MAP_EMPTY")
+	}
+	sc.trajectories = append(sc.trajectories, []simNode{})
+	sc.slamMode = LocalizingMode
+	return nil
+}
+
+// startAt mirrors starting a new trajectory at an initial trajectory pose. The pose is moved, as long as
+// there is a map to place it in, & a new trajectory is started unless the active one has no nodes yet.
+func (sc *simCarto) startAt(pose Pose2D) error {
+	p, err := simPoseFromPose2D(pose)
+	if err != nil {
+		return err
+	}
+	sc.mapMu.Lock()
+	defer sc.mapMu.Unlock()
+	if sc.numScans == 0 {
+		return errors.New("VIAM_CARTO_FROZEN_MAP_EMPTY")
+	}
+	sc.pose = p
+	if len(sc.trajectories[len(sc.trajectories)-1]) > 0 {
+		sc.trajectories = append(sc.trajectories, []simNode{})
+	}
+	return nil
+}
+
+// simPoseFromPose2D mirrors from_viam_carto_pose.
+func simPoseFromPose2D(pose Pose2D) (simPose, error) {
+	for _, v := range []float64{pose.X, pose.Y, pose.Theta} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return simPose{}, errors.New("VIAM_CARTO_POSE_INVALID")
+		}
+	}
+	return simPose{X: pose.X / 1000, Y: pose.Y / 1000, Theta: pose.Theta * math.Pi / 180}, nil
+}
+
+// optimize mirrors CartoFacade::StartOptimization. The simulator has no pose graph, so the optimization
+// finishes immediately without changing the map.
+func (sc *simCarto) optimize() error {
+	if sc.state != simStarted {
+		return errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	return nil
+}
+
+// getOptimizationStatus mirrors CartoFacade::GetOptimizationStatus.
+func (sc *simCarto) getOptimizationStatus() (OptimizationStatus, error) {
+	if sc.state != simStarted {
+		return OptimizationStatus{}, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	return OptimizationStatus{}, nil
+}
+
+// incrementMapRevision mirrors MapBuilder::IncrementMapRevision.
+func (sc *simCarto) incrementMapRevision() {
+	sc.revisionMu.Lock()
+	defer sc.revisionMu.Unlock()
+	sc.revision = MapRevision{Revision: sc.revision.Revision + 1, ChangedAt: time.Now().UTC()}
+}
+
+// getMapRevision mirrors CartoFacade::GetMapRevision.
+func (sc *simCarto) getMapRevision() (MapRevision, error) {
+	if sc.state != simStarted {
+		return MapRevision{}, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	sc.revisionMu.Lock()
+	defer sc.revisionMu.Unlock()
+	return sc.revision, nil
+}
+
+// getTrajectory mirrors CartoFacade::GetTrajectory.
+func (sc *simCarto) getTrajectory(allTrajectories bool, everyNNodes int) ([]TrajectoryNode, error) {
+	if sc.state != simStarted {
+		return nil, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	if everyNNodes < 1 {
+		return nil, errors.New("VIAM_CARTO_GET_TRAJECTORY_EVERY_N_NODES_INVALID")
+	}
+	sc.mapMu.Lock()
+	defer sc.mapMu.Unlock()
+
+	first := len(sc.trajectories) - 1
+	if allTrajectories {
+		first = 0
+	}
+	nodes := []TrajectoryNode{}
+	for trajectoryID := first; trajectoryID < len(sc.trajectories); trajectoryID++ {
+		trajectory := sc.trajectories[trajectoryID]
+		for i, node := range trajectory {
+			if i%everyNNodes != 0 && i != len(trajectory)-1 {
+				continue
+			}
+			sin, cos := math.Sincos(node.Pose.Theta / 2)
+			nodes = append(nodes, TrajectoryNode{
+				TrajectoryID: trajectoryID,
+				NodeIndex:    i,
+				Time:         node.Time,
+				X:            node.Pose.X * 1000,
+				Y:            node.Pose.Y * 1000,
+				Real:         cos,
+				Kmag:         sin,
+			})
+		}
+	}
+	return nodes, nil
+}
+
+// getSubmaps mirrors CartoFacade::GetSubmaps. The simulator inserts every scan into a single grid at the
+// origin of the map, which is returned as one active submap once it has cells.
+func (sc *simCarto) getSubmaps() ([]Submap, error) {
+	if sc.state != simStarted {
+		return nil, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	sc.mapMu.Lock()
+	defer sc.mapMu.Unlock()
+	if len(sc.cells) == 0 {
+		return []Submap{}, nil
+	}
+
+	submap := Submap{
+		Version:          sc.numScans,
+		ResolutionMeters: simResolutionMeters,
+		Real:             1,
+		MinX:             math.Inf(1),
+		MinY:             math.Inf(1),
+		MaxX:             math.Inf(-1),
+		MaxY:             math.Inf(-1),
+	}
+	for c := range sc.cells {
+		// cells are centered on their point
+		center := c.center()
+		submap.MinX = math.Min(submap.MinX, (center.x-simResolutionMeters/2)*1000)
+		submap.MinY = math.Min(submap.MinY, (center.y-simResolutionMeters/2)*1000)
+		submap.MaxX = math.Max(submap.MaxX, (center.x+simResolutionMeters/2)*1000)
+		submap.MaxY = math.Max(submap.MaxY, (center.y+simResolutionMeters/2)*1000)
+	}
+	return []Submap{submap}, nil
+}
+
+// getOccupancyGrid mirrors CartoFacade::GetOccupancyGrid: the grid spans the bounding box of the cells of
+// the map painted with opts, whose centers are where getPointCloudMap writes their points.
+func (sc *simCarto) getOccupancyGrid(opts MapOptions) (OccupancyGrid, error) {
+	if sc.state != simStarted {
+		return OccupancyGrid{}, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	resolved, err := simResolveMapOptions(sc.algoCfg, opts)
+	if err != nil {
+		return OccupancyGrid{}, err
+	}
+	sc.mapMu.Lock()
+	defer sc.mapMu.Unlock()
+	if len(sc.cells) == 0 {
+		return OccupancyGrid{}, ErrOccupancyGridEmpty
+	}
+
+	painted := sc.paintMap(resolved, true)
+	if len(painted.cells) == 0 {
+		// no cell lies within the region
+		return OccupancyGrid{}, ErrOccupancyGridEmpty
+	}
+	return painted.occupancyGrid(), nil
+}
+
+// occupancyGrid mirrors to_occupancy_grid: the grid spans the bounding box of the painted cells, which must
+// not be empty.
+func (m simPaintedMap) occupancyGrid() OccupancyGrid {
+	minX, minY, maxX, maxY := m.bounds()
+	grid := OccupancyGrid{
+		Width:            maxX - minX + 1,
+		Height:           maxY - minY + 1,
+		ResolutionMeters: m.resolutionMeters,
+		OriginX:          (float64(minX) - 0.5) * m.resolutionMeters,
+		OriginY:          (float64(minY) - 0.5) * m.resolutionMeters,
+	}
+	grid.Cells = bytes.Repeat([]byte{OccupancyGridUnknownCell}, grid.Width*grid.Height)
+	for c, logOdds := range m.cells {
+		// the top row holds the cells with the largest y
+		i := (maxY-c.Y)*grid.Width + c.X - minX
+		grid.Cells[i] = byte(math.Round(100 * simProbability(logOdds)))
+	}
+	return grid
+}
+
+// getSubmapGrids mirrors CartoFacade::GetSubmapGrids. The single submap of the simulator is at the origin of
+// the map, so its frame is the frame of the map.
+func (sc *simCarto) getSubmapGrids(ids []SubmapID) ([]SubmapGrid, error) {
+	if sc.state != simStarted {
+		return nil, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	resolved, err := simResolveMapOptions(sc.algoCfg, MapOptions{})
+	if err != nil {
+		return nil, err
+	}
+	sc.mapMu.Lock()
+	defer sc.mapMu.Unlock()
+	grids := []SubmapGrid{}
+	if len(sc.cells) == 0 {
+		return grids, nil
+	}
+	for _, id := range ids {
+		if id != (SubmapID{}) {
+			continue
+		}
+		painted := simPaintedMap{
+			cells:            simPaintCells(sc.cells, resolved.ResolutionMeters, nil),
+			resolutionMeters: resolved.ResolutionMeters,
+		}
+		return append(grids, SubmapGrid{Version: sc.numScans, Grid: painted.occupancyGrid()}), nil
+	}
+	return grids, nil
+}
+
+// simConfigurationBasenames mirrors slam_mode_lua_config_filename.
+var simConfigurationBasenames = map[SlamMode]string{
+	MappingMode:    "mapping_new_map.lua",
+	LocalizingMode: "locating_in_map.lua",
+	UpdatingMode:   "updating_a_map.lua",
+}
+
+// getConfig mirrors CartoFacade::GetConfig. The simulator loads no lua configuration, so it has no
+// configuration directory & the trimmer values are only set in the slam modes CartoFacade::IOInit overwrites them in.
+func (sc *simCarto) getConfig() (GetConfig, error) {
+	if sc.state != simStarted {
+		return GetConfig{}, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+	}
+	cfg := GetConfig{
+		ConfigurationBasename: simConfigurationBasenames[sc.slamMode],
+		OptimizeEveryNNodes:   sc.algoCfg.OptimizeEveryNNodes,
+		NumRangeData:          sc.algoCfg.NumRangeData,
+		MissingDataRayLength:  sc.algoCfg.MissingDataRayLength,
+		MaxRange:              sc.algoCfg.MaxRange,
+		MinRange:              sc.algoCfg.MinRange,
+		OccupiedSpaceWeight:   sc.algoCfg.OccupiedSpaceWeight,
+		TranslationWeight:     sc.algoCfg.TranslationWeight,
+		RotationWeight:        sc.algoCfg.RotationWeight,
+	}
+	switch sc.slamMode {
+	case LocalizingMode:
+		cfg.MaxSubmapsToKeep = sc.algoCfg.MaxSubmapsToKeep
+	case UpdatingMode:
+		cfg.FreshSubmapsCount = sc.algoCfg.FreshSubmapsCount
+		cfg.MinCoveredArea = sc.algoCfg.MinCoveredArea
+		cfg.MinAddedSubmapsCount = sc.algoCfg.MinAddedSubmapsCount
+	}
+	return cfg, nil
+}
+
+// serialize encodes the grid, pose & trajectories, prefixed by simInternalStateMagic.
+func (sc *simCarto) serialize() ([]byte, error) {
+	sc.mapMu.Lock()
+	m := simMap{Resolution: simResolutionMeters, Cells: make(map[simCell]float64, len(sc.cells)), Pose: sc.pose, NumScans: sc.numScans}
+	for c, logOdds := range sc.cells {
+		m.Cells[c] = logOdds
+	}
+	for _, trajectory := range sc.trajectories {
+		m.Trajectories = append(m.Trajectories, append([]simNode{}, trajectory...))
+	}
+	sc.mapMu.Unlock()
+
+	buf := bytes.NewBufferString(simInternalStateMagic)
+	if err := gob.NewEncoder(buf).Encode(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// loadInternalState mirrors MapBuilder::LoadMapFromFile. Only internal state written by the simulator
+// can be loaded.
+func (sc *simCarto) loadInternalState(filename string) error {
+	//nolint:gosec
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return errors.New("VIAM_CARTO_GET_INTERNAL_STATE_FILE_READ_IO_ERROR")
+	}
+	if !bytes.HasPrefix(b, []byte(simInternalStateMagic)) {
+		return errors.New("VIAM_CARTO_MAP_CREATION_ERROR")
+	}
+	var m simMap
+	if err := gob.NewDecoder(bytes.NewReader(b[len(simInternalStateMagic):])).Decode(&m); err != nil {
+		return errors.New("VIAM_CARTO_MAP_CREATION_ERROR")
+	}
+
+	// the pose is not restored as a new trajectory is started
+	// at the origin, as is done by cartographer
+	sc.mapMu.Lock()
+	defer sc.mapMu.Unlock()
+	if m.Cells != nil {
+		sc.cells = m.Cells
+	}
+	sc.numScans = m.NumScans
+	// the loaded trajectories are kept & a new one becomes the active trajectory
+	sc.trajectories = append(m.Trajectories, []simNode{})
+	return nil
+}
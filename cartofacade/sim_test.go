@@ -0,0 +1,686 @@
+//go:build cartosim
+
+package cartofacade
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/r3"
+	"go.viam.com/rdk/pointcloud"
+	"go.viam.com/test"
+)
+
+// simulatedRoomScan returns a binary PCD of a lidar scan taken at the given pose inside of a 6m x 4m room
+// centered on the origin.
+func simulatedRoomScan(t *testing.T, x, y, theta float64) []byte {
+	t.Helper()
+	const halfWidth, halfHeight = 3.0, 2.0
+	pc := pointcloud.New()
+	for deg := 0; deg < 360; deg++ {
+		angle := theta + float64(deg)*math.Pi/180
+		dirX, dirY := math.Cos(angle), math.Sin(angle)
+		dist := math.Inf(1)
+		if dirX > 0 {
+			dist = math.Min(dist, (halfWidth-x)/dirX)
+		} else if dirX < 0 {
+			dist = math.Min(dist, (-halfWidth-x)/dirX)
+		}
+		if dirY > 0 {
+			dist = math.Min(dist, (halfHeight-y)/dirY)
+		} else if dirY < 0 {
+			dist = math.Min(dist, (-halfHeight-y)/dirY)
+		}
+		// points are in the lidar's frame, in millimeters
+		sensorAngle := float64(deg) * math.Pi / 180
+		p := r3.Vector{X: 1000 * dist * math.Cos(sensorAngle), Y: 1000 * dist * math.Sin(sensorAngle)}
+		test.That(t, pc.Set(p, nil), test.ShouldBeNil)
+	}
+	buf := new(bytes.Buffer)
+	test.That(t, pointcloud.ToPCD(pc, buf, pointcloud.PCDBinary), test.ShouldBeNil)
+	return buf.Bytes()
+}
+
+func TestSimCarto(t *testing.T) {
+	lib, err := NewLib(0, 0)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() {
+		test.That(t, lib.Terminate(), test.ShouldBeNil)
+	}()
+
+	t.Run("NewCarto validates config like viam_carto_init", func(t *testing.T) {
+		_, err := NewCarto(GetBadTestConfig(), GetTestAlgoConfig(), &lib)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_DATA_DIR_NOT_PROVIDED"))
+
+		_, err = NewCarto(GetBadTestConfig(), GetTestAlgoConfig(), &CartoLibMock{})
+		test.That(t, err, test.ShouldResemble, errors.New("cannot cast provided library to a CartoLib"))
+
+		cfg, dir, err := GetTestConfig("mysensor", "")
+		test.That(t, err, test.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		cfg.MapRateSecond = 0
+		_, err = NewCarto(cfg, GetTestAlgoConfig(), &lib)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_SLAM_MODE_INVALID"))
+	})
+
+	t.Run("builds a map, tracks the pose & can be localized against", func(t *testing.T) {
+		cfg, dir, err := GetTestConfig("mysensor", "")
+		test.That(t, err, test.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		algoCfg := GetTestAlgoConfig()
+
+		vc, err := NewCarto(cfg, algoCfg, &lib)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, vc.SlamMode, test.ShouldEqual, MappingMode)
+
+		timestamp := time.Date(2021, 8, 15, 14, 30, 45, 100, time.UTC)
+		err = vc.addLidarReading("mysensor", simulatedRoomScan(t, 0, 0, 0), timestamp)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE"))
+		_, err = vc.getMapRevision()
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE"))
+
+		test.That(t, vc.start(), test.ShouldBeNil)
+
+		_, err = vc.getPointCloudMap()
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_POINTCLOUD_MAP_EMPTY"))
+		initialRevision, err := vc.getMapRevision()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, initialRevision.Revision, test.ShouldEqual, 1)
+		test.That(t, initialRevision.ChangedAt.IsZero(), test.ShouldBeFalse)
+
+		err = vc.addLidarReading("not my sensor", simulatedRoomScan(t, 0, 0, 0), timestamp)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_SENSOR_NOT_IN_SENSOR_LIST"))
+		err = vc.addLidarReading("mysensor", []byte{}, timestamp)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_LIDAR_READING_EMPTY"))
+		err = vc.addLidarReading("mysensor", []byte("he0llo"), timestamp)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_LIDAR_READING_INVALID"))
+
+		// drive the robot along the x axis while turning slightly
+		for i := 0; i <= 10; i++ {
+			timestamp = timestamp.Add(200 * time.Millisecond)
+			scan := simulatedRoomScan(t, 0.05*float64(i), 0.02*float64(i), 0.01*float64(i))
+			test.That(t, vc.addLidarReading("mysensor", scan, timestamp), test.ShouldBeNil)
+		}
+		revision, err := vc.getMapRevision()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, revision.Revision, test.ShouldEqual, initialRevision.Revision+11)
+
+		position, err := vc.getPosition()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, position.ComponentReference, test.ShouldEqual, "mysensor")
+		test.That(t, position.X, test.ShouldAlmostEqual, 500, 50)
+		test.That(t, position.Y, test.ShouldAlmostEqual, 200, 50)
+		test.That(t, position.Z, test.ShouldEqual, 0)
+		test.That(t, 2*math.Atan2(position.Kmag, position.Real), test.ShouldAlmostEqual, 0.1, 0.02)
+
+		pcd, err := vc.getPointCloudMap()
+		test.That(t, err, test.ShouldBeNil)
+		pc, err := pointcloud.ReadPCD(bytes.NewReader(pcd))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, pc.Size(), test.ShouldBeGreaterThan, 100)
+		pc.Iterate(0, 0, func(p r3.Vector, d pointcloud.Data) bool {
+			// every occupied cell should be on a wall of the room
+			onWall := math.Abs(math.Abs(p.X)-3000) <= 100 || math.Abs(math.Abs(p.Y)-2000) <= 100
+			test.That(t, onWall, test.ShouldBeTrue)
+			return true
+		})
+
+		internalState, err := vc.getInternalState()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(internalState), test.ShouldBeGreaterThan, 0)
+
+		// the streams yield the same exports in chunks
+		pcdStream, err := vc.getPointCloudMapStream(MapOptions{})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, readSimStream(t, pcdStream, 100), test.ShouldResemble, pcd)
+		internalStateStream, err := vc.getInternalStateStream()
+		test.That(t, err, test.ShouldBeNil)
+		// the grid is serialized from a map, so only the size of the internal state is stable
+		test.That(t, len(readSimStream(t, internalStateStream, 100)), test.ShouldEqual, len(internalState))
+
+		stats, err := vc.getStats()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, stats.Trajectories, test.ShouldEqual, 1)
+		test.That(t, stats.Nodes, test.ShouldEqual, 11)
+		test.That(t, stats.SubmapsActive, test.ShouldEqual, 1)
+		test.That(t, stats.GridBytes, test.ShouldBeGreaterThan, 0)
+
+		savedMap := filepath.Join(dir, "saved_map.pbstream")
+		_, err = vc.saveMap("", false)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_SAVE_MAP_PATH_INVALID"))
+		saved, err := vc.saveMap(savedMap, true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, saved.Nodes, test.ShouldEqual, 11)
+		savedInternalState, err := os.ReadFile(savedMap)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(savedInternalState), test.ShouldEqual, len(internalState))
+
+		// the simulator has no pose graph, so an optimization finishes immediately
+		test.That(t, vc.optimize(), test.ShouldBeNil)
+		optimizationStatus, err := vc.getOptimizationStatus()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, optimizationStatus.Running, test.ShouldBeFalse)
+
+		// the grid of the room is returned as a single submap
+		submaps, err := vc.getSubmaps()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(submaps), test.ShouldEqual, 1)
+		test.That(t, submaps[0].Version, test.ShouldEqual, 11)
+		test.That(t, submaps[0].Finished, test.ShouldBeFalse)
+		test.That(t, submaps[0].ResolutionMeters, test.ShouldEqual, simResolutionMeters)
+		test.That(t, submaps[0].MinX, test.ShouldAlmostEqual, -3000, 100)
+		test.That(t, submaps[0].MaxX, test.ShouldAlmostEqual, 3000, 100)
+		test.That(t, submaps[0].MinY, test.ShouldAlmostEqual, -2000, 100)
+		test.That(t, submaps[0].MaxY, test.ShouldAlmostEqual, 2000, 100)
+
+		// every scan adds a node to the active trajectory
+		nodes, err := vc.getTrajectory(false, 1)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(nodes), test.ShouldEqual, 11)
+		test.That(t, nodes[10].Time.Equal(timestamp), test.ShouldBeTrue)
+		test.That(t, nodes[10].X, test.ShouldAlmostEqual, position.X)
+		test.That(t, nodes[10].Y, test.ShouldAlmostEqual, position.Y)
+		// downsampling keeps every nth node & the last node
+		nodes, err = vc.getTrajectory(false, 4)
+		test.That(t, err, test.ShouldBeNil)
+		nodeIndexes := []int{}
+		for _, node := range nodes {
+			nodeIndexes = append(nodeIndexes, node.NodeIndex)
+		}
+		test.That(t, nodeIndexes, test.ShouldResemble, []int{0, 4, 8, 10})
+		_, err = vc.getTrajectory(false, 0)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_GET_TRAJECTORY_EVERY_N_NODES_INVALID"))
+
+		// the config reports the mapping lua configuration without the trimmers, which mapping doesn't use
+		getConfig, err := vc.getConfig()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, getConfig.ConfigurationBasename, test.ShouldEqual, "mapping_new_map.lua")
+		test.That(t, getConfig.NumRangeData, test.ShouldEqual, algoCfg.NumRangeData)
+		test.That(t, getConfig.MaxRange, test.ShouldEqual, algoCfg.MaxRange)
+		test.That(t, getConfig.MaxSubmapsToKeep, test.ShouldEqual, 0)
+		test.That(t, getConfig.FreshSubmapsCount, test.ShouldEqual, 0)
+
+		// the pose can only be set when localizing
+		err = vc.setPose(Pose2D{X: 1000})
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_LOCALIZING_MODE"))
+
+		// stopping saves the final internal state to the data dir
+		test.That(t, vc.stop(), test.ShouldBeNil)
+		test.That(t, vc.terminate(), test.ShouldBeNil)
+		files, err := filepath.Glob(filepath.Join(dir, "internal_state", "*.pbstream"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+
+		// restarting with map_rate_sec = 0 localizes against the saved map
+		cfg.MapRateSecond = 0
+		vc, err = NewCarto(cfg, algoCfg, &lib)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, vc.SlamMode, test.ShouldEqual, LocalizingMode)
+		test.That(t, vc.start(), test.ShouldBeNil)
+
+		for i := 0; i <= 4; i++ {
+			timestamp = timestamp.Add(200 * time.Millisecond)
+			scan := simulatedRoomScan(t, 0.05*float64(i), 0, 0)
+			test.That(t, vc.addLidarReading("mysensor", scan, timestamp), test.ShouldBeNil)
+		}
+		position, err = vc.getPosition()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, position.X, test.ShouldAlmostEqual, 200, 50)
+		test.That(t, position.Y, test.ShouldAlmostEqual, 0, 50)
+
+		// setting the pose moves the robot within the map
+		err = vc.setPose(Pose2D{X: math.NaN()})
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_POSE_INVALID"))
+		test.That(t, vc.setPose(Pose2D{X: 1000, Y: 500}), test.ShouldBeNil)
+		position, err = vc.getPosition()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, position.X, test.ShouldAlmostEqual, 1000, 1)
+		test.That(t, position.Y, test.ShouldAlmostEqual, 500, 1)
+		timestamp = timestamp.Add(200 * time.Millisecond)
+		test.That(t, vc.addLidarReading("mysensor", simulatedRoomScan(t, 1, 0.5, 0), timestamp), test.ShouldBeNil)
+		position, err = vc.getPosition()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, position.X, test.ShouldAlmostEqual, 1000, 50)
+		test.That(t, position.Y, test.ShouldAlmostEqual, 500, 50)
+
+		// the trajectory of the saved map is kept & setting the pose started a new trajectory
+		nodes, err = vc.getTrajectory(true, 1)
+		test.That(t, err, test.ShouldBeNil)
+		nodesPerTrajectory := map[int]int{}
+		for _, node := range nodes {
+			nodesPerTrajectory[node.TrajectoryID]++
+		}
+		test.That(t, nodesPerTrajectory, test.ShouldResemble, map[int]int{0: 11, 1: 5, 2: 1})
+		nodes, err = vc.getTrajectory(false, 1)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(nodes), test.ShouldEqual, 1)
+		test.That(t, nodes[0].TrajectoryID, test.ShouldEqual, 2)
+
+		test.That(t, vc.stop(), test.ShouldBeNil)
+		test.That(t, vc.terminate(), test.ShouldBeNil)
+
+		// the localization trajectory starts at the initial pose
+		cfg.InitialPose = &Pose2D{X: -1000, Y: 250, Theta: 90}
+		vc, err = NewCarto(cfg, algoCfg, &lib)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, vc.start(), test.ShouldBeNil)
+		position, err = vc.getPosition()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, position.X, test.ShouldAlmostEqual, -1000, 1)
+		test.That(t, position.Y, test.ShouldAlmostEqual, 250, 1)
+		test.That(t, 2*math.Atan2(position.Kmag, position.Real), test.ShouldAlmostEqual, math.Pi/2, 0.01)
+		test.That(t, vc.stop(), test.ShouldBeNil)
+		test.That(t, vc.terminate(), test.ShouldBeNil)
+
+		cfg.InitialPose = &Pose2D{Theta: math.Inf(1)}
+		_, err = NewCarto(cfg, algoCfg, &lib)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_POSE_INVALID"))
+	})
+
+	t.Run("paints the map as an occupancy grid in the frame of the pointcloud map", func(t *testing.T) {
+		cfg, dir, err := GetTestConfig("mysensor", "")
+		test.That(t, err, test.ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		vc, err := NewCarto(cfg, GetTestAlgoConfig(), &lib)
+		test.That(t, err, test.ShouldBeNil)
+		_, err = vc.getOccupancyGrid(MapOptions{})
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE"))
+		_, err = vc.getSubmapGrids([]SubmapID{{}})
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE"))
+		test.That(t, vc.start(), test.ShouldBeNil)
+		_, err = vc.getOccupancyGrid(MapOptions{})
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_OCCUPANCY_GRID_EMPTY"))
+		grids, err := vc.getSubmapGrids([]SubmapID{{}})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, grids, test.ShouldResemble, []SubmapGrid{})
+
+		timestamp := time.Date(2021, 8, 15, 14, 30, 45, 100, time.UTC)
+		for i := 0; i <= 4; i++ {
+			timestamp = timestamp.Add(200 * time.Millisecond)
+			scan := simulatedRoomScan(t, 0.05*float64(i), 0, 0)
+			test.That(t, vc.addLidarReading("mysensor", scan, timestamp), test.ShouldBeNil)
+		}
+
+		grid, err := vc.getOccupancyGrid(MapOptions{})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, grid.ResolutionMeters, test.ShouldEqual, simResolutionMeters)
+		test.That(t, len(grid.Cells), test.ShouldEqual, grid.Width*grid.Height)
+		// the rays of the scans are traced through free cells
+		var free int
+		for _, cell := range grid.Cells {
+			if cell < 50 {
+				free++
+			} else if cell != OccupancyGridUnknownCell {
+				test.That(t, cell, test.ShouldBeLessThanOrEqualTo, 100)
+			}
+		}
+		test.That(t, free, test.ShouldBeGreaterThan, 0)
+
+		// the single submap is at the origin of the map, so its grid is the grid of the map, & submaps which
+		// don't exist are left out
+		submaps, err := vc.getSubmaps()
+		test.That(t, err, test.ShouldBeNil)
+		grids, err = vc.getSubmapGrids([]SubmapID{{}, {SubmapIndex: 1}})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, grids, test.ShouldResemble, []SubmapGrid{{Version: submaps[0].Version, Grid: grid}})
+
+		// every point of the pointcloud map is an occupied cell of the grid
+		pcd, err := vc.getPointCloudMap()
+		test.That(t, err, test.ShouldBeNil)
+		points, err := simPointsFromPCD(pcd)
+		test.That(t, err, test.ShouldBeNil)
+		for _, p := range points {
+			col := int(math.Floor((p.x - grid.OriginX) / grid.ResolutionMeters))
+			row := grid.Height - 1 - int(math.Floor((p.y-grid.OriginY)/grid.ResolutionMeters))
+			test.That(t, grid.Cells[row*grid.Width+col], test.ShouldBeBetweenOrEqual, 50, 100)
+		}
+
+		test.That(t, vc.stop(), test.ShouldBeNil)
+		test.That(t, vc.terminate(), test.ShouldBeNil)
+	})
+
+	t.Run("paints the map with the given map options", func(t *testing.T) {
+		cfg, dir, err := GetTestConfig("mysensor", "")
+		test.That(t, err, test.ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		algoCfg := GetTestAlgoConfig()
+		algoCfg.MapResolutionMeters = MinMapResolutionMeters / 2
+		_, err = NewCarto(cfg, algoCfg, &lib)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_MAP_OPTIONS_INVALID"))
+
+		vc, err := NewCarto(cfg, GetTestAlgoConfig(), &lib)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, vc.start(), test.ShouldBeNil)
+		timestamp := time.Date(2021, 8, 15, 14, 30, 45, 100, time.UTC)
+		for i := 0; i <= 4; i++ {
+			timestamp = timestamp.Add(200 * time.Millisecond)
+			scan := simulatedRoomScan(t, 0.05*float64(i), 0, 0)
+			test.That(t, vc.addLidarReading("mysensor", scan, timestamp), test.ShouldBeNil)
+		}
+
+		for _, opts := range []MapOptions{{ResolutionMeters: MinMapResolutionMeters / 2}, {MaxPoints: -1}} {
+			_, err = vc.getOccupancyGrid(opts)
+			test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_MAP_OPTIONS_INVALID"))
+			_, err = vc.getPointCloudMapStream(opts)
+			test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_MAP_OPTIONS_INVALID"))
+		}
+
+		fine, err := vc.getOccupancyGrid(MapOptions{})
+		test.That(t, err, test.ShouldBeNil)
+		finePCD, err := vc.getPointCloudMap()
+		test.That(t, err, test.ShouldBeNil)
+		finePoints, err := simPointsFromPCD(finePCD)
+		test.That(t, err, test.ShouldBeNil)
+
+		// a coarser resolution paints fewer cells & points
+		coarse, err := vc.getOccupancyGrid(MapOptions{ResolutionMeters: 4 * simResolutionMeters})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, coarse.ResolutionMeters, test.ShouldEqual, 4*simResolutionMeters)
+		test.That(t, coarse.Width*coarse.Height, test.ShouldBeLessThan, fine.Width*fine.Height)
+		coarseStream, err := vc.getPointCloudMapStream(MapOptions{ResolutionMeters: 4 * simResolutionMeters})
+		test.That(t, err, test.ShouldBeNil)
+		coarsePoints, err := simPointsFromPCD(readSimStream(t, coarseStream, 100))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(coarsePoints), test.ShouldBeLessThan, len(finePoints))
+
+		// a finer resolution still paints every occupied cell
+		finer, err := vc.getOccupancyGrid(MapOptions{ResolutionMeters: simResolutionMeters / 2})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, finer.Width*finer.Height, test.ShouldBeGreaterThan, fine.Width*fine.Height)
+
+		// the point budget coarsens the map until it fits
+		budget, err := vc.getOccupancyGrid(MapOptions{MaxPoints: fine.Width * fine.Height / 4})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, budget.ResolutionMeters, test.ShouldBeGreaterThan, fine.ResolutionMeters)
+		test.That(t, budget.Width*budget.Height, test.ShouldBeLessThanOrEqualTo, fine.Width*fine.Height/4)
+		budgetStream, err := vc.getPointCloudMapStream(MapOptions{MaxPoints: len(finePoints) / 4})
+		test.That(t, err, test.ShouldBeNil)
+		budgetPoints, err := simPointsFromPCD(readSimStream(t, budgetStream, 100))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(budgetPoints), test.ShouldBeLessThanOrEqualTo, len(finePoints)/4)
+
+		// every format holds the points of the pcd, with their probability
+		_, err = vc.getPointCloudMapStream(MapOptions{Format: PLYFormat + 1})
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_MAP_OPTIONS_INVALID"))
+		expectedPoints := readSimPointCloud(t, PCDFormat, finePCD)
+		test.That(t, len(expectedPoints), test.ShouldEqual, len(finePoints))
+		for _, format := range PointCloudFormats {
+			stream, err := vc.getPointCloudMapStream(MapOptions{Format: format})
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, readSimPointCloud(t, format, readSimStream(t, stream, 100)), test.ShouldResemble, expectedPoints)
+		}
+
+		// only the cells within the region are painted
+		_, err = vc.getOccupancyGrid(MapOptions{Region: &MapRegion{MinX: 1000, MaxX: 0, MinY: 0, MaxY: 1000}})
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_MAP_OPTIONS_INVALID"))
+		_, err = vc.getOccupancyGrid(MapOptions{Region: &MapRegion{MinX: 1e6, MaxX: 1e6 + 1000, MinY: 0, MaxY: 1000}})
+		test.That(t, err, test.ShouldBeError, ErrOccupancyGridEmpty)
+		region := MapRegion{MinX: 2000, MaxX: 4000, MinY: -1000, MaxY: 1000}
+		regionGrid, err := vc.getOccupancyGrid(MapOptions{Region: &region})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, regionGrid.Width*regionGrid.Height, test.ShouldBeLessThan, fine.Width*fine.Height)
+		test.That(t, regionGrid.OriginX, test.ShouldBeGreaterThanOrEqualTo, 2-simResolutionMeters)
+		test.That(t, regionGrid.OriginY, test.ShouldBeGreaterThanOrEqualTo, -1-simResolutionMeters)
+		regionStream, err := vc.getPointCloudMapStream(MapOptions{Region: &region})
+		test.That(t, err, test.ShouldBeNil)
+		regionPoints, err := simPointsFromPCD(readSimStream(t, regionStream, 100))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(regionPoints), test.ShouldBeGreaterThan, 0)
+		test.That(t, len(regionPoints), test.ShouldBeLessThan, len(finePoints))
+		for _, p := range regionPoints {
+			test.That(t, region.Contains(p.x*1000, p.y*1000), test.ShouldBeTrue)
+		}
+
+		test.That(t, vc.stop(), test.ShouldBeNil)
+		test.That(t, vc.terminate(), test.ShouldBeNil)
+	})
+
+	t.Run("continues mapping from an initial internal state rather than updating it", func(t *testing.T) {
+		cfg, dir, err := GetTestConfig("mysensor", "")
+		test.That(t, err, test.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		algoCfg := GetTestAlgoConfig()
+
+		vc, err := NewCarto(cfg, algoCfg, &lib)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, vc.start(), test.ShouldBeNil)
+		timestamp := time.Date(2021, 8, 15, 14, 30, 45, 100, time.UTC)
+		for i := 0; i <= 4; i++ {
+			timestamp = timestamp.Add(200 * time.Millisecond)
+			scan := simulatedRoomScan(t, 0.05*float64(i), 0, 0)
+			test.That(t, vc.addLidarReading("mysensor", scan, timestamp), test.ShouldBeNil)
+		}
+		test.That(t, vc.stop(), test.ShouldBeNil)
+		test.That(t, vc.terminate(), test.ShouldBeNil)
+		files, err := filepath.Glob(filepath.Join(dir, "internal_state", "*.pbstream"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+
+		// the saved internal state would otherwise be updated
+		cfg.InitialInternalState = files[0]
+		vc, err = NewCarto(cfg, algoCfg, &lib)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, vc.SlamMode, test.ShouldEqual, MappingMode)
+		test.That(t, vc.start(), test.ShouldBeNil)
+		getConfig, err := vc.getConfig()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, getConfig.ConfigurationBasename, test.ShouldEqual, "mapping_new_map.lua")
+		stats, err := vc.getStats()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, stats.Nodes, test.ShouldEqual, 5)
+		test.That(t, vc.stop(), test.ShouldBeNil)
+		test.That(t, vc.terminate(), test.ShouldBeNil)
+
+		cfg.InitialInternalState = filepath.Join(dir, "missing.pbstream")
+		_, err = NewCarto(cfg, algoCfg, &lib)
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_GET_INTERNAL_STATE_FILE_READ_IO_ERROR"))
+	})
+
+	t.Run("switches from mapping to localizing against the map built so far", func(t *testing.T) {
+		cfg, dir, err := GetTestConfig("mysensor", "")
+		test.That(t, err, test.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		algoCfg := GetTestAlgoConfig()
+
+		vc, err := NewCarto(cfg, algoCfg, &lib)
+		test.That(t, err, test.ShouldBeNil)
+		_, err = vc.switchToLocalization()
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE"))
+		test.That(t, vc.start(), test.ShouldBeNil)
+
+		// there is nothing to localize against yet, so mapping continues
+		slamMode, err := vc.switchToLocalization()
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_FROZEN_MAP_EMPTY"))
+		test.That(t, slamMode, test.ShouldEqual, MappingMode)
+		test.That(t, vc.SlamMode, test.ShouldEqual, MappingMode)
+
+		timestamp := time.Date(2021, 8, 15, 14, 30, 45, 100, time.UTC)
+		for i := 0; i <= 4; i++ {
+			timestamp = timestamp.Add(200 * time.Millisecond)
+			scan := simulatedRoomScan(t, 0.05*float64(i), 0, 0)
+			test.That(t, vc.addLidarReading("mysensor", scan, timestamp), test.ShouldBeNil)
+		}
+		mapBeforeSwitch, err := vc.getPointCloudMap()
+		test.That(t, err, test.ShouldBeNil)
+
+		slamMode, err = vc.switchToLocalization()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, slamMode, test.ShouldEqual, LocalizingMode)
+		test.That(t, vc.SlamMode, test.ShouldEqual, LocalizingMode)
+		slamMode, err = vc.switchToLocalization()
+		test.That(t, err, test.ShouldResemble, errors.New("VIAM_CARTO_ALREADY_IN_LOCALIZING_MODE"))
+		test.That(t, slamMode, test.ShouldEqual, LocalizingMode)
+		getConfig, err := vc.getConfig()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, getConfig.ConfigurationBasename, test.ShouldEqual, "locating_in_map.lua")
+		test.That(t, getConfig.MaxSubmapsToKeep, test.ShouldEqual, algoCfg.MaxSubmapsToKeep)
+
+		// scans are localized against the frozen map in a new trajectory
+		timestamp = timestamp.Add(200 * time.Millisecond)
+		revisionBeforeScan, err := vc.getMapRevision()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, vc.addLidarReading("mysensor", simulatedRoomScan(t, 0.5, 0.5, 0), timestamp), test.ShouldBeNil)
+		revisionAfterScan, err := vc.getMapRevision()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, revisionAfterScan, test.ShouldResemble, revisionBeforeScan)
+		mapAfterSwitch, err := vc.getPointCloudMap()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, mapAfterSwitch, test.ShouldResemble, mapBeforeSwitch)
+		nodes, err := vc.getTrajectory(false, 1)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(nodes), test.ShouldEqual, 1)
+		test.That(t, nodes[0].TrajectoryID, test.ShouldEqual, 1)
+
+		// the pose can be set once localizing
+		test.That(t, vc.setPose(Pose2D{X: 1000}), test.ShouldBeNil)
+
+		test.That(t, vc.stop(), test.ShouldBeNil)
+		test.That(t, vc.terminate(), test.ShouldBeNil)
+	})
+}
+
+// readSimPointCloud returns the x, y, z & probability of each point of a pointcloud map written in the format.
+func readSimPointCloud(t *testing.T, format PointCloudFormat, data []byte) [][4]float64 {
+	t.Helper()
+	header := simPointCloudHeader(format, 0)
+	headerEnd := header[strings.LastIndex(header[:len(header)-1], "\n")+1:]
+	i := bytes.Index(data, []byte(headerEnd))
+	test.That(t, i, test.ShouldBeGreaterThan, 0)
+	var numPoints int
+	numPointsLine := "\nPOINTS %d\n"
+	if format == PLYFormat {
+		numPointsLine = "\nelement vertex %d\n"
+	}
+	lines := strings.SplitAfter(string(data[:i]), "\n")
+	for j := range lines[:len(lines)-1] {
+		if n, err := fmt.Sscanf("\n"+lines[j], numPointsLine, &numPoints); err == nil && n == 1 {
+			break
+		}
+	}
+	test.That(t, numPoints, test.ShouldBeGreaterThan, 0)
+	body := data[i+len(headerEnd):]
+
+	points := make([][4]float64, numPoints)
+	float := func(b []byte) float64 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	switch format {
+	case PCDFormat:
+		test.That(t, len(body), test.ShouldEqual, 16*numPoints)
+		for j := range points {
+			p := body[16*j:]
+			points[j] = [4]float64{float(p), float(p[4:]), float(p[8:]), float64(binary.LittleEndian.Uint32(p[12:]))}
+		}
+	case PCDASCIIFormat:
+		rows := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+		test.That(t, len(rows), test.ShouldEqual, numPoints)
+		for j, row := range rows {
+			// the values are parsed as floats, which they were written from
+			var x, y, z float32
+			_, err := fmt.Sscanf(row, "%f %f %f %f", &x, &y, &z, &points[j][3])
+			test.That(t, err, test.ShouldBeNil)
+			points[j][0], points[j][1], points[j][2] = float64(x), float64(y), float64(z)
+		}
+	case PCDBinaryCompressedFormat:
+		compressedSize := int(binary.LittleEndian.Uint32(body))
+		fields := simLZFDecompress(t, body[8:], int(binary.LittleEndian.Uint32(body[4:])))
+		test.That(t, len(body), test.ShouldEqual, 8+compressedSize)
+		test.That(t, len(fields), test.ShouldEqual, 13*numPoints)
+		for j := range points {
+			points[j] = [4]float64{
+				float(fields[4*j:]),
+				float(fields[4*(numPoints+j):]),
+				float(fields[4*(2*numPoints+j):]),
+				float64(fields[12*numPoints+j]),
+			}
+		}
+	default:
+		test.That(t, len(body), test.ShouldEqual, 13*numPoints)
+		for j := range points {
+			p := body[13*j:]
+			points[j] = [4]float64{float(p), float(p[4:]), float(p[8:]), float64(p[12])}
+		}
+	}
+	return points
+}
+
+// simLZFDecompress decompresses the LZF data written by simLZFCompress into size bytes.
+func simLZFDecompress(t *testing.T, in []byte, size int) []byte {
+	t.Helper()
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			out = append(out, in[i:i+ctrl+1]...)
+			i += ctrl + 1
+			continue
+		}
+		length := ctrl >> 5
+		if length == 7 {
+			length += int(in[i])
+			i++
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		test.That(t, ref, test.ShouldBeGreaterThanOrEqualTo, 0)
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	test.That(t, len(out), test.ShouldEqual, size)
+	return out
+}
+
+func TestSimLZFCompress(t *testing.T) {
+	for _, in := range [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("abcabcabcabcabcabcabcabcabcabcabcabcabcabcabcabcabc"),
+		bytes.Repeat([]byte{0}, 10000),
+		bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz"), 500),
+	} {
+		compressed := simLZFCompress(in)
+		test.That(t, simLZFDecompress(t, compressed, len(in)), test.ShouldResemble, append([]byte{}, in...))
+		if len(in) > 100 {
+			test.That(t, len(compressed), test.ShouldBeLessThan, len(in)/10)
+		}
+	}
+
+	// data which doesn't compress is written as literals
+	random := make([]byte, 1000)
+	for i := range random {
+		random[i] = byte(i * 7919 % 251)
+	}
+	test.That(t, simLZFDecompress(t, simLZFCompress(random), len(random)), test.ShouldResemble, random)
+}
+
+// readSimStream reads & closes the stream, checking that no chunk is larger than maxChunkSizeBytes.
+func readSimStream(t *testing.T, s CartoStreamInterface, maxChunkSizeBytes int) []byte {
+	t.Helper()
+	var all []byte
+	for {
+		chunk, err := s.next(maxChunkSizeBytes)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(chunk), test.ShouldBeLessThanOrEqualTo, maxChunkSizeBytes)
+		all = append(all, chunk...)
+	}
+	test.That(t, s.close(), test.ShouldBeNil)
+	_, err := s.next(maxChunkSizeBytes)
+	test.That(t, err, test.ShouldBeError, errors.New("VIAM_CARTO_STREAM_INVALID"))
+	return all
+}
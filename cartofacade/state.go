@@ -0,0 +1,136 @@
+package cartofacade
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// State is the lifecycle state of a CartoFacade. It mirrors the CartoFacadeState of the C++ CartoFacade,
+// with the addition of the states before viam_carto_init & after viam_carto_terminate.
+type State int64
+
+const (
+	// UninitializedState is the state of a CartoFacade before Initialize has succeeded.
+	UninitializedState State = iota
+	// IOInitializedState is the state of a CartoFacade after Initialize or Stop have succeeded.
+	IOInitializedState
+	// StartedState is the state of a CartoFacade after Start has succeeded.
+	StartedState
+	// TerminatedState is the state of a CartoFacade after Terminate has succeeded.
+	TerminatedState
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case UninitializedState:
+		return "uninitialized"
+	case IOInitializedState:
+		return "io_initialized"
+	case StartedState:
+		return "started"
+	case TerminatedState:
+		return "terminated"
+	default:
+		return "unknown"
+	}
+}
+
+// StateTransition describes a change of the lifecycle state of a CartoFacade.
+type StateTransition struct {
+	From        State
+	To          State
+	RequestType RequestType
+	Time        time.Time
+}
+
+// lifecycle tracks the State of a CartoFacade. The state is only changed by the C goroutine,
+// but can be read from any goroutine.
+type lifecycle struct {
+	mu           sync.RWMutex
+	state        State
+	onTransition func(StateTransition)
+}
+
+// get returns the current state, a nil lifecycle is uninitialized.
+func (l *lifecycle) get() State {
+	if l == nil {
+		return UninitializedState
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.state
+}
+
+// check returns the error the carto C API would return if the request was made in the current state,
+// so that invalid requests are rejected before calling into C.
+func (l *lifecycle) check(requestType RequestType) error {
+	state := l.get()
+	switch requestType {
+	case initialize:
+		if state != UninitializedState {
+			return errors.New("VIAM_CARTO_NOT_IN_INITIALIZED_STATE")
+		}
+	case start:
+		if state != IOInitializedState {
+			return errors.New("VIAM_CARTO_NOT_IN_IO_INITIALIZED_STATE")
+		}
+	case terminate:
+		if state != IOInitializedState {
+			return errors.New("VIAM_CARTO_NOT_IN_TERMINATABLE_STATE")
+		}
+	case stop, addLidarReading, position, internalState, pointCloudMap, statistics,
+		pointCloudMapStream, internalStateStream, saveMap, setPose, switchToLocalization, optimize, optimizationStatus,
+		getTrajectory, getSubmaps, configuration, occupancyGrid, submapGrids:
+		if state != StartedState {
+			return errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")
+		}
+	case streamNext, streamClose:
+		// streams are independent of the carto object, so they can be read & closed in any state
+	}
+	return nil
+}
+
+// transition moves to the state which follows a successful request of the given type & emits the transition
+// to the registered handler, if any. Requests which don't change the state are ignored.
+func (l *lifecycle) transition(requestType RequestType) {
+	if l == nil {
+		return
+	}
+	var next State
+	switch requestType {
+	case initialize, stop:
+		next = IOInitializedState
+	case start:
+		next = StartedState
+	case terminate:
+		next = TerminatedState
+	default:
+		return
+	}
+
+	l.mu.Lock()
+	t := StateTransition{From: l.state, To: next, RequestType: requestType, Time: time.Now()}
+	l.state = next
+	onTransition := l.onTransition
+	l.mu.Unlock()
+
+	if onTransition != nil {
+		onTransition(t)
+	}
+}
+
+// set overwrites the current state without emitting a transition.
+func (l *lifecycle) set(state State) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = state
+}
+
+// setOnTransition registers the handler called on every state transition.
+func (l *lifecycle) setOnTransition(onTransition func(StateTransition)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onTransition = onTransition
+}
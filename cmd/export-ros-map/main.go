@@ -0,0 +1,59 @@
+// Package main exports the map of a pbstream saved by the cartographer module as a PGM & YAML in the
+// map_server format of ROS, without a running robot.
+package main
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"strings"
+
+	"github.com/edaniels/golog"
+	"github.com/pkg/errors"
+	"go.viam.com/utils"
+
+	viamcartographer "github.com/viamrobotics/viam-cartographer"
+	"github.com/viamrobotics/viam-cartographer/rosmap"
+)
+
+func main() {
+	utils.ContextualMain(mainWithArgs, golog.NewDevelopmentLogger("exportROSMap"))
+}
+
+func mainWithArgs(ctx context.Context, args []string, logger golog.Logger) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	pbstream := flags.String("pbstream", "", "path of the pbstream to export")
+	outDir := flags.String("out_dir", ".", "directory the pgm & yaml are written into")
+	name := flags.String("name", "", "name of the pgm & yaml, defaults to the name of the pbstream")
+	freeThresh := flags.Float64("free_thresh", rosmap.DefaultFreeThresh, "cells less likely occupied are free")
+	occupiedThresh := flags.Float64("occupied_thresh", rosmap.DefaultOccupiedThresh, "cells more likely occupied are occupied")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *pbstream == "" {
+		return errors.New("-pbstream is required")
+	}
+	if *name == "" {
+		*name = strings.TrimSuffix(filepath.Base(*pbstream), filepath.Ext(*pbstream))
+	}
+	thresholds := rosmap.Thresholds{Free: *freeThresh, Occupied: *occupiedThresh}
+	if err := thresholds.Validate(); err != nil {
+		return err
+	}
+
+	if err := viamcartographer.InitCartoLib(logger); err != nil {
+		return err
+	}
+	defer func() {
+		if err := viamcartographer.TerminateCartoLib(); err != nil {
+			logger.Errorw("failed to terminate carto lib", "error", err)
+		}
+	}()
+
+	pgmPath, yamlPath, err := viamcartographer.ExportROSMap(ctx, *pbstream, *outDir, *name, thresholds, logger)
+	if err != nil {
+		return err
+	}
+	logger.Infow("exported ros map", "pgm", pgmPath, "yaml", yamlPath)
+	return nil
+}
@@ -25,6 +25,9 @@ type Config struct {
 	IMUIntegrationEnabled bool              `json:"imu_integration_enabled"`
 	Sensors               []string          `json:"sensors"`
 	DataRateMsec          *int              `json:"data_rate_msec"`
+	ChunkSizeBytes        *int              `json:"chunk_size_bytes"`
+	InitialPose           *InitialPose      `json:"initial_pose"`
+	MapImage              *MapImage         `json:"map_image"`
 
 	CloudStoryEnabled bool   `json:"cloud_story_enabled"`
 	ExistingMap       string `json:"existing_map"`
@@ -32,6 +35,36 @@ type Config struct {
 	UseCloudSlam      *bool  `json:"use_cloud_slam"`
 }
 
+// InitialPose is the pose in the frame of the map that localization starts at, rather than the origin.
+type InitialPose struct {
+	// X is in millimeters
+	X float64 `json:"x"`
+	// Y is in millimeters
+	Y float64 `json:"y"`
+	// Theta is in degrees counterclockwise from the x axis
+	Theta float64 `json:"theta"`
+}
+
+// MapImage configures a PNG of the map, the trajectory & the current pose periodically written into the data
+// directory.
+type MapImage struct {
+	// RateSec is how often the PNG is written, it is not written if 0
+	RateSec int `json:"rate_sec"`
+	// Scale is the number of pixels per cell of the map, it defaults to 1
+	Scale float64 `json:"scale"`
+	// Crop is the part of the map rendered, the whole map if unset
+	Crop *MapImageCrop `json:"crop"`
+}
+
+// MapImageCrop is an axis aligned box in the frame of the map.
+type MapImageCrop struct {
+	// MinX, MinY, MaxX & MaxY are in millimeters
+	MinX float64 `json:"min_x"`
+	MinY float64 `json:"min_y"`
+	MaxX float64 `json:"max_x"`
+	MaxY float64 `json:"max_y"`
+}
+
 // OptionalConfigParams holds the optional config parameters of SLAM.
 type OptionalConfigParams struct {
 	LidarDataRateMsec int
@@ -84,6 +117,16 @@ func (config *Config) Validate(path string) ([]string, error) {
 		}
 	}
 
+	if config.ChunkSizeBytes != nil && *config.ChunkSizeBytes <= 0 {
+		return nil, errors.New("cannot specify chunk_size_bytes less than or equal to zero")
+	}
+
+	if config.MapImage != nil {
+		if err := config.MapImage.validate(config.DataDirectory); err != nil {
+			return nil, err
+		}
+	}
+
 	if config.ConfigParams["mode"] == "" {
 		return nil, utils.NewConfigValidationFieldRequiredError(path, "config_params[mode]")
 	}
@@ -105,6 +148,22 @@ func (config *Config) Validate(path string) ([]string, error) {
 	return deps, nil
 }
 
+func (mapImage *MapImage) validate(dataDirectory string) error {
+	if mapImage.RateSec < 0 {
+		return errors.New("cannot specify map_image[rate_sec] less than zero")
+	}
+	if mapImage.RateSec > 0 && dataDirectory == "" {
+		return errors.New("map_image[rate_sec] requires data_dir")
+	}
+	if mapImage.Scale < 0 {
+		return errors.New("cannot specify map_image[scale] less than zero")
+	}
+	if crop := mapImage.Crop; crop != nil && !(crop.MinX < crop.MaxX && crop.MinY < crop.MaxY) {
+		return errors.New("map_image[crop] must satisfy min_x < max_x & min_y < max_y")
+	}
+	return nil
+}
+
 // GetOptionalParameters sets any unset optional config parameters to the values passed to this function,
 // and returns them.
 func GetOptionalParameters(config *Config, defaultLidarDataRateMsec, defaultIMUDataRateMsec, defaultMapRateSec int, logger golog.Logger,
@@ -127,6 +127,38 @@ func testValidateTesthelper(
 		} else {
 			test.That(t, mapRateSecError, test.ShouldBeError, newError("cannot specify map_rate_sec less than zero"))
 		}
+
+		cfgService = makeCfgService(imuIntegrationEnabled, cloudStoryEnabled)
+		cfgService.Attributes["chunk_size_bytes"] = 0
+		_, err := newConfig(cfgService)
+		test.That(t, err, test.ShouldBeError, newError("cannot specify chunk_size_bytes less than or equal to zero"))
+
+		for _, tc := range []struct {
+			mapImage map[string]interface{}
+			err      string
+		}{
+			{map[string]interface{}{"rate_sec": -1}, "cannot specify map_image[rate_sec] less than zero"},
+			{map[string]interface{}{"scale": -0.5}, "cannot specify map_image[scale] less than zero"},
+			{
+				map[string]interface{}{"crop": map[string]interface{}{"min_x": 10, "max_x": 10, "max_y": 10}},
+				"map_image[crop] must satisfy min_x < max_x & min_y < max_y",
+			},
+		} {
+			cfgService = makeCfgService(imuIntegrationEnabled, cloudStoryEnabled)
+			cfgService.Attributes["map_image"] = tc.mapImage
+			_, err = newConfig(cfgService)
+			test.That(t, err, test.ShouldBeError, newError(tc.err))
+		}
+
+		cfgService = makeCfgService(imuIntegrationEnabled, cloudStoryEnabled)
+		cfgService.Attributes["map_image"] = map[string]interface{}{"rate_sec": 10}
+		delete(cfgService.Attributes, "data_dir")
+		_, err = newConfig(cfgService)
+		if cloudStoryEnabled {
+			test.That(t, err, test.ShouldBeError, newError("map_image[rate_sec] requires data_dir"))
+		} else {
+			test.That(t, err, test.ShouldNotBeNil)
+		}
 	})
 
 	t.Run(fmt.Sprintf("All parameters e2e %s", suffix), func(t *testing.T) {
@@ -134,6 +166,13 @@ func testValidateTesthelper(
 		cfgService.Attributes["sensors"] = []string{"a", "b"}
 		cfgService.Attributes["data_rate_msec"] = 1001
 		cfgService.Attributes["map_rate_sec"] = 1002
+		cfgService.Attributes["chunk_size_bytes"] = 65536
+		cfgService.Attributes["initial_pose"] = map[string]interface{}{"x": 1500.0, "y": -250.0, "theta": 90.0}
+		cfgService.Attributes["map_image"] = map[string]interface{}{
+			"rate_sec": 30,
+			"scale":    2.5,
+			"crop":     map[string]interface{}{"min_x": -1000.0, "min_y": -2000.0, "max_x": 3000.0, "max_y": 4000.0},
+		}
 
 		cfgService.Attributes["config_params"] = map[string]string{
 			"mode":    "test mode",
@@ -147,6 +186,13 @@ func testValidateTesthelper(
 		test.That(t, cfg.ConfigParams, test.ShouldResemble, cfgService.Attributes["config_params"])
 		test.That(t, cfg.DataDirectory, test.ShouldEqual, cfgService.Attributes["data_dir"])
 		test.That(t, *cfg.MapRateSec, test.ShouldEqual, cfgService.Attributes["map_rate_sec"])
+		test.That(t, *cfg.ChunkSizeBytes, test.ShouldEqual, cfgService.Attributes["chunk_size_bytes"])
+		test.That(t, *cfg.InitialPose, test.ShouldResemble, InitialPose{X: 1500, Y: -250, Theta: 90})
+		test.That(t, *cfg.MapImage, test.ShouldResemble, MapImage{
+			RateSec: 30,
+			Scale:   2.5,
+			Crop:    &MapImageCrop{MinX: -1000, MinY: -2000, MaxX: 3000, MaxY: 4000},
+		})
 	})
 }
 
@@ -0,0 +1,108 @@
+// Package densegrid encodes cartographer's occupancy grid as dense cells, so that consumers such as planners can
+// tell explored free space from unexplored space, unlike in the pointcloud map which only holds occupied cells.
+//
+// An encoded grid is a little endian header followed by its cells:
+//
+//	magic               4 bytes, "VCOG"
+//	version             uint16, 1
+//	unknown cell        uint8, the value of cells which were never observed, 255
+//	reserved            uint8, 0
+//	width               uint32, number of columns
+//	height              uint32, number of rows
+//	resolution meters   float64, size of a cell
+//	origin x meters     float64, x of the lower left corner of the first cell in the frame of GetPosition
+//	origin y meters     float64, y of the lower left corner of the first cell in the frame of GetPosition
+//	cells               width * height bytes, the probability that a cell is occupied in percent (0 - 100) or
+//	                    the unknown cell value
+//
+// Cells are row major starting at the origin, as in the OccupancyGrid message of ROS: the center of the cell at
+// column c & row r is at origin + ((c + 0.5) * resolution, (r + 0.5) * resolution).
+package densegrid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+const (
+	// Version is the version of the format written by Encode.
+	Version = 1
+	// HeaderSizeBytes is the size of the header preceding the cells.
+	HeaderSizeBytes = 40
+)
+
+// Magic starts every encoded grid.
+var Magic = [4]byte{'V', 'C', 'O', 'G'}
+
+type header struct {
+	Magic            [4]byte
+	Version          uint16
+	UnknownCell      uint8
+	Reserved         uint8
+	Width            uint32
+	Height           uint32
+	ResolutionMeters float64
+	OriginX          float64
+	OriginY          float64
+}
+
+// Encode writes the grid, whose cells are top row first, with its rows flipped so that they start at the origin.
+func Encode(w io.Writer, grid cartofacade.OccupancyGrid) error {
+	if len(grid.Cells) != grid.Width*grid.Height {
+		return errors.Errorf("occupancy grid has %d cells, expected %d x %d", len(grid.Cells), grid.Width, grid.Height)
+	}
+	h := header{
+		Magic:            Magic,
+		Version:          Version,
+		UnknownCell:      cartofacade.OccupancyGridUnknownCell,
+		Width:            uint32(grid.Width),
+		Height:           uint32(grid.Height),
+		ResolutionMeters: grid.ResolutionMeters,
+		OriginX:          grid.OriginX,
+		OriginY:          grid.OriginY,
+	}
+	if err := binary.Write(w, binary.LittleEndian, h); err != nil {
+		return err
+	}
+	for row := grid.Height - 1; row >= 0; row-- {
+		if _, err := w.Write(grid.Cells[row*grid.Width : (row+1)*grid.Width]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode reads a grid written by Encode, returning its cells top row first as in cartofacade.OccupancyGrid.
+func Decode(r io.Reader) (cartofacade.OccupancyGrid, error) {
+	var h header
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return cartofacade.OccupancyGrid{}, errors.Wrap(err, "failed to read header")
+	}
+	if !bytes.Equal(h.Magic[:], Magic[:]) {
+		return cartofacade.OccupancyGrid{}, errors.Errorf("not a dense occupancy grid, magic is %q", h.Magic[:])
+	}
+	if h.Version != Version {
+		return cartofacade.OccupancyGrid{}, errors.Errorf("unsupported dense occupancy grid version %d", h.Version)
+	}
+
+	width, height := int(h.Width), int(h.Height)
+	cells := make([]byte, width*height)
+	for row := height - 1; row >= 0; row-- {
+		if _, err := io.ReadFull(r, cells[row*width:(row+1)*width]); err != nil {
+			return cartofacade.OccupancyGrid{}, errors.Wrap(err, "failed to read cells")
+		}
+	}
+	return cartofacade.OccupancyGrid{
+		Width:            width,
+		Height:           height,
+		ResolutionMeters: h.ResolutionMeters,
+		OriginX:          h.OriginX,
+		OriginY:          h.OriginY,
+		Cells:            cells,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package densegrid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.viam.com/test"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+// testGrid is 3 cells wide & 2 cells high, its top row is occupied, free & unknown.
+var testGrid = cartofacade.OccupancyGrid{
+	Width:            3,
+	Height:           2,
+	ResolutionMeters: 0.05,
+	OriginX:          -0.075,
+	OriginY:          -1.525,
+	Cells:            []byte{100, 0, cartofacade.OccupancyGridUnknownCell, 65, 19, 50},
+}
+
+func TestEncode(t *testing.T) {
+	t.Run("writes the header & the cells starting at the origin", func(t *testing.T) {
+		var buf bytes.Buffer
+		test.That(t, Encode(&buf, testGrid), test.ShouldBeNil)
+		b := buf.Bytes()
+		test.That(t, len(b), test.ShouldEqual, HeaderSizeBytes+6)
+
+		test.That(t, string(b[0:4]), test.ShouldEqual, "VCOG")
+		test.That(t, binary.LittleEndian.Uint16(b[4:6]), test.ShouldEqual, 1)
+		test.That(t, b[6], test.ShouldEqual, cartofacade.OccupancyGridUnknownCell)
+		test.That(t, binary.LittleEndian.Uint32(b[8:12]), test.ShouldEqual, 3)
+		test.That(t, binary.LittleEndian.Uint32(b[12:16]), test.ShouldEqual, 2)
+		test.That(t, math.Float64frombits(binary.LittleEndian.Uint64(b[16:24])), test.ShouldEqual, 0.05)
+		test.That(t, math.Float64frombits(binary.LittleEndian.Uint64(b[24:32])), test.ShouldEqual, -0.075)
+		test.That(t, math.Float64frombits(binary.LittleEndian.Uint64(b[32:40])), test.ShouldEqual, -1.525)
+		test.That(t, b[HeaderSizeBytes:], test.ShouldResemble, []byte{65, 19, 50, 100, 0, cartofacade.OccupancyGridUnknownCell})
+	})
+
+	t.Run("rejects a grid whose cells don't match its size", func(t *testing.T) {
+		grid := testGrid
+		grid.Width = 4
+		err := Encode(&bytes.Buffer{}, grid)
+		test.That(t, err, test.ShouldBeError, errors.New("occupancy grid has 6 cells, expected 4 x 2"))
+	})
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("reads back an encoded grid", func(t *testing.T) {
+		var buf bytes.Buffer
+		test.That(t, Encode(&buf, testGrid), test.ShouldBeNil)
+		grid, err := Decode(&buf)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, grid, test.ShouldResemble, testGrid)
+	})
+
+	t.Run("rejects anything else", func(t *testing.T) {
+		_, err := Decode(bytes.NewReader(make([]byte, HeaderSizeBytes)))
+		test.That(t, err, test.ShouldBeError, errors.New("not a dense occupancy grid, magic is \"\\x00\\x00\\x00\\x00\""))
+
+		var buf bytes.Buffer
+		test.That(t, Encode(&buf, testGrid), test.ShouldBeNil)
+		b := buf.Bytes()
+		b[4] = 2
+		_, err = Decode(bytes.NewReader(b))
+		test.That(t, err, test.ShouldBeError, errors.New("unsupported dense occupancy grid version 2"))
+
+		b[4] = 1
+		_, err = Decode(bytes.NewReader(b[:len(b)-1]))
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,946 @@
+package viamcartographer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"go.uber.org/multierr"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+	"github.com/viamrobotics/viam-cartographer/rosmap"
+	"github.com/viamrobotics/viam-cartographer/sensorprocess"
+)
+
+const (
+	helpCommand          = "help"
+	jobDoneCommand       = "job_done"
+	restartCommand       = "restart"
+	getStatsCommand      = "get_stats"
+	saveMapCommand       = "save_map"
+	setPoseCommand       = "set_pose"
+	optimizeCommand      = "optimize"
+	getTrajectoryCommand = "get_trajectory"
+	getSubmapsCommand    = "get_submaps"
+	getConfigCommand     = "get_config"
+	resetMapCommand      = "reset_map"
+	switchModeCommand    = "switch_mode"
+	exportROSMapCommand  = "export_ros_map"
+	renderMapCommand     = "render_map"
+	getMapRegionCommand  = "get_map_region"
+	getMapUpdatesCommand = "get_map_updates"
+	// exportPointCloudMapCommand & exportInternalStateCommand export what GetPointCloudMap & GetInternalState
+	// return with options the slam service API has no parameters for.
+	exportPointCloudMapCommand = "export_pointcloud_map"
+	exportInternalStateCommand = "export_internal_state"
+	// exportOccupancyGridCommand exports the dense occupancy grid, which tells free from unexplored space.
+	exportOccupancyGridCommand = "export_occupancy_grid"
+	// finalOptimizationTimeout bounds waiting for a final optimization, which can take far longer than
+	// the other cartofacade requests on large maps.
+	finalOptimizationTimeout = 5 * time.Minute
+	// internalStateTimeFormat mirrors time_format in io.h, which cartographer uses to name the internal state files.
+	internalStateTimeFormat = "2006-01-02T15:04:05.0000Z"
+)
+
+// restart snapshots the internal state of the running cartofacade, terminates it & initializes a new one
+// with the given config_params, which reloads the snapshot in the same slam mode. The sensor dependencies & the
+// service are kept, so neither the sensor validation nor the map built so far are lost.
+func (cartoSvc *CartographerService) restart(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::restart")
+	defer span.End()
+
+	cartoSvc.mu.Lock()
+	defer cartoSvc.mu.Unlock()
+	if cartoSvc.closed {
+		return nil, ErrClosed
+	}
+
+	configParams, err := restartConfigParams(cartoSvc.configParams, params)
+	if err != nil {
+		return nil, err
+	}
+	// validate the new config params before tearing anything down
+	if _, err := parseCartoAlgoConfig(configParams, cartoSvc.logger); err != nil {
+		return nil, errors.Wrap(err, "invalid config_params")
+	}
+
+	// stop sensor process workers so that no readings are added while restarting
+	cartoSvc.cancelSensorProcessFunc()
+	cartoSvc.sensorProcessWorkers.Wait()
+
+	snapshot, err := cartoSvc.snapshotInternalState(ctx)
+	if err != nil {
+		// nothing has been torn down yet, so resume adding sensor readings
+		cartoSvc.restartSensorProcess()
+		return nil, errors.Wrap(err, "failed to snapshot the internal state")
+	}
+
+	if err := terminateCartoFacade(ctx, cartoSvc); err != nil {
+		cartoSvc.logger.Errorw("restart hit error terminating cartofacade", "error", err)
+	}
+	cartoSvc.cancelCartoFacadeFunc()
+	cartoSvc.cartoFacadeWorkers.Wait()
+
+	if snapshot != "" && cartoSvc.cloudStoryEnabled {
+		// the temporary snapshot is only needed until the new cartofacade loaded it
+		defer func() {
+			if err := os.Remove(snapshot); err != nil {
+				cartoSvc.logger.Warnw("restart failed to remove the snapshot", "snapshot", snapshot, "error", err)
+			}
+		}()
+	}
+	cartoSvc.useSnapshot(snapshot)
+	defer func() { cartoSvc.initialInternalState = "" }()
+	previousConfigParams := cartoSvc.configParams
+	cartoSvc.configParams = configParams
+
+	if initErr := cartoSvc.restartCartoFacade(); initErr != nil {
+		cartoSvc.logger.Errorw("restart with new config_params failed, restarting with the previous config_params", "error", initErr)
+		cartoSvc.configParams = previousConfigParams
+		if err := cartoSvc.restartCartoFacade(); err != nil {
+			return nil, multierr.Combine(initErr, err)
+		}
+		cartoSvc.restartSensorProcess()
+		return nil, errors.Wrap(initErr, "failed to restart with the new config_params, restarted with the previous config_params")
+	}
+	cartoSvc.restartSensorProcess()
+
+	return map[string]interface{}{
+		restartCommand: true,
+		"slam_mode":    cartoSvc.SlamMode.String(),
+		"snapshot":     snapshot,
+	}, nil
+}
+
+// resetMap throws away the map built so far & starts mapping again with the same config, e.g. after a bad
+// start. The cartofacade is terminated & a new one initialized, while the sensor dependencies & the service are
+// kept. The internal state snapshots of the thrown away map would otherwise be loaded by the new cartofacade, so
+// they are moved out of the way, or deleted if the delete_snapshots param is set.
+func (cartoSvc *CartographerService) resetMap(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::resetMap")
+	defer span.End()
+
+	deleteSnapshots, err := resetMapParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	cartoSvc.mu.Lock()
+	defer cartoSvc.mu.Unlock()
+	if cartoSvc.closed {
+		return nil, ErrClosed
+	}
+
+	// stop sensor process workers so that no readings are added while resetting
+	cartoSvc.cancelSensorProcessFunc()
+	cartoSvc.sensorProcessWorkers.Wait()
+
+	if err := terminateCartoFacade(ctx, cartoSvc); err != nil {
+		cartoSvc.logger.Errorw("reset_map hit error terminating cartofacade", "error", err)
+	}
+	cartoSvc.cancelCartoFacadeFunc()
+	cartoSvc.cartoFacadeWorkers.Wait()
+
+	// terminating saves a final snapshot, so the snapshots are only cleared once the cartofacade is terminated
+	snapshots, archiveDir, clearErr := cartoSvc.clearInternalState(deleteSnapshots)
+	if clearErr != nil {
+		cartoSvc.logger.Errorw("reset_map failed to clear the internal state", "error", clearErr)
+	}
+
+	if err := cartoSvc.restartCartoFacade(); err != nil {
+		return nil, multierr.Combine(clearErr, err)
+	}
+	cartoSvc.restartSensorProcess()
+	if clearErr != nil {
+		return nil, errors.Wrap(clearErr, "failed to clear the internal state, so the map was reloaded rather than reset")
+	}
+
+	return map[string]interface{}{
+		resetMapCommand: true,
+		"slam_mode":     cartoSvc.SlamMode.String(),
+		"snapshots":     snapshots,
+		"archive_dir":   archiveDir,
+	}, nil
+}
+
+// switchMode freezes the map built so far & starts localizing against it in a new trajectory, so that an operator
+// who has finished mapping doesn't have to save, reconfigure & restart the service. A final optimization is run
+// before freezing, as the frozen trajectory is no longer optimized. Only switching to localizing is supported.
+func (cartoSvc *CartographerService) switchMode(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::switchMode")
+	defer span.End()
+
+	if err := switchModeParams(params); err != nil {
+		return nil, err
+	}
+
+	// the final optimization can take minutes on large maps, so mu isn't held while it runs
+	if _, err := cartoSvc.runOptimization(ctx); err != nil {
+		return nil, errors.Wrap(err, "switch_mode failed to optimize the map")
+	}
+
+	cartoSvc.mu.Lock()
+	defer cartoSvc.mu.Unlock()
+	if cartoSvc.closed {
+		return nil, ErrClosed
+	}
+	// the map may have been switched or reset while it was optimized
+	if cartoSvc.SlamMode == cartofacade.LocalizingMode {
+		return nil, errors.Errorf("switch_mode failed, cartographer is in %s mode", cartoSvc.SlamMode)
+	}
+	// the slam mode is taken from cartographer, which may have frozen the map even if switching failed afterwards
+	slamMode, err := cartoSvc.cartofacade.SwitchToLocalization(ctx, cartoSvc.cartoFacadeTimeout)
+	if slamMode == cartofacade.LocalizingMode {
+		cartoSvc.SlamMode = cartofacade.LocalizingMode
+		// the map isn't modified from now on, so GetLatestMapInfo keeps returning when it was frozen
+		cartoSvc.mapTimestamp = time.Now().UTC()
+		// the frozen map was never loaded from a file, so it has to be snapshotted to survive a restart, which
+		// then reloads it for localizing
+		cartoSvc.switchedToLocalization = true
+		cartoSvc.enableMapping = false
+	}
+	if err != nil {
+		return nil, err
+	}
+	if slamMode != cartofacade.LocalizingMode {
+		return nil, errors.Errorf("switch_mode failed, cartographer is in %s mode", slamMode)
+	}
+
+	return map[string]interface{}{
+		switchModeCommand: true,
+		"slam_mode":       cartoSvc.SlamMode.String(),
+	}, nil
+}
+
+// switchModeParams validates the mode param of the switch_mode command, which must be localizing.
+func switchModeParams(params interface{}) error {
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		return errors.New("switch_mode requires a mode param")
+	}
+	mode, ok := paramsMap["mode"].(string)
+	if !ok {
+		return errors.Errorf("mode must be a string, got %T", paramsMap["mode"])
+	}
+	if mode != cartofacade.LocalizingMode.String() {
+		return errors.Errorf("switch_mode only supports switching to %s, got %q", cartofacade.LocalizingMode, mode)
+	}
+	return nil
+}
+
+// resetMapParams returns the optional delete_snapshots param of the reset_map command.
+func resetMapParams(params interface{}) (bool, error) {
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+
+	var deleteSnapshots bool
+	if untyped, ok := paramsMap["delete_snapshots"]; ok {
+		if deleteSnapshots, ok = untyped.(bool); !ok {
+			return false, errors.Errorf("delete_snapshots must be a bool, got %T", untyped)
+		}
+	}
+	return deleteSnapshots, nil
+}
+
+// getStats returns the size of cartographer's pose graph & the approximate memory held by its grids, so that
+// robots running long mapping sessions can be alerted on before running out of memory, along with the counts of
+// lidar readings fetched & added by the sensor process and the time requests wait to be queued on the cartofacade.
+func (cartoSvc *CartographerService) getStats(ctx context.Context) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::getStats")
+	defer span.End()
+
+	cf, _ := cartoSvc.facade()
+	stats, err := cf.GetStats(ctx, cartoSvc.cartoFacadeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	lastOptimizationTime := ""
+	if !stats.LastOptimizationTime.IsZero() {
+		lastOptimizationTime = stats.LastOptimizationTime.UTC().Format(time.RFC3339Nano)
+	}
+	queueWait := cf.QueueWait()
+	return map[string]interface{}{
+		"map_builder": map[string]interface{}{
+			"trajectories":           stats.Trajectories,
+			"submaps_finished":       stats.SubmapsFinished,
+			"submaps_active":         stats.SubmapsActive,
+			"nodes":                  stats.Nodes,
+			"constraints":            stats.Constraints,
+			"grid_bytes":             stats.GridBytes,
+			"last_optimization_time": lastOptimizationTime,
+		},
+		"sensor_process": sensorProcessStats(cartoSvc.sensorProcessStats),
+		"cartofacade": map[string]interface{}{
+			"queue_wait_ms": map[string]interface{}{
+				"count": queueWait.Count,
+				"p50":   cartofacade.DurationMs(queueWait.P50),
+				"p90":   cartofacade.DurationMs(queueWait.P90),
+				"p99":   cartofacade.DurationMs(queueWait.P99),
+				"max":   cartofacade.DurationMs(queueWait.Max),
+			},
+		},
+	}, nil
+}
+
+// sensorProcessStats returns the counts of the sensor process since the service started, with the rates at which
+// readings were fetched & submitted over the last sensorprocess.RateWindow.
+func sensorProcessStats(stats *sensorprocess.Stats) map[string]interface{} {
+	var snapshot sensorprocess.StatsSnapshot
+	if stats != nil {
+		snapshot = stats.Snapshot()
+	}
+	failed := map[string]interface{}{}
+	for category, count := range snapshot.Failed {
+		failed[category] = count
+	}
+	return map[string]interface{}{
+		"fetched":                     snapshot.Fetched,
+		"fetch_failed":                snapshot.FetchFailed,
+		"submitted":                   snapshot.Submitted,
+		"skipped_lock_contention":     snapshot.SkippedLockContention,
+		"failed":                      failed,
+		"last_fetched_reading_time":   formatReadingTime(snapshot.LastFetchedReadingTime),
+		"last_submitted_reading_time": formatReadingTime(snapshot.LastSubmittedReadingTime),
+		"fetched_per_sec":             snapshot.FetchedPerSec,
+		"submitted_per_sec":           snapshot.SubmittedPerSec,
+	}
+}
+
+// formatReadingTime returns an empty string for the zero time, i.e. when no reading has been seen yet.
+func formatReadingTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// saveMap writes the current internal state as a pbstream into the data directory on demand, rather than
+// waiting for the next internal state saved every map_rate_sec, which is never saved with the cloud story.
+// The map is named after the optional name param, and is optimized first if the optimize param is set.
+func (cartoSvc *CartographerService) saveMap(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::saveMap")
+	defer span.End()
+
+	if cartoSvc.dataDirectory == "" {
+		return nil, errors.New("save_map requires data_dir to be configured")
+	}
+	name, optimize, err := saveMapParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "map_data_" + time.Now().UTC().Format(internalStateTimeFormat)
+	}
+	path := filepath.Join(cartoSvc.dataDirectory, name+".pbstream")
+
+	// the final optimization runs on the cartofacade goroutine while saving, so it holds up sensor readings
+	// & other requests until the map is saved
+	timeout := cartoSvc.cartoFacadeTimeout
+	if optimize {
+		timeout = finalOptimizationTimeout
+	}
+	cf, _ := cartoSvc.facade()
+	saved, err := cf.SaveMap(ctx, timeout, path, optimize)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"path":       path,
+		"size_bytes": info.Size(),
+		"nodes":      saved.Nodes,
+	}, nil
+}
+
+// saveMapParams returns the optional name & optimize params of the save_map command. The name may not
+// contain a path, so that maps are only ever written into the data directory.
+func saveMapParams(params interface{}) (string, bool, error) {
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		return "", false, nil
+	}
+
+	name, err := fileNameParam(paramsMap, ".pbstream")
+	if err != nil {
+		return "", false, err
+	}
+
+	var optimize bool
+	if untyped, ok := paramsMap["optimize"]; ok {
+		if optimize, ok = untyped.(bool); !ok {
+			return "", false, errors.Errorf("optimize must be a bool, got %T", untyped)
+		}
+	}
+	return name, optimize, nil
+}
+
+// fileNameParam returns the optional name param without any of the given extensions. The name may not contain a
+// path, so that files are only ever written into the data directory.
+func fileNameParam(paramsMap map[string]interface{}, exts ...string) (string, error) {
+	untyped, ok := paramsMap["name"]
+	if !ok {
+		return "", nil
+	}
+	name, ok := untyped.(string)
+	if !ok {
+		return "", errors.Errorf("name must be a string, got %T", untyped)
+	}
+	for _, ext := range exts {
+		name = strings.TrimSuffix(name, ext)
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", errors.Errorf("name must be a file name without a directory, got %q", untyped)
+	}
+	return name, nil
+}
+
+// exportROSMap writes the painted map as a trinary PGM along with its YAML into the data directory, in the
+// map_server format of ROS, so that navigation stacks consuming ROS maps can load it. The files are named after
+// the optional name param & cells are classified with the optional free_thresh & occupied_thresh params, which
+// default to the thresholds of map_saver.
+func (cartoSvc *CartographerService) exportROSMap(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::exportROSMap")
+	defer span.End()
+
+	if cartoSvc.dataDirectory == "" {
+		return nil, errors.New("export_ros_map requires data_dir to be configured")
+	}
+	name, thresholds, err := exportROSMapParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "ros_map_" + time.Now().UTC().Format(internalStateTimeFormat)
+	}
+
+	cf, _ := cartoSvc.facade()
+	grid, err := cf.GetOccupancyGrid(ctx, cartoSvc.cartoFacadeTimeout, cartofacade.MapOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pgmPath, yamlPath, err := rosmap.Write(cartoSvc.dataDirectory, name, grid, thresholds)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"pgm":               pgmPath,
+		"yaml":              yamlPath,
+		"width":             grid.Width,
+		"height":            grid.Height,
+		"resolution_meters": grid.ResolutionMeters,
+		"origin":            []interface{}{grid.OriginX, grid.OriginY, 0.0},
+	}, nil
+}
+
+// exportROSMapParams returns the optional name, free_thresh & occupied_thresh params of the export_ros_map command.
+func exportROSMapParams(params interface{}) (string, rosmap.Thresholds, error) {
+	thresholds := rosmap.DefaultThresholds()
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		return "", thresholds, nil
+	}
+
+	name, err := fileNameParam(paramsMap, ".pgm", ".yaml")
+	if err != nil {
+		return "", rosmap.Thresholds{}, err
+	}
+	for _, field := range []struct {
+		name  string
+		value *float64
+	}{
+		{"free_thresh", &thresholds.Free},
+		{"occupied_thresh", &thresholds.Occupied},
+	} {
+		if untyped, ok := paramsMap[field.name]; ok {
+			v, err := toFloat64(untyped)
+			if err != nil {
+				return "", rosmap.Thresholds{}, errors.Wrap(err, field.name)
+			}
+			*field.value = v
+		}
+	}
+	if err := thresholds.Validate(); err != nil {
+		return "", rosmap.Thresholds{}, err
+	}
+	return name, thresholds, nil
+}
+
+// optimize runs a final optimization of the pose graph on demand, rather than waiting for the next
+// optimization cartographer runs every optimize_every_n_nodes, & reports how long it took & how much it
+// reduced the residual of the pose graph constraints.
+func (cartoSvc *CartographerService) optimize(ctx context.Context) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::optimize")
+	defer span.End()
+
+	status, err := cartoSvc.runOptimization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"duration_ms":     status.Duration.Milliseconds(),
+		"residual_before": status.ResidualBefore,
+		"residual_after":  status.ResidualAfter,
+		"residual_change": status.ResidualAfter - status.ResidualBefore,
+	}, nil
+}
+
+// runOptimization waits up to finalOptimizationTimeout for a final optimization. The optimization runs in the
+// background of the cartofacade, so sensor readings keep being added while it runs.
+func (cartoSvc *CartographerService) runOptimization(ctx context.Context) (cartofacade.OptimizationStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, finalOptimizationTimeout)
+	defer cancel()
+	cf, _ := cartoSvc.facade()
+	return cf.Optimize(ctx, cartoSvc.cartoFacadeTimeout)
+}
+
+// setPose restarts the localization trajectory at the pose given in the frame of the map, so that cartographer
+// doesn't have to search the whole map for the robot. The pose is kept as the initial pose of later restarts.
+func (cartoSvc *CartographerService) setPose(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::setPose")
+	defer span.End()
+
+	pose, err := setPoseParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	cartoSvc.mu.Lock()
+	defer cartoSvc.mu.Unlock()
+	if err := cartoSvc.cartofacade.SetPose(ctx, cartoSvc.cartoFacadeTimeout, pose); err != nil {
+		return nil, err
+	}
+	cartoSvc.initialPose = &pose
+
+	return map[string]interface{}{setPoseCommand: true}, nil
+}
+
+// setPoseParams returns the pose of the set_pose command, where x & y are required & theta defaults to 0.
+func setPoseParams(params interface{}) (cartofacade.Pose2D, error) {
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		return cartofacade.Pose2D{}, errors.Errorf("%s must be a map with x, y & theta, got %T", setPoseCommand, params)
+	}
+
+	var pose cartofacade.Pose2D
+	for _, field := range []struct {
+		name     string
+		value    *float64
+		required bool
+	}{
+		{"x", &pose.X, true},
+		{"y", &pose.Y, true},
+		{"theta", &pose.Theta, false},
+	} {
+		untyped, ok := paramsMap[field.name]
+		if !ok {
+			if field.required {
+				return cartofacade.Pose2D{}, errors.Errorf("%s requires %s", setPoseCommand, field.name)
+			}
+			continue
+		}
+		v, err := toFloat64(untyped)
+		if err != nil {
+			return cartofacade.Pose2D{}, errors.Wrap(err, field.name)
+		}
+		*field.value = v
+	}
+	return pose, nil
+}
+
+// getTrajectory returns the optimized global pose & timestamp of every node of the active trajectory, or of
+// all trajectories if the all_trajectories param is set. Only every every_n_nodes-th node, along with the last
+// node of each trajectory, is returned if the every_n_nodes param is set.
+func (cartoSvc *CartographerService) getTrajectory(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::getTrajectory")
+	defer span.End()
+
+	allTrajectories, everyNNodes, err := getTrajectoryParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	cf, _ := cartoSvc.facade()
+	nodes, err := cf.GetTrajectory(ctx, cartoSvc.cartoFacadeTimeout, allTrajectories, everyNNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		resp = append(resp, map[string]interface{}{
+			"trajectory_id": node.TrajectoryID,
+			"node_index":    node.NodeIndex,
+			"time":          node.Time.UTC().Format(time.RFC3339Nano),
+			"x":             node.X,
+			"y":             node.Y,
+			"z":             node.Z,
+			"theta":         yawDegrees(node.Real, node.Imag, node.Jmag, node.Kmag),
+		})
+	}
+	return map[string]interface{}{"nodes": resp}, nil
+}
+
+// getSubmaps returns the id, optimized global pose, version, resolution & bounding box of every submap, along
+// with whether it is finished, so that submap boundaries can be shown & bad alignments debugged.
+func (cartoSvc *CartographerService) getSubmaps(ctx context.Context) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::getSubmaps")
+	defer span.End()
+
+	cf, _ := cartoSvc.facade()
+	submaps, err := cf.GetSubmaps(ctx, cartoSvc.cartoFacadeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]interface{}, 0, len(submaps))
+	for _, submap := range submaps {
+		resp = append(resp, toSubmapResponse(submap))
+	}
+	return map[string]interface{}{"submaps": resp}, nil
+}
+
+// toSubmapResponse returns the submap as returned by the get_submaps command.
+func toSubmapResponse(submap cartofacade.Submap) map[string]interface{} {
+	return map[string]interface{}{
+		"trajectory_id":     submap.TrajectoryID,
+		"submap_index":      submap.SubmapIndex,
+		"version":           submap.Version,
+		"finished":          submap.Finished,
+		"resolution_meters": submap.ResolutionMeters,
+		"pose": map[string]interface{}{
+			"x":     submap.X,
+			"y":     submap.Y,
+			"z":     submap.Z,
+			"theta": yawDegrees(submap.Real, submap.Imag, submap.Jmag, submap.Kmag),
+		},
+		"bounding_box": map[string]interface{}{
+			"min_x": submap.MinX,
+			"min_y": submap.MinY,
+			"max_x": submap.MaxX,
+			"max_y": submap.MaxY,
+		},
+	}
+}
+
+// getConfig returns the config cartographer is actually run with: the CartoAlgoConfig resolved from the defaults
+// & config_params along with where each of its values came from, the lua configuration the map builder loaded &
+// the values the map builder was built with once the lua configuration was overwritten by the CartoAlgoConfig.
+func (cartoSvc *CartographerService) getConfig(ctx context.Context) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::getConfig")
+	defer span.End()
+
+	// a restart swaps the config along with the cartofacade
+	cartoSvc.mu.RLock()
+	cf, algoCfg, configParams := cartoSvc.cartofacade, cartoSvc.cartoAlgoConfig, cartoSvc.configParams
+	cartoSvc.mu.RUnlock()
+
+	cfg, err := cf.GetConfig(ctx, cartoSvc.cartoFacadeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"algo_config": map[string]interface{}{
+			"optimize_on_start":               algoCfg.OptimizeOnStart,
+			"optimize_every_n_nodes":          algoCfg.OptimizeEveryNNodes,
+			"num_range_data":                  algoCfg.NumRangeData,
+			"missing_data_ray_length_meters":  float32ToFloat64(algoCfg.MissingDataRayLength),
+			"max_range_meters":                float32ToFloat64(algoCfg.MaxRange),
+			"min_range_meters":                float32ToFloat64(algoCfg.MinRange),
+			"max_submaps_to_keep":             algoCfg.MaxSubmapsToKeep,
+			"fresh_submaps_count":             algoCfg.FreshSubmapsCount,
+			"min_covered_area_meters_squared": algoCfg.MinCoveredArea,
+			"min_added_submaps_count":         algoCfg.MinAddedSubmapsCount,
+			"occupied_space_weight":           algoCfg.OccupiedSpaceWeight,
+			"translation_weight":              algoCfg.TranslationWeight,
+			"rotation_weight":                 algoCfg.RotationWeight,
+			"map_resolution_meters":           algoCfg.MapResolutionMeters,
+			"max_map_points":                  algoCfg.MaxMapPoints,
+		},
+		"sources": cartoAlgoConfigSources(configParams),
+		"lua": map[string]interface{}{
+			"configuration_directory": cfg.ConfigurationDirectory,
+			"configuration_basename":  cfg.ConfigurationBasename,
+		},
+		"map_builder": map[string]interface{}{
+			"optimize_every_n_nodes":          cfg.OptimizeEveryNNodes,
+			"num_range_data":                  cfg.NumRangeData,
+			"missing_data_ray_length_meters":  float32ToFloat64(cfg.MissingDataRayLength),
+			"max_range_meters":                float32ToFloat64(cfg.MaxRange),
+			"min_range_meters":                float32ToFloat64(cfg.MinRange),
+			"max_submaps_to_keep":             cfg.MaxSubmapsToKeep,
+			"fresh_submaps_count":             cfg.FreshSubmapsCount,
+			"min_covered_area_meters_squared": cfg.MinCoveredArea,
+			"min_added_submaps_count":         cfg.MinAddedSubmapsCount,
+			"occupied_space_weight":           cfg.OccupiedSpaceWeight,
+			"translation_weight":              cfg.TranslationWeight,
+			"rotation_weight":                 cfg.RotationWeight,
+		},
+	}, nil
+}
+
+// cartoAlgoConfigParamKeys maps each value of the CartoAlgoConfig to the config_params keys parseCartoAlgoConfig
+// sets it from, as some values accept an alias without their unit.
+var cartoAlgoConfigParamKeys = map[string][]string{
+	"optimize_on_start":               {"optimize_on_start"},
+	"optimize_every_n_nodes":          {"optimize_every_n_nodes"},
+	"num_range_data":                  {"num_range_data"},
+	"missing_data_ray_length_meters":  {"missing_data_ray_length_meters", "missing_data_ray_length"},
+	"max_range_meters":                {"max_range_meters", "max_range"},
+	"min_range_meters":                {"min_range_meters", "min_range"},
+	"max_submaps_to_keep":             {"max_submaps_to_keep"},
+	"fresh_submaps_count":             {"fresh_submaps_count"},
+	"min_covered_area_meters_squared": {"min_covered_area_meters_squared", "min_covered_area"},
+	"min_added_submaps_count":         {"min_added_submaps_count"},
+	"occupied_space_weight":           {"occupied_space_weight"},
+	"translation_weight":              {"translation_weight"},
+	"rotation_weight":                 {"rotation_weight"},
+	"map_resolution_meters":           {"map_resolution_meters"},
+	"max_map_points":                  {"max_map_points"},
+}
+
+// cartoAlgoConfigSources returns, for each value of the CartoAlgoConfig, whether it is the default or was set
+// by config_params, in which case the keys it was set by are returned too.
+func cartoAlgoConfigSources(configParams map[string]string) map[string]interface{} {
+	sources := map[string]interface{}{}
+	for name, keys := range cartoAlgoConfigParamKeys {
+		setBy := []interface{}{}
+		for _, key := range keys {
+			if _, ok := configParams[key]; ok {
+				setBy = append(setBy, key)
+			}
+		}
+		if len(setBy) == 0 {
+			sources[name] = map[string]interface{}{"source": "default"}
+			continue
+		}
+		sources[name] = map[string]interface{}{"source": "config_params", "keys": setBy}
+	}
+	return sources
+}
+
+// float32ToFloat64 converts f to the float64 with the same shortest decimal representation, so that e.g. a
+// max range of 0.2 isn't reported as 0.20000000298023224.
+func float32ToFloat64(f float32) float64 {
+	f64, err := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	if err != nil {
+		return float64(f)
+	}
+	return f64
+}
+
+// yawDegrees returns the rotation around the z axis of the quaternion, as cartographer is run in 2D.
+func yawDegrees(w, i, j, k float64) float64 {
+	return math.Atan2(2*(w*k+i*j), 1-2*(j*j+k*k)) * 180 / math.Pi
+}
+
+// getTrajectoryParams returns the optional all_trajectories & every_n_nodes params of the get_trajectory
+// command, which default to the active trajectory & every node.
+func getTrajectoryParams(params interface{}) (bool, int, error) {
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		return false, 1, nil
+	}
+
+	var allTrajectories bool
+	if untyped, ok := paramsMap["all_trajectories"]; ok {
+		if allTrajectories, ok = untyped.(bool); !ok {
+			return false, 0, errors.Errorf("all_trajectories must be a bool, got %T", untyped)
+		}
+	}
+
+	everyNNodes := 1
+	if untyped, ok := paramsMap["every_n_nodes"]; ok {
+		v, err := toFloat64(untyped)
+		if err != nil {
+			return false, 0, errors.Wrap(err, "every_n_nodes")
+		}
+		if v < 1 || v != math.Trunc(v) || v > math.MaxInt32 {
+			return false, 0, errors.Errorf("every_n_nodes must be a positive integer, got %v", untyped)
+		}
+		everyNNodes = int(v)
+	}
+	return allTrajectories, everyNNodes, nil
+}
+
+// toFloat64 accepts any number, as numbers may be decoded from JSON or passed directly from go.
+func toFloat64(untyped interface{}) (float64, error) {
+	switch v := untyped.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	default:
+		return 0, errors.Errorf("must be a number, got %T", untyped)
+	}
+}
+
+// restartConfigParams returns the config params to restart with, which are the current ones overwritten by
+// the optional config_params of the restart command.
+func restartConfigParams(current map[string]string, params interface{}) (map[string]string, error) {
+	configParams := map[string]string{}
+	for k, v := range current {
+		configParams[k] = v
+	}
+
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		return configParams, nil
+	}
+	untyped, ok := paramsMap["config_params"]
+	if !ok {
+		return configParams, nil
+	}
+	overrides, ok := untyped.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("config_params must be a map, got %T", untyped)
+	}
+	for k, v := range overrides {
+		val := fmt.Sprint(v)
+		if k == "mode" && val != current["mode"] {
+			return nil, errors.New("config_params mode can't be changed by a restart")
+		}
+		configParams[k] = val
+	}
+	return configParams, nil
+}
+
+// snapshotInternalState saves the current internal state where the next cartofacade will load it from and
+// returns its path. When localizing the map isn't modified, so it is reloaded from where it was loaded from,
+// unless it was frozen by switch_mode.
+func (cartoSvc *CartographerService) snapshotInternalState(ctx context.Context) (string, error) {
+	if cartoSvc.SlamMode == cartofacade.LocalizingMode && !cartoSvc.switchedToLocalization {
+		return "", nil
+	}
+
+	// with the cloud story the map to load is passed explicitly, otherwise cartographer loads the latest
+	// internal state in the data directory
+	var f *os.File
+	var err error
+	if cartoSvc.cloudStoryEnabled {
+		f, err = os.CreateTemp(cartoSvc.dataDirectory, "restart_snapshot_*.pbstream")
+	} else {
+		filename := filepath.Join(
+			cartoSvc.dataDirectory,
+			"internal_state",
+			"map_data_"+time.Now().UTC().Format(internalStateTimeFormat)+".pbstream",
+		)
+		f, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// the internal state is streamed to the file, so that it is never held in memory as a whole
+	if err := cartoSvc.writeInternalState(ctx, f); err != nil {
+		return "", multierr.Combine(err, f.Close(), os.Remove(f.Name()))
+	}
+	return f.Name(), f.Close()
+}
+
+// useSnapshot makes the next cartofacade load the snapshot taken by snapshotInternalState. A mapping session is
+// continued from it in mapping mode, as cartographer would otherwise update it as an existing map. Otherwise it is
+// loaded as the existing map with the cloud story, or as the latest internal state in the data directory.
+func (cartoSvc *CartographerService) useSnapshot(snapshot string) {
+	if snapshot == "" {
+		return
+	}
+	if cartoSvc.SlamMode == cartofacade.MappingMode {
+		cartoSvc.initialInternalState = snapshot
+		return
+	}
+	if cartoSvc.cloudStoryEnabled {
+		cartoSvc.existingMap = snapshot
+	}
+}
+
+// clearInternalState removes the internal state snapshots cartographer would load from the data directory, so
+// that the next cartofacade starts mapping from scratch, & returns how many there were. They are deleted if
+// deleteSnapshots is set, otherwise they are moved into a subdirectory, whose path is returned. With the cloud
+// story the map to load is passed explicitly, so there is nothing to clear.
+func (cartoSvc *CartographerService) clearInternalState(deleteSnapshots bool) (int, string, error) {
+	if cartoSvc.cloudStoryEnabled {
+		cartoSvc.existingMap = ""
+		return 0, "", nil
+	}
+
+	internalStateDir := filepath.Join(cartoSvc.dataDirectory, "internal_state")
+	snapshots, err := filepath.Glob(filepath.Join(internalStateDir, "*.pbstream"))
+	if err != nil || len(snapshots) == 0 {
+		return 0, "", err
+	}
+
+	if deleteSnapshots {
+		for _, snapshot := range snapshots {
+			if err := os.Remove(snapshot); err != nil {
+				return 0, "", err
+			}
+		}
+		return len(snapshots), "", nil
+	}
+
+	// cartographer only looks for snapshots directly in the internal state directory
+	archiveDir := filepath.Join(internalStateDir, "reset_"+time.Now().UTC().Format(internalStateTimeFormat))
+	if err := os.Mkdir(archiveDir, 0o755); err != nil {
+		return 0, "", err
+	}
+	for _, snapshot := range snapshots {
+		if err := os.Rename(snapshot, filepath.Join(archiveDir, filepath.Base(snapshot))); err != nil {
+			return 0, "", err
+		}
+	}
+	return len(snapshots), archiveDir, nil
+}
+
+// writeInternalState streams the current internal state to w. mu must be held.
+func (cartoSvc *CartographerService) writeInternalState(ctx context.Context, w io.Writer) error {
+	opts, err := cartoSvc.resolveExportOptions(nil)
+	if err != nil {
+		return err
+	}
+	stream, err := cartoSvc.cartofacade.GetInternalStateStream(ctx, cartoSvc.cartoFacadeTimeout)
+	if err != nil {
+		return err
+	}
+	r := exportReader(stream, cartoSvc.cartoFacadeTimeout, opts)
+	if _, err := io.Copy(w, r); err != nil {
+		return multierr.Combine(err, r.Close())
+	}
+	return nil
+}
+
+// restartCartoFacade initializes & starts a new cartofacade with its own background workers.
+func (cartoSvc *CartographerService) restartCartoFacade() error {
+	cancelCartoFacadeCtx, cancelCartoFacadeFunc := context.WithCancel(context.Background())
+	cartoSvc.cancelCartoFacadeFunc = cancelCartoFacadeFunc
+	if err := initCartoFacade(cancelCartoFacadeCtx, cartoSvc); err != nil {
+		cancelCartoFacadeFunc()
+		cartoSvc.cartoFacadeWorkers.Wait()
+		return err
+	}
+	return nil
+}
+
+// restartSensorProcess starts adding sensor readings to the current cartofacade.
+func (cartoSvc *CartographerService) restartSensorProcess() {
+	cancelSensorProcessCtx, cancelSensorProcessFunc := context.WithCancel(context.Background())
+	cartoSvc.cancelSensorProcessFunc = cancelSensorProcessFunc
+	initSensorProcess(cancelSensorProcessCtx, cartoSvc)
+}
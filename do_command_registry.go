@@ -0,0 +1,398 @@
+package viamcartographer
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	viamgrpc "go.viam.com/rdk/grpc"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+// argType is the type a DoCommand argument must have once decoded from JSON.
+type argType string
+
+const (
+	boolArg   argType = "bool"
+	numberArg argType = "number"
+	stringArg argType = "string"
+	mapArg    argType = "map"
+	listArg   argType = "list"
+)
+
+// doCommandArg declares an argument of a DoCommand.
+type doCommandArg struct {
+	name        string
+	typ         argType
+	required    bool
+	description string
+}
+
+// doCommand declares a DoCommand, so that DoCommand can validate its arguments & slam mode before running it
+// & the help command can list it.
+type doCommand struct {
+	name        string
+	description string
+	args        []doCommandArg
+	// slamModes are the slam modes the command is allowed in, it is allowed in every slam mode if empty
+	slamModes []cartofacade.SlamMode
+	run       func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error)
+}
+
+// doCommands are the DoCommands in the order DoCommand looks for them in a request. It is set in init, as the
+// help command lists doCommands itself.
+var doCommands []doCommand
+
+func init() {
+	doCommands = []doCommand{
+		{
+			name:        helpCommand,
+			description: "lists the available commands along with their arguments & the slam modes they are allowed in",
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.help(), nil
+			},
+		},
+		{
+			name:        jobDoneCommand,
+			description: "reports whether the sensor process has finished adding the readings of a replay sensor",
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return map[string]interface{}{jobDoneCommand: cartoSvc.jobDone.Load()}, nil
+			},
+		},
+		{
+			name:        restartCommand,
+			description: "restarts cartographer with new config_params, reloading a snapshot of the map built so far",
+			args: []doCommandArg{
+				{name: "config_params", typ: mapArg, description: "config_params overriding the current ones, except mode"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.restart(ctx, params)
+			},
+		},
+		{
+			name:        getStatsCommand,
+			description: "returns the size of the pose graph, the memory held by its grids & sensor process counters",
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.getStats(ctx)
+			},
+		},
+		{
+			name:        saveMapCommand,
+			description: "saves the current internal state as a pbstream into the data directory",
+			args: []doCommandArg{
+				{name: "name", typ: stringArg, description: "name of the pbstream, defaults to a timestamp"},
+				{name: "optimize", typ: boolArg, description: "runs a final optimization before saving"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.saveMap(ctx, params)
+			},
+		},
+		{
+			name:        setPoseCommand,
+			description: "restarts the localization trajectory at the given pose in the frame of the map",
+			args: []doCommandArg{
+				{name: "x", typ: numberArg, required: true, description: "x in millimeters"},
+				{name: "y", typ: numberArg, required: true, description: "y in millimeters"},
+				{name: "theta", typ: numberArg, description: "theta in degrees, defaults to 0"},
+			},
+			slamModes: []cartofacade.SlamMode{cartofacade.LocalizingMode},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.setPose(ctx, params)
+			},
+		},
+		{
+			name:        optimizeCommand,
+			description: "runs a final optimization of the pose graph & reports the change of its residual",
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.optimize(ctx)
+			},
+		},
+		{
+			name:        getTrajectoryCommand,
+			description: "returns the optimized pose & timestamp of the nodes of the active trajectory",
+			args: []doCommandArg{
+				{name: "all_trajectories", typ: boolArg, description: "returns the nodes of every trajectory"},
+				{name: "every_n_nodes", typ: numberArg, description: "only returns every n-th node, defaults to 1"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.getTrajectory(ctx, params)
+			},
+		},
+		{
+			name:        getSubmapsCommand,
+			description: "returns the pose, version, resolution & bounds of every submap",
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.getSubmaps(ctx)
+			},
+		},
+		{
+			name:        getConfigCommand,
+			description: "returns the resolved algo config & its sources, the lua configuration & the map builder values",
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.getConfig(ctx)
+			},
+		},
+		{
+			name:        resetMapCommand,
+			description: "throws away the map built so far & starts mapping again from scratch",
+			args: []doCommandArg{
+				{name: "delete_snapshots", typ: boolArg, description: "deletes the snapshots of the map rather than archiving them"},
+			},
+			slamModes: []cartofacade.SlamMode{cartofacade.MappingMode},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.resetMap(ctx, params)
+			},
+		},
+		{
+			name:        exportROSMapCommand,
+			description: "writes the map as a trinary pgm along with its yaml into the data directory, as map_server loads maps",
+			args: []doCommandArg{
+				{name: "name", typ: stringArg, description: "name of the pgm & yaml, defaults to a timestamp"},
+				{name: "free_thresh", typ: numberArg, description: "cells less likely occupied are free, defaults to 0.196"},
+				{name: "occupied_thresh", typ: numberArg, description: "cells more likely occupied are occupied, defaults to 0.65"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.exportROSMap(ctx, params)
+			},
+		},
+		{
+			name:        renderMapCommand,
+			description: "renders the map as a png with the trajectory, the submap boundaries & the current pose drawn on top",
+			args: []doCommandArg{
+				{name: "name", typ: stringArg, description: "writes the png into the data directory, rather than returning it base64 encoded"},
+				{name: "scale", typ: numberArg, description: "pixels per cell of the map, defaults to map_image[scale] or 1"},
+				{name: "crop", typ: mapArg, description: "min_x, min_y, max_x & max_y in millimeters of the part of the map rendered"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.renderMap(ctx, params)
+			},
+		},
+		{
+			name:        getMapRegionCommand,
+			description: "returns the submaps intersecting a region of the map along with the occupancy grid of the cells within it",
+			args: []doCommandArg{
+				{name: regionParam, typ: mapArg, description: "min_x, min_y, max_x & max_y in millimeters of the region"},
+				{name: radiusMMParam, typ: numberArg, description: "half side in millimeters of the square region around the current pose"},
+				{name: resolutionMetersParam, typ: numberArg, description: "resolution of the grid, defaults to map_resolution_meters"},
+				{name: maxPointsParam, typ: numberArg, description: "most cells of the grid before it is coarsened, defaults to max_map_points"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.getMapRegion(ctx, params)
+			},
+		},
+		{
+			name:        getMapUpdatesCommand,
+			description: "returns the grids of the submaps changed since the given versions & the poses of the moved ones",
+			args: []doCommandArg{
+				{name: submapsParam, typ: listArg, description: "trajectory_id, submap_index, version & pose of each submap held"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.getMapUpdates(ctx, params)
+			},
+		},
+		{
+			name:        exportPointCloudMapCommand,
+			description: "exports the pointcloud map, which is returned base64 encoded unless it is written into the data directory",
+			args: []doCommandArg{
+				{name: "name", typ: stringArg, description: "writes the map into the data directory, rather than returning it"},
+				{name: compressionParam, typ: stringArg, description: "none or gzip, defaults to none"},
+				{name: formatParam, typ: stringArg, description: "pcd, pcd_binary, pcd_binary_compressed, pcd_ascii or ply, defaults to pcd"},
+				{name: resolutionMetersParam, typ: numberArg, description: "resolution of the map, defaults to map_resolution_meters"},
+				{name: maxPointsParam, typ: numberArg, description: "most points of the map before it is coarsened, defaults to max_map_points"},
+				{name: regionParam, typ: mapArg, description: "limits the map to a region, as get_map_region"},
+				{name: radiusMMParam, typ: numberArg, description: "limits the map to the square around the current pose, as get_map_region"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.exportPointCloudMap(ctx, params)
+			},
+		},
+		{
+			name:        exportOccupancyGridCommand,
+			description: "exports the dense occupancy grid, which is returned base64 encoded unless it is written into the data directory",
+			args: []doCommandArg{
+				{name: "name", typ: stringArg, description: "writes the grid into the data directory, rather than returning it"},
+				{name: compressionParam, typ: stringArg, description: "none or gzip, defaults to none"},
+				{name: resolutionMetersParam, typ: numberArg, description: "resolution of the grid, defaults to map_resolution_meters"},
+				{name: maxPointsParam, typ: numberArg, description: "most points of the grid before it is coarsened, defaults to max_map_points"},
+				{name: regionParam, typ: mapArg, description: "limits the grid to a region, as get_map_region"},
+				{name: radiusMMParam, typ: numberArg, description: "limits the grid to the square around the current pose, as get_map_region"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.exportOccupancyGrid(ctx, params)
+			},
+		},
+		{
+			name:        exportInternalStateCommand,
+			description: "exports the internal state as a pbstream, which is returned base64 encoded unless it is written into the data directory",
+			args: []doCommandArg{
+				{name: "name", typ: stringArg, description: "writes the pbstream into the data directory, rather than returning it"},
+				{name: compressionParam, typ: stringArg, description: "none or gzip, defaults to none"},
+			},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.exportInternalState(ctx, params)
+			},
+		},
+		{
+			name:        switchModeCommand,
+			description: "freezes the map built so far & starts localizing against it",
+			args: []doCommandArg{
+				{name: "mode", typ: stringArg, required: true, description: "slam mode to switch to, only localizing is supported"},
+			},
+			slamModes: []cartofacade.SlamMode{cartofacade.MappingMode, cartofacade.UpdatingMode},
+			run: func(cartoSvc *CartographerService, ctx context.Context, params interface{}) (map[string]interface{}, error) {
+				return cartoSvc.switchMode(ctx, params)
+			},
+		},
+	}
+}
+
+// runDoCommand runs the first command of doCommands found in req, once its arguments & slam mode are validated.
+func (cartoSvc *CartographerService) runDoCommand(ctx context.Context, req map[string]interface{}) (map[string]interface{}, error) {
+	for _, cmd := range doCommands {
+		params, ok := req[cmd.name]
+		if !ok {
+			continue
+		}
+		if err := cmd.validateArgs(params); err != nil {
+			return nil, err
+		}
+		if _, slamMode := cartoSvc.facade(); !cmd.allowedIn(slamMode) {
+			return nil, errors.Errorf("%s is only supported in %s mode, slam mode is %s", cmd.name, cmd.slamModesString(), slamMode)
+		}
+		return cmd.run(cartoSvc, ctx, params)
+	}
+	return nil, viamgrpc.UnimplementedError
+}
+
+// allowedIn returns whether the command may be run in the given slam mode.
+func (cmd doCommand) allowedIn(slamMode cartofacade.SlamMode) bool {
+	if len(cmd.slamModes) == 0 {
+		return true
+	}
+	for _, m := range cmd.slamModes {
+		if m == slamMode {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmd doCommand) slamModesString() string {
+	modes := make([]string, 0, len(cmd.slamModes))
+	for _, m := range cmd.slamModes {
+		modes = append(modes, m.String())
+	}
+	return strings.Join(modes, " or ")
+}
+
+// validateArgs checks that every required argument is given, that every argument is of its declared type & that
+// there are no unknown arguments. Commands without required arguments may be given any other value than a map,
+// e.g. true, which is treated as no arguments.
+func (cmd doCommand) validateArgs(params interface{}) error {
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		for _, arg := range cmd.args {
+			if arg.required {
+				return errors.Errorf("%s requires %s", cmd.name, arg.name)
+			}
+		}
+		return nil
+	}
+
+	declared := map[string]doCommandArg{}
+	for _, arg := range cmd.args {
+		declared[arg.name] = arg
+		untyped, ok := paramsMap[arg.name]
+		if !ok {
+			if arg.required {
+				return errors.Errorf("%s requires %s", cmd.name, arg.name)
+			}
+			continue
+		}
+		if !arg.typ.matches(untyped) {
+			return errors.Errorf("%s must be a %s, got %T", arg.name, arg.typ, untyped)
+		}
+	}
+
+	var unknown []string
+	for name := range paramsMap {
+		if _, ok := declared[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return errors.Errorf("%s got unknown arguments %s, expected %s",
+			cmd.name, strings.Join(unknown, ", "), cmd.argNamesString())
+	}
+	return nil
+}
+
+func (cmd doCommand) argNamesString() string {
+	if len(cmd.args) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(cmd.args))
+	for _, arg := range cmd.args {
+		names = append(names, arg.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// matches returns whether v is of the argument type, where numbers may be any go number.
+func (typ argType) matches(v interface{}) bool {
+	switch typ {
+	case boolArg:
+		_, ok := v.(bool)
+		return ok
+	case numberArg:
+		_, err := toFloat64(v)
+		return err == nil
+	case stringArg:
+		_, ok := v.(string)
+		return ok
+	case mapArg:
+		_, ok := v.(map[string]interface{})
+		return ok
+	case listArg:
+		_, ok := v.([]interface{})
+		return ok
+	default:
+		return false
+	}
+}
+
+// help lists every command with its arguments, the slam modes it is allowed in & whether it is available in the
+// current slam mode, so that tooling can discover what the running module supports.
+func (cartoSvc *CartographerService) help() map[string]interface{} {
+	_, slamMode := cartoSvc.facade()
+	commands := make([]interface{}, 0, len(doCommands))
+	for _, cmd := range doCommands {
+		args := make([]interface{}, 0, len(cmd.args))
+		for _, arg := range cmd.args {
+			args = append(args, map[string]interface{}{
+				"name":        arg.name,
+				"type":        string(arg.typ),
+				"required":    arg.required,
+				"description": arg.description,
+			})
+		}
+		slamModes := make([]interface{}, 0, len(cmd.slamModes))
+		for _, m := range cmd.slamModes {
+			slamModes = append(slamModes, m.String())
+		}
+		commands = append(commands, map[string]interface{}{
+			"name":        cmd.name,
+			"description": cmd.description,
+			"args":        args,
+			"slam_modes":  slamModes,
+			"available":   cmd.allowedIn(slamMode),
+		})
+	}
+	return map[string]interface{}{
+		"commands":  commands,
+		"slam_mode": slamMode.String(),
+	}
+}
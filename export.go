@@ -0,0 +1,499 @@
+package viamcartographer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"go.uber.org/multierr"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+	"github.com/viamrobotics/viam-cartographer/densegrid"
+)
+
+const (
+	// defaultChunkSizeBytes is the size of the chunks exports are returned in, unless chunk_size_bytes is configured.
+	defaultChunkSizeBytes = 1 * 1024 * 1024
+	// compressionParam selects the compression of an export, one of noCompression & gzipCompression.
+	compressionParam = "compression"
+	noCompression    = "none"
+	gzipCompression  = "gzip"
+	// formatParam selects the format of a pointcloud map export, the name of one of the cartofacade.PointCloudFormats.
+	formatParam = "format"
+	// occupancyGridFileExt is the extension of the dense occupancy grid encoded by densegrid.
+	occupancyGridFileExt = ".grid"
+	// resolutionMetersParam & maxPointsParam overwrite the configured map_resolution_meters & max_map_points of a
+	// pointcloud map export.
+	resolutionMetersParam = "resolution_meters"
+	maxPointsParam        = "max_points"
+	// maxCachedPointCloudMapBytes bounds the size of the pcd held by the pointCloudMapCache, larger pcds are
+	// repainted for every export.
+	maxCachedPointCloudMapBytes = 64 * 1024 * 1024
+)
+
+// exportOptions describe how an export is returned to the caller.
+type exportOptions struct {
+	chunkSizeBytes int
+	compression    string
+}
+
+// resolveExportOptions returns the configured export options along with the compression requested with the
+// given params.
+func (cartoSvc *CartographerService) resolveExportOptions(params map[string]interface{}) (exportOptions, error) {
+	opts := exportOptions{chunkSizeBytes: cartoSvc.chunkSizeBytes, compression: noCompression}
+	if opts.chunkSizeBytes <= 0 {
+		opts.chunkSizeBytes = defaultChunkSizeBytes
+	}
+
+	if untyped, ok := params[compressionParam]; ok {
+		compression, ok := untyped.(string)
+		if !ok || (compression != noCompression && compression != gzipCompression) {
+			return exportOptions{}, errors.Errorf("%s must be one of %q or %q, got %v",
+				compressionParam, noCompression, gzipCompression, untyped)
+		}
+		opts.compression = compression
+	}
+	return opts, nil
+}
+
+// fileExt returns the extension of a file holding an export in the given format with the export options.
+func (opts exportOptions) fileExt(formatExt string) string {
+	if opts.compression == gzipCompression {
+		return formatExt + ".gz"
+	}
+	return formatExt
+}
+
+// pointCloudMapFormat returns the format of a pointcloud map export requested with the given params.
+func pointCloudMapFormat(params map[string]interface{}) (cartofacade.PointCloudFormat, error) {
+	untyped, ok := params[formatParam]
+	if !ok {
+		return cartofacade.PCDFormat, nil
+	}
+	format, _ := untyped.(string)
+	names := make([]string, 0, len(cartofacade.PointCloudFormats))
+	for _, pointCloudFormat := range cartofacade.PointCloudFormats {
+		if format == pointCloudFormat.String() {
+			return pointCloudFormat, nil
+		}
+		names = append(names, strconv.Quote(pointCloudFormat.String()))
+	}
+	return cartofacade.PCDFormat, errors.Errorf("%s must be one of %s or %s, got %v",
+		formatParam, strings.Join(names[:len(names)-1], ", "), names[len(names)-1], untyped)
+}
+
+// pointCloudMapOptions returns the options a pointcloud map export requested with the given params is painted
+// with. Unset options are left zero, so that cartographer falls back to the configured ones.
+func pointCloudMapOptions(params map[string]interface{}) (cartofacade.MapOptions, error) {
+	var opts cartofacade.MapOptions
+	if untyped, ok := params[resolutionMetersParam]; ok {
+		resolutionMeters, err := toFloat64(untyped)
+		if err != nil {
+			return cartofacade.MapOptions{}, errors.Wrap(err, resolutionMetersParam)
+		}
+		if resolutionMeters < cartofacade.MinMapResolutionMeters {
+			return cartofacade.MapOptions{}, errors.Errorf("%s must be at least %v, got %v",
+				resolutionMetersParam, cartofacade.MinMapResolutionMeters, resolutionMeters)
+		}
+		opts.ResolutionMeters = resolutionMeters
+	}
+	if untyped, ok := params[maxPointsParam]; ok {
+		maxPoints, err := toFloat64(untyped)
+		if err != nil {
+			return cartofacade.MapOptions{}, errors.Wrap(err, maxPointsParam)
+		}
+		if maxPoints != float64(int(maxPoints)) || maxPoints <= 0 {
+			return cartofacade.MapOptions{}, errors.Errorf("%s must be a whole number greater than zero, got %v",
+				maxPointsParam, untyped)
+		}
+		opts.MaxPoints = int(maxPoints)
+	}
+	return opts, nil
+}
+
+// exportReader returns a reader of the stream which applies the compression of the export options.
+func exportReader(stream cartofacade.Stream, timeout time.Duration, opts exportOptions) io.ReadCloser {
+	return compressReader(&streamReader{stream: stream, timeout: timeout, maxChunkSizeBytes: opts.chunkSizeBytes}, opts)
+}
+
+// compressReader returns a reader of r which applies the compression of the export options.
+func compressReader(r io.ReadCloser, opts exportOptions) io.ReadCloser {
+	if opts.compression == gzipCompression {
+		return newGzipReader(r, opts.chunkSizeBytes)
+	}
+	return r
+}
+
+// streamReader reads a cartofacade stream. Closing it closes the stream.
+type streamReader struct {
+	stream            cartofacade.Stream
+	timeout           time.Duration
+	maxChunkSizeBytes int
+	// pending is the part of the last chunk which didn't fit into the caller's buffer
+	pending []byte
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		// the stream outlives the request which created it, so it isn't bound to the request's context
+		chunk, err := r.stream.Next(context.Background(), r.timeout, r.maxChunkSizeBytes)
+		if err != nil {
+			return 0, err
+		}
+		r.pending = chunk
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	return r.stream.Close(context.Background(), r.timeout)
+}
+
+// gzipReader compresses what it reads from src as it is read, rather than through a goroutine & a pipe,
+// so that a reader which is abandoned by the caller doesn't leak anything.
+type gzipReader struct {
+	src        io.ReadCloser
+	compressed bytes.Buffer
+	zw         *gzip.Writer
+	buf        []byte
+	done       bool
+}
+
+func newGzipReader(src io.ReadCloser, chunkSizeBytes int) *gzipReader {
+	r := &gzipReader{src: src, buf: make([]byte, chunkSizeBytes)}
+	r.zw = gzip.NewWriter(&r.compressed)
+	return r
+}
+
+func (r *gzipReader) Read(p []byte) (int, error) {
+	for r.compressed.Len() == 0 && !r.done {
+		n, err := r.src.Read(r.buf)
+		if n > 0 {
+			if _, err := r.zw.Write(r.buf[:n]); err != nil {
+				return 0, err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			r.done = true
+			if err := r.zw.Close(); err != nil {
+				return 0, err
+			}
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if r.compressed.Len() == 0 {
+		return 0, io.EOF
+	}
+	return r.compressed.Read(p)
+}
+
+func (r *gzipReader) Close() error {
+	return r.src.Close()
+}
+
+// toChunkedFunc returns a callback which returns the next chunk of the reader, so that the export is never
+// held in memory as a whole. Every chunk has its own buffer, as callers may hold on to previous chunks.
+// The reader is closed once it has failed, as callers stop calling the callback after the first error.
+func toChunkedFunc(r io.ReadCloser, chunkSizeBytes int) func() ([]byte, error) {
+	f := func() ([]byte, error) {
+		chunk := make([]byte, chunkSizeBytes)
+		n, err := io.ReadFull(r, chunk)
+		switch {
+		case err == nil, errors.Is(err, io.ErrUnexpectedEOF):
+			return chunk[:n], nil
+		case errors.Is(err, io.EOF):
+			// the stream closes itself once it has been read
+			return nil, err
+		default:
+			return nil, multierr.Combine(err, r.Close())
+		}
+	}
+	return f
+}
+
+// internalStateReader returns a reader of the internal state which applies the export options.
+func (cartoSvc *CartographerService) internalStateReader(ctx context.Context, opts exportOptions) (io.ReadCloser, error) {
+	cf, _ := cartoSvc.facade()
+	stream, err := cf.GetInternalStateStream(ctx, cartoSvc.cartoFacadeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return exportReader(stream, cartoSvc.cartoFacadeTimeout, opts), nil
+}
+
+// pointCloudMapReader returns a reader of the pointcloud map painted with mapOpts & written in their format, which
+// applies the export options. The pointcloud map is cached per revision of the map, so that it is only repainted
+// once the map changed.
+func (cartoSvc *CartographerService) pointCloudMapReader(
+	ctx context.Context,
+	opts exportOptions,
+	mapOpts cartofacade.MapOptions,
+) (io.ReadCloser, error) {
+	cf, _ := cartoSvc.facade()
+	revision, revisionErr := cf.MapRevision()
+	key := newPointCloudMapKey(revision, mapOpts)
+	if revisionErr != nil {
+		// the last revision read may be stale, so the pcd is painted but not cached under it
+		cartoSvc.logger.Warnw("reading the map revision failed", "error", revisionErr)
+	} else if pcd, ok := cartoSvc.pointCloudMapCache.get(key); ok {
+		return compressReader(io.NopCloser(bytes.NewReader(pcd)), opts), nil
+	}
+
+	stream, err := cf.GetPointCloudMapStream(ctx, cartoSvc.cartoFacadeTimeout, mapOpts)
+	if err != nil {
+		return nil, err
+	}
+	src := &streamReader{stream: stream, timeout: cartoSvc.cartoFacadeTimeout, maxChunkSizeBytes: opts.chunkSizeBytes}
+	if revisionErr != nil {
+		return compressReader(src, opts), nil
+	}
+	r := &cachingReader{
+		src: src,
+		done: func(pcd []byte) {
+			cartoSvc.pointCloudMapCache.put(key, pcd)
+		},
+	}
+	return compressReader(r, opts), nil
+}
+
+// pointCloudMapKey identifies a pcd by the revision of the map & the options it was painted with.
+type pointCloudMapKey struct {
+	revision         int
+	changedAt        int64
+	resolutionMeters float64
+	maxPoints        int
+	hasRegion        bool
+	region           cartofacade.MapRegion
+	format           cartofacade.PointCloudFormat
+}
+
+func newPointCloudMapKey(revision cartofacade.MapRevision, opts cartofacade.MapOptions) pointCloudMapKey {
+	key := pointCloudMapKey{
+		revision: revision.Revision,
+		// a restarted cartofacade counts revisions from the start again, but not at the same time
+		changedAt:        revision.ChangedAt.UnixNano(),
+		resolutionMeters: opts.ResolutionMeters,
+		maxPoints:        opts.MaxPoints,
+		format:           opts.Format,
+	}
+	if opts.Region != nil {
+		key.hasRegion = true
+		key.region = *opts.Region
+	}
+	return key
+}
+
+// pointCloudMapCache holds the last pcd which was read in full, so that clients polling the same map don't
+// have it repainted until it changed.
+type pointCloudMapCache struct {
+	mu  sync.Mutex
+	key pointCloudMapKey
+	pcd []byte
+}
+
+func (c *pointCloudMapCache) get(key pointCloudMapKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pcd == nil || c.key != key {
+		return nil, false
+	}
+	return c.pcd, true
+}
+
+func (c *pointCloudMapCache) put(key pointCloudMapKey, pcd []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key = key
+	c.pcd = pcd
+}
+
+// cachingReader passes what it read from src to done once src has been read to the end, unless it is larger
+// than maxCachedPointCloudMapBytes.
+type cachingReader struct {
+	src      io.ReadCloser
+	buf      bytes.Buffer
+	tooLarge bool
+	done     func([]byte)
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if !r.tooLarge {
+		r.buf.Write(p[:n])
+		if r.buf.Len() > maxCachedPointCloudMapBytes {
+			r.tooLarge = true
+			r.buf = bytes.Buffer{}
+		}
+	}
+	if errors.Is(err, io.EOF) && !r.tooLarge && r.done != nil {
+		r.done(r.buf.Bytes())
+		r.done = nil
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	return r.src.Close()
+}
+
+// occupancyGridReader returns a reader of the dense occupancy grid painted with mapOpts, which applies the export
+// options. Unlike the pointcloud map, the grid is painted as a whole by cartographer, so it is encoded in memory
+// rather than streamed.
+func (cartoSvc *CartographerService) occupancyGridReader(
+	ctx context.Context,
+	opts exportOptions,
+	mapOpts cartofacade.MapOptions,
+) (io.ReadCloser, error) {
+	cf, _ := cartoSvc.facade()
+	grid, err := cf.GetOccupancyGrid(ctx, cartoSvc.cartoFacadeTimeout, mapOpts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := densegrid.Encode(&buf, grid); err != nil {
+		return nil, err
+	}
+	return compressReader(io.NopCloser(&buf), opts), nil
+}
+
+// pointCloudFileExt returns the extension of a file holding a pointcloud map in the given format.
+func pointCloudFileExt(format cartofacade.PointCloudFormat) string {
+	if format == cartofacade.PLYFormat {
+		return ".ply"
+	}
+	return ".pcd"
+}
+
+// exportPointCloudMap returns the pointcloud map painted with the optional params of the export_pointcloud_map
+// command, see writeExport.
+func (cartoSvc *CartographerService) exportPointCloudMap(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::exportPointCloudMap")
+	defer span.End()
+
+	paramsMap, _ := params.(map[string]interface{})
+	opts, err := cartoSvc.resolveExportOptions(paramsMap)
+	if err != nil {
+		return nil, err
+	}
+	format, err := pointCloudMapFormat(paramsMap)
+	if err != nil {
+		return nil, err
+	}
+	name, err := fileNameParam(paramsMap, ".gz", pointCloudFileExt(format))
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && cartoSvc.dataDirectory == "" {
+		return nil, errors.Errorf("%s requires data_dir to be configured to write name", exportPointCloudMapCommand)
+	}
+	mapOpts, err := cartoSvc.mapOptions(ctx, paramsMap)
+	if err != nil {
+		return nil, err
+	}
+	mapOpts.Format = format
+
+	r, err := cartoSvc.pointCloudMapReader(ctx, opts, mapOpts)
+	if err != nil {
+		return nil, err
+	}
+	return cartoSvc.writeExport(r, name, opts.fileExt(pointCloudFileExt(format)))
+}
+
+// exportOccupancyGrid returns the dense occupancy grid encoded by densegrid, painted with the optional params of
+// the export_occupancy_grid command, so that free space can be told from unexplored space. See writeExport.
+func (cartoSvc *CartographerService) exportOccupancyGrid(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::exportOccupancyGrid")
+	defer span.End()
+
+	paramsMap, _ := params.(map[string]interface{})
+	opts, err := cartoSvc.resolveExportOptions(paramsMap)
+	if err != nil {
+		return nil, err
+	}
+	name, err := fileNameParam(paramsMap, ".gz", occupancyGridFileExt)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && cartoSvc.dataDirectory == "" {
+		return nil, errors.Errorf("%s requires data_dir to be configured to write name", exportOccupancyGridCommand)
+	}
+	mapOpts, err := cartoSvc.mapOptions(ctx, paramsMap)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := cartoSvc.occupancyGridReader(ctx, opts, mapOpts)
+	if err != nil {
+		return nil, err
+	}
+	return cartoSvc.writeExport(r, name, opts.fileExt(occupancyGridFileExt))
+}
+
+// exportInternalState returns the internal state as a pbstream, see writeExport.
+func (cartoSvc *CartographerService) exportInternalState(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::exportInternalState")
+	defer span.End()
+
+	paramsMap, _ := params.(map[string]interface{})
+	opts, err := cartoSvc.resolveExportOptions(paramsMap)
+	if err != nil {
+		return nil, err
+	}
+	name, err := fileNameParam(paramsMap, ".gz", ".pbstream")
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && cartoSvc.dataDirectory == "" {
+		return nil, errors.Errorf("%s requires data_dir to be configured to write name", exportInternalStateCommand)
+	}
+
+	r, err := cartoSvc.internalStateReader(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return cartoSvc.writeExport(r, name, opts.fileExt(".pbstream"))
+}
+
+// writeExport reads r to the end & closes it. The export is returned base64 encoded as data, unless name is set,
+// in which case it is written as name with the given extension into the data directory & its path is returned.
+func (cartoSvc *CartographerService) writeExport(r io.ReadCloser, name, ext string) (map[string]interface{}, error) {
+	if name == "" {
+		var buf bytes.Buffer
+		_, err := io.Copy(&buf, r)
+		if err := multierr.Combine(err, r.Close()); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"data":       base64.StdEncoding.EncodeToString(buf.Bytes()),
+			"size_bytes": buf.Len(),
+		}, nil
+	}
+
+	path := filepath.Join(cartoSvc.dataDirectory, name+ext)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, multierr.Combine(err, r.Close())
+	}
+	sizeBytes, err := io.Copy(f, r)
+	if err := multierr.Combine(err, r.Close(), f.Close()); err != nil {
+		// don't leave a truncated export behind
+		return nil, multierr.Combine(err, os.Remove(path))
+	}
+	return map[string]interface{}{
+		"path":       path,
+		"size_bytes": sizeBytes,
+	}, nil
+}
@@ -43,7 +43,7 @@ func testCartographerMap(t *testing.T, svc slam.Service, localizationMode bool)
 	if localizationMode == true {
 		test.That(t, timestamp1, test.ShouldResemble, timestamp2)
 	} else {
-		test.That(t, timestamp2.After(timestamp1), test.ShouldBeTrue)
+		test.That(t, timestamp2.Before(timestamp1), test.ShouldBeFalse)
 	}
 
 	pointcloud, _ := pointcloud.ReadPCD(bytes.NewReader(pcd))
@@ -0,0 +1,180 @@
+package viamcartographer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+	"github.com/viamrobotics/viam-cartographer/maprender"
+)
+
+// mapImageFile is the name of the PNG periodically written into the data directory when map_image[rate_sec] is set.
+const mapImageFile = "map_image.png"
+
+// mapImageScene returns the painted map along with the optimized trajectory of every trajectory, the submaps &
+// the current pose, to be rendered by maprender.
+func (cartoSvc *CartographerService) mapImageScene(ctx context.Context) (maprender.Scene, error) {
+	cf, _ := cartoSvc.facade()
+	grid, err := cf.GetOccupancyGrid(ctx, cartoSvc.cartoFacadeTimeout, cartofacade.MapOptions{})
+	if err != nil {
+		return maprender.Scene{}, err
+	}
+	nodes, err := cf.GetTrajectory(ctx, cartoSvc.cartoFacadeTimeout, true, 1)
+	if err != nil {
+		return maprender.Scene{}, err
+	}
+	submaps, err := cf.GetSubmaps(ctx, cartoSvc.cartoFacadeTimeout)
+	if err != nil {
+		return maprender.Scene{}, err
+	}
+	pos, err := cf.GetPosition(ctx, cartoSvc.cartoFacadeTimeout)
+	if err != nil {
+		return maprender.Scene{}, err
+	}
+	return maprender.Scene{Grid: grid, Trajectory: nodes, Submaps: submaps, Pose: &pos}, nil
+}
+
+// renderMap renders the map as a PNG with the trajectory, the submap boundaries & the current pose drawn on top of
+// it. The optional scale & crop params overwrite the configured map_image ones. The PNG is returned base64 encoded,
+// unless the optional name param is set, in which case it is written as name.png into the data directory.
+func (cartoSvc *CartographerService) renderMap(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::renderMap")
+	defer span.End()
+
+	name, opts, err := renderMapParams(cartoSvc.mapImageOptions, params)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && cartoSvc.dataDirectory == "" {
+		return nil, errors.New("render_map requires data_dir to be configured to write name")
+	}
+
+	scene, err := cartoSvc.mapImageScene(ctx)
+	if err != nil {
+		return nil, err
+	}
+	img, err := maprender.Render(scene, opts)
+	if err != nil {
+		return nil, err
+	}
+	resp := map[string]interface{}{
+		"width":  img.Bounds().Dx(),
+		"height": img.Bounds().Dy(),
+	}
+
+	if name != "" {
+		path := filepath.Join(cartoSvc.dataDirectory, name+".png")
+		if err := maprender.WriteFile(path, img); err != nil {
+			return nil, err
+		}
+		resp["path"] = path
+		return resp, nil
+	}
+
+	var buf bytes.Buffer
+	if err := maprender.WritePNG(&buf, img); err != nil {
+		return nil, err
+	}
+	resp["png"] = base64.StdEncoding.EncodeToString(buf.Bytes())
+	return resp, nil
+}
+
+// renderMapParams returns the optional name param along with the configured options overwritten by the optional
+// scale & crop params of the render_map command.
+func renderMapParams(configured maprender.Options, params interface{}) (string, maprender.Options, error) {
+	opts := configured
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		return "", opts, nil
+	}
+
+	name, err := fileNameParam(paramsMap, ".png")
+	if err != nil {
+		return "", maprender.Options{}, err
+	}
+	if untyped, ok := paramsMap["scale"]; ok {
+		if opts.Scale, err = toFloat64(untyped); err != nil {
+			return "", maprender.Options{}, errors.Wrap(err, "scale")
+		}
+	}
+	if untyped, ok := paramsMap["crop"]; ok {
+		cropMap, ok := untyped.(map[string]interface{})
+		if !ok {
+			return "", maprender.Options{}, errors.Errorf("crop must be a map, got %T", untyped)
+		}
+		crop := &maprender.Bounds{}
+		for _, field := range []struct {
+			name  string
+			value *float64
+		}{
+			{"min_x", &crop.MinX},
+			{"min_y", &crop.MinY},
+			{"max_x", &crop.MaxX},
+			{"max_y", &crop.MaxY},
+		} {
+			untyped, ok := cropMap[field.name]
+			if !ok {
+				return "", maprender.Options{}, errors.Errorf("crop requires %s", field.name)
+			}
+			if *field.value, err = toFloat64(untyped); err != nil {
+				return "", maprender.Options{}, errors.Wrap(err, "crop "+field.name)
+			}
+		}
+		opts.Crop = crop
+	}
+	if err := opts.Validate(); err != nil {
+		return "", maprender.Options{}, err
+	}
+	return name, opts, nil
+}
+
+// startMapImageProcess periodically writes the map image into the data directory until cancelCtx is done, if
+// map_image[rate_sec] is set. It runs alongside the sensor process, so that it is stopped whenever the
+// cartofacade is replaced.
+func startMapImageProcess(cancelCtx context.Context, cartoSvc *CartographerService) {
+	if cartoSvc.mapImageRateSec <= 0 {
+		return
+	}
+	cartoSvc.sensorProcessWorkers.Add(1)
+	go func() {
+		defer cartoSvc.sensorProcessWorkers.Done()
+		ticker := time.NewTicker(time.Duration(cartoSvc.mapImageRateSec) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cancelCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := cartoSvc.writeMapImage(cancelCtx); err != nil && cancelCtx.Err() == nil {
+				cartoSvc.logger.Warnw("failed to write map image", "error", err)
+			}
+		}
+	}()
+}
+
+// writeMapImage writes the map image into the data directory, replacing the previous one.
+func (cartoSvc *CartographerService) writeMapImage(ctx context.Context) error {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::writeMapImage")
+	defer span.End()
+
+	scene, err := cartoSvc.mapImageScene(ctx)
+	if err != nil {
+		if errors.Is(err, cartofacade.ErrOccupancyGridEmpty) {
+			// nothing has been mapped yet
+			return nil
+		}
+		return err
+	}
+	img, err := maprender.Render(scene, cartoSvc.mapImageOptions)
+	if err != nil {
+		return err
+	}
+	return maprender.WriteFile(filepath.Join(cartoSvc.dataDirectory, mapImageFile), img)
+}
@@ -0,0 +1,165 @@
+package viamcartographer
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+const (
+	// regionParam limits a map query to a bounding box with min_x, min_y, max_x & max_y in millimeters.
+	regionParam = "region"
+	// radiusMMParam limits a map query to the square of the given half side in millimeters around the current pose.
+	radiusMMParam = "radius_mm"
+)
+
+// mapRegion returns the region of the map requested with the region or radius_mm params, or nil if neither is
+// set. A radius is resolved against the current pose, so that callers such as a local planner don't have to
+// query the pose first.
+func (cartoSvc *CartographerService) mapRegion(
+	ctx context.Context,
+	params map[string]interface{},
+) (*cartofacade.MapRegion, error) {
+	untypedRegion, hasRegion := params[regionParam]
+	untypedRadius, hasRadius := params[radiusMMParam]
+	switch {
+	case hasRegion && hasRadius:
+		return nil, errors.Errorf("only one of %s & %s may be set", regionParam, radiusMMParam)
+	case hasRegion:
+		return toMapRegion(untypedRegion)
+	case hasRadius:
+		radius, err := toFloat64(untypedRadius)
+		if err != nil {
+			return nil, errors.Wrap(err, radiusMMParam)
+		}
+		if radius <= 0 {
+			return nil, errors.Errorf("%s must be greater than zero, got %v", radiusMMParam, radius)
+		}
+		cf, _ := cartoSvc.facade()
+		pos, err := cf.GetPosition(ctx, cartoSvc.cartoFacadeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return &cartofacade.MapRegion{
+			MinX: pos.X - radius,
+			MinY: pos.Y - radius,
+			MaxX: pos.X + radius,
+			MaxY: pos.Y + radius,
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// toMapRegion returns the region described by a map with min_x, min_y, max_x & max_y in millimeters.
+func toMapRegion(untyped interface{}) (*cartofacade.MapRegion, error) {
+	regionMap, ok := untyped.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s must be a map, got %T", regionParam, untyped)
+	}
+	region := &cartofacade.MapRegion{}
+	for _, field := range []struct {
+		name  string
+		value *float64
+	}{
+		{"min_x", &region.MinX},
+		{"min_y", &region.MinY},
+		{"max_x", &region.MaxX},
+		{"max_y", &region.MaxY},
+	} {
+		untyped, ok := regionMap[field.name]
+		if !ok {
+			return nil, errors.Errorf("%s requires %s", regionParam, field.name)
+		}
+		var err error
+		if *field.value, err = toFloat64(untyped); err != nil {
+			return nil, errors.Wrap(err, regionParam+" "+field.name)
+		}
+	}
+	if !(region.MinX < region.MaxX && region.MinY < region.MaxY) {
+		return nil, errors.Errorf("%s must satisfy min_x < max_x & min_y < max_y", regionParam)
+	}
+	return region, nil
+}
+
+// mapOptions returns the options the map is painted with, requested with the resolution_meters, max_points,
+// region & radius_mm params.
+func (cartoSvc *CartographerService) mapOptions(
+	ctx context.Context,
+	params map[string]interface{},
+) (cartofacade.MapOptions, error) {
+	opts, err := pointCloudMapOptions(params)
+	if err != nil {
+		return cartofacade.MapOptions{}, err
+	}
+	if opts.Region, err = cartoSvc.mapRegion(ctx, params); err != nil {
+		return cartofacade.MapOptions{}, err
+	}
+	return opts, nil
+}
+
+// getMapRegion returns the submaps intersecting the region requested with the region or radius_mm params along
+// with the occupancy grid of the cells within it, so that the work is bounded by the size of the region rather
+// than the map. The cells of the grid are base64 encoded. export_pointcloud_map & export_occupancy_grid accept the
+// same params to export a region of the map in full.
+func (cartoSvc *CartographerService) getMapRegion(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::getMapRegion")
+	defer span.End()
+
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		paramsMap = map[string]interface{}{}
+	}
+	opts, err := cartoSvc.mapOptions(ctx, paramsMap)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Region == nil {
+		return nil, errors.Errorf("%s requires %s or %s", getMapRegionCommand, regionParam, radiusMMParam)
+	}
+
+	cf, _ := cartoSvc.facade()
+	grid, err := cf.GetOccupancyGrid(ctx, cartoSvc.cartoFacadeTimeout, opts)
+	if err != nil {
+		return nil, err
+	}
+	submaps, err := cf.GetSubmaps(ctx, cartoSvc.cartoFacadeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	inRegion := []interface{}{}
+	for _, submap := range submaps {
+		box := cartofacade.MapRegion{MinX: submap.MinX, MinY: submap.MinY, MaxX: submap.MaxX, MaxY: submap.MaxY}
+		if opts.Region.Intersects(box) {
+			inRegion = append(inRegion, toSubmapResponse(submap))
+		}
+	}
+
+	return map[string]interface{}{
+		"region": map[string]interface{}{
+			"min_x": opts.Region.MinX,
+			"min_y": opts.Region.MinY,
+			"max_x": opts.Region.MaxX,
+			"max_y": opts.Region.MaxY,
+		},
+		"submaps":        inRegion,
+		"occupancy_grid": toOccupancyGridResponse(grid),
+	}, nil
+}
+
+// toOccupancyGridResponse returns the occupancy grid as returned by the get_map_region & get_map_updates
+// commands, with its cells base64 encoded.
+func toOccupancyGridResponse(grid cartofacade.OccupancyGrid) map[string]interface{} {
+	return map[string]interface{}{
+		"width":             grid.Width,
+		"height":            grid.Height,
+		"resolution_meters": grid.ResolutionMeters,
+		"origin_x":          grid.OriginX,
+		"origin_y":          grid.OriginY,
+		"cells":             base64.StdEncoding.EncodeToString(grid.Cells),
+	}
+}
@@ -0,0 +1,215 @@
+package viamcartographer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+const (
+	// submapsParam holds the submaps a client of get_map_updates holds, each with the trajectory_id,
+	// submap_index, version & pose returned by a previous get_map_updates.
+	submapsParam = "submaps"
+	// submapMovedMM & submapMovedDegrees are how far a submap has to be moved from the pose a client holds
+	// before get_map_updates returns its new pose.
+	submapMovedMM      = 1.0
+	submapMovedDegrees = 0.01
+)
+
+// heldSubmap is a submap as held by a client of get_map_updates.
+type heldSubmap struct {
+	version int
+	// pose is nil if the client didn't say where it placed the submap
+	pose *cartofacade.Pose2D
+}
+
+// getMapUpdates returns the submaps which are new or whose version differs from the version the client holds
+// along with their grids, the submaps which were only moved, e.g. by an optimization, & the submaps the client
+// holds which no longer exist, e.g. as they were trimmed. The grids are painted in the frame of their submap, so
+// a client only has to place them at the new pose of a moved submap rather than fetch them again. Without the
+// submaps param every submap is returned along with its grid.
+func (cartoSvc *CartographerService) getMapUpdates(ctx context.Context, params interface{}) (map[string]interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::getMapUpdates")
+	defer span.End()
+
+	held, err := toHeldSubmaps(params)
+	if err != nil {
+		return nil, err
+	}
+
+	cf, _ := cartoSvc.facade()
+	submaps, err := cf.GetSubmaps(ctx, cartoSvc.cartoFacadeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[cartofacade.SubmapID]cartofacade.Submap, len(submaps))
+	changed := []cartofacade.SubmapID{}
+	moved := []interface{}{}
+	for _, submap := range submaps {
+		id := cartofacade.SubmapID{TrajectoryID: submap.TrajectoryID, SubmapIndex: submap.SubmapIndex}
+		current[id] = submap
+		h, ok := held[id]
+		switch {
+		case !ok || h.version != submap.Version:
+			changed = append(changed, id)
+		case h.pose == nil || submapMoved(*h.pose, submap):
+			moved = append(moved, toSubmapResponse(submap))
+		}
+	}
+
+	updated := []interface{}{}
+	if len(changed) > 0 {
+		grids, err := cf.GetSubmapGrids(ctx, cartoSvc.cartoFacadeTimeout, changed)
+		if err != nil {
+			return nil, err
+		}
+		painted := make(map[cartofacade.SubmapID]bool, len(grids))
+		for _, grid := range grids {
+			id := cartofacade.SubmapID{TrajectoryID: grid.TrajectoryID, SubmapIndex: grid.SubmapIndex}
+			submap, ok := current[id]
+			if !ok {
+				continue
+			}
+			painted[id] = true
+			resp := toSubmapResponse(submap)
+			// the submap may have changed since it was listed, the grid holds the version it was painted at
+			resp["version"] = grid.Version
+			resp["grid"] = toOccupancyGridResponse(grid.Grid)
+			updated = append(updated, resp)
+		}
+		// submaps trimmed since they were listed are removed as well
+		for _, id := range changed {
+			if !painted[id] {
+				delete(current, id)
+			}
+		}
+	}
+
+	removedIDs := []cartofacade.SubmapID{}
+	for id := range held {
+		if _, ok := current[id]; !ok {
+			removedIDs = append(removedIDs, id)
+		}
+	}
+	sort.Slice(removedIDs, func(i, j int) bool {
+		if removedIDs[i].TrajectoryID != removedIDs[j].TrajectoryID {
+			return removedIDs[i].TrajectoryID < removedIDs[j].TrajectoryID
+		}
+		return removedIDs[i].SubmapIndex < removedIDs[j].SubmapIndex
+	})
+	removed := make([]interface{}, 0, len(removedIDs))
+	for _, id := range removedIDs {
+		removed = append(removed, map[string]interface{}{
+			"trajectory_id": id.TrajectoryID,
+			"submap_index":  id.SubmapIndex,
+		})
+	}
+
+	return map[string]interface{}{
+		"updated": updated,
+		"moved":   moved,
+		"removed": removed,
+	}, nil
+}
+
+// submapMoved returns whether the submap is further than submapMovedMM or submapMovedDegrees from the pose.
+func submapMoved(pose cartofacade.Pose2D, submap cartofacade.Submap) bool {
+	theta := yawDegrees(submap.Real, submap.Imag, submap.Jmag, submap.Kmag)
+	dTheta := math.Mod(math.Abs(theta-pose.Theta), 360)
+	if dTheta > 180 {
+		dTheta = 360 - dTheta
+	}
+	return math.Hypot(submap.X-pose.X, submap.Y-pose.Y) > submapMovedMM || dTheta > submapMovedDegrees
+}
+
+// toHeldSubmaps returns the submaps held by the client, as given by the submaps param.
+func toHeldSubmaps(params interface{}) (map[cartofacade.SubmapID]heldSubmap, error) {
+	held := map[cartofacade.SubmapID]heldSubmap{}
+	paramsMap, ok := params.(map[string]interface{})
+	if !ok {
+		return held, nil
+	}
+	untyped, ok := paramsMap[submapsParam]
+	if !ok {
+		return held, nil
+	}
+	list, ok := untyped.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s must be a list, got %T", submapsParam, untyped)
+	}
+
+	for i, untypedSubmap := range list {
+		name := fmt.Sprintf("%s[%d]", submapsParam, i)
+		submapMap, ok := untypedSubmap.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("%s must be a map, got %T", name, untypedSubmap)
+		}
+		var trajectoryID, submapIndex, version float64
+		for _, field := range []struct {
+			name  string
+			value *float64
+		}{
+			{"trajectory_id", &trajectoryID},
+			{"submap_index", &submapIndex},
+			{"version", &version},
+		} {
+			untypedValue, ok := submapMap[field.name]
+			if !ok {
+				return nil, errors.Errorf("%s requires %s", name, field.name)
+			}
+			var err error
+			if *field.value, err = toFloat64(untypedValue); err != nil {
+				return nil, errors.Wrap(err, name+" "+field.name)
+			}
+		}
+
+		h := heldSubmap{version: int(version)}
+		if untypedPose, ok := submapMap["pose"]; ok {
+			pose, err := toHeldPose(name, untypedPose)
+			if err != nil {
+				return nil, err
+			}
+			h.pose = &pose
+		}
+		held[cartofacade.SubmapID{TrajectoryID: int(trajectoryID), SubmapIndex: int(submapIndex)}] = h
+	}
+	return held, nil
+}
+
+// toHeldPose returns the pose a client placed a submap at, given as a map with x & y in millimeters & theta in
+// degrees, which defaults to 0.
+func toHeldPose(name string, untyped interface{}) (cartofacade.Pose2D, error) {
+	poseMap, ok := untyped.(map[string]interface{})
+	if !ok {
+		return cartofacade.Pose2D{}, errors.Errorf("%s pose must be a map, got %T", name, untyped)
+	}
+	var pose cartofacade.Pose2D
+	for _, field := range []struct {
+		name     string
+		value    *float64
+		required bool
+	}{
+		{"x", &pose.X, true},
+		{"y", &pose.Y, true},
+		{"theta", &pose.Theta, false},
+	} {
+		untypedValue, ok := poseMap[field.name]
+		if !ok {
+			if field.required {
+				return cartofacade.Pose2D{}, errors.Errorf("%s pose requires %s", name, field.name)
+			}
+			continue
+		}
+		var err error
+		if *field.value, err = toFloat64(untypedValue); err != nil {
+			return cartofacade.Pose2D{}, errors.Wrap(err, name+" pose "+field.name)
+		}
+	}
+	return pose, nil
+}
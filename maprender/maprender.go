@@ -0,0 +1,276 @@
+// Package maprender renders cartographer's occupancy grid as a PNG, with the optimized trajectory, the submap
+// boundaries & the current pose drawn on top of it, to get a quick picture of what the robot thinks.
+package maprender
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+// MaxPixels is the largest number of pixels an image may have.
+const MaxPixels = 4096 * 4096
+
+// arrowLengthPixels & arrowHeadPixels are the length of the pose arrow & of the strokes of its head.
+const (
+	arrowLengthPixels = 15
+	arrowHeadPixels   = 5
+)
+
+var (
+	unknownColor        = color.RGBA{R: 128, G: 128, B: 128, A: 255}
+	trajectoryColor     = color.RGBA{R: 220, G: 40, B: 40, A: 255}
+	finishedSubmapColor = color.RGBA{R: 40, G: 90, B: 220, A: 255}
+	activeSubmapColor   = color.RGBA{R: 40, G: 170, B: 60, A: 255}
+	poseColor           = color.RGBA{R: 255, G: 140, B: 0, A: 255}
+)
+
+var (
+	errScaleNegative = errors.New("scale must not be negative")
+	errCropEmpty     = errors.New("crop must satisfy min_x < max_x & min_y < max_y")
+)
+
+// Bounds is an axis aligned box in the frame of the map, in millimeters.
+type Bounds struct {
+	MinX float64
+	MinY float64
+	MaxX float64
+	MaxY float64
+}
+
+// Options describe how a scene is rendered.
+type Options struct {
+	// Scale is the number of pixels per cell of the grid, it may be below 1 to shrink large maps. It defaults to 1.
+	Scale float64
+	// Crop is the part of the map rendered, the whole grid if nil. Parts of the crop outside of the grid are unknown.
+	Crop *Bounds
+}
+
+// Validate checks that the scale is not negative & that the crop is not empty.
+func (opts Options) Validate() error {
+	if opts.Scale < 0 {
+		return errScaleNegative
+	}
+	if opts.Crop != nil && !(opts.Crop.MinX < opts.Crop.MaxX && opts.Crop.MinY < opts.Crop.MaxY) {
+		return errCropEmpty
+	}
+	return nil
+}
+
+// Scene is what is rendered: the grid along with the overlays drawn on top of it, any of which may be empty.
+type Scene struct {
+	Grid       cartofacade.OccupancyGrid
+	Trajectory []cartofacade.TrajectoryNode
+	Submaps    []cartofacade.Submap
+	Pose       *cartofacade.GetPosition
+}
+
+// canvas maps the frame of the map to the pixels of an image.
+type canvas struct {
+	img *image.RGBA
+	// minX & maxY are the top left corner of the image in meters
+	minX, maxY float64
+	// metersPerPixel is the size of a pixel
+	metersPerPixel float64
+}
+
+// pixel returns the pixel of a point of the map given in millimeters.
+func (c canvas) pixel(xMillis, yMillis float64) (int, int) {
+	return int(math.Floor((xMillis/1000 - c.minX) / c.metersPerPixel)),
+		int(math.Floor((c.maxY - yMillis/1000) / c.metersPerPixel))
+}
+
+// Render renders the scene. The grid is drawn from white for free cells to black for occupied cells, with gray
+// unknown cells. The submap boundaries are drawn in blue once finished & in green while active, the trajectory
+// in red & the pose as an orange arrow.
+func Render(scene Scene, opts Options) (*image.RGBA, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	grid := scene.Grid
+	if len(grid.Cells) != grid.Width*grid.Height {
+		return nil, errors.Errorf("occupancy grid has %d cells, expected %d x %d", len(grid.Cells), grid.Width, grid.Height)
+	}
+	scale := opts.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	c := canvas{metersPerPixel: grid.ResolutionMeters / scale}
+	minY, maxX := grid.OriginY, grid.OriginX+float64(grid.Width)*grid.ResolutionMeters
+	c.minX, c.maxY = grid.OriginX, grid.OriginY+float64(grid.Height)*grid.ResolutionMeters
+	if opts.Crop != nil {
+		c.minX, minY = opts.Crop.MinX/1000, opts.Crop.MinY/1000
+		maxX, c.maxY = opts.Crop.MaxX/1000, opts.Crop.MaxY/1000
+	}
+	width := pixels((maxX - c.minX) / c.metersPerPixel)
+	height := pixels((c.maxY - minY) / c.metersPerPixel)
+	if width*height > MaxPixels {
+		return nil, errors.Errorf("map image of %d x %d pixels exceeds %d pixels, decrease the scale or crop the map",
+			width, height, MaxPixels)
+	}
+	c.img = image.NewRGBA(image.Rect(0, 0, width, height))
+
+	drawGrid(c, grid)
+	for _, submap := range scene.Submaps {
+		col := activeSubmapColor
+		if submap.Finished {
+			col = finishedSubmapColor
+		}
+		drawRect(c, submap.MinX, submap.MinY, submap.MaxX, submap.MaxY, col)
+	}
+	for i := 1; i < len(scene.Trajectory); i++ {
+		from, to := scene.Trajectory[i-1], scene.Trajectory[i]
+		if from.TrajectoryID != to.TrajectoryID {
+			continue
+		}
+		x0, y0 := c.pixel(from.X, from.Y)
+		x1, y1 := c.pixel(to.X, to.Y)
+		drawLine(c.img, x0, y0, x1, y1, trajectoryColor)
+	}
+	if scene.Pose != nil {
+		drawArrow(c, *scene.Pose)
+	}
+	return c.img, nil
+}
+
+// pixels rounds a length in pixels up, ignoring floating point noise, to at least 1 pixel.
+func pixels(length float64) int {
+	n := int(math.Ceil(length - 1e-9))
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// drawGrid paints every pixel with the cell of the grid its center falls into.
+func drawGrid(c canvas, grid cartofacade.OccupancyGrid) {
+	bounds := c.img.Bounds()
+	for py := 0; py < bounds.Dy(); py++ {
+		y := c.maxY - (float64(py)+0.5)*c.metersPerPixel
+		row := grid.Height - 1 - int(math.Floor((y-grid.OriginY)/grid.ResolutionMeters))
+		for px := 0; px < bounds.Dx(); px++ {
+			x := c.minX + (float64(px)+0.5)*c.metersPerPixel
+			col := int(math.Floor((x - grid.OriginX) / grid.ResolutionMeters))
+			if row < 0 || row >= grid.Height || col < 0 || col >= grid.Width {
+				c.img.SetRGBA(px, py, unknownColor)
+				continue
+			}
+			c.img.SetRGBA(px, py, cellColor(grid.Cells[row*grid.Width+col]))
+		}
+	}
+}
+
+// cellColor returns the gray of a cell, which is darker the more likely the cell is occupied.
+func cellColor(cell byte) color.RGBA {
+	if cell == cartofacade.OccupancyGridUnknownCell {
+		return unknownColor
+	}
+	if cell > 100 {
+		cell = 100
+	}
+	gray := uint8(math.Round(255 * (1 - float64(cell)/100)))
+	return color.RGBA{R: gray, G: gray, B: gray, A: 255}
+}
+
+func drawRect(c canvas, minX, minY, maxX, maxY float64, col color.RGBA) {
+	left, top := c.pixel(minX, maxY)
+	right, bottom := c.pixel(maxX, minY)
+	drawLine(c.img, left, top, right, top, col)
+	drawLine(c.img, right, top, right, bottom, col)
+	drawLine(c.img, right, bottom, left, bottom, col)
+	drawLine(c.img, left, bottom, left, top, col)
+}
+
+// drawArrow draws the pose as an arrow pointing along its yaw, the image's y axis points down so the yaw is negated.
+func drawArrow(c canvas, pose cartofacade.GetPosition) {
+	yaw := math.Atan2(2*(pose.Real*pose.Kmag+pose.Imag*pose.Jmag), 1-2*(pose.Jmag*pose.Jmag+pose.Kmag*pose.Kmag))
+	x0, y0 := c.pixel(pose.X, pose.Y)
+	tip := func(angle float64, length int, fromX, fromY int) (int, int) {
+		return fromX + int(math.Round(float64(length)*math.Cos(angle))),
+			fromY - int(math.Round(float64(length)*math.Sin(angle)))
+	}
+	x1, y1 := tip(yaw, arrowLengthPixels, x0, y0)
+	drawLine(c.img, x0, y0, x1, y1, poseColor)
+	for _, side := range []float64{-1, 1} {
+		x2, y2 := tip(yaw+math.Pi+side*math.Pi/6, arrowHeadPixels, x1, y1)
+		drawLine(c.img, x1, y1, x2, y2, poseColor)
+	}
+}
+
+// drawLine draws a line with Bresenham's algorithm, skipping lines which are entirely to one side of the image.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, col color.RGBA) {
+	b := img.Bounds()
+	if (x0 < b.Min.X && x1 < b.Min.X) || (x0 >= b.Max.X && x1 >= b.Max.X) ||
+		(y0 < b.Min.Y && y1 < b.Min.Y) || (y0 >= b.Max.Y && y1 >= b.Max.Y) {
+		return
+	}
+	dx, sx := abs(x1-x0), 1
+	if x0 > x1 {
+		sx = -1
+	}
+	dy, sy := -abs(y1-y0), 1
+	if y0 > y1 {
+		sy = -1
+	}
+	for e := dx + dy; ; {
+		if (image.Point{X: x0, Y: y0}).In(b) {
+			img.SetRGBA(x0, y0, col)
+		}
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// WritePNG encodes a rendered image as a PNG.
+func WritePNG(w io.Writer, img image.Image) error {
+	return png.Encode(w, img)
+}
+
+// WriteFile writes a rendered image as a PNG at path. The PNG is written next to path & renamed into place, so
+// that readers never see a partially written image when it is periodically overwritten.
+func WriteFile(path string, img image.Image) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if err := WritePNG(f, img); err != nil {
+		return multierr.Combine(err, f.Close(), os.Remove(f.Name()))
+	}
+	if err := f.Close(); err != nil {
+		return multierr.Combine(err, os.Remove(f.Name()))
+	}
+	if err := os.Chmod(f.Name(), 0o640); err != nil {
+		return multierr.Combine(err, os.Remove(f.Name()))
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return multierr.Combine(err, os.Remove(f.Name()))
+	}
+	return nil
+}
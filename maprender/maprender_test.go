@@ -0,0 +1,144 @@
+package maprender
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.viam.com/test"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+// testGrid is 3 cells wide & 2 cells high with 5cm cells, centered on the origin of the map.
+var testGrid = cartofacade.OccupancyGrid{
+	Width:            3,
+	Height:           2,
+	ResolutionMeters: 0.05,
+	OriginX:          -0.075,
+	OriginY:          -0.05,
+	Cells:            []byte{100, 0, cartofacade.OccupancyGridUnknownCell, 50, 0, 0},
+}
+
+var (
+	black = color.RGBA{A: 255}
+	white = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	gray  = color.RGBA{R: 128, G: 128, B: 128, A: 255}
+)
+
+func TestRender(t *testing.T) {
+	t.Run("paints a pixel per cell with the top row of the grid on top", func(t *testing.T) {
+		img, err := Render(Scene{Grid: testGrid}, Options{})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, img.Bounds().Dx(), test.ShouldEqual, 3)
+		test.That(t, img.Bounds().Dy(), test.ShouldEqual, 2)
+		test.That(t, img.RGBAAt(0, 0), test.ShouldResemble, black)
+		test.That(t, img.RGBAAt(1, 0), test.ShouldResemble, white)
+		test.That(t, img.RGBAAt(2, 0), test.ShouldResemble, unknownColor)
+		test.That(t, img.RGBAAt(0, 1), test.ShouldResemble, gray)
+	})
+
+	t.Run("scales the grid", func(t *testing.T) {
+		img, err := Render(Scene{Grid: testGrid}, Options{Scale: 2})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, img.Bounds().Dx(), test.ShouldEqual, 6)
+		test.That(t, img.Bounds().Dy(), test.ShouldEqual, 4)
+		test.That(t, img.RGBAAt(1, 1), test.ShouldResemble, black)
+		test.That(t, img.RGBAAt(2, 1), test.ShouldResemble, white)
+		test.That(t, img.RGBAAt(1, 2), test.ShouldResemble, gray)
+	})
+
+	t.Run("crops the map & paints outside of the grid as unknown", func(t *testing.T) {
+		img, err := Render(Scene{Grid: testGrid}, Options{Crop: &Bounds{MinX: -25, MinY: -100, MaxX: 125, MaxY: 50}})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, img.Bounds().Dx(), test.ShouldEqual, 3)
+		test.That(t, img.Bounds().Dy(), test.ShouldEqual, 3)
+		test.That(t, img.RGBAAt(0, 0), test.ShouldResemble, white)
+		test.That(t, img.RGBAAt(2, 0), test.ShouldResemble, unknownColor)
+		test.That(t, img.RGBAAt(0, 1), test.ShouldResemble, white)
+		test.That(t, img.RGBAAt(0, 2), test.ShouldResemble, unknownColor)
+	})
+
+	t.Run("draws the submaps, the trajectory & the pose on top of the grid", func(t *testing.T) {
+		scene := Scene{
+			Grid: testGrid,
+			Submaps: []cartofacade.Submap{
+				{Finished: true, MinX: -75, MinY: -50, MaxX: 75, MaxY: 50},
+			},
+			Trajectory: []cartofacade.TrajectoryNode{
+				{X: -50, Y: -25},
+				{X: 50, Y: -25},
+				{TrajectoryID: 1, X: 50, Y: 25},
+			},
+			Pose: &cartofacade.GetPosition{Real: 1},
+		}
+		img, err := Render(scene, Options{Scale: 10})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, img.Bounds().Dx(), test.ShouldEqual, 30)
+		test.That(t, img.Bounds().Dy(), test.ShouldEqual, 20)
+
+		// the submap boundary is drawn along the top left corner of the image
+		test.That(t, img.RGBAAt(0, 0), test.ShouldResemble, finishedSubmapColor)
+		test.That(t, img.RGBAAt(10, 0), test.ShouldResemble, finishedSubmapColor)
+		// the trajectory is drawn between the nodes of the same trajectory only
+		test.That(t, img.RGBAAt(12, 15), test.ShouldResemble, trajectoryColor)
+		test.That(t, img.RGBAAt(25, 10), test.ShouldNotResemble, trajectoryColor)
+		// the pose points along the x axis from the center of the image
+		test.That(t, img.RGBAAt(20, 10), test.ShouldResemble, poseColor)
+		test.That(t, img.RGBAAt(10, 5), test.ShouldNotResemble, poseColor)
+	})
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := Render(Scene{Grid: testGrid}, Options{Scale: -1})
+		test.That(t, err, test.ShouldBeError, errScaleNegative)
+
+		_, err = Render(Scene{Grid: testGrid}, Options{Crop: &Bounds{MinX: 10, MaxX: 10, MaxY: 10}})
+		test.That(t, err, test.ShouldBeError, errCropEmpty)
+
+		_, err = Render(Scene{Grid: testGrid}, Options{Scale: 2000})
+		test.That(t, err, test.ShouldBeError,
+			errors.New("map image of 6000 x 4000 pixels exceeds 16777216 pixels, decrease the scale or crop the map"))
+
+		grid := testGrid
+		grid.Height = 3
+		_, err = Render(Scene{Grid: grid}, Options{})
+		test.That(t, err, test.ShouldBeError, errors.New("occupancy grid has 6 cells, expected 3 x 3"))
+	})
+}
+
+func TestWritePNG(t *testing.T) {
+	rendered, err := Render(Scene{Grid: testGrid}, Options{Scale: 2})
+	test.That(t, err, test.ShouldBeNil)
+	var buf bytes.Buffer
+	test.That(t, WritePNG(&buf, rendered), test.ShouldBeNil)
+	img, err := png.Decode(&buf)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, img.Bounds().Dx(), test.ShouldEqual, 6)
+	test.That(t, img.Bounds().Dy(), test.ShouldEqual, 4)
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "map_image.png")
+	rendered, err := Render(Scene{Grid: testGrid}, Options{})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, WriteFile(path, rendered), test.ShouldBeNil)
+	// overwriting the image replaces it without leaving temporary files behind
+	rendered, err = Render(Scene{Grid: testGrid}, Options{Scale: 2})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, WriteFile(path, rendered), test.ShouldBeNil)
+
+	entries, err := os.ReadDir(dir)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(entries), test.ShouldEqual, 1)
+	f, err := os.Open(path)
+	test.That(t, err, test.ShouldBeNil)
+	defer f.Close()
+	img, err := png.Decode(f)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, img.Bounds().Dx(), test.ShouldEqual, 6)
+}
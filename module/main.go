@@ -6,11 +6,13 @@ import (
 	"strings"
 
 	"github.com/edaniels/golog"
+	"go.opencensus.io/stats/view"
 	"go.viam.com/rdk/module"
 	"go.viam.com/rdk/services/slam"
 	"go.viam.com/utils"
 
 	viamcartographer "github.com/viamrobotics/viam-cartographer"
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
 )
 
 // Versioning variables which are replaced by LD flags.
@@ -41,6 +43,11 @@ func mainWithArgs(ctx context.Context, args []string, logger golog.Logger) error
 		return nil
 	}
 
+	if err := view.Register(cartofacade.RequestViews...); err != nil {
+		logger.Errorw("failed to register cartofacade request views", "error", err)
+	}
+	defer view.Unregister(cartofacade.RequestViews...)
+
 	if err := viamcartographer.InitCartoLib(logger); err != nil {
 		return err
 	}
@@ -0,0 +1,83 @@
+package viamcartographer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+	"github.com/viamrobotics/viam-cartographer/rosmap"
+)
+
+// offlineCartoFacadeTimeout is the timeout of the requests of an offline cartofacade, which is generous as
+// loading a large pbstream can take a while.
+const offlineCartoFacadeTimeout = time.Minute
+
+// ExportROSMap loads the pbstream at pbstreamPath & writes its map as name.pgm & name.yaml into dir, in the
+// map_server format of ROS. It returns the paths of the pgm & yaml. InitCartoLib must have been called.
+func ExportROSMap(
+	ctx context.Context,
+	pbstreamPath, dir, name string,
+	thresholds rosmap.Thresholds,
+	logger golog.Logger,
+) (string, string, error) {
+	var pgmPath, yamlPath string
+	err := withOfflineCartoFacade(ctx, pbstreamPath, logger, func(cf cartofacade.Interface) error {
+		grid, err := cf.GetOccupancyGrid(ctx, offlineCartoFacadeTimeout, cartofacade.MapOptions{})
+		if err != nil {
+			return err
+		}
+		pgmPath, yamlPath, err = rosmap.Write(dir, name, grid, thresholds)
+		return err
+	})
+	return pgmPath, yamlPath, err
+}
+
+// withOfflineCartoFacade runs f against a cartofacade which localizes on the pbstream at pbstreamPath without any
+// sensors, so that the map of a pbstream can be read outside of a running service.
+func withOfflineCartoFacade(
+	ctx context.Context,
+	pbstreamPath string,
+	logger golog.Logger,
+	f func(cf cartofacade.Interface) error,
+) (err error) {
+	if pbstreamPath == "" {
+		return errors.New("a pbstream is required")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var workers sync.WaitGroup
+	defer func() {
+		cancel()
+		workers.Wait()
+	}()
+
+	cartoCfg := cartofacade.CartoConfig{
+		Camera:             "offline",
+		ComponentReference: "offline",
+		LidarConfig:        cartofacade.TwoD,
+		CloudStoryEnabled:  true,
+		EnableMapping:      false,
+		ExistingMap:        pbstreamPath,
+	}
+	cf := cartofacade.New(&cartoLib, cartoCfg, defaultCartoAlgoCfg)
+	if _, err := cf.Initialize(ctx, offlineCartoFacadeTimeout, &workers); err != nil {
+		return errors.Wrap(err, "failed to load pbstream")
+	}
+	defer func() {
+		err = multierr.Combine(err, cf.Terminate(ctx, offlineCartoFacadeTimeout))
+	}()
+
+	if err := cf.Start(ctx, offlineCartoFacadeTimeout); err != nil {
+		return err
+	}
+	defer func() {
+		err = multierr.Combine(err, cf.Stop(ctx, offlineCartoFacadeTimeout))
+	}()
+
+	logger.Debugw("loaded pbstream", "path", pbstreamPath)
+	return f(&cf)
+}
@@ -0,0 +1,168 @@
+// Package rosmap writes cartographer's occupancy grid in the map_server format of ROS: a trinary PGM image along
+// with a YAML file describing its resolution, origin & thresholds.
+package rosmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+const (
+	// DefaultFreeThresh & DefaultOccupiedThresh are the thresholds map_saver writes maps with.
+	DefaultFreeThresh     = 0.196
+	DefaultOccupiedThresh = 0.65
+
+	// gray values map_saver writes trinary maps with.
+	occupiedValue = 0
+	freeValue     = 254
+	unknownValue  = 205
+)
+
+// Thresholds classify cells by the probability (0 - 1) that they are occupied: cells above Occupied are occupied,
+// cells below Free are free & the others are unknown, which is how map_server reads a map.
+type Thresholds struct {
+	Free     float64
+	Occupied float64
+}
+
+// DefaultThresholds returns the thresholds map_saver writes maps with.
+func DefaultThresholds() Thresholds {
+	return Thresholds{Free: DefaultFreeThresh, Occupied: DefaultOccupiedThresh}
+}
+
+// Validate checks that the thresholds are probabilities between 0 & 1, & that free is below occupied.
+func (th Thresholds) Validate() error {
+	if !(th.Free > 0 && th.Free < th.Occupied && th.Occupied < 1) {
+		return errors.Errorf("thresholds must satisfy 0 < free_thresh < occupied_thresh < 1, got free_thresh %v & "+
+			"occupied_thresh %v", th.Free, th.Occupied)
+	}
+	return nil
+}
+
+// occupancy returns the probability that a cell is occupied, as map_server reads it from a gray value of a map
+// which is not negated.
+func occupancy(value byte) float64 {
+	return float64(math.MaxUint8-value) / math.MaxUint8
+}
+
+// values returns the gray values the occupied, free & unknown cells are written with. map_saver's values are kept
+// as long as map_server reads them back as the same class under the thresholds.
+func (th Thresholds) values() (occupied, free, unknown byte, err error) {
+	if err := th.Validate(); err != nil {
+		return 0, 0, 0, err
+	}
+	// the occupancy of occupiedValue is 1, which is above any valid occupied threshold
+	occupied = occupiedValue
+
+	free = freeValue
+	if occupancy(free) >= th.Free {
+		free = math.MaxUint8
+	}
+
+	unknown = unknownValue
+	if occ := occupancy(unknown); occ < th.Free || occ > th.Occupied {
+		unknown = byte(math.Round(math.MaxUint8 * (1 - (th.Free+th.Occupied)/2)))
+		if occ := occupancy(unknown); occ < th.Free || occ > th.Occupied {
+			return 0, 0, 0, errors.Errorf("free_thresh %v & occupied_thresh %v are too close to write unknown cells",
+				th.Free, th.Occupied)
+		}
+	}
+	return occupied, free, unknown, nil
+}
+
+// WritePGM writes the grid as a binary trinary PGM, whose top row is the top row of the grid.
+func WritePGM(w io.Writer, grid cartofacade.OccupancyGrid, th Thresholds) error {
+	occupied, free, unknown, err := th.values()
+	if err != nil {
+		return err
+	}
+	if len(grid.Cells) != grid.Width*grid.Height {
+		return errors.Errorf("occupancy grid has %d cells, expected %d x %d", len(grid.Cells), grid.Width, grid.Height)
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "P5\n# CREATOR: viam-cartographer %.3f m/pix\n%d %d\n%d\n",
+		grid.ResolutionMeters, grid.Width, grid.Height, math.MaxUint8); err != nil {
+		return err
+	}
+	for _, cell := range grid.Cells {
+		value := unknown
+		if cell != cartofacade.OccupancyGridUnknownCell {
+			switch p := float64(cell) / 100; {
+			case p > th.Occupied:
+				value = occupied
+			case p < th.Free:
+				value = free
+			}
+		}
+		if err := bw.WriteByte(value); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteYAML writes the YAML describing the PGM written by WritePGM, whose path is image. The origin is the lower
+// left corner of the bottom left cell in meters, in the same frame as GetPosition.
+func WriteYAML(w io.Writer, grid cartofacade.OccupancyGrid, image string, th Thresholds) error {
+	if err := th.Validate(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w,
+		"image: %s\n"+
+			"mode: trinary\n"+
+			"resolution: %s\n"+
+			"origin: [%s, %s, 0]\n"+
+			"negate: 0\n"+
+			"occupied_thresh: %s\n"+
+			"free_thresh: %s\n",
+		image,
+		formatFloat(grid.ResolutionMeters),
+		formatFloat(grid.OriginX),
+		formatFloat(grid.OriginY),
+		formatFloat(th.Occupied),
+		formatFloat(th.Free),
+	)
+	return err
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Write writes the grid as name.pgm & name.yaml into dir & returns their paths. The YAML refers to the PGM by its
+// file name, which map_server resolves relative to the YAML.
+func Write(dir, name string, grid cartofacade.OccupancyGrid, th Thresholds) (string, string, error) {
+	pgmPath := filepath.Join(dir, name+".pgm")
+	yamlPath := filepath.Join(dir, name+".yaml")
+	if err := writeFile(pgmPath, func(w io.Writer) error { return WritePGM(w, grid, th) }); err != nil {
+		return "", "", err
+	}
+	if err := writeFile(yamlPath, func(w io.Writer) error {
+		return WriteYAML(w, grid, filepath.Base(pgmPath), th)
+	}); err != nil {
+		return "", "", multierr.Combine(err, os.Remove(pgmPath))
+	}
+	return pgmPath, yamlPath, nil
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		return multierr.Combine(err, f.Close(), os.Remove(path))
+	}
+	return f.Close()
+}
@@ -0,0 +1,138 @@
+package rosmap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.viam.com/test"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+// testGrid is 3 cells wide & 2 cells high, its top row is occupied, free & unknown.
+var testGrid = cartofacade.OccupancyGrid{
+	Width:            3,
+	Height:           2,
+	ResolutionMeters: 0.05,
+	OriginX:          -0.075,
+	OriginY:          -1.525,
+	Cells:            []byte{100, 0, cartofacade.OccupancyGridUnknownCell, 65, 19, 50},
+}
+
+// mapServerClass mirrors how map_server classifies a gray value of a trinary map which is not negated.
+func mapServerClass(value byte, th Thresholds) string {
+	switch occ := occupancy(value); {
+	case occ > th.Occupied:
+		return "occupied"
+	case occ < th.Free:
+		return "free"
+	default:
+		return "unknown"
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	t.Run("rejects invalid thresholds", func(t *testing.T) {
+		for _, th := range []Thresholds{
+			{Free: 0, Occupied: 0.65},
+			{Free: 0.65, Occupied: 0.196},
+			{Free: 0.5, Occupied: 0.5},
+			{Free: 0.196, Occupied: 1},
+		} {
+			test.That(t, th.Validate(), test.ShouldNotBeNil)
+		}
+		test.That(t, DefaultThresholds().Validate(), test.ShouldBeNil)
+	})
+
+	t.Run("keeps map_saver's values with the default thresholds", func(t *testing.T) {
+		occupied, free, unknown, err := DefaultThresholds().values()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, occupied, test.ShouldEqual, 0)
+		test.That(t, free, test.ShouldEqual, 254)
+		test.That(t, unknown, test.ShouldEqual, 205)
+	})
+
+	t.Run("values are read back as the same class by map_server", func(t *testing.T) {
+		for _, th := range []Thresholds{
+			DefaultThresholds(),
+			{Free: 0.25, Occupied: 0.65},
+			{Free: 0.003, Occupied: 0.01},
+			{Free: 0.8, Occupied: 0.9},
+		} {
+			occupied, free, unknown, err := th.values()
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, mapServerClass(occupied, th), test.ShouldEqual, "occupied")
+			test.That(t, mapServerClass(free, th), test.ShouldEqual, "free")
+			test.That(t, mapServerClass(unknown, th), test.ShouldEqual, "unknown")
+		}
+	})
+
+	t.Run("rejects thresholds too close to write unknown cells", func(t *testing.T) {
+		th := Thresholds{Free: 0.5, Occupied: 0.501}
+		_, _, _, err := th.values()
+		test.That(t, err, test.ShouldBeError, errors.New("free_thresh 0.5 & occupied_thresh 0.501 are too close to write unknown cells"))
+	})
+}
+
+func TestWritePGM(t *testing.T) {
+	t.Run("writes a trinary binary pgm", func(t *testing.T) {
+		var buf bytes.Buffer
+		test.That(t, WritePGM(&buf, testGrid, DefaultThresholds()), test.ShouldBeNil)
+		expected := append([]byte("P5\n# CREATOR: viam-cartographer 0.050 m/pix\n3 2\n255\n"), 0, 254, 205, 205, 254, 205)
+		test.That(t, buf.Bytes(), test.ShouldResemble, expected)
+	})
+
+	t.Run("classifies cells with the given thresholds", func(t *testing.T) {
+		var buf bytes.Buffer
+		test.That(t, WritePGM(&buf, testGrid, Thresholds{Free: 0.55, Occupied: 0.6}), test.ShouldBeNil)
+		test.That(t, buf.Bytes()[buf.Len()-6:], test.ShouldResemble, []byte{0, 254, 108, 0, 254, 254})
+	})
+
+	t.Run("rejects a grid whose cells don't match its size", func(t *testing.T) {
+		grid := testGrid
+		grid.Height = 3
+		err := WritePGM(&bytes.Buffer{}, grid, DefaultThresholds())
+		test.That(t, err, test.ShouldBeError, errors.New("occupancy grid has 6 cells, expected 3 x 3"))
+	})
+}
+
+func TestWriteYAML(t *testing.T) {
+	var buf bytes.Buffer
+	test.That(t, WriteYAML(&buf, testGrid, "map.pgm", DefaultThresholds()), test.ShouldBeNil)
+	test.That(t, buf.String(), test.ShouldEqual, "image: map.pgm\n"+
+		"mode: trinary\n"+
+		"resolution: 0.05\n"+
+		"origin: [-0.075, -1.525, 0]\n"+
+		"negate: 0\n"+
+		"occupied_thresh: 0.65\n"+
+		"free_thresh: 0.196\n")
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("writes the pgm & a yaml referring to it", func(t *testing.T) {
+		dir := t.TempDir()
+		pgmPath, yamlPath, err := Write(dir, "office", testGrid, DefaultThresholds())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, pgmPath, test.ShouldEqual, filepath.Join(dir, "office.pgm"))
+		test.That(t, yamlPath, test.ShouldEqual, filepath.Join(dir, "office.yaml"))
+
+		pgm, err := os.ReadFile(pgmPath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(pgm), test.ShouldStartWith, "P5\n")
+		yaml, err := os.ReadFile(yamlPath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(yaml), test.ShouldStartWith, "image: office.pgm\n")
+	})
+
+	t.Run("writes nothing with invalid thresholds", func(t *testing.T) {
+		dir := t.TempDir()
+		_, _, err := Write(dir, "office", testGrid, Thresholds{Free: 0.7, Occupied: 0.6})
+		test.That(t, err, test.ShouldNotBeNil)
+		entries, err := os.ReadDir(dir)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, entries, test.ShouldBeEmpty)
+	})
+}
@@ -23,6 +23,8 @@ type Config struct {
 	LidarDataRateMsec int
 	Timeout           time.Duration
 	Logger            golog.Logger
+	// Stats counts the readings fetched & added to the cartofacade, it is optional.
+	Stats *Stats
 }
 
 // Start polls the lidar to get the next sensor reading and adds it to the cartofacade.
@@ -49,6 +51,7 @@ func addLidarReading(
 	config Config,
 ) bool {
 	tsr, err := config.Lidar.TimedLidarSensorReading(ctx)
+	config.Stats.recordFetch(tsr.ReadingTime, err)
 	if err != nil {
 		config.Logger.Warn(err)
 		// only end the sensor process if we are in offline mode
@@ -84,6 +87,7 @@ func tryAddLidarReadingUntilSuccess(ctx context.Context, reading []byte, reading
 			return
 		default:
 			err := config.CartoFacade.AddLidarReading(ctx, config.Timeout, config.LidarName, reading, readingTime)
+			config.Stats.recordAdd(readingTime, err)
 			if err == nil {
 				return
 			}
@@ -99,6 +103,7 @@ func tryAddLidarReadingUntilSuccess(ctx context.Context, reading []byte, reading
 func tryAddLidarReading(ctx context.Context, reading []byte, readingTime time.Time, config Config) int {
 	startTime := time.Now()
 	err := config.CartoFacade.AddLidarReading(ctx, config.Timeout, config.LidarName, reading, readingTime)
+	config.Stats.recordAdd(readingTime, err)
 	if err != nil {
 		if errors.Is(err, cartofacade.ErrUnableToAcquireLock) {
 			config.Logger.Debugw("Skipping lidar reading due to lock contention in cartofacade", "error", err)
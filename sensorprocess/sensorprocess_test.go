@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/edaniels/golog"
+	"go.uber.org/multierr"
 	"go.viam.com/test"
 
 	"github.com/viamrobotics/viam-cartographer/cartofacade"
@@ -458,6 +459,122 @@ func TestAddSensorReading(t *testing.T) {
 	})
 }
 
+func TestStats(t *testing.T) {
+	logger := golog.NewTestLogger(t)
+	cf := cartofacade.Mock{}
+	stats := NewStats()
+
+	config := Config{
+		Logger:            logger,
+		CartoFacade:       &cf,
+		LidarDataRateMsec: 0,
+		Timeout:           10 * time.Second,
+		Stats:             stats,
+	}
+	ctx := context.Background()
+
+	t.Run("no readings are counted at first", func(t *testing.T) {
+		snapshot := stats.Snapshot()
+		test.That(t, snapshot.Fetched, test.ShouldEqual, 0)
+		test.That(t, snapshot.Submitted, test.ShouldEqual, 0)
+		test.That(t, snapshot.Failed, test.ShouldBeEmpty)
+		test.That(t, snapshot.LastFetchedReadingTime.IsZero(), test.ShouldBeTrue)
+		test.That(t, snapshot.LastSubmittedReadingTime.IsZero(), test.ShouldBeTrue)
+	})
+
+	t.Run("counts readings fetched, submitted, skipped & failed", func(t *testing.T) {
+		cam := "replay_lidar"
+		replaySensor, err := s.NewLidar(context.Background(), s.SetupDeps(cam, ""), cam, logger)
+		test.That(t, err, test.ShouldBeNil)
+
+		calls := 0
+		var submittedTimestamp time.Time
+		cf.AddLidarReadingFunc = func(
+			ctx context.Context,
+			timeout time.Duration,
+			sensorName string,
+			currentReading []byte,
+			readingTimestamp time.Time,
+		) error {
+			calls++
+			switch calls {
+			case 1:
+				return errUnknown
+			case 2:
+				return cartofacade.ErrUnableToAcquireLock
+			default:
+				submittedTimestamp = readingTimestamp
+				return nil
+			}
+		}
+		config.Lidar = replaySensor
+		config.LidarName = replaySensor.Name
+
+		jobDone := addLidarReading(ctx, config)
+		test.That(t, jobDone, test.ShouldBeFalse)
+
+		snapshot := stats.Snapshot()
+		test.That(t, snapshot.Fetched, test.ShouldEqual, 1)
+		test.That(t, snapshot.FetchFailed, test.ShouldEqual, 0)
+		test.That(t, snapshot.Submitted, test.ShouldEqual, 1)
+		test.That(t, snapshot.SkippedLockContention, test.ShouldEqual, 1)
+		test.That(t, snapshot.Failed, test.ShouldResemble, map[string]int64{FailedOther: 1})
+		test.That(t, snapshot.LastFetchedReadingTime, test.ShouldEqual, submittedTimestamp)
+		test.That(t, snapshot.LastSubmittedReadingTime, test.ShouldEqual, submittedTimestamp)
+		test.That(t, snapshot.Uptime > 0, test.ShouldBeTrue)
+		test.That(t, snapshot.FetchedPerSec, test.ShouldBeGreaterThan, 0)
+		test.That(t, snapshot.SubmittedPerSec, test.ShouldBeGreaterThan, 0)
+	})
+
+	t.Run("counts readings the lidar failed to return", func(t *testing.T) {
+		cam := "lidar_with_erroring_functions"
+		lidar, err := s.NewLidar(context.Background(), s.SetupDeps(cam, ""), cam, logger)
+		test.That(t, err, test.ShouldBeNil)
+		config.Lidar = lidar
+		config.LidarDataRateMsec = 200
+
+		jobDone := addLidarReading(ctx, config)
+		test.That(t, jobDone, test.ShouldBeFalse)
+		test.That(t, stats.Snapshot().FetchFailed, test.ShouldEqual, 1)
+	})
+}
+
+func TestFailureCategory(t *testing.T) {
+	timeoutErr := multierr.Combine(errors.New("timeout reading from cartographer"), context.DeadlineExceeded)
+	test.That(t, failureCategory(timeoutErr), test.ShouldEqual, FailedTimeout)
+	test.That(t, failureCategory(context.Canceled), test.ShouldEqual, FailedCanceled)
+	test.That(t, failureCategory(errors.New("VIAM_CARTO_NOT_IN_STARTED_STATE")), test.ShouldEqual, FailedCartographer)
+	test.That(t, failureCategory(errUnknown), test.ShouldEqual, FailedOther)
+}
+
+func TestRateWindow(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := newRateWindow(start, 10*time.Second)
+
+	t.Run("no events have a rate of 0", func(t *testing.T) {
+		test.That(t, w.rate(start), test.ShouldEqual, 0)
+		test.That(t, w.rate(start.Add(time.Minute)), test.ShouldEqual, 0)
+	})
+
+	t.Run("the rate is computed since start until the window is full", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			w.record(start.Add(time.Duration(i) * 500 * time.Millisecond))
+		}
+		test.That(t, w.rate(start.Add(5*time.Second)), test.ShouldEqual, 2)
+		// the window covers whole seconds, so the events of second 0 drop out at second 10
+		test.That(t, w.rate(start.Add(10*time.Second)), test.ShouldEqual, 0.8)
+	})
+
+	t.Run("events drop out of the window", func(t *testing.T) {
+		test.That(t, w.rate(start.Add(12*time.Second)), test.ShouldEqual, 0.4)
+		test.That(t, w.rate(start.Add(time.Minute)), test.ShouldEqual, 0)
+
+		// a bucket reused for a later second starts counting from 0
+		w.record(start.Add(time.Minute))
+		test.That(t, w.rate(start.Add(time.Minute)), test.ShouldEqual, 0.1)
+	})
+}
+
 func TestStart(t *testing.T) {
 	logger := golog.NewTestLogger(t)
 	cf := cartofacade.Mock{}
@@ -0,0 +1,199 @@
+package sensorprocess
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viamrobotics/viam-cartographer/cartofacade"
+)
+
+// RateWindow is the time over which the rates of Stats are computed, so that they follow stalls & recoveries of
+// the lidar rather than averaging over the lifetime of the service.
+const RateWindow = time.Minute
+
+// The categories by which Stats count the readings the cartofacade failed to add. Errors are categorized rather
+// than counted by message, as messages may contain timestamps & sensor names.
+const (
+	// FailedTimeout counts requests which timed out waiting for the cartofacade
+	FailedTimeout = "timeout"
+	// FailedCanceled counts requests which were canceled, e.g. as the service was closed
+	FailedCanceled = "canceled"
+	// FailedCartographer counts errors returned by cartographer, e.g. as it isn't started
+	FailedCartographer = "cartographer"
+	// FailedOther counts every other error
+	FailedOther = "other"
+)
+
+// Stats counts the lidar readings fetched by the sensor process & added to the cartofacade. It is safe for
+// concurrent use & is meant to be shared by every sensor process started by the service, so that the counts
+// cover the lifetime of the service rather than of a single sensor process.
+type Stats struct {
+	start time.Time
+
+	mu                       sync.Mutex
+	fetched                  int64
+	fetchFailed              int64
+	submitted                int64
+	skippedLockContention    int64
+	failed                   map[string]int64
+	fetchedRate              *rateWindow
+	submittedRate            *rateWindow
+	lastFetchedReadingTime   time.Time
+	lastSubmittedReadingTime time.Time
+}
+
+// StatsSnapshot is a copy of the counts of Stats at a point in time.
+type StatsSnapshot struct {
+	// Uptime is the time since the Stats were created
+	Uptime time.Duration
+	// Fetched is the number of readings returned by the lidar
+	Fetched int64
+	// FetchFailed is the number of times the lidar returned an error
+	FetchFailed int64
+	// Submitted is the number of readings added to the cartofacade
+	Submitted int64
+	// SkippedLockContention is the number of times the cartofacade couldn't add a reading as it was busy.
+	// Online the reading is skipped, offline it is retried.
+	SkippedLockContention int64
+	// Failed is the number of readings the cartofacade failed to add, by one of the Failed* categories
+	Failed map[string]int64
+	// FetchedPerSec & SubmittedPerSec are the rates at which readings were fetched & submitted over the last
+	// RateWindow, or since the Stats were created if that is more recent
+	FetchedPerSec   float64
+	SubmittedPerSec float64
+	// LastFetchedReadingTime & LastSubmittedReadingTime are the zero time until a reading has been fetched or
+	// submitted
+	LastFetchedReadingTime   time.Time
+	LastSubmittedReadingTime time.Time
+}
+
+// NewStats returns Stats with no readings counted.
+func NewStats() *Stats {
+	start := time.Now()
+	return &Stats{
+		start:         start,
+		failed:        map[string]int64{},
+		fetchedRate:   newRateWindow(start, RateWindow),
+		submittedRate: newRateWindow(start, RateWindow),
+	}
+}
+
+// Snapshot returns a copy of the current counts.
+func (s *Stats) Snapshot() StatsSnapshot {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failed := make(map[string]int64, len(s.failed))
+	for k, v := range s.failed {
+		failed[k] = v
+	}
+	return StatsSnapshot{
+		Uptime:                   now.Sub(s.start),
+		Fetched:                  s.fetched,
+		FetchFailed:              s.fetchFailed,
+		Submitted:                s.submitted,
+		SkippedLockContention:    s.skippedLockContention,
+		Failed:                   failed,
+		FetchedPerSec:            s.fetchedRate.rate(now),
+		SubmittedPerSec:          s.submittedRate.rate(now),
+		LastFetchedReadingTime:   s.lastFetchedReadingTime,
+		LastSubmittedReadingTime: s.lastSubmittedReadingTime,
+	}
+}
+
+// The record functions are no-ops on nil Stats, so that the sensor process can be run without them.
+
+func (s *Stats) recordFetch(readingTime time.Time, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.fetchFailed++
+		return
+	}
+	s.fetched++
+	s.fetchedRate.record(time.Now())
+	s.lastFetchedReadingTime = readingTime
+}
+
+func (s *Stats) recordAdd(readingTime time.Time, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		s.submitted++
+		s.submittedRate.record(time.Now())
+		s.lastSubmittedReadingTime = readingTime
+	case errors.Is(err, cartofacade.ErrUnableToAcquireLock):
+		s.skippedLockContention++
+	default:
+		s.failed[failureCategory(err)]++
+	}
+}
+
+// failureCategory returns the Failed* category of an error returned by the cartofacade.
+func failureCategory(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return FailedTimeout
+	case errors.Is(err, context.Canceled):
+		return FailedCanceled
+	case strings.HasPrefix(err.Error(), "VIAM_CARTO_"):
+		return FailedCartographer
+	default:
+		return FailedOther
+	}
+}
+
+// rateWindow counts events in one second buckets over a sliding window. It isn't safe for concurrent use, Stats
+// guards it with its mutex.
+type rateWindow struct {
+	start time.Time
+	// counts[i] is the number of events in the second seconds[i], which is the most recent second with
+	// seconds[i] % len(counts) == i that had an event
+	counts  []int64
+	seconds []int64
+}
+
+func newRateWindow(start time.Time, window time.Duration) *rateWindow {
+	size := int(window / time.Second)
+	return &rateWindow{start: start, counts: make([]int64, size), seconds: make([]int64, size)}
+}
+
+func (w *rateWindow) record(now time.Time) {
+	second := now.Unix()
+	i := int(second % int64(len(w.counts)))
+	if w.seconds[i] != second {
+		w.seconds[i] = second
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+}
+
+// rate returns the events per second over the window ending now, or since start if the window reaches back
+// further than that.
+func (w *rateWindow) rate(now time.Time) float64 {
+	window := time.Duration(len(w.counts)) * time.Second
+	if elapsed := now.Sub(w.start); elapsed < window {
+		window = elapsed
+	}
+	if window <= 0 {
+		return 0
+	}
+	second := now.Unix()
+	var count int64
+	for i, bucketSecond := range w.seconds {
+		if second-bucketSecond < int64(len(w.counts)) {
+			count += w.counts[i]
+		}
+	}
+	return float64(count) / window.Seconds()
+}
@@ -7,7 +7,13 @@
 #include <boost/uuid/uuid.hpp>             // uuid class
 #include <boost/uuid/uuid_generators.hpp>  // generators
 #include <boost/uuid/uuid_io.hpp>
+#include <algorithm>
+#include <cmath>
+#include <set>
 
+#include "cartographer/common/math.h"
+#include "cartographer/common/time.h"
+#include "cartographer/mapping/2d/submap_2d.h"
 #include "glog/logging.h"
 #include "io.h"
 #include "map_builder.h"
@@ -67,6 +73,267 @@ int calculate_probability_from_color_channels(ColorARGB pixel_color) {
     return prob;
 }
 
+// painted_map_height checks that the painted surface is in ARGB32 format &
+// returns its height in pixels.
+int painted_map_height(
+    const cartographer::io::PaintSubmapSlicesResult &painted_slices) {
+    auto painted_surface = painted_slices.surface.get();
+    auto image_format = cairo_image_surface_get_format(painted_surface);
+    if (image_format != cartographer::io::kCairoFormat) {
+        std::string error_log =
+            "Error cairo surface in wrong format, expected Cairo_Format_ARGB32";
+        LOG(ERROR) << error_log;
+        throw std::runtime_error(error_log);
+    }
+    return cairo_image_surface_get_height(painted_surface);
+}
+
+// painted_pixel_color returns the color of the given pixel of the painted
+// surface, which is in ARGB32 format.
+ColorARGB painted_pixel_color(
+    const cartographer::io::PaintSubmapSlicesResult &painted_slices,
+    int pixel_x, int pixel_y) {
+    auto painted_surface = painted_slices.surface.get();
+    int width = cairo_image_surface_get_width(painted_surface);
+    auto image_data_ptr = cairo_image_surface_get_data(painted_surface);
+
+    // Get byte index associated with pixel
+    int pixel_index = pixel_x + pixel_y * width;
+    int byte_index = pixel_index * bytesPerPixel;
+
+    // We assume we are running on a little-endian system, so the ARGB
+    // order is reversed
+    ColorARGB pixel_color;
+    pixel_color.A = image_data_ptr[byte_index + 3];
+    pixel_color.R = image_data_ptr[byte_index + 2];
+    pixel_color.G = image_data_ptr[byte_index + 1];
+    pixel_color.B = image_data_ptr[byte_index + 0];
+    return pixel_color;
+}
+
+// write_painted_map_row appends the points of the given row of the painted
+// surface to the buffer in the given format & returns the number of points
+// written. The points are only counted if the buffer is null.
+int write_painted_map_row(const PaintedMap &painted_map, int pixel_y,
+                          util::PointCloudFormat format, std::string *buffer) {
+    const cartographer::io::PaintSubmapSlicesResult &painted_slices =
+        painted_map.slices;
+    double resolution_meters = painted_map.resolution_meters;
+
+    // Get pixel containing map origin (0, 0)
+    float origin_pixel_x = painted_slices.origin.x();
+    float origin_pixel_y = painted_slices.origin.y();
+
+    int num_points = 0;
+    for (int pixel_x = painted_map.min_pixel_x;
+         pixel_x < painted_map.max_pixel_x; pixel_x++) {
+        ColorARGB pixel_color =
+            painted_pixel_color(painted_slices, pixel_x, pixel_y);
+
+        // Skip pixel if it contains empty data (default color)
+        if (check_if_empty_pixel(pixel_color)) {
+            continue;
+        }
+
+        // Determine probability based on the color of the pixel and skip if
+        // it is 0
+        int prob = calculate_probability_from_color_channels(pixel_color);
+        if (prob == 0) {
+            continue;
+        }
+
+        num_points++;
+        if (buffer == nullptr) {
+            continue;
+        }
+
+        // Convert pixel location to pointcloud point in meters
+        float x_pos = (pixel_x - origin_pixel_x) * resolution_meters;
+        // Y is inverted to match output from getPosition()
+        float y_pos = -(pixel_y - origin_pixel_y) * resolution_meters;
+        float z_pos = 0;  // Z is 0 in 2D SLAM
+
+        // Add point to buffer
+        util::write_point(*buffer, format, x_pos, y_pos, z_pos, prob);
+    }
+    return num_points;
+}
+
+// write_occupancy_grid_row appends a cell for each pixel of the given row of
+// the painted map to the buffer, holding the probability that the cell is
+// occupied or VIAM_CARTO_OCCUPANCY_GRID_UNKNOWN_CELL for empty pixels.
+void write_occupancy_grid_row(const PaintedMap &painted_map, int pixel_y,
+                              std::string &buffer) {
+    for (int pixel_x = painted_map.min_pixel_x;
+         pixel_x < painted_map.max_pixel_x; pixel_x++) {
+        ColorARGB pixel_color =
+            painted_pixel_color(painted_map.slices, pixel_x, pixel_y);
+        if (check_if_empty_pixel(pixel_color)) {
+            buffer.push_back(static_cast<char>(
+                static_cast<unsigned char>(
+                    VIAM_CARTO_OCCUPANCY_GRID_UNKNOWN_CELL)));
+            continue;
+        }
+        buffer.push_back(static_cast<char>(
+            calculate_probability_from_color_channels(pixel_color)));
+    }
+}
+
+// count_painted_map_points returns the number of points of the pcd of the
+// painted map.
+int64_t count_painted_map_points(const PaintedMap &painted_map) {
+    int64_t num_points = 0;
+    for (int pixel_y = painted_map.min_pixel_y;
+         pixel_y < painted_map.max_pixel_y; pixel_y++) {
+        num_points += write_painted_map_row(
+            painted_map, pixel_y, util::PointCloudFormat::PCD, nullptr);
+    }
+    return num_points;
+}
+
+// count_painted_map_cells returns the number of cells of the occupancy grid
+// of the painted map.
+int64_t count_painted_map_cells(const PaintedMap &painted_map) {
+    int64_t width = painted_map.max_pixel_x - painted_map.min_pixel_x;
+    return width * (painted_map.max_pixel_y - painted_map.min_pixel_y);
+}
+
+// OccupancyGrid holds the cells within the window of a painted map, along
+// with the position of the lower left corner of the bottom left cell in
+// meters.
+struct OccupancyGrid {
+    int width;
+    int height;
+    double resolution_meters;
+    double origin_x;
+    double origin_y;
+    std::string cells;
+};
+
+// to_occupancy_grid returns the occupancy grid of the pixels within the
+// window of the painted map.
+OccupancyGrid to_occupancy_grid(const PaintedMap &painted_map) {
+    OccupancyGrid grid;
+    grid.width = painted_map.max_pixel_x - painted_map.min_pixel_x;
+    grid.height = painted_map.max_pixel_y - painted_map.min_pixel_y;
+    grid.resolution_meters = painted_map.resolution_meters;
+    grid.cells.reserve(grid.width * grid.height);
+    for (int pixel_y = painted_map.min_pixel_y;
+         pixel_y < painted_map.max_pixel_y; pixel_y++) {
+        write_occupancy_grid_row(painted_map, pixel_y, grid.cells);
+    }
+
+    // Points of the pointcloud map are written at the center of their pixel,
+    // so the lower left corner of the grid is half a cell beyond the center
+    // of the bottom left pixel. Y is inverted to match output from
+    // getPosition().
+    float origin_pixel_x = painted_map.slices.origin.x();
+    float origin_pixel_y = painted_map.slices.origin.y();
+    grid.origin_x = (painted_map.min_pixel_x - origin_pixel_x - 0.5) *
+                    grid.resolution_meters;
+    grid.origin_y = (origin_pixel_y - painted_map.max_pixel_y + 0.5) *
+                    grid.resolution_meters;
+    return grid;
+}
+
+// to_painted_map returns the map painted at resolution_meters, limited to the
+// pixels whose centers lie within the region of the map options if it has
+// one.
+PaintedMap to_painted_map(
+    cartographer::io::PaintSubmapSlicesResult painted_slices,
+    double resolution_meters, const viam_carto_map_options &options) {
+    int width = cairo_image_surface_get_width(painted_slices.surface.get());
+    int height = painted_map_height(painted_slices);
+    PaintedMap painted_map{std::move(painted_slices), resolution_meters, 0, 0,
+                           width, height};
+    if (!options.has_region) {
+        return painted_map;
+    }
+    // Points are written at (pixel - origin) * resolution with y inverted,
+    // see write_painted_map_row, & the region is in millimeters.
+    double origin_pixel_x = painted_map.slices.origin.x();
+    double origin_pixel_y = painted_map.slices.origin.y();
+    double scale = 1000 * resolution_meters;
+    // clamping before the conversion keeps huge regions from overflowing
+    auto to_pixel = [](double pixel, int min_pixel, int max_pixel) {
+        return static_cast<int>(std::clamp(pixel,
+                                           static_cast<double>(min_pixel),
+                                           static_cast<double>(max_pixel)));
+    };
+    painted_map.min_pixel_x = to_pixel(
+        std::ceil(origin_pixel_x + options.region.min_x / scale), 0, width);
+    painted_map.max_pixel_x =
+        to_pixel(std::floor(origin_pixel_x + options.region.max_x / scale) + 1,
+                 painted_map.min_pixel_x, width);
+    painted_map.min_pixel_y = to_pixel(
+        std::ceil(origin_pixel_y - options.region.max_y / scale), 0, height);
+    painted_map.max_pixel_y =
+        to_pixel(std::floor(origin_pixel_y - options.region.min_y / scale) + 1,
+                 painted_map.min_pixel_y, height);
+    return painted_map;
+}
+
+// regions_intersect returns whether the regions share any point.
+bool regions_intersect(const viam_carto_map_region &a,
+                       const viam_carto_map_region &b) {
+    return a.min_x <= b.max_x && b.min_x <= a.max_x && a.min_y <= b.max_y &&
+           b.min_y <= a.max_y;
+}
+
+// submap_bounding_box returns the bounding box of the cropped grid of the
+// submap in millimeters, in the frame of the map. It is the global position
+// of the submap if it has no grid.
+viam_carto_map_region submap_bounding_box(
+    const cartographer::mapping::PoseGraphInterface::SubmapData &data) {
+    const auto &submap = data.submap;
+    const auto &global_pose = data.pose;
+    viam_carto_map_region box;
+    box.min_x = global_pose.translation().x() * 1000;
+    box.min_y = global_pose.translation().y() * 1000;
+    box.max_x = box.min_x;
+    box.max_y = box.min_y;
+
+    auto submap_2d =
+        dynamic_cast<const cartographer::mapping::Submap2D *>(submap.get());
+    if (submap_2d == nullptr || submap_2d->grid() == nullptr) {
+        return box;
+    }
+    const auto &limits = submap_2d->grid()->limits();
+    Eigen::Array2i offset;
+    cartographer::mapping::CellLimits cell_limits;
+    submap_2d->grid()->ComputeCroppedLimits(&offset, &cell_limits);
+    // cell (i, j) of the grid is at max - resolution * (j, i) in the local
+    // frame of the submap
+    double max_x = limits.max().x() - offset.y() * limits.resolution();
+    double max_y = limits.max().y() - offset.x() * limits.resolution();
+    double min_x = max_x - cell_limits.num_y_cells * limits.resolution();
+    double min_y = max_y - cell_limits.num_x_cells * limits.resolution();
+    // the grid is in the local frame, so the corners are moved into the
+    // frame of the map by the optimized submap pose
+    auto local_to_global = global_pose * submap->local_pose().inverse();
+    bool first = true;
+    for (const auto &corner : {Eigen::Vector3d(min_x, min_y, 0.),
+                               Eigen::Vector3d(min_x, max_y, 0.),
+                               Eigen::Vector3d(max_x, min_y, 0.),
+                               Eigen::Vector3d(max_x, max_y, 0.)}) {
+        Eigen::Vector3d global_corner = (local_to_global * corner) * 1000;
+        if (first || global_corner.x() < box.min_x) {
+            box.min_x = global_corner.x();
+        }
+        if (first || global_corner.y() < box.min_y) {
+            box.min_y = global_corner.y();
+        }
+        if (first || global_corner.x() > box.max_x) {
+            box.max_x = global_corner.x();
+        }
+        if (first || global_corner.y() > box.max_y) {
+            box.max_y = global_corner.y();
+        }
+        first = false;
+    }
+    return box;
+}
+
 std::ostream &operator<<(std::ostream &os,
                          const viam::carto_facade::SlamMode &slam_mode) {
     std::string slam_mode_str;
@@ -118,6 +385,7 @@ config from_viam_carto_config(viam_carto_config vcc) {
     c.cloud_story_enabled = vcc.cloud_story_enabled;
     c.enable_mapping = vcc.enable_mapping;
     c.existing_map = to_std_string(vcc.existing_map);
+    c.initial_internal_state = to_std_string(vcc.initial_internal_state);
     c.lidar_config = vcc.lidar_config;
 
     if (!c.cloud_story_enabled) {
@@ -133,11 +401,24 @@ config from_viam_carto_config(viam_carto_config vcc) {
         throw VIAM_CARTO_COMPONENT_REFERENCE_INVALID;
     }
     validate_lidar_config(c.lidar_config);
+    if (vcc.has_initial_pose) {
+        c.initial_pose = from_viam_carto_pose(vcc.initial_pose);
+    }
     c.component_reference = bstrcpy(vcc.camera);
 
     return c;
 };
 
+cartographer::transform::Rigid3d from_viam_carto_pose(viam_carto_pose pose) {
+    if (!std::isfinite(pose.x) || !std::isfinite(pose.y) ||
+        !std::isfinite(pose.theta)) {
+        throw VIAM_CARTO_POSE_INVALID;
+    }
+    return cartographer::transform::Embed3D(cartographer::transform::Rigid2d(
+        {pose.x / 1000, pose.y / 1000},
+        cartographer::common::DegToRad(pose.theta)));
+};
+
 std::string find_lua_files() {
     auto programLocation = boost::dll::program_location();
     auto localRelativePathToLuas = programLocation.parent_path().parent_path();
@@ -191,9 +472,14 @@ CartoFacade::CartoFacade(viam_carto_lib *pVCL, const viam_carto_config c,
     algo_config = ac;
     path_to_internal_state = config.data_dir + "/internal_state";
     path_to_internal_state_file = config.existing_map;
+    // throws if the configured map options are invalid
+    ResolveMapOptions(nullptr);
 };
 
-CartoFacade::~CartoFacade() { bdestroy(config.component_reference); }
+CartoFacade::~CartoFacade() {
+    JoinOptimization();
+    bdestroy(config.component_reference);
+}
 
 void setup_filesystem(std::string data_dir,
                       std::string path_to_internal_state) {
@@ -263,7 +549,15 @@ void CartoFacade::IOInit() {
                    << CartoFacadeState::INITIALIZED;
         throw VIAM_CARTO_NOT_IN_INITIALIZED_STATE;
     }
-    if (config.cloud_story_enabled == true) {
+    if (!config.initial_internal_state.empty()) {
+        // Continuing a mapping session doesn't depend on the apriori maps
+        if (!config.cloud_story_enabled) {
+            setup_filesystem(config.data_dir, path_to_internal_state);
+        }
+        LOG(INFO) << "Running in mapping mode from "
+                  << config.initial_internal_state;
+        slam_mode = viam::carto_facade::SlamMode::MAPPING;
+    } else if (config.cloud_story_enabled == true) {
         slam_mode = determine_slam_mode_cloud_story_enabled(
             path_to_internal_state_file, config.enable_mapping);
     } else {
@@ -289,11 +583,11 @@ void CartoFacade::IOInit() {
     }
     configuration_directory = cd;
     // Detect slam mode
-    auto config_basename = slam_mode_lua_config_filename(slam_mode);
+    configuration_basename = slam_mode_lua_config_filename(slam_mode);
     // Setup MapBuilder
     {
         std::lock_guard<std::mutex> lk(map_builder_mutex);
-        map_builder.SetUp(configuration_directory, config_basename);
+        map_builder.SetUp(configuration_directory, configuration_basename);
         VLOG(1) << "overwriting map_builder config";
         map_builder.OverwriteOptimizeEveryNNodes(
             algo_config.optimize_every_n_nodes);
@@ -364,9 +658,40 @@ void CartoFacade::IOInit() {
         CacheMapInLocalizationMode();
     }
 
+    if (!config.initial_internal_state.empty()) {
+        // The trajectories are loaded unfrozen so that the submaps keep
+        // being optimized, as they would have been without the restart
+        std::lock_guard<std::mutex> lk(map_builder_mutex);
+        map_builder.LoadMapFromFile(config.initial_internal_state, false,
+                                    algo_config.optimize_on_start);
+    }
+
+    std::optional<cartographer::mapping::proto::InitialTrajectoryPose>
+        initial_trajectory_pose;
+    if (slam_mode == viam::carto_facade::SlamMode::LOCALIZING &&
+        config.initial_pose.has_value()) {
+        initial_trajectory_pose.emplace();
+        std::lock_guard<std::mutex> lk(map_builder_mutex);
+        if (!map_builder.GetInitialTrajectoryPose(
+                config.initial_pose.value(),
+                &initial_trajectory_pose.value())) {
+            LOG(ERROR) << "Unable to start at the initial pose, the map has "
+                          "no frozen trajectory nodes";
+            throw VIAM_CARTO_FROZEN_MAP_EMPTY;
+        }
+    } else if (config.initial_pose.has_value()) {
+        LOG(WARNING) << "Ignoring the initial pose, which is only used in "
+                     << viam::carto_facade::SlamMode::LOCALIZING
+                     << " mode, current mode: " << slam_mode;
+    }
+
     {
         std::lock_guard<std::mutex> lk(map_builder_mutex);
-        map_builder.StartLidarTrajectoryBuilder();
+        map_builder.StartLidarTrajectoryBuilder(initial_trajectory_pose);
+    }
+    if (initial_trajectory_pose.has_value()) {
+        std::lock_guard<std::mutex> lk(viam_response_mutex);
+        latest_global_pose = config.initial_pose.value();
     }
     state = CartoFacadeState::IO_INITIALIZED;
 };
@@ -415,12 +740,23 @@ void CartoFacade::CacheMapInLocalizationMode() {
     }
 }
 
-cartographer::io::PaintSubmapSlicesResult
-CartoFacade::GetLatestPaintedMapSlices() {
-    VLOG(1) << "GetLatestPaintedMapSlices()";
-    cartographer::mapping::MapById<
-        cartographer::mapping::SubmapId,
-        cartographer::mapping::PoseGraphInterface::SubmapPose>
+std::map<cartographer::mapping::SubmapId, cartographer::io::SubmapSlice>
+CartoFacade::GetLatestSubmapSlices(const viam_carto_map_options &options) {
+    VLOG(1) << "GetLatestSubmapSlices()";
+    // Submaps outside of the region are neither serialized nor painted, so
+    // the work is bounded by the size of the region.
+    return GetSubmapSlices(
+        [&options](
+            const cartographer::mapping::SubmapId &,
+            const cartographer::mapping::PoseGraphInterface::SubmapData &data) {
+            return !options.has_region ||
+                   regions_intersect(submap_bounding_box(data), options.region);
+        });
+}
+
+std::map<cartographer::mapping::SubmapId, cartographer::io::SubmapSlice>
+CartoFacade::GetSubmapSlices(const SubmapFilter &include) {
+    std::map<cartographer::mapping::SubmapId, cartographer::transform::Rigid3d>
         submap_poses;
     std::map<cartographer::mapping::SubmapId,
              cartographer::mapping::proto::SubmapQuery::Response>
@@ -428,14 +764,16 @@ CartoFacade::GetLatestPaintedMapSlices() {
 
     {
         std::lock_guard<std::mutex> lk(map_builder_mutex);
-        submap_poses =
-            map_builder.map_builder_->pose_graph()->GetAllSubmapPoses();
-
-        for (const auto &&submap_id_pose : submap_poses) {
+        for (const auto &submap_id_data :
+             map_builder.map_builder_->pose_graph()->GetAllSubmapData()) {
+            if (!include(submap_id_data.id, submap_id_data.data)) {
+                continue;
+            }
+            submap_poses[submap_id_data.id] = submap_id_data.data.pose;
             cartographer::mapping::proto::SubmapQuery::Response
-                &response_proto = response_protos[submap_id_pose.id];
+                &response_proto = response_protos[submap_id_data.id];
             const std::string error = map_builder.map_builder_->SubmapToProto(
-                submap_id_pose.id, &response_proto);
+                submap_id_data.id, &response_proto);
             if (error != "") {
                 throw std::runtime_error(error);
             }
@@ -449,13 +787,13 @@ CartoFacade::GetLatestPaintedMapSlices() {
         throw std::runtime_error(viam::carto_facade::errorNoSubmaps);
     }
 
-    for (const auto &&submap_id_pose : submap_poses) {
+    for (const auto &submap_id_pose : submap_poses) {
         auto submap_textures =
             absl::make_unique<::cartographer::io::SubmapTextures>();
         submap_textures->version =
-            response_protos[submap_id_pose.id].submap_version();
+            response_protos[submap_id_pose.first].submap_version();
         for (const auto &texture_proto :
-             response_protos[submap_id_pose.id].textures()) {
+             response_protos[submap_id_pose.first].textures()) {
             const std::string compressed_cells(texture_proto.cells().begin(),
                                                texture_proto.cells().end());
             submap_textures->textures.emplace_back(
@@ -471,9 +809,10 @@ CartoFacade::GetLatestPaintedMapSlices() {
 
         // Prepares SubmapSlice
         ::cartographer::io::SubmapSlice &submap_slice =
-            submap_slices[submap_id_pose.id];
+            submap_slices[submap_id_pose.first];
         const auto fetched_texture = submap_textures->textures.begin();
-        submap_slice.pose = submap_id_pose.data.pose;
+        submap_slice.pose = submap_id_pose.second;
+        submap_slice.version = submap_textures->version;
         submap_slice.width = fetched_texture->width;
         submap_slice.height = fetched_texture->height;
         submap_slice.slice_pose = fetched_texture->slice_pose;
@@ -485,20 +824,112 @@ CartoFacade::GetLatestPaintedMapSlices() {
             fetched_texture->width, fetched_texture->height,
             &submap_slice.cairo_data);
     }
-    cartographer::io::PaintSubmapSlicesResult painted_slices =
-        cartographer::io::PaintSubmapSlices(submap_slices, resolutionMeters);
+    return submap_slices;
+}
+
+viam_carto_map_options CartoFacade::ResolveMapOptions(
+    const viam_carto_map_options *options) {
+    viam_carto_map_options resolved;
+    resolved.resolution_meters = algo_config.map_resolution_meters;
+    resolved.max_points = algo_config.max_map_points;
+    resolved.has_region = options != nullptr && options->has_region;
+    if (resolved.has_region) {
+        resolved.region = options->region;
+    }
+    if (options != nullptr && options->resolution_meters != 0) {
+        resolved.resolution_meters = options->resolution_meters;
+    }
+    if (options != nullptr && options->max_points != 0) {
+        resolved.max_points = options->max_points;
+    }
+    resolved.format = options != nullptr ? options->format
+                                         : VIAM_CARTO_POINT_CLOUD_FORMAT_PCD;
+    if (resolved.resolution_meters == 0) {
+        resolved.resolution_meters = VIAM_CARTO_DEFAULT_MAP_RESOLUTION_METERS;
+    }
+    if (!(resolved.resolution_meters >=
+          VIAM_CARTO_MIN_MAP_RESOLUTION_METERS) ||
+        resolved.max_points < 0) {
+        LOG(ERROR) << "invalid map options, resolution_meters: "
+                   << resolved.resolution_meters
+                   << " max_points: " << resolved.max_points;
+        throw VIAM_CARTO_MAP_OPTIONS_INVALID;
+    }
+    if (resolved.has_region &&
+        !(resolved.region.min_x < resolved.region.max_x &&
+          resolved.region.min_y < resolved.region.max_y)) {
+        LOG(ERROR) << "invalid map options, region must satisfy min_x < "
+                      "max_x & min_y < max_y";
+        throw VIAM_CARTO_MAP_OPTIONS_INVALID;
+    }
+    if (resolved.format < VIAM_CARTO_POINT_CLOUD_FORMAT_PCD ||
+        resolved.format > VIAM_CARTO_POINT_CLOUD_FORMAT_PLY) {
+        LOG(ERROR) << "invalid map options, unknown format: "
+                   << resolved.format;
+        throw VIAM_CARTO_MAP_OPTIONS_INVALID;
+    }
+    return resolved;
+}
+
+util::PointCloudFormat to_point_cloud_format(int format) {
+    switch (format) {
+        case VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_BINARY:
+            return util::PointCloudFormat::PCD_BINARY;
+        case VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_BINARY_COMPRESSED:
+            return util::PointCloudFormat::PCD_BINARY_COMPRESSED;
+        case VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_ASCII:
+            return util::PointCloudFormat::PCD_ASCII;
+        case VIAM_CARTO_POINT_CLOUD_FORMAT_PLY:
+            return util::PointCloudFormat::PLY;
+        default:
+            return util::PointCloudFormat::PCD;
+    }
+}
 
-    return painted_slices;
+PaintedMap CartoFacade::PaintMap(viam_carto_map_options options,
+                                 bool count_cells) {
+    VLOG(1) << "PaintMap()";
+    // The submaps are only fetched once, as they don't depend on the
+    // resolution the map is painted at.
+    auto submap_slices = GetLatestSubmapSlices(options);
+    double resolution_meters = options.resolution_meters;
+    for (int coarsenings = 0;; coarsenings++) {
+        PaintedMap painted_map = to_painted_map(
+            cartographer::io::PaintSubmapSlices(submap_slices,
+                                                resolution_meters),
+            resolution_meters, options);
+        if (options.max_points == 0) {
+            return painted_map;
+        }
+        int64_t points = count_cells ? count_painted_map_cells(painted_map)
+                                     : count_painted_map_points(painted_map);
+        if (points <= options.max_points) {
+            return painted_map;
+        }
+        if (coarsenings == maxMapCoarsenings) {
+            LOG(WARNING) << "map has " << points
+                         << " points at a resolution of " << resolution_meters
+                         << " meters, which is more than max_points "
+                         << options.max_points;
+            return painted_map;
+        }
+        // The number of cells shrinks with the square of the resolution &
+        // the number of points at least linearly, so the square root never
+        // coarsens the map more than needed.
+        resolution_meters *= std::max(
+            1.1, std::sqrt(static_cast<double>(points) / options.max_points));
+        VLOG(1) << "map has " << points << " points, more than max_points "
+                << options.max_points << ", repainting it at a resolution of "
+                << resolution_meters << " meters";
+    }
 }
 
 void CartoFacade::GetLatestSampledPointCloudMapString(std::string &pointcloud) {
     VLOG(1) << "GetLatestSampledPointCloudMapString()";
-    std::unique_ptr<cartographer::io::PaintSubmapSlicesResult> painted_slices =
-        nullptr;
+    std::unique_ptr<PaintedMap> painted_map = nullptr;
     try {
-        painted_slices =
-            std::make_unique<cartographer::io::PaintSubmapSlicesResult>(
-                GetLatestPaintedMapSlices());
+        painted_map = std::make_unique<PaintedMap>(
+            PaintMap(ResolveMapOptions(nullptr), false));
     } catch (std::exception &e) {
         if (e.what() == viam::carto_facade::errorNoSubmaps) {
             LOG(INFO) << "Error creating pcd map: " << e.what();
@@ -511,70 +942,13 @@ void CartoFacade::GetLatestSampledPointCloudMapString(std::string &pointcloud) {
         }
     }
 
-    // Get data from painted surface in ARGB32 format
-    auto painted_surface = painted_slices->surface.get();
-    auto image_format = cairo_image_surface_get_format(painted_surface);
-    if (image_format != cartographer::io::kCairoFormat) {
-        std::string error_log =
-            "Error cairo surface in wrong format, expected Cairo_Format_ARGB32";
-        LOG(ERROR) << error_log;
-        throw std::runtime_error(error_log);
-    }
-    int width = cairo_image_surface_get_width(painted_surface);
-    int height = cairo_image_surface_get_height(painted_surface);
-    auto image_data_ptr = cairo_image_surface_get_data(painted_surface);
-
-    // Get pixel containing map origin (0, 0)
-    float origin_pixel_x = painted_slices->origin.x();
-    float origin_pixel_y = painted_slices->origin.y();
-
     // Iterate over image data and add to pointcloud buffer
     int num_points = 0;
     std::string pcd_data;
-    for (int pixel_y = 0; pixel_y < height; pixel_y++) {
-        for (int pixel_x = 0; pixel_x < width; pixel_x++) {
-            // Get byte index associated with pixel
-            int pixel_index = pixel_x + pixel_y * width;
-            int byte_index = pixel_index * bytesPerPixel;
-
-            // We assume we are running on a little-endian system, so the ARGB
-            // order is reversed
-            ColorARGB pixel_color;
-            pixel_color.A = image_data_ptr[byte_index + 3];
-            pixel_color.R = image_data_ptr[byte_index + 2];
-            pixel_color.G = image_data_ptr[byte_index + 1];
-            pixel_color.B = image_data_ptr[byte_index + 0];
-
-            // Skip pixel if it contains empty data (default color)
-            if (check_if_empty_pixel(pixel_color)) {
-                continue;
-            }
-
-            // Determine probability based on the color of the pixel and skip if
-            // it is 0
-            int prob = calculate_probability_from_color_channels(pixel_color);
-            if (prob == 0) {
-                continue;
-            }
-
-            // Convert pixel location to pointcloud point in meters
-            float x_pos = (pixel_x - origin_pixel_x) * resolutionMeters;
-            // Y is inverted to match output from getPosition()
-            float y_pos = -(pixel_y - origin_pixel_y) * resolutionMeters;
-            float z_pos = 0;  // Z is 0 in 2D SLAM
-
-            // Add point to buffer
-            viam::carto_facade::util::write_float_to_buffer_in_bytes(pcd_data,
-                                                                     x_pos);
-            viam::carto_facade::util::write_float_to_buffer_in_bytes(pcd_data,
-                                                                     y_pos);
-            viam::carto_facade::util::write_float_to_buffer_in_bytes(pcd_data,
-                                                                     z_pos);
-            viam::carto_facade::util::write_int_to_buffer_in_bytes(pcd_data,
-                                                                   prob);
-
-            num_points++;
-        }
+    for (int pixel_y = painted_map->min_pixel_y;
+         pixel_y < painted_map->max_pixel_y; pixel_y++) {
+        num_points += write_painted_map_row(
+            *painted_map, pixel_y, util::PointCloudFormat::PCD, &pcd_data);
     }
 
     // Write our PCD file, which is written as a binary.
@@ -585,6 +959,40 @@ void CartoFacade::GetLatestSampledPointCloudMapString(std::string &pointcloud) {
     return;
 }
 
+PaintedMapStream::PaintedMapStream(PaintedMap painted_map,
+                                   util::PointCloudFormat format)
+    : painted_map(std::move(painted_map)), format(format) {
+    next_row = this->painted_map.min_pixel_y;
+    // The header needs the number of points, so the points are counted up
+    // front, one row at a time.
+    int num_points = count_painted_map_points(this->painted_map);
+    pending = util::point_cloud_header(format, num_points);
+    if (format == util::PointCloudFormat::PCD_BINARY_COMPRESSED) {
+        // The points are compressed as a whole, so they are all written up
+        // front.
+        std::string points;
+        for (; next_row < this->painted_map.max_pixel_y; next_row++) {
+            write_painted_map_row(this->painted_map, next_row,
+                                  util::PointCloudFormat::PCD_BINARY, &points);
+        }
+        pending += util::pcd_binary_compressed_data(points, num_points);
+    }
+}
+
+bool PaintedMapStream::Next(size_t max_bytes, std::string &chunk) {
+    while (pending.size() < max_bytes && next_row < painted_map.max_pixel_y) {
+        write_painted_map_row(painted_map, next_row, format, &pending);
+        next_row++;
+    }
+    if (pending.empty()) {
+        return false;
+    }
+    size_t n = std::min(max_bytes, pending.size());
+    chunk.append(pending, 0, n);
+    pending.erase(0, n);
+    return true;
+}
+
 void CartoFacade::GetPosition(viam_carto_get_position_response *r) {
     if (state != CartoFacadeState::STARTED) {
         LOG(ERROR) << "carto facade is in state: " << state << " expected "
@@ -692,6 +1100,266 @@ void CartoFacade::GetInternalState(viam_carto_get_internal_state_response *r) {
     r->internal_state = to_bstring(internal_state);
 };
 
+std::unique_ptr<stream::Stream> CartoFacade::GetPointCloudMapStream(
+    const viam_carto_map_options *options) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    viam_carto_map_options resolved = ResolveMapOptions(options);
+    // The cached map is painted as configured, so the map is painted even in
+    // localization mode if the map options are overwritten.
+    bool overwritten = options != nullptr &&
+                       (options->resolution_meters != 0 ||
+                        options->max_points != 0 || options->has_region);
+    std::shared_lock optimization_lock{optimization_shared_mutex,
+                                       std::defer_lock};
+    if ((slam_mode != viam::carto_facade::SlamMode::LOCALIZING ||
+         overwritten) &&
+        optimization_lock.try_lock()) {
+        // We are able to lock the optimization_shared_mutex, which means
+        // that the optimization is not ongoing and we can paint the newest
+        // map. The painted map is a copy, so the lock is only needed while
+        // painting it.
+        std::unique_ptr<PaintedMap> painted_map = nullptr;
+        try {
+            painted_map =
+                std::make_unique<PaintedMap>(PaintMap(resolved, false));
+        } catch (std::exception &e) {
+            if (e.what() == viam::carto_facade::errorNoSubmaps) {
+                LOG(ERROR) << "map pointcloud does not have points yet";
+                throw VIAM_CARTO_POINTCLOUD_MAP_EMPTY;
+            }
+            std::string errorLog = "Error writing submap to proto: ";
+            errorLog += e.what();
+            LOG(ERROR) << errorLog;
+            throw std::runtime_error(errorLog);
+        }
+        optimization_lock.unlock();
+        return std::make_unique<PaintedMapStream>(
+            std::move(*painted_map), to_point_cloud_format(resolved.format));
+    }
+
+    // Either we are in localization mode or we couldn't lock the mutex
+    // which means the optimization process locked it and we need to use
+    // the backed up latest map
+    if (slam_mode == viam::carto_facade::SlamMode::LOCALIZING) {
+        LOG(INFO) << "In localization mode, using cached pointcloud map";
+    } else {
+        LOG(INFO) << "Optimization is occuring, using cached pointcloud map";
+    }
+    std::string pointcloud_map;
+    {
+        std::lock_guard<std::mutex> lk(viam_response_mutex);
+        pointcloud_map = latest_pointcloud_map;
+    }
+    if (pointcloud_map.empty()) {
+        LOG(ERROR) << "map pointcloud does not have points yet";
+        throw VIAM_CARTO_POINTCLOUD_MAP_EMPTY;
+    }
+    // The cached map is written as a pcd, so it is converted to the requested
+    // format.
+    return std::make_unique<stream::StringStream>(util::convert_pcd(
+        pointcloud_map, to_point_cloud_format(resolved.format)));
+};
+
+std::unique_ptr<stream::Stream> CartoFacade::GetInternalStateStream() {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    boost::uuids::uuid uuid = boost::uuids::random_generator()();
+
+    std::string filename;
+    if (config.cloud_story_enabled) {
+        filename = "temp_internal_state_" + boost::uuids::to_string(uuid) +
+                   ".pbstream";
+    } else {
+        filename = path_to_internal_state + "/" + "temp_internal_state_" +
+                   boost::uuids::to_string(uuid) + ".pbstream";
+    }
+
+    {
+        std::lock_guard<std::mutex> lk(map_builder_mutex);
+        bool ok = map_builder.SaveMapToFile(true, filename);
+        if (!ok) {
+            LOG(ERROR) << "Failed to save the internal state as a pbstream.";
+            throw VIAM_CARTO_GET_INTERNAL_STATE_FILE_WRITE_IO_ERROR;
+        }
+    }
+
+    try {
+        return std::make_unique<stream::FileStream>(filename);
+    } catch (std::exception &e) {
+        LOG(ERROR) << "Failed to open and/or delete internal state file: "
+                   << e.what();
+        throw VIAM_CARTO_GET_INTERNAL_STATE_FILE_READ_IO_ERROR;
+    }
+};
+
+void CartoFacade::GetStats(viam_carto_get_stats_response *r) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    int submaps_finished = 0;
+    int submaps_active = 0;
+    int64_t grid_bytes = 0;
+    std::lock_guard<std::mutex> lk(map_builder_mutex);
+    auto pose_graph = map_builder.map_builder_->pose_graph();
+    for (const auto &submap_id_data : pose_graph->GetAllSubmapData()) {
+        const auto &submap = submap_id_data.data.submap;
+        if (submap->insertion_finished()) {
+            submaps_finished++;
+        } else {
+            submaps_active++;
+        }
+        auto submap_2d =
+            dynamic_cast<const cartographer::mapping::Submap2D *>(submap.get());
+        if (submap_2d != nullptr && submap_2d->grid() != nullptr) {
+            // Grids store one uint16 correspondence cost per cell
+            const auto &cell_limits = submap_2d->grid()->limits().cell_limits();
+            grid_bytes += static_cast<int64_t>(cell_limits.num_x_cells) *
+                          cell_limits.num_y_cells * sizeof(uint16_t);
+        }
+    }
+    r->trajectories = pose_graph->GetTrajectoryStates().size();
+    r->submaps_finished = submaps_finished;
+    r->submaps_active = submaps_active;
+    r->nodes = pose_graph->GetTrajectoryNodePoses().size();
+    r->constraints = pose_graph->constraints().size();
+    r->grid_bytes = grid_bytes;
+    r->last_optimization_time_unix_milli =
+        map_builder.GetLastOptimizationTimeUnixMilli();
+};
+
+void CartoFacade::SaveMap(std::string path, bool run_final_optimization,
+                          viam_carto_save_map_response *r) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    if (path.empty()) {
+        throw VIAM_CARTO_SAVE_MAP_PATH_INVALID;
+    }
+
+    std::unique_lock optimization_lock{optimization_shared_mutex,
+                                       std::defer_lock};
+    if (run_final_optimization) {
+        // Painting the map is skipped while the optimization runs
+        optimization_lock.lock();
+    }
+    std::lock_guard<std::mutex> lk(map_builder_mutex);
+    if (run_final_optimization) {
+        VLOG(1) << "running final optimization before saving the map";
+        map_builder.map_builder_->pose_graph()->RunFinalOptimization();
+    }
+    bool ok = map_builder.SaveMapToFile(true, path);
+    if (!ok) {
+        LOG(ERROR) << "Failed to save the map as a pbstream to: " << path;
+        throw VIAM_CARTO_SAVE_MAP_FILE_WRITE_IO_ERROR;
+    }
+    r->nodes = map_builder.map_builder_->pose_graph()
+                   ->GetTrajectoryNodePoses()
+                   .size();
+};
+
+void CartoFacade::SetPose(const viam_carto_pose *pose) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    if (slam_mode != viam::carto_facade::SlamMode::LOCALIZING) {
+        LOG(ERROR) << "setting the pose is only supported in "
+                   << viam::carto_facade::SlamMode::LOCALIZING
+                   << " mode, current mode: " << slam_mode;
+        throw VIAM_CARTO_NOT_IN_LOCALIZING_MODE;
+    }
+    cartographer::transform::Rigid3d global_pose = from_viam_carto_pose(*pose);
+
+    {
+        std::lock_guard<std::mutex> lk(map_builder_mutex);
+        cartographer::mapping::proto::InitialTrajectoryPose
+            initial_trajectory_pose;
+        if (!map_builder.GetInitialTrajectoryPose(global_pose,
+                                                  &initial_trajectory_pose)) {
+            LOG(ERROR) << "Unable to set the pose, the map has no frozen "
+                          "trajectory nodes";
+            throw VIAM_CARTO_FROZEN_MAP_EMPTY;
+        }
+        map_builder.FinishLidarTrajectory();
+        map_builder.StartLidarTrajectoryBuilder(initial_trajectory_pose);
+    }
+    std::lock_guard<std::mutex> lk(viam_response_mutex);
+    latest_global_pose = global_pose;
+};
+
+void CartoFacade::SwitchToLocalization() {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    if (slam_mode == viam::carto_facade::SlamMode::LOCALIZING) {
+        LOG(ERROR) << "already in " << slam_mode << " mode";
+        throw VIAM_CARTO_ALREADY_IN_LOCALIZING_MODE;
+    }
+    {
+        std::lock_guard<std::mutex> lk(optimization_status_mutex);
+        if (optimization_status.running) {
+            throw VIAM_CARTO_OPTIMIZATION_IN_PROGRESS;
+        }
+    }
+    cartographer::transform::Rigid3d global_pose;
+    {
+        std::lock_guard<std::mutex> lk(viam_response_mutex);
+        global_pose = latest_global_pose;
+    }
+
+    {
+        std::unique_lock optimization_lock{optimization_shared_mutex};
+        std::lock_guard<std::mutex> lk(map_builder_mutex);
+        // Check before finishing the trajectory, so that mapping can continue
+        // if there is nothing to localize against
+        auto node_poses =
+            map_builder.map_builder_->pose_graph()->GetTrajectoryNodePoses();
+        if (node_poses.SizeOfTrajectoryOrZero(map_builder.trajectory_id) == 0) {
+            LOG(ERROR) << "Unable to switch to "
+                       << viam::carto_facade::SlamMode::LOCALIZING
+                       << " mode, the map has no trajectory nodes";
+            throw VIAM_CARTO_FROZEN_MAP_EMPTY;
+        }
+        // Finishing & freezing are applied by the pose graph in the
+        // background. The map is not optimized here, callers optimize it with
+        // viam_carto_optimize before switching, without blocking the
+        // cartofacade for the whole optimization.
+        map_builder.FinishLidarTrajectory();
+        map_builder.FreezeLidarTrajectory();
+
+        map_builder.OverwriteMaxSubmapsToKeep(algo_config.max_submaps_to_keep);
+        cartographer::mapping::proto::InitialTrajectoryPose
+            initial_trajectory_pose;
+        if (map_builder.GetInitialTrajectoryPose(global_pose,
+                                                 &initial_trajectory_pose)) {
+            map_builder.StartLidarTrajectoryBuilder(initial_trajectory_pose);
+        } else {
+            // Unreachable as the frozen trajectory has nodes, but localizing
+            // without an initial pose is still better than not localizing
+            LOG(WARNING) << "Unable to start localizing at the current pose";
+            map_builder.StartLidarTrajectoryBuilder();
+        }
+    }
+    LOG(INFO) << "Switched from " << slam_mode << " to "
+              << viam::carto_facade::SlamMode::LOCALIZING << " mode";
+    slam_mode = viam::carto_facade::SlamMode::LOCALIZING;
+    CacheMapInLocalizationMode();
+};
+
 void CartoFacade::Start() {
     if (state != CartoFacadeState::IO_INITIALIZED) {
         LOG(ERROR) << "carto facade is in state: " << state << " expected "
@@ -768,37 +1436,400 @@ void CartoFacade::Stop() {
         throw VIAM_CARTO_NOT_IN_STARTED_STATE;
     }
     state = CartoFacadeState::IO_INITIALIZED;
+    // Wait for a running optimization, so that the final internal state is
+    // optimized
+    JoinOptimization();
     if (!config.cloud_story_enabled) {
         StopSaveInternalState();
     }
 };
 
-void CartoFacade::AddLidarReading(const viam_carto_lidar_reading *sr) {
+void CartoFacade::StartOptimization() {
     if (state != CartoFacadeState::STARTED) {
-        LOG(ERROR) << "carto facade is in state: " << state
-                   << " expected it to be in state: "
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
                    << CartoFacadeState::STARTED;
         throw VIAM_CARTO_NOT_IN_STARTED_STATE;
     }
-    if (biseq(config.component_reference, sr->lidar) == false) {
-        VLOG(1) << "expected sensor: " << to_std_string(sr->lidar) << " to be "
-                << config.component_reference;
-        throw VIAM_CARTO_SENSOR_NOT_IN_SENSOR_LIST;
+    std::lock_guard<std::mutex> lk(optimization_status_mutex);
+    if (optimization_status.running) {
+        throw VIAM_CARTO_OPTIMIZATION_IN_PROGRESS;
     }
-    std::string lidar_reading = to_std_string(sr->lidar_reading);
-    if (lidar_reading.length() == 0) {
-        throw VIAM_CARTO_LIDAR_READING_EMPTY;
+    // The previous optimization has finished, so this doesn't block
+    if (thread_optimization != nullptr && thread_optimization->joinable()) {
+        thread_optimization->join();
     }
-
-    int64_t lidar_reading_time_unix_milli = sr->lidar_reading_time_unix_milli;
-    auto [success, measurement] = viam::carto_facade::util::carto_lidar_reading(
-        lidar_reading, lidar_reading_time_unix_milli);
-    if (!success) {
-        throw VIAM_CARTO_LIDAR_READING_INVALID;
+    try {
+        CacheLatestMap();
+    } catch (int err) {
+        // There is no map to cache before any submap has been inserted
+        VLOG(1) << "unable to cache the map before optimizing: " << err;
     }
+    optimization_status.running = true;
+    thread_optimization =
+        std::make_unique<std::thread>([&]() { this->RunOptimization(); });
+};
 
-    cartographer::transform::Rigid3d tmp_global_pose;
-    bool update_latest_global_pose = false;
+void CartoFacade::RunOptimization() {
+    auto start = std::chrono::steady_clock::now();
+    // AddLidarReading changes the pose graph under the map_builder_mutex, so
+    // the residual is read under it, but the optimization isn't
+    double residual_before;
+    {
+        std::lock_guard<std::mutex> lk(map_builder_mutex);
+        residual_before = map_builder.GetConstraintResidual();
+    }
+    {
+        // GetPointCloudMap returns the cached map while optimizing. Lidar
+        // readings are added concurrently, as the pose graph synchronizes
+        // the optimization itself.
+        std::unique_lock optimization_lock{optimization_shared_mutex};
+        VLOG(1) << "running final optimization";
+        map_builder.map_builder_->pose_graph()->RunFinalOptimization();
+    }
+    double residual_after;
+    {
+        std::lock_guard<std::mutex> lk(map_builder_mutex);
+        residual_after = map_builder.GetConstraintResidual();
+    }
+    auto duration = std::chrono::duration_cast<std::chrono::milliseconds>(
+        std::chrono::steady_clock::now() - start);
+    LOG(INFO) << "Finished final optimization in " << duration.count()
+              << " ms, residual: " << residual_before << " -> "
+              << residual_after;
+
+    std::lock_guard<std::mutex> lk(optimization_status_mutex);
+    optimization_status.running = false;
+    optimization_status.duration_milli = duration.count();
+    optimization_status.residual_before = residual_before;
+    optimization_status.residual_after = residual_after;
+};
+
+void CartoFacade::JoinOptimization() {
+    if (thread_optimization != nullptr && thread_optimization->joinable()) {
+        thread_optimization->join();
+    }
+};
+
+void CartoFacade::GetOptimizationStatus(
+    viam_carto_optimization_status_response *r) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    std::lock_guard<std::mutex> lk(optimization_status_mutex);
+    *r = optimization_status;
+};
+
+void CartoFacade::GetMapRevision(viam_carto_map_revision_response *r) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    // the map builder keeps the revision behind its own mutex, so that it can
+    // be read while the map_builder_mutex is held
+    auto [revision, changed_unix_milli] = map_builder.GetMapRevision();
+    r->revision = revision;
+    r->changed_unix_milli = changed_unix_milli;
+};
+
+void CartoFacade::GetTrajectory(bool all_trajectories, int every_n_nodes,
+                                viam_carto_get_trajectory_response *r) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    if (every_n_nodes < 1) {
+        throw VIAM_CARTO_GET_TRAJECTORY_EVERY_N_NODES_INVALID;
+    }
+
+    std::vector<viam_carto_trajectory_node> nodes;
+    {
+        std::lock_guard<std::mutex> lk(map_builder_mutex);
+        auto node_poses =
+            map_builder.map_builder_->pose_graph()->GetTrajectoryNodePoses();
+        std::vector<int> trajectory_ids;
+        if (all_trajectories) {
+            for (const int trajectory_id : node_poses.trajectory_ids()) {
+                trajectory_ids.push_back(trajectory_id);
+            }
+        } else {
+            trajectory_ids.push_back(map_builder.trajectory_id);
+        }
+
+        for (const int trajectory_id : trajectory_ids) {
+            std::vector<viam_carto_trajectory_node> trajectory_nodes;
+            for (const auto &node_id_pose :
+                 node_poses.trajectory(trajectory_id)) {
+                // nodes trimmed from the pose graph have no data
+                if (!node_id_pose.data.constant_pose_data.has_value()) {
+                    continue;
+                }
+                const auto &global_pose = node_id_pose.data.global_pose;
+                auto pos_vector = global_pose.translation();
+                auto pos_quat = global_pose.rotation();
+                viam_carto_trajectory_node node;
+                node.trajectory_id = trajectory_id;
+                node.node_index = node_id_pose.id.node_index;
+                node.time_unix_milli =
+                    std::chrono::duration_cast<std::chrono::milliseconds>(
+                        node_id_pose.data.constant_pose_data->time -
+                        cartographer::common::FromUniversal(0))
+                        .count();
+                node.x = pos_vector.x() * 1000;
+                node.y = pos_vector.y() * 1000;
+                node.z = pos_vector.z() * 1000;
+                node.real = pos_quat.w();
+                node.imag = pos_quat.x();
+                node.jmag = pos_quat.y();
+                node.kmag = pos_quat.z();
+                trajectory_nodes.push_back(node);
+            }
+            for (size_t i = 0; i < trajectory_nodes.size(); i++) {
+                if (i % static_cast<size_t>(every_n_nodes) == 0 ||
+                    i == trajectory_nodes.size() - 1) {
+                    nodes.push_back(trajectory_nodes[i]);
+                }
+            }
+        }
+    }
+
+    r->nodes = nullptr;
+    r->nodes_len = 0;
+    if (nodes.empty()) {
+        return;
+    }
+    r->nodes = (viam_carto_trajectory_node *)malloc(
+        nodes.size() * sizeof(viam_carto_trajectory_node));
+    if (r->nodes == nullptr) {
+        throw VIAM_CARTO_OUT_OF_MEMORY;
+    }
+    std::copy(nodes.begin(), nodes.end(), r->nodes);
+    r->nodes_len = nodes.size();
+};
+
+void CartoFacade::GetSubmaps(viam_carto_get_submaps_response *r) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+
+    std::vector<viam_carto_submap> submaps;
+    {
+        std::lock_guard<std::mutex> lk(map_builder_mutex);
+        for (const auto &submap_id_data :
+             map_builder.map_builder_->pose_graph()->GetAllSubmapData()) {
+            const auto &submap = submap_id_data.data.submap;
+            const auto &global_pose = submap_id_data.data.pose;
+            auto pos_vector = global_pose.translation();
+            auto pos_quat = global_pose.rotation();
+            viam_carto_submap s;
+            s.trajectory_id = submap_id_data.id.trajectory_id;
+            s.submap_index = submap_id_data.id.submap_index;
+            s.version = submap->num_range_data();
+            s.finished = submap->insertion_finished();
+            s.resolution_meters = 0;
+            s.x = pos_vector.x() * 1000;
+            s.y = pos_vector.y() * 1000;
+            s.z = pos_vector.z() * 1000;
+            s.real = pos_quat.w();
+            s.imag = pos_quat.x();
+            s.jmag = pos_quat.y();
+            s.kmag = pos_quat.z();
+            viam_carto_map_region box =
+                submap_bounding_box(submap_id_data.data);
+            s.min_x = box.min_x;
+            s.min_y = box.min_y;
+            s.max_x = box.max_x;
+            s.max_y = box.max_y;
+
+            auto submap_2d =
+                dynamic_cast<const cartographer::mapping::Submap2D *>(
+                    submap.get());
+            if (submap_2d != nullptr && submap_2d->grid() != nullptr) {
+                s.resolution_meters = submap_2d->grid()->limits().resolution();
+            }
+            submaps.push_back(s);
+        }
+    }
+
+    r->submaps = nullptr;
+    r->submaps_len = 0;
+    if (submaps.empty()) {
+        return;
+    }
+    r->submaps = (viam_carto_submap *)malloc(submaps.size() *
+                                             sizeof(viam_carto_submap));
+    if (r->submaps == nullptr) {
+        throw VIAM_CARTO_OUT_OF_MEMORY;
+    }
+    std::copy(submaps.begin(), submaps.end(), r->submaps);
+    r->submaps_len = submaps.size();
+};
+
+void CartoFacade::GetConfig(viam_carto_get_config_response *r) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    std::lock_guard<std::mutex> lk(map_builder_mutex);
+    r->optimize_every_n_nodes = map_builder.GetOptimizeEveryNNodes();
+    r->num_range_data = map_builder.GetNumRangeData();
+    r->missing_data_ray_length = map_builder.GetMissingDataRayLength();
+    r->max_range = map_builder.GetMaxRange();
+    r->min_range = map_builder.GetMinRange();
+    r->max_submaps_to_keep = map_builder.GetMaxSubmapsToKeep();
+    r->fresh_submaps_count = map_builder.GetFreshSubmapsCount();
+    r->min_covered_area = map_builder.GetMinCoveredArea();
+    r->min_added_submaps_count = map_builder.GetMinAddedSubmapsCount();
+    r->occupied_space_weight = map_builder.GetOccupiedSpaceWeight();
+    r->translation_weight = map_builder.GetTranslationWeight();
+    r->rotation_weight = map_builder.GetRotationWeight();
+    r->configuration_directory = to_bstring(configuration_directory);
+    r->configuration_basename = to_bstring(configuration_basename);
+};
+
+void CartoFacade::GetOccupancyGrid(const viam_carto_map_options *options,
+                                   viam_carto_get_occupancy_grid_response *r) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    viam_carto_map_options resolved = ResolveMapOptions(options);
+    std::unique_ptr<PaintedMap> painted_map = nullptr;
+    try {
+        painted_map = std::make_unique<PaintedMap>(PaintMap(resolved, true));
+    } catch (std::exception &e) {
+        if (e.what() == viam::carto_facade::errorNoSubmaps) {
+            LOG(INFO) << "Error creating occupancy grid: " << e.what();
+            throw VIAM_CARTO_OCCUPANCY_GRID_EMPTY;
+        }
+        std::string errorLog = "Error writing submap to proto: ";
+        errorLog += e.what();
+        LOG(ERROR) << errorLog;
+        throw std::runtime_error(errorLog);
+    }
+
+    OccupancyGrid grid = to_occupancy_grid(*painted_map);
+    if (grid.width == 0 || grid.height == 0) {
+        LOG(INFO) << "Error creating occupancy grid: no cell within region";
+        throw VIAM_CARTO_OCCUPANCY_GRID_EMPTY;
+    }
+    r->width = grid.width;
+    r->height = grid.height;
+    r->resolution_meters = grid.resolution_meters;
+    r->origin_x = grid.origin_x;
+    r->origin_y = grid.origin_y;
+    r->cells = to_bstring(grid.cells);
+};
+
+void CartoFacade::GetSubmapGrids(
+    const std::vector<cartographer::mapping::SubmapId> &ids,
+    viam_carto_get_submap_grids_response *r) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state << " expected "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    r->grids = nullptr;
+    r->grids_len = 0;
+    if (ids.empty()) {
+        return;
+    }
+    double resolution_meters = ResolveMapOptions(nullptr).resolution_meters;
+    std::set<cartographer::mapping::SubmapId> requested(ids.begin(),
+                                                        ids.end());
+    std::map<cartographer::mapping::SubmapId, cartographer::io::SubmapSlice>
+        submap_slices;
+    try {
+        submap_slices = GetSubmapSlices(
+            [&requested](
+                const cartographer::mapping::SubmapId &id,
+                const cartographer::mapping::PoseGraphInterface::SubmapData &) {
+                return requested.count(id) > 0;
+            });
+    } catch (std::exception &e) {
+        if (e.what() == viam::carto_facade::errorNoSubmaps) {
+            // none of the submaps exist anymore
+            return;
+        }
+        std::string errorLog = "Error writing submap to proto: ";
+        errorLog += e.what();
+        LOG(ERROR) << errorLog;
+        throw std::runtime_error(errorLog);
+    }
+
+    std::vector<viam_carto_submap_grid> grids;
+    grids.reserve(submap_slices.size());
+    for (auto &id_slice : submap_slices) {
+        viam_carto_submap_grid g;
+        g.trajectory_id = id_slice.first.trajectory_id;
+        g.submap_index = id_slice.first.submap_index;
+        g.version = id_slice.second.version;
+        // Painting the slice at the origin paints it in the frame of its
+        // submap.
+        std::map<cartographer::mapping::SubmapId, cartographer::io::SubmapSlice>
+            single_slice;
+        single_slice[id_slice.first] = std::move(id_slice.second);
+        single_slice[id_slice.first].pose =
+            cartographer::transform::Rigid3d::Identity();
+        viam_carto_map_options options = {};
+        OccupancyGrid grid = to_occupancy_grid(to_painted_map(
+            cartographer::io::PaintSubmapSlices(single_slice,
+                                                resolution_meters),
+            resolution_meters, options));
+        g.width = grid.width;
+        g.height = grid.height;
+        g.resolution_meters = grid.resolution_meters;
+        g.origin_x = grid.origin_x;
+        g.origin_y = grid.origin_y;
+        g.cells = to_bstring(grid.cells);
+        grids.push_back(g);
+    }
+
+    r->grids = (viam_carto_submap_grid *)malloc(grids.size() *
+                                                sizeof(viam_carto_submap_grid));
+    if (r->grids == nullptr) {
+        for (auto &g : grids) {
+            bdestroy(g.cells);
+        }
+        throw VIAM_CARTO_OUT_OF_MEMORY;
+    }
+    std::copy(grids.begin(), grids.end(), r->grids);
+    r->grids_len = grids.size();
+};
+
+void CartoFacade::AddLidarReading(const viam_carto_lidar_reading *sr) {
+    if (state != CartoFacadeState::STARTED) {
+        LOG(ERROR) << "carto facade is in state: " << state
+                   << " expected it to be in state: "
+                   << CartoFacadeState::STARTED;
+        throw VIAM_CARTO_NOT_IN_STARTED_STATE;
+    }
+    if (biseq(config.component_reference, sr->lidar) == false) {
+        VLOG(1) << "expected sensor: " << to_std_string(sr->lidar) << " to be "
+                << config.component_reference;
+        throw VIAM_CARTO_SENSOR_NOT_IN_SENSOR_LIST;
+    }
+    std::string lidar_reading = to_std_string(sr->lidar_reading);
+    if (lidar_reading.length() == 0) {
+        throw VIAM_CARTO_LIDAR_READING_EMPTY;
+    }
+
+    int64_t lidar_reading_time_unix_milli = sr->lidar_reading_time_unix_milli;
+    auto [success, measurement] = viam::carto_facade::util::carto_lidar_reading(
+        lidar_reading, lidar_reading_time_unix_milli);
+    if (!success) {
+        throw VIAM_CARTO_LIDAR_READING_INVALID;
+    }
+
+    cartographer::transform::Rigid3d tmp_global_pose;
+    bool update_latest_global_pose = false;
 
     if (map_builder_mutex.try_lock()) {
         VLOG(1) << "AddSensorData timestamp: " << measurement.time
@@ -1200,3 +2231,487 @@ extern int viam_carto_get_internal_state_response_destroy(
     r->internal_state = nullptr;
     return return_code;
 };
+
+extern int viam_carto_get_stats(viam_carto *vc,
+                                viam_carto_get_stats_response *r) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_STATS_RESPONSE_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->GetStats(r);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+// to_viam_carto_stream wraps the stream into a newly allocated
+// viam_carto_stream
+static int to_viam_carto_stream(
+    std::unique_ptr<viam::carto_facade::stream::Stream> stream,
+    viam_carto_stream **ppS) {
+    viam_carto_stream *s =
+        (viam_carto_stream *)malloc(sizeof(viam_carto_stream));
+    if (s == nullptr) {
+        return VIAM_CARTO_OUT_OF_MEMORY;
+    }
+    s->stream_obj = stream.release();
+    *ppS = s;
+    return VIAM_CARTO_SUCCESS;
+}
+
+extern int viam_carto_get_point_cloud_map_stream(
+    viam_carto *vc, const viam_carto_map_options *options,
+    viam_carto_stream **ppS) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (ppS == nullptr) {
+        return VIAM_CARTO_STREAM_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        return to_viam_carto_stream(cf->GetPointCloudMapStream(options), ppS);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+};
+
+extern int viam_carto_get_internal_state_stream(viam_carto *vc,
+                                                viam_carto_stream **ppS) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (ppS == nullptr) {
+        return VIAM_CARTO_STREAM_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        return to_viam_carto_stream(cf->GetInternalStateStream(), ppS);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+};
+
+extern int viam_carto_stream_next(viam_carto_stream *s,
+                                  int max_chunk_size_bytes,
+                                  viam_carto_stream_next_response *r) {
+    if (s == nullptr) {
+        return VIAM_CARTO_STREAM_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_STREAM_NEXT_RESPONSE_INVALID;
+    }
+
+    if (max_chunk_size_bytes <= 0) {
+        return VIAM_CARTO_STREAM_MAX_CHUNK_SIZE_INVALID;
+    }
+    try {
+        viam::carto_facade::stream::Stream *stream =
+            static_cast<viam::carto_facade::stream::Stream *>(s->stream_obj);
+        std::string chunk;
+        if (!stream->Next(max_chunk_size_bytes, chunk)) {
+            return VIAM_CARTO_STREAM_DONE;
+        }
+        r->chunk = viam::carto_facade::to_bstring(chunk);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_stream_next_response_destroy(
+    viam_carto_stream_next_response *r) {
+    if (r == nullptr) {
+        return VIAM_CARTO_STREAM_NEXT_RESPONSE_INVALID;
+    }
+    int return_code = VIAM_CARTO_SUCCESS;
+    int rc = BSTR_OK;
+    rc = bdestroy(r->chunk);
+    if (rc != BSTR_OK) {
+        return_code = VIAM_CARTO_DESTRUCTOR_ERROR;
+    }
+    r->chunk = nullptr;
+    return return_code;
+};
+
+extern int viam_carto_stream_destroy(viam_carto_stream **ppS) {
+    if (ppS == nullptr) {
+        return VIAM_CARTO_STREAM_INVALID;
+    }
+
+    if (*ppS == nullptr) {
+        return VIAM_CARTO_STREAM_INVALID;
+    }
+    delete static_cast<viam::carto_facade::stream::Stream *>(
+        (*ppS)->stream_obj);
+    free((viam_carto_stream *)*ppS);
+    *ppS = nullptr;
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_save_map(viam_carto *vc, bstring path,
+                               bool run_final_optimization,
+                               viam_carto_save_map_response *r) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (path == nullptr) {
+        return VIAM_CARTO_SAVE_MAP_PATH_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_SAVE_MAP_RESPONSE_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->SaveMap(viam::carto_facade::to_std_string(path),
+                    run_final_optimization, r);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_set_pose(viam_carto *vc, const viam_carto_pose *pose) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (pose == nullptr) {
+        return VIAM_CARTO_POSE_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->SetPose(pose);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_switch_to_localization(viam_carto *vc) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    viam::carto_facade::CartoFacade *cf =
+        static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+    int status = VIAM_CARTO_SUCCESS;
+    try {
+        cf->SwitchToLocalization();
+    } catch (int err) {
+        status = err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        status = VIAM_CARTO_UNKNOWN_ERROR;
+    }
+    // The caller reads the mode from vc, which reflects whether the switch
+    // happened regardless of the status
+    vc->slam_mode =
+        viam::carto_facade::slam_mode_to_vc_slam_mode(cf->slam_mode);
+
+    return status;
+};
+
+extern int viam_carto_optimize(viam_carto *vc) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->StartOptimization();
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_optimization_status(
+    viam_carto *vc, viam_carto_optimization_status_response *r) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_OPTIMIZATION_STATUS_RESPONSE_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->GetOptimizationStatus(r);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_map_revision(viam_carto *vc,
+                                       viam_carto_map_revision_response *r) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_MAP_REVISION_RESPONSE_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->GetMapRevision(r);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_trajectory(viam_carto *vc, bool all_trajectories,
+                                     int every_n_nodes,
+                                     viam_carto_get_trajectory_response *r) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_TRAJECTORY_RESPONSE_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->GetTrajectory(all_trajectories, every_n_nodes, r);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_trajectory_response_destroy(
+    viam_carto_get_trajectory_response *r) {
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_TRAJECTORY_RESPONSE_INVALID;
+    }
+    free(r->nodes);
+    r->nodes = nullptr;
+    r->nodes_len = 0;
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_submaps(viam_carto *vc,
+                                  viam_carto_get_submaps_response *r) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_SUBMAPS_RESPONSE_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->GetSubmaps(r);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_submaps_response_destroy(
+    viam_carto_get_submaps_response *r) {
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_SUBMAPS_RESPONSE_INVALID;
+    }
+    free(r->submaps);
+    r->submaps = nullptr;
+    r->submaps_len = 0;
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_config(viam_carto *vc,
+                                 viam_carto_get_config_response *r) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_CONFIG_RESPONSE_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->GetConfig(r);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_config_response_destroy(
+    viam_carto_get_config_response *r) {
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_CONFIG_RESPONSE_INVALID;
+    }
+    int return_code = VIAM_CARTO_SUCCESS;
+    int rc = BSTR_OK;
+    rc = bdestroy(r->configuration_directory);
+    if (rc != BSTR_OK) {
+        return_code = VIAM_CARTO_DESTRUCTOR_ERROR;
+    }
+    r->configuration_directory = nullptr;
+    rc = bdestroy(r->configuration_basename);
+    if (rc != BSTR_OK) {
+        return_code = VIAM_CARTO_DESTRUCTOR_ERROR;
+    }
+    r->configuration_basename = nullptr;
+    return return_code;
+};
+
+extern int viam_carto_get_occupancy_grid(
+    viam_carto *vc, const viam_carto_map_options *options,
+    viam_carto_get_occupancy_grid_response *r) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_OCCUPANCY_GRID_RESPONSE_INVALID;
+    }
+    try {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->GetOccupancyGrid(options, r);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_occupancy_grid_response_destroy(
+    viam_carto_get_occupancy_grid_response *r) {
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_OCCUPANCY_GRID_RESPONSE_INVALID;
+    }
+    int return_code = VIAM_CARTO_SUCCESS;
+    int rc = BSTR_OK;
+    rc = bdestroy(r->cells);
+    if (rc != BSTR_OK) {
+        return_code = VIAM_CARTO_DESTRUCTOR_ERROR;
+    }
+    r->cells = nullptr;
+    return return_code;
+};
+
+extern int viam_carto_get_submap_grids(
+    viam_carto *vc, const viam_carto_submap_id *ids, int ids_len,
+    viam_carto_get_submap_grids_response *r) {
+    if (vc == nullptr) {
+        return VIAM_CARTO_VC_INVALID;
+    }
+
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_SUBMAP_GRIDS_RESPONSE_INVALID;
+    }
+
+    if (ids_len < 0 || (ids == nullptr && ids_len != 0)) {
+        return VIAM_CARTO_SUBMAP_IDS_INVALID;
+    }
+    try {
+        std::vector<cartographer::mapping::SubmapId> submap_ids;
+        submap_ids.reserve(ids_len);
+        for (int i = 0; i < ids_len; i++) {
+            submap_ids.push_back(cartographer::mapping::SubmapId{
+                ids[i].trajectory_id, ids[i].submap_index});
+        }
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>((vc)->carto_obj);
+        cf->GetSubmapGrids(submap_ids, r);
+    } catch (int err) {
+        return err;
+    } catch (std::exception &e) {
+        LOG(ERROR) << e.what();
+        return VIAM_CARTO_UNKNOWN_ERROR;
+    }
+
+    return VIAM_CARTO_SUCCESS;
+};
+
+extern int viam_carto_get_submap_grids_response_destroy(
+    viam_carto_get_submap_grids_response *r) {
+    if (r == nullptr) {
+        return VIAM_CARTO_GET_SUBMAP_GRIDS_RESPONSE_INVALID;
+    }
+    int return_code = VIAM_CARTO_SUCCESS;
+    for (int i = 0; i < r->grids_len; i++) {
+        if (bdestroy(r->grids[i].cells) != BSTR_OK) {
+            return_code = VIAM_CARTO_DESTRUCTOR_ERROR;
+        }
+    }
+    free(r->grids);
+    r->grids = nullptr;
+    r->grids_len = 0;
+    return return_code;
+};
@@ -5,11 +5,16 @@
 #ifdef __cplusplus
 #include <atomic>
 #include <chrono>
+#include <functional>
+#include <optional>
 #include <shared_mutex>
 #include <string>
+#include <vector>
 
 #include "cartographer/io/submap_painter.h"
 #include "map_builder.h"
+#include "stream.h"
+#include "util.h"
 #else
 #include <stdbool.h>
 #include <stdint.h>
@@ -67,6 +72,179 @@ typedef struct viam_carto_get_internal_state_response {
     bstring internal_state;
 } viam_carto_get_internal_state_response;
 
+typedef struct viam_carto_get_stats_response {
+    int trajectories;
+    int submaps_finished;
+    int submaps_active;
+    // number of trajectory nodes in the pose graph
+    int nodes;
+    // number of constraints in the pose graph
+    int constraints;
+    // approximate number of bytes held by the grids of all submaps
+    int64_t grid_bytes;
+    // 0 if no optimization has run yet
+    int64_t last_optimization_time_unix_milli;
+} viam_carto_get_stats_response;
+
+typedef struct viam_carto_optimization_status_response {
+    // true while the optimization started by viam_carto_optimize is running
+    bool running;
+    // duration of the last optimization, 0 if none has finished yet
+    int64_t duration_milli;
+    // sum of the squared weighted errors of the pose graph constraints before
+    // & after the last optimization
+    double residual_before;
+    double residual_after;
+} viam_carto_optimization_status_response;
+
+typedef struct viam_carto_map_revision_response {
+    // incremented whenever range data is inserted into the submaps or an
+    // optimization moves them, as the painted map only depends on the submaps
+    int64_t revision;
+    // when revision was last incremented
+    int64_t changed_unix_milli;
+} viam_carto_map_revision_response;
+
+typedef struct viam_carto_trajectory_node {
+    int trajectory_id;
+    // index of the node within its trajectory
+    int node_index;
+    int64_t time_unix_milli;
+    // optimized global pose, millimeters from the origin
+    double x;
+    double y;
+    double z;
+
+    // Quaternian information
+    double real;
+    double imag;
+    double jmag;
+    double kmag;
+} viam_carto_trajectory_node;
+
+typedef struct viam_carto_get_trajectory_response {
+    viam_carto_trajectory_node *nodes;
+    int nodes_len;
+} viam_carto_get_trajectory_response;
+
+typedef struct viam_carto_submap {
+    int trajectory_id;
+    // index of the submap within its trajectory
+    int submap_index;
+    // number of range data inserted into the submap
+    int version;
+    bool finished;
+    double resolution_meters;
+
+    // optimized global pose, millimeters from the origin
+    double x;
+    double y;
+    double z;
+
+    // Quaternian information
+    double real;
+    double imag;
+    double jmag;
+    double kmag;
+
+    // axis aligned bounding box of the known cells of the submap's grid in the
+    // frame of the map, millimeters from the origin
+    double min_x;
+    double min_y;
+    double max_x;
+    double max_y;
+} viam_carto_submap;
+
+typedef struct viam_carto_get_submaps_response {
+    viam_carto_submap *submaps;
+    int submaps_len;
+} viam_carto_get_submaps_response;
+
+typedef struct viam_carto_get_config_response {
+    // directory & basename of the lua configuration loaded by the map builder
+    bstring configuration_directory;
+    bstring configuration_basename;
+
+    // values in effect in the map builder, after the lua configuration has
+    // been overwritten by the viam_carto_algo_config
+    int optimize_every_n_nodes;
+    int num_range_data;
+    float missing_data_ray_length;
+    float max_range;
+    float min_range;
+    int max_submaps_to_keep;
+    int fresh_submaps_count;
+    double min_covered_area;
+    int min_added_submaps_count;
+    double occupied_space_weight;
+    double translation_weight;
+    double rotation_weight;
+} viam_carto_get_config_response;
+
+typedef struct viam_carto_get_occupancy_grid_response {
+    // number of columns & rows of cells
+    int width;
+    int height;
+    // side of a cell in meters
+    double resolution_meters;
+    // position of the lower left corner of the bottom left cell in the frame
+    // of the map, meters from the origin
+    double origin_x;
+    double origin_y;
+    // width * height bytes in row major order starting with the top row, each
+    // the probability (0 - 100) that the cell is occupied or
+    // VIAM_CARTO_OCCUPANCY_GRID_UNKNOWN_CELL if the cell was never observed
+    bstring cells;
+} viam_carto_get_occupancy_grid_response;
+
+#define VIAM_CARTO_OCCUPANCY_GRID_UNKNOWN_CELL 255
+
+typedef struct viam_carto_submap_id {
+    int trajectory_id;
+    // index of the submap within its trajectory
+    int submap_index;
+} viam_carto_submap_id;
+
+typedef struct viam_carto_submap_grid {
+    int trajectory_id;
+    int submap_index;
+    // number of range data inserted into the submap when it was painted
+    int version;
+    // number of columns & rows of cells
+    int width;
+    int height;
+    // side of a cell in meters
+    double resolution_meters;
+    // position of the lower left corner of the bottom left cell in the frame
+    // of the submap, meters from its origin. The grid is placed in the frame
+    // of the map with the global pose of the submap, so it stays valid when
+    // an optimization moves the submap.
+    double origin_x;
+    double origin_y;
+    // width * height bytes in row major order starting with the top row, as
+    // in viam_carto_get_occupancy_grid_response
+    bstring cells;
+} viam_carto_submap_grid;
+
+typedef struct viam_carto_get_submap_grids_response {
+    viam_carto_submap_grid *grids;
+    int grids_len;
+} viam_carto_get_submap_grids_response;
+
+typedef struct viam_carto_save_map_response {
+    // number of trajectory nodes in the saved map
+    int nodes;
+} viam_carto_save_map_response;
+
+// Represents an export which is read in chunks
+typedef struct viam_carto_stream {
+    void *stream_obj;
+} viam_carto_stream;
+
+typedef struct viam_carto_stream_next_response {
+    bstring chunk;
+} viam_carto_stream_next_response;
+
 typedef struct viam_carto_lidar_reading {
     bstring lidar;
     bstring lidar_reading;
@@ -111,6 +289,46 @@ typedef enum viam_carto_LIDAR_CONFIG {
 #define VIAM_CARTO_NOT_IN_IO_INITIALIZED_STATE 30
 #define VIAM_CARTO_NOT_IN_STARTED_STATE 31
 #define VIAM_CARTO_NOT_IN_TERMINATABLE_STATE 32
+#define VIAM_CARTO_GET_STATS_RESPONSE_INVALID 33
+#define VIAM_CARTO_STREAM_INVALID 34
+#define VIAM_CARTO_STREAM_DONE 35
+#define VIAM_CARTO_STREAM_NEXT_RESPONSE_INVALID 36
+#define VIAM_CARTO_STREAM_MAX_CHUNK_SIZE_INVALID 37
+#define VIAM_CARTO_SAVE_MAP_PATH_INVALID 38
+#define VIAM_CARTO_SAVE_MAP_RESPONSE_INVALID 39
+#define VIAM_CARTO_SAVE_MAP_FILE_WRITE_IO_ERROR 40
+#define VIAM_CARTO_POSE_INVALID 41
+#define VIAM_CARTO_NOT_IN_LOCALIZING_MODE 42
+#define VIAM_CARTO_FROZEN_MAP_EMPTY 43
+#define VIAM_CARTO_OPTIMIZATION_IN_PROGRESS 44
+#define VIAM_CARTO_OPTIMIZATION_STATUS_RESPONSE_INVALID 45
+#define VIAM_CARTO_GET_TRAJECTORY_RESPONSE_INVALID 46
+#define VIAM_CARTO_GET_TRAJECTORY_EVERY_N_NODES_INVALID 47
+#define VIAM_CARTO_GET_SUBMAPS_RESPONSE_INVALID 48
+#define VIAM_CARTO_GET_CONFIG_RESPONSE_INVALID 49
+#define VIAM_CARTO_ALREADY_IN_LOCALIZING_MODE 50
+#define VIAM_CARTO_GET_OCCUPANCY_GRID_RESPONSE_INVALID 51
+#define VIAM_CARTO_OCCUPANCY_GRID_EMPTY 52
+#define VIAM_CARTO_MAP_OPTIONS_INVALID 53
+#define VIAM_CARTO_GET_SUBMAP_GRIDS_RESPONSE_INVALID 54
+#define VIAM_CARTO_SUBMAP_IDS_INVALID 55
+#define VIAM_CARTO_MAP_REVISION_RESPONSE_INVALID 56
+
+// side of a point of the painted map in meters, unless configured otherwise
+#define VIAM_CARTO_DEFAULT_MAP_RESOLUTION_METERS 0.05
+// finest resolution the map may be painted at in meters, which bounds the
+// size of the painted surface
+#define VIAM_CARTO_MIN_MAP_RESOLUTION_METERS 0.005
+
+// formats the pointcloud map may be written in.
+// VIAM_CARTO_POINT_CLOUD_FORMAT_PCD is a binary pcd with the probability
+// (0 - 100) that each point is occupied written to its rgb field, as the RDK
+// expects. The other formats carry it as a probability field instead.
+#define VIAM_CARTO_POINT_CLOUD_FORMAT_PCD 0
+#define VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_BINARY 1
+#define VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_BINARY_COMPRESSED 2
+#define VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_ASCII 3
+#define VIAM_CARTO_POINT_CLOUD_FORMAT_PLY 4
 
 typedef struct viam_carto_algo_config {
     bool optimize_on_start;
@@ -126,8 +344,52 @@ typedef struct viam_carto_algo_config {
     double occupied_space_weight;
     double translation_weight;
     double rotation_weight;
+    // side of a point of the painted map in meters,
+    // VIAM_CARTO_DEFAULT_MAP_RESOLUTION_METERS if 0
+    double map_resolution_meters;
+    // most points the painted map may have before it is painted at a coarser
+    // resolution, unlimited if 0
+    int max_map_points;
 } viam_carto_algo_config;
 
+// viam_carto_map_region is a region of the map in millimeters, in the same
+// frame as viam_carto_get_position.
+typedef struct viam_carto_map_region {
+    double min_x;
+    double min_y;
+    double max_x;
+    double max_y;
+} viam_carto_map_region;
+
+// viam_carto_map_options overwrite how the map is painted for a single
+// request, unset values fall back to the viam_carto_algo_config.
+typedef struct viam_carto_map_options {
+    // side of a point of the painted map in meters, the configured
+    // map_resolution_meters if 0
+    double resolution_meters;
+    // most points of the pointcloud map or cells of the occupancy grid the
+    // painted map may have, the resolution is coarsened until the map fits.
+    // The configured max_map_points if 0.
+    int max_points;
+    // if has_region is set only the submaps intersecting the region are
+    // painted & only the cells whose centers lie within it are returned, so
+    // the work is bounded by the size of the region rather than the map
+    bool has_region;
+    viam_carto_map_region region;
+    // one of the VIAM_CARTO_POINT_CLOUD_FORMAT_* the pointcloud map is
+    // written in, which doesn't change how the map is painted
+    int format;
+} viam_carto_map_options;
+
+typedef struct viam_carto_pose {
+    // millimeters from the origin of the map
+    double x;
+    // millimeters from the origin of the map
+    double y;
+    // degrees counterclockwise from the x axis of the map
+    double theta;
+} viam_carto_pose;
+
 typedef struct viam_carto_config {
     bstring camera;
     bstring movement_sensor;
@@ -137,6 +399,14 @@ typedef struct viam_carto_config {
     bool cloud_story_enabled;
     bool enable_mapping;
     bstring existing_map;
+    // the localization trajectory starts at initial_pose, rather than at the
+    // origin, if has_initial_pose is true. Only used in localizing mode.
+    bool has_initial_pose;
+    viam_carto_pose initial_pose;
+    // if initial_internal_state is not empty, the mapping session it was
+    // saved from is continued in mapping mode, rather than updating it as an
+    // apriori map, e.g. when restarting with new algo config values.
+    bstring initial_internal_state;
 } viam_carto_config;
 
 // viam_carto_lib_init/4 takes an empty viam_carto_lib pointer to pointer
@@ -273,6 +543,288 @@ extern int viam_carto_get_internal_state(
 extern int viam_carto_get_internal_state_response_destroy(
     viam_carto_get_internal_state_response *r);
 
+// viam_carto_get_stats/3 takes a viam_carto pointer, a
+// viam_carto_get_stats_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, mutates viam_carto_get_stats_response
+// to contain the response. The response holds no allocated
+// resources, so it doesn't need to be destroyed.
+extern int viam_carto_get_stats(viam_carto *vc,                   //
+                                viam_carto_get_stats_response *r  // OUT
+);
+
+// viam_carto_get_point_cloud_map_stream/3 takes a viam_carto pointer, a
+// viam_carto_map_options pointer, which may be NULL to paint the map as
+// configured, & a viam_carto_stream pointer pointer
+//
+// On error: Returns a non 0 error code, VIAM_CARTO_MAP_OPTIONS_INVALID if the
+// resolution is finer than VIAM_CARTO_MIN_MAP_RESOLUTION_METERS, a value is
+// negative, the region is empty or the format is unknown
+//
+// On success: Returns 0, mutates viam_carto_stream to point to a stream of
+// the pointcloud map viam_carto_get_point_cloud_map returns, painted with the
+// given map options & written in their format. The stream is independent of
+// the viam_carto & must be freed with viam_carto_stream_destroy.
+extern int viam_carto_get_point_cloud_map_stream(
+    viam_carto *vc,                         //
+    const viam_carto_map_options *options,  //
+    viam_carto_stream **ppS                 // OUT
+);
+
+// viam_carto_get_internal_state_stream/2 takes a viam_carto pointer, a
+// viam_carto_stream pointer pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, mutates viam_carto_stream to point to a stream of
+// the same pbstream as viam_carto_get_internal_state returns. The stream is
+// independent of the viam_carto & must be freed with
+// viam_carto_stream_destroy.
+extern int viam_carto_get_internal_state_stream(viam_carto *vc,         //
+                                                viam_carto_stream **ppS  // OUT
+);
+
+// viam_carto_stream_next/3 takes a viam_carto_stream pointer, the max size
+// of the chunk in bytes & a viam_carto_stream_next_response pointer
+//
+// On error: Returns a non 0 error code, VIAM_CARTO_STREAM_DONE once the whole
+// stream has been read
+//
+// On success: Returns 0, mutates viam_carto_stream_next_response to contain
+// the next chunk of the stream
+extern int viam_carto_stream_next(viam_carto_stream *s,               //
+                                  int max_chunk_size_bytes,           //
+                                  viam_carto_stream_next_response *r  // OUT
+);
+
+// viam_carto_stream_next_response_destroy/1 takes a
+// viam_carto_stream_next_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, frees the viam_carto_stream_next_response.
+extern int viam_carto_stream_next_response_destroy(
+    viam_carto_stream_next_response *r  //
+);
+
+// viam_carto_stream_destroy/1 takes a viam_carto_stream pointer pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, frees the viam_carto_stream & sets it to NULL.
+extern int viam_carto_stream_destroy(viam_carto_stream **ppS  // OUT
+);
+
+// viam_carto_save_map/4 takes a viam_carto pointer, the path of the pbstream
+// to write, whether to run a final optimization first & a
+// viam_carto_save_map_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, writes the internal state as a pbstream to the path
+// & mutates viam_carto_save_map_response to contain the response. The
+// response holds no allocated resources, so it doesn't need to be destroyed.
+extern int viam_carto_save_map(viam_carto *vc,                  //
+                               bstring path,                    //
+                               bool run_final_optimization,     //
+                               viam_carto_save_map_response *r  // OUT
+);
+
+// viam_carto_set_pose/2 takes a viam_carto pointer & a viam_carto_pose
+// pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, finishes the localization trajectory & starts a new
+// one at the pose in the frame of the frozen map. Only supported in
+// localizing mode.
+extern int viam_carto_set_pose(viam_carto *vc,              //
+                               const viam_carto_pose *pose  //
+);
+
+// viam_carto_switch_to_localization/1 takes a viam_carto pointer
+//
+// On error: Returns a non 0 error code, VIAM_CARTO_OPTIMIZATION_IN_PROGRESS if
+// an optimization started by viam_carto_optimize is running
+//
+// On success: Returns 0, finishes & freezes the current trajectory & starts a
+// new localization trajectory with the pure localization trimmer at the
+// current pose. The frozen trajectory is no longer optimized, so callers
+// should optimize the map with viam_carto_optimize first. Only supported in
+// mapping & updating mode.
+//
+// In both cases: Sets the slam_mode of the viam_carto to the mode cartographer
+// is in after the call.
+extern int viam_carto_switch_to_localization(viam_carto *vc  //
+);
+
+// viam_carto_optimize/1 takes a viam_carto pointer
+//
+// On error: Returns a non 0 error code, VIAM_CARTO_OPTIMIZATION_IN_PROGRESS if
+// an optimization is already running
+//
+// On success: Returns 0 & starts a final optimization of the pose graph in
+// the background, so that lidar readings can still be added while it runs.
+// Its progress is returned by viam_carto_get_optimization_status.
+extern int viam_carto_optimize(viam_carto *vc  //
+);
+
+// viam_carto_get_optimization_status/2 takes a viam_carto pointer, a
+// viam_carto_optimization_status_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, mutates viam_carto_optimization_status_response
+// to contain the response. The response holds no allocated resources, so it
+// doesn't need to be destroyed.
+extern int viam_carto_get_optimization_status(
+    viam_carto *vc,                              //
+    viam_carto_optimization_status_response *r  // OUT
+);
+
+// viam_carto_get_map_revision/2 takes a viam_carto pointer, a
+// viam_carto_map_revision_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, mutates viam_carto_map_revision_response to contain
+// the current revision of the map. It doesn't take the map builder mutex, so
+// it returns immediately even while an optimization holds it. The response
+// holds no allocated resources, so it doesn't need to be destroyed.
+extern int viam_carto_get_map_revision(
+    viam_carto *vc,                       //
+    viam_carto_map_revision_response *r  // OUT
+);
+
+// viam_carto_get_trajectory/4 takes a viam_carto pointer, whether to return
+// all trajectories rather than only the active one, the number of nodes to
+// advance between returned nodes & a viam_carto_get_trajectory_response
+// pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, mutates viam_carto_get_trajectory_response to
+// contain every every_n_nodes-th node of the trajectories, along with the last
+// node of each trajectory, ordered by trajectory & time.
+extern int viam_carto_get_trajectory(
+    viam_carto *vc,                        //
+    bool all_trajectories,                 //
+    int every_n_nodes,                     //
+    viam_carto_get_trajectory_response *r  // OUT
+);
+
+// viam_carto_get_trajectory_response_destroy/1 takes a
+// viam_carto_get_trajectory_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, frees the viam_carto_get_trajectory_response.
+extern int viam_carto_get_trajectory_response_destroy(
+    viam_carto_get_trajectory_response *r  //
+);
+
+// viam_carto_get_submaps/2 takes a viam_carto pointer, a
+// viam_carto_get_submaps_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, mutates viam_carto_get_submaps_response to contain
+// every submap of every trajectory, ordered by trajectory & submap index.
+extern int viam_carto_get_submaps(viam_carto *vc,                     //
+                                  viam_carto_get_submaps_response *r  // OUT
+);
+
+// viam_carto_get_submaps_response_destroy/1 takes a
+// viam_carto_get_submaps_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, frees the viam_carto_get_submaps_response.
+extern int viam_carto_get_submaps_response_destroy(
+    viam_carto_get_submaps_response *r  //
+);
+
+// viam_carto_get_config/2 takes a viam_carto pointer, a
+// viam_carto_get_config_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, mutates viam_carto_get_config_response to contain
+// the lua configuration in use & the values the map builder was built with.
+extern int viam_carto_get_config(viam_carto *vc,                    //
+                                 viam_carto_get_config_response *r  // OUT
+);
+
+// viam_carto_get_config_response_destroy/1 takes a
+// viam_carto_get_config_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, frees the viam_carto_get_config_response.
+extern int viam_carto_get_config_response_destroy(
+    viam_carto_get_config_response *r  //
+);
+
+// viam_carto_get_occupancy_grid/3 takes a viam_carto pointer, a
+// viam_carto_map_options pointer, which may be NULL to paint the map as
+// configured, & a viam_carto_get_occupancy_grid_response pointer
+//
+// On error: Returns a non 0 error code, VIAM_CARTO_MAP_OPTIONS_INVALID if the
+// resolution is finer than VIAM_CARTO_MIN_MAP_RESOLUTION_METERS, a value is
+// negative or the region is empty
+//
+// On success: Returns 0, mutates viam_carto_get_occupancy_grid_response to
+// contain the painted map as a grid of occupancy probabilities, in the same
+// frame as viam_carto_get_position. The resolution of the response is the one
+// the map was painted at, which is coarser than requested if the grid would
+// otherwise have more cells than max_points.
+extern int viam_carto_get_occupancy_grid(
+    viam_carto *vc,                            //
+    const viam_carto_map_options *options,     //
+    viam_carto_get_occupancy_grid_response *r  // OUT
+);
+
+// viam_carto_get_occupancy_grid_response_destroy/1 takes a
+// viam_carto_get_occupancy_grid_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, frees the viam_carto_get_occupancy_grid_response.
+extern int viam_carto_get_occupancy_grid_response_destroy(
+    viam_carto_get_occupancy_grid_response *r  //
+);
+
+// viam_carto_get_submap_grids/4 takes a viam_carto pointer, an array of
+// ids_len viam_carto_submap_ids & a viam_carto_get_submap_grids_response
+// pointer
+//
+// On error: Returns a non 0 error code, VIAM_CARTO_SUBMAP_IDS_INVALID if
+// ids_len is negative or ids is NULL while ids_len isn't 0
+//
+// On success: Returns 0, mutates viam_carto_get_submap_grids_response to
+// contain the grid of each of the given submaps, painted at the configured
+// map_resolution_meters in the frame of the submap & ordered by trajectory &
+// submap index. Submaps which don't exist, e.g. as they were trimmed, are
+// left out, so that clients can tell which of the submaps they hold are gone.
+extern int viam_carto_get_submap_grids(
+    viam_carto *vc,                          //
+    const viam_carto_submap_id *ids,         //
+    int ids_len,                             //
+    viam_carto_get_submap_grids_response *r  // OUT
+);
+
+// viam_carto_get_submap_grids_response_destroy/1 takes a
+// viam_carto_get_submap_grids_response pointer
+//
+// On error: Returns a non 0 error code
+//
+// On success: Returns 0, frees the viam_carto_get_submap_grids_response.
+extern int viam_carto_get_submap_grids_response_destroy(
+    viam_carto_get_submap_grids_response *r  //
+);
+
 #ifdef __cplusplus
 }
 #endif
@@ -287,10 +839,23 @@ enum class SlamMode { MAPPING, LOCALIZING, UPDATING };
 std::ostream &operator<<(std::ostream &os, const SlamMode &slam_mode);
 static const int checkForShutdownIntervalMicroseconds = 1e5;
 
-// The resolutionMeters variable defines the area in meters that each pixel
-// represents. This is used to draw the cairo map and in so doing defines the
-// resolution of the outputted PCD
-static const double resolutionMeters = 0.05;
+// maxMapCoarsenings bounds how many times the map is repainted at a coarser
+// resolution to fit into max_points.
+static const int maxMapCoarsenings = 8;
+
+// PaintedMap is the map painted at resolution_meters, which is the area in
+// meters each pixel represents & in so doing the resolution of the outputted
+// PCD.
+// Only the pixels in [min_pixel_x, max_pixel_x) x [min_pixel_y, max_pixel_y)
+// of the painted surface are within the requested region.
+struct PaintedMap {
+    cartographer::io::PaintSubmapSlicesResult slices;
+    double resolution_meters;
+    int min_pixel_x;
+    int min_pixel_y;
+    int max_pixel_x;
+    int max_pixel_y;
+};
 
 typedef struct config {
     std::string camera;
@@ -302,11 +867,17 @@ typedef struct config {
     bool cloud_story_enabled;
     bool enable_mapping;
     std::string existing_map;
+    std::optional<cartographer::transform::Rigid3d> initial_pose;
+    std::string initial_internal_state;
 } config;
 
 // function to convert viam_carto_config into  viam::carto_facade::config
 config from_viam_carto_config(viam_carto_config vcc);
 
+// function to convert viam_carto_pose into a pose in meters & radians,
+// throws VIAM_CARTO_POSE_INVALID if the pose is not finite
+cartographer::transform::Rigid3d from_viam_carto_pose(viam_carto_pose pose);
+
 // Error log for when no submaps exist
 static const std::string errorNoSubmaps = "No submaps to paint";
 
@@ -322,6 +893,27 @@ carto_facade::SlamMode determine_slam_mode_cloud_story_enabled(
 
 int slam_mode_to_vc_slam_mode(viam::carto_facade::SlamMode sm);
 
+// to_point_cloud_format returns the util::PointCloudFormat of one of the
+// VIAM_CARTO_POINT_CLOUD_FORMAT_* constants, which ResolveMapOptions validated
+util::PointCloudFormat to_point_cloud_format(int format);
+
+// PaintedMapStream yields the pointcloud map of a painted map written in the
+// given format. The points of each row of the painted surface are only
+// written once the previous chunks have been read, unless the format is
+// compressed.
+class PaintedMapStream : public stream::Stream {
+   public:
+    PaintedMapStream(PaintedMap painted_map, util::PointCloudFormat format);
+    bool Next(size_t max_bytes, std::string &chunk) override;
+
+   private:
+    PaintedMap painted_map;
+    util::PointCloudFormat format;
+    int next_row;
+    // header or points which didn't fit into the previous chunk
+    std::string pending;
+};
+
 enum class CartoFacadeState { INITIALIZED, IO_INITIALIZED, STARTED };
 class CartoFacade {
    public:
@@ -355,6 +947,76 @@ class CartoFacade {
     // maximumGRPCByteChunkSize
     void GetInternalState(viam_carto_get_internal_state_response *r);
 
+    // GetPointCloudMapStream returns a stream of the same pcd as
+    // GetPointCloudMap, painted with the given map options. The points are
+    // read from the painted map one row at a time, so the pcd is never held
+    // in memory as a whole.
+    std::unique_ptr<stream::Stream> GetPointCloudMapStream(
+        const viam_carto_map_options *options);
+
+    // GetInternalStateStream returns a stream of the same pbstream as
+    // GetInternalState, which is read from the saved file in chunks.
+    std::unique_ptr<stream::Stream> GetInternalStateStream();
+
+    // GetStats returns the size of the pose graph & the approximate memory
+    // held by the grids of the submaps, along with the time of the last
+    // optimization.
+    void GetStats(viam_carto_get_stats_response *r);
+
+    // SaveMap writes the internal state as a pbstream to the given path,
+    // optionally after running a final optimization of the pose graph. The
+    // final optimization blocks adding lidar readings until it is done.
+    void SaveMap(std::string path, bool run_final_optimization,
+                 viam_carto_save_map_response *r);
+
+    // SetPose finishes the localization trajectory & starts a new one at the
+    // given pose in the frame of the frozen map, so that cartographer doesn't
+    // have to find the robot in the whole map.
+    void SetPose(const viam_carto_pose *pose);
+
+    // SwitchToLocalization freezes the map built so far & starts localizing
+    // against it at the current pose, without reloading the map.
+    void SwitchToLocalization();
+
+    // StartOptimization caches the current map, so that it is returned while
+    // the pose graph is optimized, & starts a final optimization of the pose
+    // graph in a separate thread.
+    void StartOptimization();
+
+    // GetOptimizationStatus returns whether an optimization is running, along
+    // with the duration & residuals of the last one.
+    void GetOptimizationStatus(viam_carto_optimization_status_response *r);
+
+    // GetMapRevision returns the revision of the map, see
+    // viam_carto_map_revision_response.
+    void GetMapRevision(viam_carto_map_revision_response *r);
+
+    // GetTrajectory returns the optimized global poses of the nodes of the
+    // active trajectory, or of all trajectories, keeping every
+    // every_n_nodes-th node & the last node of each trajectory.
+    void GetTrajectory(bool all_trajectories, int every_n_nodes,
+                       viam_carto_get_trajectory_response *r);
+
+    // GetSubmaps returns the optimized global pose, version, resolution &
+    // bounding box of every submap, along with whether it is finished.
+    void GetSubmaps(viam_carto_get_submaps_response *r);
+
+    // GetConfig returns the lua configuration directory & basename in use,
+    // along with the values the map builder was built with.
+    void GetConfig(viam_carto_get_config_response *r);
+
+    // GetOccupancyGrid returns the map painted with the given map options as
+    // a grid of the probability that each cell is occupied.
+    void GetOccupancyGrid(const viam_carto_map_options *options,
+                          viam_carto_get_occupancy_grid_response *r);
+
+    // GetSubmapGrids returns the grids of the given submaps that exist, each
+    // painted in the frame of its submap, so that clients only have to fetch
+    // the submaps whose version changed.
+    void GetSubmapGrids(
+        const std::vector<cartographer::mapping::SubmapId> &ids,
+        viam_carto_get_submap_grids_response *r);
+
     void AddLidarReading(const viam_carto_lidar_reading *sr);
 
     void Start();
@@ -365,7 +1027,28 @@ class CartoFacade {
     void CacheLatestMap();
     void CacheMapInLocalizationMode();
     void GetLatestSampledPointCloudMapString(std::string &pointcloud);
-    cartographer::io::PaintSubmapSlicesResult GetLatestPaintedMapSlices();
+    // GetLatestSubmapSlices returns the slices of the submaps intersecting the
+    // region of the map options, or of all submaps if it has none.
+    std::map<cartographer::mapping::SubmapId, cartographer::io::SubmapSlice>
+    GetLatestSubmapSlices(const viam_carto_map_options &options);
+    // GetSubmapSlices returns the slices, along with their versions, of the
+    // submaps for which include returns true. It throws errorNoSubmaps if
+    // there are none.
+    using SubmapFilter = std::function<bool(
+        const cartographer::mapping::SubmapId &,
+        const cartographer::mapping::PoseGraphInterface::SubmapData &)>;
+    std::map<cartographer::mapping::SubmapId, cartographer::io::SubmapSlice>
+    GetSubmapSlices(const SubmapFilter &include);
+    // ResolveMapOptions returns the given map options, which may be null,
+    // with unset values replaced by the configured ones. It throws
+    // VIAM_CARTO_MAP_OPTIONS_INVALID if they are invalid.
+    viam_carto_map_options ResolveMapOptions(
+        const viam_carto_map_options *options);
+    // PaintMap paints the latest map within the region of the resolved map
+    // options at their resolution, repainting it at a coarser resolution
+    // while it has more than max_points points, or cells if count_cells is
+    // set.
+    PaintedMap PaintMap(viam_carto_map_options options, bool count_cells);
     viam_carto_lib *lib;
     viam::carto_facade::config config;
     viam_carto_algo_config algo_config;
@@ -373,6 +1056,7 @@ class CartoFacade {
     std::string path_to_internal_state_file;
     std::atomic<CartoFacadeState> state{CartoFacadeState::INITIALIZED};
     std::string configuration_directory;
+    std::string configuration_basename;
     SlamMode slam_mode = SlamMode::MAPPING;
 
     // If mutexes map_builder_mutex and optimization_shared_mutex are held
@@ -396,6 +1080,13 @@ class CartoFacade {
     // includes the timestamp of the time when the map is saved.
     void SaveInternalStateOnInterval();
 
+    // RunOptimization runs the final optimization started by
+    // StartOptimization & records its status.
+    void RunOptimization();
+
+    // JoinOptimization waits for the optimization thread to finish, if any.
+    void JoinOptimization();
+
     // ConvertSavedMapToStream converted the saved pbstream to the passed in
     // string and deletes the file.
     // void ConvertSavedMapToStream(const std::string filename_with_timestamp,
@@ -442,6 +1133,10 @@ class CartoFacade {
     // std::atomic<bool> finished_processing_offline{false};
     std::unique_ptr<std::thread> thread_save_internal_state;
 
+    std::unique_ptr<std::thread> thread_optimization;
+    std::mutex optimization_status_mutex;
+    viam_carto_optimization_status_response optimization_status{};
+
     std::mutex viam_response_mutex;
     cartographer::transform::Rigid3d latest_global_pose =
         cartographer::transform::Rigid3d();
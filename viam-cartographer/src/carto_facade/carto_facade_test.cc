@@ -1,5 +1,6 @@
 #include "carto_facade.h"
 
+#include <pcl/common/io.h>    // for pcl::getFieldsList
 #include <pcl/conversions.h>  // for pcl::fromPCLPointCloud2
 #include <pcl/point_cloud.h>  // for pcl::PointCloud
 #include <pcl/point_types.h>  // for pcl::PointXYZRGB
@@ -9,6 +10,8 @@
 #include <boost/test/unit_test.hpp>
 #include <boost/uuid/uuid.hpp>
 #include <boost/uuid/uuid_generators.hpp>
+#include <algorithm>
+#include <cmath>
 #include <cstring>
 #include <exception>
 #include <filesystem>
@@ -41,6 +44,9 @@ viam_carto_config viam_carto_config_setup(
     vcc.cloud_story_enabled = cloud_story_enabled;
     vcc.enable_mapping = enable_mapping;
     vcc.existing_map = bfromcstr(existing_map.c_str());
+    vcc.has_initial_pose = false;
+    vcc.initial_pose = {0, 0, 0};
+    vcc.initial_internal_state = bfromcstr("");
     return vcc;
 }
 
@@ -49,6 +55,7 @@ void viam_carto_config_teardown(viam_carto_config vcc) {
     BOOST_TEST(bdestroy(vcc.camera) == BSTR_OK);
     BOOST_TEST(bdestroy(vcc.movement_sensor) == BSTR_OK);
     BOOST_TEST(bdestroy(vcc.existing_map) == BSTR_OK);
+    BOOST_TEST(bdestroy(vcc.initial_internal_state) == BSTR_OK);
 }
 viam_carto_lidar_reading new_test_lidar_reading(
     std::string lidar, std::string pcd_path,
@@ -62,6 +69,32 @@ viam_carto_lidar_reading new_test_lidar_reading(
     return sr;
 }
 
+// read_viam_carto_stream reads & destroys the stream, checking that no chunk
+// is larger than max_chunk_size_bytes.
+std::string read_viam_carto_stream(viam_carto_stream *s,
+                                   int max_chunk_size_bytes) {
+    std::string all;
+    while (true) {
+        viam_carto_stream_next_response r;
+        int rc = viam_carto_stream_next(s, max_chunk_size_bytes, &r);
+        if (rc == VIAM_CARTO_STREAM_DONE) {
+            break;
+        }
+        BOOST_TEST(rc == VIAM_CARTO_SUCCESS);
+        if (rc != VIAM_CARTO_SUCCESS) {
+            break;
+        }
+        BOOST_TEST(blength(r.chunk) > 0);
+        BOOST_TEST(blength(r.chunk) <= max_chunk_size_bytes);
+        all += to_std_string(r.chunk);
+        BOOST_TEST(viam_carto_stream_next_response_destroy(&r) ==
+                   VIAM_CARTO_SUCCESS);
+    }
+    BOOST_TEST(viam_carto_stream_destroy(&s) == VIAM_CARTO_SUCCESS);
+    BOOST_TEST(s == nullptr);
+    return all;
+}
+
 viam_carto_algo_config viam_carto_algo_config_setup() {
     struct viam_carto_algo_config ac;
     ac.optimize_on_start = false;
@@ -77,6 +110,8 @@ viam_carto_algo_config viam_carto_algo_config_setup() {
     ac.occupied_space_weight = 20.0;
     ac.translation_weight = 10.0;
     ac.rotation_weight = 1.0;
+    ac.map_resolution_meters = VIAM_CARTO_DEFAULT_MAP_RESOLUTION_METERS;
+    ac.max_map_points = 0;
     return ac;
 }
 
@@ -312,6 +347,32 @@ BOOST_AUTO_TEST_CASE(CartoFacade_init_derive_slam_mode_cloud_story_enabled) {
         BOOST_TEST(viam_carto_terminate(&vc2) == VIAM_CARTO_SUCCESS);
         viam_carto_config_teardown(vcc_updating);
     }
+
+    {
+        // mapping continued from an internal state, despite the apriori map
+        viam_carto *vc;
+        struct viam_carto_config vcc_continued = viam_carto_config_setup(
+            1, VIAM_CARTO_THREE_D, updating_dir.string(), camera,
+            movement_sensor, false, false, "");
+        auto internal_state =
+            updating_dir / fs::path("internal_state") /
+            fs::path("map_data_2022-02-11T01:44:53.1903Z.pbstream");
+        BOOST_TEST(bassigncstr(vcc_continued.initial_internal_state,
+                               internal_state.string().c_str()) == BSTR_OK);
+        BOOST_TEST(viam_carto_init(&vc, lib, vcc_continued, ac) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(vc->slam_mode == VIAM_CARTO_SLAM_MODE_MAPPING);
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>(vc->carto_obj);
+        BOOST_TEST(cf->slam_mode == SlamMode::MAPPING);
+        BOOST_TEST(cf->configuration_basename ==
+                   configuration_mapping_basename);
+        BOOST_TEST(cf->map_builder.map_builder_->pose_graph()
+                       ->GetTrajectoryNodePoses()
+                       .size() > 0);
+        BOOST_TEST(viam_carto_terminate(&vc) == VIAM_CARTO_SUCCESS);
+        viam_carto_config_teardown(vcc_continued);
+    }
     struct viam_carto_algo_config ac_optimize_on_start =
         viam_carto_algo_config_setup();
     ac_optimize_on_start.optimize_on_start = true;
@@ -708,6 +769,89 @@ BOOST_AUTO_TEST_CASE(CartoFacade_demo) {
                    VIAM_CARTO_NOT_IN_STARTED_STATE);
     }
 
+    // GetStats
+    {
+        viam_carto_get_stats_response sr;
+        BOOST_TEST(viam_carto_get_stats(vc, &sr) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+    }
+
+    // GetPointCloudMapStream & GetInternalStateStream
+    {
+        viam_carto_stream *s = nullptr;
+        BOOST_TEST(viam_carto_get_point_cloud_map_stream(vc, nullptr, &s) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+        BOOST_TEST(viam_carto_get_internal_state_stream(vc, &s) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+        BOOST_TEST(s == nullptr);
+    }
+
+    // Optimize
+    {
+        BOOST_TEST(viam_carto_optimize(vc) == VIAM_CARTO_NOT_IN_STARTED_STATE);
+        viam_carto_optimization_status_response osr;
+        BOOST_TEST(viam_carto_get_optimization_status(vc, &osr) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+    }
+
+    // GetMapRevision
+    {
+        viam_carto_map_revision_response mrr;
+        BOOST_TEST(viam_carto_get_map_revision(vc, &mrr) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+    }
+
+    // GetTrajectory
+    {
+        viam_carto_get_trajectory_response tr;
+        BOOST_TEST(viam_carto_get_trajectory(vc, false, 1, &tr) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+    }
+
+    // GetSubmaps
+    {
+        viam_carto_get_submaps_response smr;
+        BOOST_TEST(viam_carto_get_submaps(vc, &smr) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+    }
+
+    // GetConfig
+    {
+        viam_carto_get_config_response gcr;
+        BOOST_TEST(viam_carto_get_config(vc, &gcr) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+    }
+
+    // SwitchToLocalization
+    BOOST_TEST(viam_carto_switch_to_localization(vc) ==
+               VIAM_CARTO_NOT_IN_STARTED_STATE);
+
+    // GetOccupancyGrid
+    {
+        viam_carto_get_occupancy_grid_response ogr;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, nullptr, &ogr) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+    }
+
+    // GetSubmapGrids
+    {
+        viam_carto_submap_id id = {0, 0};
+        viam_carto_get_submap_grids_response sgr;
+        BOOST_TEST(viam_carto_get_submap_grids(vc, &id, 1, &sgr) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+    }
+
+    // SaveMap
+    {
+        auto saved_map = tmp_dir / fs::path("saved_map.pbstream");
+        bstring path = bfromcstr(saved_map.string().c_str());
+        viam_carto_save_map_response sr;
+        BOOST_TEST(viam_carto_save_map(vc, path, false, &sr) ==
+                   VIAM_CARTO_NOT_IN_STARTED_STATE);
+        BOOST_TEST(!fs::exists(saved_map));
+        BOOST_TEST(bdestroy(path) == BSTR_OK);
+    }
+
     // Start
     BOOST_TEST(viam_carto_start(vc) == VIAM_CARTO_SUCCESS);
     // start not allowed if already started
@@ -849,6 +993,43 @@ BOOST_AUTO_TEST_CASE(CartoFacade_demo) {
                    VIAM_CARTO_POINTCLOUD_MAP_EMPTY);
     }
 
+    // GetPointCloudMapStream before successful sensor readings
+    {
+        BOOST_TEST(viam_carto_get_point_cloud_map_stream(nullptr, nullptr,
+                                                         nullptr) ==
+                   VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, nullptr, nullptr) ==
+            VIAM_CARTO_STREAM_INVALID);
+
+        viam_carto_stream *s = nullptr;
+        BOOST_TEST(viam_carto_get_point_cloud_map_stream(vc, nullptr, &s) ==
+                   VIAM_CARTO_POINTCLOUD_MAP_EMPTY);
+        BOOST_TEST(s == nullptr);
+    }
+
+    // Stream argument validation
+    {
+        viam_carto_stream_next_response r;
+        BOOST_TEST(viam_carto_stream_next(nullptr, 1024, &r) ==
+                   VIAM_CARTO_STREAM_INVALID);
+        BOOST_TEST(viam_carto_stream_next_response_destroy(nullptr) ==
+                   VIAM_CARTO_STREAM_NEXT_RESPONSE_INVALID);
+        BOOST_TEST(viam_carto_stream_destroy(nullptr) ==
+                   VIAM_CARTO_STREAM_INVALID);
+
+        viam_carto_stream *s = nullptr;
+        BOOST_TEST(viam_carto_stream_destroy(&s) == VIAM_CARTO_STREAM_INVALID);
+        BOOST_TEST(viam_carto_get_internal_state_stream(vc, &s) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(viam_carto_stream_next(s, 1024, nullptr) ==
+                   VIAM_CARTO_STREAM_NEXT_RESPONSE_INVALID);
+        BOOST_TEST(viam_carto_stream_next(s, 0, &r) ==
+                   VIAM_CARTO_STREAM_MAX_CHUNK_SIZE_INVALID);
+        BOOST_TEST(viam_carto_stream_destroy(&s) == VIAM_CARTO_SUCCESS);
+        BOOST_TEST(s == nullptr);
+    }
+
     // GetInternalState
     int last_internal_state_response_size = 0;
     {
@@ -869,6 +1050,210 @@ BOOST_AUTO_TEST_CASE(CartoFacade_demo) {
                    VIAM_CARTO_SUCCESS);
     }
 
+    // GetStats
+    {
+        BOOST_TEST(viam_carto_get_stats(nullptr, nullptr) ==
+                   VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_get_stats(vc, nullptr) ==
+                   VIAM_CARTO_GET_STATS_RESPONSE_INVALID);
+
+        // before any data is provided the pose graph is empty
+        viam_carto_get_stats_response sr;
+        BOOST_TEST(viam_carto_get_stats(vc, &sr) == VIAM_CARTO_SUCCESS);
+        BOOST_TEST(sr.submaps_finished == 0);
+        BOOST_TEST(sr.submaps_active == 0);
+        BOOST_TEST(sr.nodes == 0);
+        BOOST_TEST(sr.constraints == 0);
+        BOOST_TEST(sr.grid_bytes == 0);
+        BOOST_TEST(sr.last_optimization_time_unix_milli == 0);
+    }
+
+    // Optimize
+    {
+        BOOST_TEST(viam_carto_optimize(nullptr) == VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_get_optimization_status(vc, nullptr) ==
+                   VIAM_CARTO_OPTIMIZATION_STATUS_RESPONSE_INVALID);
+
+        // an empty pose graph can be optimized
+        BOOST_TEST(viam_carto_optimize(vc) == VIAM_CARTO_SUCCESS);
+        viam_carto_optimization_status_response osr;
+        do {
+            std::this_thread::sleep_for(std::chrono::milliseconds(10));
+            BOOST_TEST(viam_carto_get_optimization_status(vc, &osr) ==
+                       VIAM_CARTO_SUCCESS);
+        } while (osr.running);
+        BOOST_TEST(osr.duration_milli >= 0);
+        BOOST_TEST(osr.residual_before == 0);
+        BOOST_TEST(osr.residual_after == 0);
+    }
+
+    // GetMapRevision before successful sensor readings
+    viam_carto_map_revision_response initial_map_revision;
+    {
+        BOOST_TEST(
+            viam_carto_get_map_revision(nullptr, &initial_map_revision) ==
+            VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_get_map_revision(vc, nullptr) ==
+                   VIAM_CARTO_MAP_REVISION_RESPONSE_INVALID);
+
+        // building the map builder is the first revision of the map
+        BOOST_TEST(viam_carto_get_map_revision(vc, &initial_map_revision) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(initial_map_revision.revision >= 1);
+        BOOST_TEST(initial_map_revision.changed_unix_milli > 0);
+    }
+
+    // GetTrajectory before successful sensor readings
+    {
+        viam_carto_get_trajectory_response tr;
+        BOOST_TEST(viam_carto_get_trajectory(nullptr, false, 1, &tr) ==
+                   VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_get_trajectory(vc, false, 1, nullptr) ==
+                   VIAM_CARTO_GET_TRAJECTORY_RESPONSE_INVALID);
+        BOOST_TEST(viam_carto_get_trajectory(vc, false, 0, &tr) ==
+                   VIAM_CARTO_GET_TRAJECTORY_EVERY_N_NODES_INVALID);
+        BOOST_TEST(viam_carto_get_trajectory_response_destroy(nullptr) ==
+                   VIAM_CARTO_GET_TRAJECTORY_RESPONSE_INVALID);
+
+        // before any data is provided the trajectory has no nodes
+        BOOST_TEST(viam_carto_get_trajectory(vc, true, 1, &tr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(tr.nodes_len == 0);
+        BOOST_TEST(tr.nodes == nullptr);
+        BOOST_TEST(viam_carto_get_trajectory_response_destroy(&tr) ==
+                   VIAM_CARTO_SUCCESS);
+    }
+
+    // GetSubmaps before successful sensor readings
+    {
+        viam_carto_get_submaps_response smr;
+        BOOST_TEST(viam_carto_get_submaps(nullptr, &smr) ==
+                   VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_get_submaps(vc, nullptr) ==
+                   VIAM_CARTO_GET_SUBMAPS_RESPONSE_INVALID);
+        BOOST_TEST(viam_carto_get_submaps_response_destroy(nullptr) ==
+                   VIAM_CARTO_GET_SUBMAPS_RESPONSE_INVALID);
+
+        // before any data is provided there are no submaps
+        BOOST_TEST(viam_carto_get_submaps(vc, &smr) == VIAM_CARTO_SUCCESS);
+        BOOST_TEST(smr.submaps_len == 0);
+        BOOST_TEST(smr.submaps == nullptr);
+        BOOST_TEST(viam_carto_get_submaps_response_destroy(&smr) ==
+                   VIAM_CARTO_SUCCESS);
+    }
+
+    // GetConfig
+    {
+        viam_carto_get_config_response gcr;
+        BOOST_TEST(viam_carto_get_config(nullptr, &gcr) ==
+                   VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_get_config(vc, nullptr) ==
+                   VIAM_CARTO_GET_CONFIG_RESPONSE_INVALID);
+        BOOST_TEST(viam_carto_get_config_response_destroy(nullptr) ==
+                   VIAM_CARTO_GET_CONFIG_RESPONSE_INVALID);
+
+        BOOST_TEST(viam_carto_get_config(vc, &gcr) == VIAM_CARTO_SUCCESS);
+        BOOST_TEST(to_std_string(gcr.configuration_basename) ==
+                   viam::carto_facade::configuration_mapping_basename);
+        BOOST_TEST(!to_std_string(gcr.configuration_directory).empty());
+        BOOST_TEST(gcr.optimize_every_n_nodes == ac.optimize_every_n_nodes);
+        BOOST_TEST(gcr.num_range_data == ac.num_range_data);
+        BOOST_TEST(gcr.max_range == ac.max_range, tol);
+        BOOST_TEST(gcr.min_range == ac.min_range, tol);
+        BOOST_TEST(gcr.occupied_space_weight == ac.occupied_space_weight, tol);
+        BOOST_TEST(viam_carto_get_config_response_destroy(&gcr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(gcr.configuration_directory == nullptr);
+        BOOST_TEST(gcr.configuration_basename == nullptr);
+    }
+
+    // GetOccupancyGrid before successful sensor readings
+    {
+        viam_carto_get_occupancy_grid_response ogr;
+        BOOST_TEST(viam_carto_get_occupancy_grid(nullptr, nullptr, &ogr) ==
+                   VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, nullptr, nullptr) ==
+                   VIAM_CARTO_GET_OCCUPANCY_GRID_RESPONSE_INVALID);
+        BOOST_TEST(viam_carto_get_occupancy_grid_response_destroy(nullptr) ==
+                   VIAM_CARTO_GET_OCCUPANCY_GRID_RESPONSE_INVALID);
+
+        // before any data is provided there is nothing to paint
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, nullptr, &ogr) ==
+                   VIAM_CARTO_OCCUPANCY_GRID_EMPTY);
+    }
+
+    // GetSubmapGrids before successful sensor readings
+    {
+        viam_carto_submap_id id = {0, 0};
+        viam_carto_get_submap_grids_response sgr;
+        BOOST_TEST(viam_carto_get_submap_grids(nullptr, &id, 1, &sgr) ==
+                   VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_get_submap_grids(vc, &id, 1, nullptr) ==
+                   VIAM_CARTO_GET_SUBMAP_GRIDS_RESPONSE_INVALID);
+        BOOST_TEST(viam_carto_get_submap_grids(vc, &id, -1, &sgr) ==
+                   VIAM_CARTO_SUBMAP_IDS_INVALID);
+        BOOST_TEST(viam_carto_get_submap_grids(vc, nullptr, 1, &sgr) ==
+                   VIAM_CARTO_SUBMAP_IDS_INVALID);
+        BOOST_TEST(viam_carto_get_submap_grids_response_destroy(nullptr) ==
+                   VIAM_CARTO_GET_SUBMAP_GRIDS_RESPONSE_INVALID);
+
+        // submaps which don't exist are left out
+        BOOST_TEST(viam_carto_get_submap_grids(vc, &id, 1, &sgr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(sgr.grids_len == 0);
+        BOOST_TEST(sgr.grids == nullptr);
+        BOOST_TEST(viam_carto_get_submap_grids_response_destroy(&sgr) ==
+                   VIAM_CARTO_SUCCESS);
+    }
+
+    // SwitchToLocalization before successful sensor readings
+    BOOST_TEST(viam_carto_switch_to_localization(nullptr) ==
+               VIAM_CARTO_VC_INVALID);
+    // there is nothing to localize against, so mapping continues
+    BOOST_TEST(viam_carto_switch_to_localization(vc) ==
+               VIAM_CARTO_FROZEN_MAP_EMPTY);
+    BOOST_TEST(vc->slam_mode == VIAM_CARTO_SLAM_MODE_MAPPING);
+
+    // SetPose
+    {
+        viam_carto_pose pose = {1000, 2000, 45};
+        BOOST_TEST(viam_carto_set_pose(nullptr, &pose) ==
+                   VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_set_pose(vc, nullptr) ==
+                   VIAM_CARTO_POSE_INVALID);
+        // setting the pose is only supported in localizing mode
+        BOOST_TEST(viam_carto_set_pose(vc, &pose) ==
+                   VIAM_CARTO_NOT_IN_LOCALIZING_MODE);
+    }
+
+    // SaveMap
+    {
+        auto saved_map = tmp_dir / fs::path("saved_map.pbstream");
+        bstring path = bfromcstr(saved_map.string().c_str());
+        bstring empty_path = bfromcstr("");
+        viam_carto_save_map_response sr;
+        BOOST_TEST(viam_carto_save_map(nullptr, path, false, &sr) ==
+                   VIAM_CARTO_VC_INVALID);
+        BOOST_TEST(viam_carto_save_map(vc, nullptr, false, &sr) ==
+                   VIAM_CARTO_SAVE_MAP_PATH_INVALID);
+        BOOST_TEST(viam_carto_save_map(vc, empty_path, false, &sr) ==
+                   VIAM_CARTO_SAVE_MAP_PATH_INVALID);
+        BOOST_TEST(viam_carto_save_map(vc, path, false, nullptr) ==
+                   VIAM_CARTO_SAVE_MAP_RESPONSE_INVALID);
+
+        // an empty map can be saved, with & without a final optimization
+        BOOST_TEST(viam_carto_save_map(vc, path, false, &sr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(sr.nodes == 0);
+        BOOST_TEST(fs::file_size(saved_map) > 0);
+        BOOST_TEST(viam_carto_save_map(vc, path, true, &sr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(sr.nodes == 0);
+        BOOST_TEST(fs::remove(saved_map));
+        BOOST_TEST(bdestroy(path) == BSTR_OK);
+        BOOST_TEST(bdestroy(empty_path) == BSTR_OK);
+    }
+
     // GetPosition unchanged from failed AddLidarReading requests
     {
         viam_carto_get_position_response pr;
@@ -954,6 +1339,15 @@ BOOST_AUTO_TEST_CASE(CartoFacade_demo) {
                    VIAM_CARTO_SUCCESS);
     }
 
+    // GetMapRevision after the second reading was inserted into the submaps
+    {
+        viam_carto_map_revision_response mrr;
+        BOOST_TEST(viam_carto_get_map_revision(vc, &mrr) == VIAM_CARTO_SUCCESS);
+        BOOST_TEST(mrr.revision > initial_map_revision.revision);
+        BOOST_TEST(mrr.changed_unix_milli >=
+                   initial_map_revision.changed_unix_milli);
+    }
+
     // GetPosition returning origin position from 2 successful AddLidarReading
     // requests
     {
@@ -985,6 +1379,13 @@ BOOST_AUTO_TEST_CASE(CartoFacade_demo) {
         pcl::fromPCLPointCloud2(blob, *cloud);
         BOOST_TEST(cloud != nullptr);
         BOOST_TEST(cloud->points.size() != 0);
+
+        // the streamed pcd is the same as the one returned as a whole
+        viam_carto_stream *stream = nullptr;
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, nullptr, &stream) ==
+            VIAM_CARTO_SUCCESS);
+        BOOST_TEST(read_viam_carto_stream(stream, 1024) == s);
         BOOST_TEST(viam_carto_get_point_cloud_map_response_destroy(&mr) ==
                    VIAM_CARTO_SUCCESS);
     }
@@ -998,6 +1399,14 @@ BOOST_AUTO_TEST_CASE(CartoFacade_demo) {
         last_internal_state_response_size = blength(isr.internal_state);
         BOOST_TEST(viam_carto_get_internal_state_response_destroy(&isr) ==
                    VIAM_CARTO_SUCCESS);
+
+        // the streamed internal state has the same size as the one returned
+        // as a whole
+        viam_carto_stream *stream = nullptr;
+        BOOST_TEST(viam_carto_get_internal_state_stream(vc, &stream) ==
+                   VIAM_CARTO_SUCCESS);
+        int stream_size = read_viam_carto_stream(stream, 1024).size();
+        BOOST_TEST(stream_size == last_internal_state_response_size);
     }
 
     // third sensor reading
@@ -1028,6 +1437,283 @@ BOOST_AUTO_TEST_CASE(CartoFacade_demo) {
                    VIAM_CARTO_SUCCESS);
     }
 
+    // GetTrajectory after successful sensor readings
+    {
+        viam_carto_get_trajectory_response tr;
+        BOOST_TEST(viam_carto_get_trajectory(vc, false, 1, &tr) ==
+                   VIAM_CARTO_SUCCESS);
+        for (int i = 0; i < tr.nodes_len; i++) {
+            BOOST_TEST(tr.nodes[i].trajectory_id == 0);
+            BOOST_TEST(tr.nodes[i].z == 0);
+            if (i > 0) {
+                BOOST_TEST(tr.nodes[i].node_index >
+                           tr.nodes[i - 1].node_index);
+                BOOST_TEST(tr.nodes[i].time_unix_milli >=
+                           tr.nodes[i - 1].time_unix_milli);
+            }
+        }
+
+        // downsampling keeps the last node
+        viam_carto_get_trajectory_response downsampled;
+        BOOST_TEST(viam_carto_get_trajectory(vc, false, 1000, &downsampled) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(downsampled.nodes_len <= std::min(tr.nodes_len, 2));
+        if (tr.nodes_len > 0) {
+            const auto &last = downsampled.nodes[downsampled.nodes_len - 1];
+            BOOST_TEST(last.node_index ==
+                       tr.nodes[tr.nodes_len - 1].node_index);
+        }
+        BOOST_TEST(viam_carto_get_trajectory_response_destroy(&downsampled) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(viam_carto_get_trajectory_response_destroy(&tr) ==
+                   VIAM_CARTO_SUCCESS);
+    }
+
+    // GetSubmaps after successful sensor readings
+    {
+        viam_carto_get_submaps_response smr;
+        BOOST_TEST(viam_carto_get_submaps(vc, &smr) == VIAM_CARTO_SUCCESS);
+        BOOST_TEST(smr.submaps_len > 0);
+        for (int i = 0; i < smr.submaps_len; i++) {
+            BOOST_TEST(smr.submaps[i].trajectory_id == 0);
+            BOOST_TEST(smr.submaps[i].submap_index == i);
+            BOOST_TEST(smr.submaps[i].version > 0);
+            BOOST_TEST(smr.submaps[i].resolution_meters ==
+                       VIAM_CARTO_DEFAULT_MAP_RESOLUTION_METERS);
+            BOOST_TEST(smr.submaps[i].min_x < smr.submaps[i].max_x);
+            BOOST_TEST(smr.submaps[i].min_y < smr.submaps[i].max_y);
+        }
+        BOOST_TEST(viam_carto_get_submaps_response_destroy(&smr) ==
+                   VIAM_CARTO_SUCCESS);
+    }
+
+    // GetOccupancyGrid after successful sensor readings
+    {
+        viam_carto_get_occupancy_grid_response ogr;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, nullptr, &ogr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(ogr.width > 0);
+        BOOST_TEST(ogr.height > 0);
+        BOOST_TEST(ogr.resolution_meters ==
+                   VIAM_CARTO_DEFAULT_MAP_RESOLUTION_METERS);
+        BOOST_TEST(blength(ogr.cells) == ogr.width * ogr.height);
+        // the origin of the map is within the grid
+        BOOST_TEST(ogr.origin_x < 0);
+        BOOST_TEST(ogr.origin_y < 0);
+        BOOST_TEST(ogr.origin_x + ogr.width * ogr.resolution_meters > 0);
+        BOOST_TEST(ogr.origin_y + ogr.height * ogr.resolution_meters > 0);
+        auto cells = to_std_string(ogr.cells);
+        int known = 0;
+        for (unsigned char cell : cells) {
+            if (cell == VIAM_CARTO_OCCUPANCY_GRID_UNKNOWN_CELL) {
+                continue;
+            }
+            BOOST_TEST(cell <= 100);
+            known++;
+        }
+        BOOST_TEST(known > 0);
+        BOOST_TEST(viam_carto_get_occupancy_grid_response_destroy(&ogr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(ogr.cells == nullptr);
+    }
+
+    // GetSubmapGrids after successful sensor readings
+    {
+        viam_carto_get_submaps_response smr;
+        BOOST_TEST(viam_carto_get_submaps(vc, &smr) == VIAM_CARTO_SUCCESS);
+        BOOST_TEST(smr.submaps_len > 0);
+        // the last id doesn't exist
+        std::vector<viam_carto_submap_id> ids;
+        for (int i = 0; i < smr.submaps_len; i++) {
+            ids.push_back(
+                {smr.submaps[i].trajectory_id, smr.submaps[i].submap_index});
+        }
+        ids.push_back({0, smr.submaps_len});
+
+        viam_carto_get_submap_grids_response sgr;
+        BOOST_TEST(viam_carto_get_submap_grids(vc, ids.data(), ids.size(),
+                                               &sgr) == VIAM_CARTO_SUCCESS);
+        BOOST_TEST(sgr.grids_len == smr.submaps_len);
+        for (int i = 0; i < sgr.grids_len; i++) {
+            auto &g = sgr.grids[i];
+            BOOST_TEST(g.trajectory_id == smr.submaps[i].trajectory_id);
+            BOOST_TEST(g.submap_index == smr.submaps[i].submap_index);
+            BOOST_TEST(g.version >= smr.submaps[i].version);
+            BOOST_TEST(g.resolution_meters ==
+                       VIAM_CARTO_DEFAULT_MAP_RESOLUTION_METERS);
+            BOOST_TEST(g.width > 0);
+            BOOST_TEST(g.height > 0);
+            BOOST_TEST(blength(g.cells) == g.width * g.height);
+        }
+        BOOST_TEST(viam_carto_get_submap_grids_response_destroy(&sgr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(sgr.grids == nullptr);
+        BOOST_TEST(viam_carto_get_submaps_response_destroy(&smr) ==
+                   VIAM_CARTO_SUCCESS);
+    }
+
+    // GetOccupancyGrid & GetPointCloudMapStream with map options
+    {
+        viam_carto_map_options invalid = {};
+        invalid.resolution_meters = VIAM_CARTO_MIN_MAP_RESOLUTION_METERS / 2;
+        invalid.max_points = 0;
+        viam_carto_get_occupancy_grid_response ogr;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, &invalid, &ogr) ==
+                   VIAM_CARTO_MAP_OPTIONS_INVALID);
+        viam_carto_stream *stream = nullptr;
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, &invalid, &stream) ==
+            VIAM_CARTO_MAP_OPTIONS_INVALID);
+        BOOST_TEST(stream == nullptr);
+        invalid.resolution_meters = 0;
+        invalid.max_points = -1;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, &invalid, &ogr) ==
+                   VIAM_CARTO_MAP_OPTIONS_INVALID);
+
+        viam_carto_get_occupancy_grid_response fine;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, nullptr, &fine) ==
+                   VIAM_CARTO_SUCCESS);
+
+        // a coarser resolution paints fewer cells
+        viam_carto_map_options coarse = {};
+        coarse.resolution_meters = 4 * VIAM_CARTO_DEFAULT_MAP_RESOLUTION_METERS;
+        coarse.max_points = 0;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, &coarse, &ogr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(ogr.resolution_meters == coarse.resolution_meters);
+        BOOST_TEST(ogr.width * ogr.height < fine.width * fine.height);
+        BOOST_TEST(viam_carto_get_occupancy_grid_response_destroy(&ogr) ==
+                   VIAM_CARTO_SUCCESS);
+
+        // the point budget coarsens the map until it fits
+        viam_carto_map_options budget = {};
+        budget.resolution_meters = 0;
+        budget.max_points = fine.width * fine.height / 4;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, &budget, &ogr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(ogr.resolution_meters > fine.resolution_meters);
+        BOOST_TEST(ogr.width * ogr.height <= budget.max_points);
+        BOOST_TEST(viam_carto_get_occupancy_grid_response_destroy(&ogr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(viam_carto_get_occupancy_grid_response_destroy(&fine) ==
+                   VIAM_CARTO_SUCCESS);
+
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, &coarse, &stream) ==
+            VIAM_CARTO_SUCCESS);
+        BOOST_TEST(stream != nullptr);
+        BOOST_TEST(read_viam_carto_stream(stream, 1024).size() > 0);
+    }
+
+    // GetPointCloudMapStream in every format
+    {
+        viam_carto_map_options options = {};
+        options.format = VIAM_CARTO_POINT_CLOUD_FORMAT_PLY + 1;
+        viam_carto_stream *stream = nullptr;
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, &options, &stream) ==
+            VIAM_CARTO_MAP_OPTIONS_INVALID);
+        BOOST_TEST(stream == nullptr);
+
+        options.format = VIAM_CARTO_POINT_CLOUD_FORMAT_PCD;
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, &options, &stream) ==
+            VIAM_CARTO_SUCCESS);
+        pcl::PCLPointCloud2 pcd;
+        BOOST_TEST(viam::carto_facade::util::read_pcd(
+                       read_viam_carto_stream(stream, 1024), pcd) == 0);
+        BOOST_TEST(pcl::getFieldsList(pcd) == "x y z rgb");
+
+        for (int format : {VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_BINARY,
+                           VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_ASCII}) {
+            options.format = format;
+            BOOST_TEST(
+                viam_carto_get_point_cloud_map_stream(vc, &options, &stream) ==
+                VIAM_CARTO_SUCCESS);
+            pcl::PCLPointCloud2 blob;
+            BOOST_TEST(viam::carto_facade::util::read_pcd(
+                           read_viam_carto_stream(stream, 1024), blob) == 0);
+            BOOST_TEST(pcl::getFieldsList(blob) == "x y z probability");
+            BOOST_TEST(blob.width == pcd.width);
+        }
+
+        options.format = VIAM_CARTO_POINT_CLOUD_FORMAT_PCD_BINARY_COMPRESSED;
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, &options, &stream) ==
+            VIAM_CARTO_SUCCESS);
+        std::string compressed = read_viam_carto_stream(stream, 1024);
+        BOOST_TEST(compressed.find("DATA binary_compressed\n") !=
+                   std::string::npos);
+
+        options.format = VIAM_CARTO_POINT_CLOUD_FORMAT_PLY;
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, &options, &stream) ==
+            VIAM_CARTO_SUCCESS);
+        std::string ply = read_viam_carto_stream(stream, 1024);
+        BOOST_TEST(ply.rfind("ply\nformat binary_little_endian 1.0\n", 0) ==
+                   0);
+        std::string vertices =
+            "element vertex " + std::to_string(pcd.width) + "\n";
+        BOOST_TEST(ply.find(vertices) != std::string::npos);
+    }
+
+    // GetOccupancyGrid & GetPointCloudMapStream within a region
+    {
+        viam_carto_map_options region = {};
+        region.has_region = true;
+        region.region.min_x = 1000;
+        region.region.min_y = 0;
+        region.region.max_x = 0;
+        region.region.max_y = 1000;
+        viam_carto_get_occupancy_grid_response ogr;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, &region, &ogr) ==
+                   VIAM_CARTO_MAP_OPTIONS_INVALID);
+
+        // a region far away from the map has no submaps to paint
+        region.region.min_x = 1e6;
+        region.region.max_x = 1e6 + 1000;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, &region, &ogr) ==
+                   VIAM_CARTO_OCCUPANCY_GRID_EMPTY);
+        viam_carto_stream *stream = nullptr;
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, &region, &stream) ==
+            VIAM_CARTO_POINTCLOUD_MAP_EMPTY);
+
+        // the grid only holds the cells within a square of 1 meter around the
+        // origin
+        region.region.min_x = -500;
+        region.region.min_y = -500;
+        region.region.max_x = 500;
+        region.region.max_y = 500;
+        BOOST_TEST(viam_carto_get_occupancy_grid(vc, &region, &ogr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(ogr.width <= 20);
+        BOOST_TEST(ogr.height <= 20);
+        BOOST_TEST(ogr.origin_x >= -0.5 - ogr.resolution_meters);
+        BOOST_TEST(ogr.origin_y >= -0.5 - ogr.resolution_meters);
+        BOOST_TEST(ogr.origin_x + ogr.width * ogr.resolution_meters <=
+                   0.5 + ogr.resolution_meters);
+        BOOST_TEST(ogr.origin_y + ogr.height * ogr.resolution_meters <=
+                   0.5 + ogr.resolution_meters);
+        BOOST_TEST(viam_carto_get_occupancy_grid_response_destroy(&ogr) ==
+                   VIAM_CARTO_SUCCESS);
+
+        // every point of the pcd is within the region
+        BOOST_TEST(
+            viam_carto_get_point_cloud_map_stream(vc, &region, &stream) ==
+            VIAM_CARTO_SUCCESS);
+        std::string pcd = read_viam_carto_stream(stream, 1024);
+        pcl::PCLPointCloud2 blob;
+        BOOST_TEST(viam::carto_facade::util::read_pcd(pcd, blob) == 0);
+        pcl::PointCloud<pcl::PointXYZRGB>::Ptr cloud(
+            new pcl::PointCloud<pcl::PointXYZRGB>);
+        pcl::fromPCLPointCloud2(blob, *cloud);
+        for (const auto &point : cloud->points) {
+            BOOST_TEST(std::abs(point.x) <= 0.5 + 1e-3);
+            BOOST_TEST(std::abs(point.y) <= 0.5 + 1e-3);
+        }
+    }
+
     {
         viam_carto_get_internal_state_response isr;
         BOOST_TEST(viam_carto_get_internal_state(vc, &isr) ==
@@ -1042,6 +1728,42 @@ BOOST_AUTO_TEST_CASE(CartoFacade_demo) {
                    VIAM_CARTO_SUCCESS);
     }
 
+    // SwitchToLocalization after successful sensor readings
+    {
+        viam::carto_facade::CartoFacade *cf =
+            static_cast<viam::carto_facade::CartoFacade *>(vc->carto_obj);
+        int mapping_trajectory_id = cf->map_builder.trajectory_id;
+        BOOST_TEST(viam_carto_switch_to_localization(vc) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(cf->slam_mode == viam::carto_facade::SlamMode::LOCALIZING);
+        BOOST_TEST(vc->slam_mode == VIAM_CARTO_SLAM_MODE_LOCALIZING);
+        BOOST_TEST(cf->map_builder.trajectory_id != mapping_trajectory_id);
+        BOOST_TEST(cf->map_builder.GetMaxSubmapsToKeep() ==
+                   ac.max_submaps_to_keep);
+        // Freezing is applied by the pose graph in the background
+        cf->map_builder.map_builder_->pose_graph()->RunFinalOptimization();
+        using TrajectoryState =
+            cartographer::mapping::PoseGraphInterface::TrajectoryState;
+        auto trajectory_states =
+            cf->map_builder.map_builder_->pose_graph()->GetTrajectoryStates();
+        BOOST_TEST((trajectory_states.at(mapping_trajectory_id) ==
+                    TrajectoryState::FROZEN));
+        BOOST_TEST(viam_carto_switch_to_localization(vc) ==
+                   VIAM_CARTO_ALREADY_IN_LOCALIZING_MODE);
+        BOOST_TEST(vc->slam_mode == VIAM_CARTO_SLAM_MODE_LOCALIZING);
+
+        // the pose can be set against the frozen map
+        viam_carto_pose pose = {0, 0, 0};
+        BOOST_TEST(viam_carto_set_pose(vc, &pose) == VIAM_CARTO_SUCCESS);
+
+        // the map is cached when switching
+        viam_carto_get_point_cloud_map_response mr;
+        BOOST_TEST(viam_carto_get_point_cloud_map(vc, &mr) ==
+                   VIAM_CARTO_SUCCESS);
+        BOOST_TEST(viam_carto_get_point_cloud_map_response_destroy(&mr) ==
+                   VIAM_CARTO_SUCCESS);
+    }
+
     // Stop
     BOOST_TEST(viam_carto_stop(vc) == VIAM_CARTO_SUCCESS);
     // stop not allowed if not started
@@ -1115,9 +1837,30 @@ BOOST_AUTO_TEST_CASE(CartoFacade_config) {
     BOOST_TEST(c.movement_sensor == "imu");
     BOOST_TEST(c.cloud_story_enabled == true);
     BOOST_TEST(c.enable_mapping == true);
+    BOOST_TEST(!c.initial_pose.has_value());
+    BOOST_TEST(bdestroy(c.component_reference) == BSTR_OK);
+
+    // the initial pose is converted from millimeters & degrees
+    vcc.has_initial_pose = true;
+    vcc.initial_pose = {1500, -250, 90};
+    struct config c_initial_pose =
+        viam::carto_facade::from_viam_carto_config(vcc);
+    BOOST_TEST(c_initial_pose.initial_pose.has_value());
+    auto initial_pose = c_initial_pose.initial_pose.value();
+    BOOST_TEST(initial_pose.translation().x() == 1.5, tol);
+    BOOST_TEST(initial_pose.translation().y() == -0.25, tol);
+    BOOST_TEST(initial_pose.translation().z() == 0, tol);
+    BOOST_TEST(cartographer::transform::GetYaw(initial_pose) == M_PI / 2, tol);
+    BOOST_TEST(bdestroy(c_initial_pose.component_reference) == BSTR_OK);
+
+    // an initial pose which isn't finite is invalid
+    vcc.initial_pose = {std::nan(""), 0, 0};
+    BOOST_CHECK_EXCEPTION(viam::carto_facade::from_viam_carto_config(vcc), int,
+                          [&](int code) {
+                              return code == VIAM_CARTO_POSE_INVALID;
+                          });
 
     viam_carto_config_teardown(vcc);
-    BOOST_TEST(bdestroy(c.component_reference) == BSTR_OK);
 
     fs::remove_all(tmp_dir);
     // library terminate
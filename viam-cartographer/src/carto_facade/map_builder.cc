@@ -5,6 +5,8 @@
 
 #include "cartographer/common/configuration_file_resolver.h"
 #include "cartographer/common/lua_parameter_dictionary.h"
+#include "cartographer/common/math.h"
+#include "cartographer/common/time.h"
 #include "cartographer/io/proto_stream.h"
 #include "cartographer/mapping/2d/grid_2d.h"
 #include "cartographer/mapping/internal/local_slam_result_data.h"
@@ -61,6 +63,62 @@ void MapBuilder::BuildMapBuilder() {
     VLOG(1) << "MapBuilder::BuildMapBuilder";
     map_builder_ =
         cartographer::mapping::CreateMapBuilder(map_builder_options_);
+    last_optimization_time_unix_milli = 0;
+    // a new map builder holds a new map
+    IncrementMapRevision();
+    map_builder_->pose_graph()->SetGlobalSlamOptimizationCallback(
+        [this](const std::map<int, cartographer::mapping::SubmapId> &,
+               const std::map<int, cartographer::mapping::NodeId> &) {
+            last_optimization_time_unix_milli =
+                std::chrono::duration_cast<std::chrono::milliseconds>(
+                    std::chrono::system_clock::now().time_since_epoch())
+                    .count();
+            // the optimization moved the submaps
+            IncrementMapRevision();
+        });
+}
+
+double MapBuilder::GetConstraintResidual() {
+    auto pose_graph = map_builder_->pose_graph();
+    auto submap_poses = pose_graph->GetAllSubmapPoses();
+    auto node_poses = pose_graph->GetTrajectoryNodePoses();
+    double residual = 0;
+    for (const auto &constraint : pose_graph->constraints()) {
+        if (!submap_poses.Contains(constraint.submap_id) ||
+            !node_poses.Contains(constraint.node_id)) {
+            continue;
+        }
+        const cartographer::transform::Rigid3d relative_pose =
+            submap_poses.at(constraint.submap_id).pose.inverse() *
+            node_poses.at(constraint.node_id).global_pose;
+        const cartographer::transform::Rigid3d error =
+            constraint.pose.zbar_ij.inverse() * relative_pose;
+        residual += cartographer::common::Pow2(
+                        constraint.pose.translation_weight) *
+                        error.translation().squaredNorm() +
+                    cartographer::common::Pow2(constraint.pose.rotation_weight) *
+                        cartographer::common::Pow2(
+                            cartographer::transform::GetAngle(error));
+    }
+    return residual;
+}
+
+int64_t MapBuilder::GetLastOptimizationTimeUnixMilli() {
+    return last_optimization_time_unix_milli;
+}
+
+void MapBuilder::IncrementMapRevision() {
+    std::lock_guard<std::mutex> lk(map_revision_mutex);
+    map_revision++;
+    map_changed_unix_milli =
+        std::chrono::duration_cast<std::chrono::milliseconds>(
+            std::chrono::system_clock::now().time_since_epoch())
+            .count();
+}
+
+std::pair<int64_t, int64_t> MapBuilder::GetMapRevision() {
+    std::lock_guard<std::mutex> lk(map_revision_mutex);
+    return {map_revision, map_changed_unix_milli};
 }
 
 void MapBuilder::LoadMapFromFile(std::string internal_state_filename,
@@ -100,14 +158,75 @@ void MapBuilder::AddSensorData(
     trajectory_builder->AddSensorData(kRangeSensorId.id, measurement);
 }
 
-void MapBuilder::StartLidarTrajectoryBuilder() {
+void MapBuilder::StartLidarTrajectoryBuilder(
+    std::optional<cartographer::mapping::proto::InitialTrajectoryPose>
+        initial_trajectory_pose) {
     VLOG(1) << "MapBuilder::StartLidarTrajectoryBuilder";
+    auto trajectory_options = trajectory_builder_options_;
+    if (initial_trajectory_pose.has_value()) {
+        *trajectory_options.mutable_initial_trajectory_pose() =
+            initial_trajectory_pose.value();
+    }
     trajectory_id = map_builder_->AddTrajectoryBuilder(
-        {kRangeSensorId}, trajectory_builder_options_,
-        GetLocalSlamResultCallback());
+        {kRangeSensorId}, trajectory_options, GetLocalSlamResultCallback());
     VLOG(1) << "Using trajectory ID: " << trajectory_id;
 
     trajectory_builder = map_builder_->GetTrajectoryBuilder(trajectory_id);
+    {
+        // The local pose of the previous trajectory is meaningless in the
+        // local frame of the new one
+        std::lock_guard<std::mutex> lk(local_slam_result_pose_mutex);
+        local_slam_result_pose = cartographer::transform::Rigid3d();
+    }
+}
+
+void MapBuilder::FinishLidarTrajectory() {
+    VLOG(1) << "MapBuilder::FinishLidarTrajectory: " << trajectory_id;
+    map_builder_->FinishTrajectory(trajectory_id);
+}
+
+void MapBuilder::FreezeLidarTrajectory() {
+    VLOG(1) << "MapBuilder::FreezeLidarTrajectory: " << trajectory_id;
+    // Only the PoseGraph, not the PoseGraphInterface returned by the
+    // map builder, can freeze a trajectory
+    auto pose_graph = dynamic_cast<cartographer::mapping::PoseGraph *>(
+        map_builder_->pose_graph());
+    if (pose_graph == nullptr) {
+        throw std::runtime_error("pose graph can't freeze trajectories");
+    }
+    pose_graph->FreezeTrajectory(trajectory_id);
+}
+
+bool MapBuilder::GetInitialTrajectoryPose(
+    const cartographer::transform::Rigid3d &pose,
+    cartographer::mapping::proto::InitialTrajectoryPose
+        *initial_trajectory_pose) {
+    auto pose_graph = map_builder_->pose_graph();
+    auto node_poses = pose_graph->GetTrajectoryNodePoses();
+    for (const auto &trajectory_state : pose_graph->GetTrajectoryStates()) {
+        if (trajectory_state.second !=
+            cartographer::mapping::PoseGraphInterface::TrajectoryState::
+                FROZEN) {
+            continue;
+        }
+        auto nodes = node_poses.trajectory(trajectory_state.first);
+        if (nodes.begin() == nodes.end() ||
+            !nodes.begin()->data.constant_pose_data.has_value()) {
+            continue;
+        }
+        // Cartographer places the new trajectory relative to the pose of the
+        // frozen trajectory at the timestamp, which is the pose of its first
+        // node for the timestamp of its first node.
+        const auto &first_node = nodes.begin()->data;
+        *initial_trajectory_pose->mutable_relative_pose() =
+            cartographer::transform::ToProto(first_node.global_pose.inverse() *
+                                             pose);
+        initial_trajectory_pose->set_to_trajectory_id(trajectory_state.first);
+        initial_trajectory_pose->set_timestamp(cartographer::common::ToUniversal(
+            first_node.constant_pose_data.value().time));
+        return true;
+    }
+    return false;
 }
 
 cartographer::mapping::MapBuilderInterface::LocalSlamResultCallback
@@ -117,11 +236,17 @@ MapBuilder::GetLocalSlamResultCallback() {
                ::cartographer::sensor::RangeData range_data_in_local,
                const std::unique_ptr<
                    const cartographer::mapping::TrajectoryBuilderInterface::
-                       InsertionResult>) {
+                       InsertionResult>
+                   insertion_result) {
         {
             std::lock_guard<std::mutex> lk(local_slam_result_pose_mutex);
             local_slam_result_pose = local_pose;
         }
+        // the range data was inserted into the submaps, changing their
+        // versions, unless the motion filter dropped it
+        if (insertion_result != nullptr) {
+            IncrementMapRevision();
+        }
     };
 }
 
@@ -2,7 +2,10 @@
 #ifndef VIAM_CARTO_FACADE_MAP_BUILDER_H
 #define VIAM_CARTO_FACADE_MAP_BUILDER_H
 
+#include <atomic>
+#include <optional>
 #include <string>
+#include <utility>
 
 #include "cartographer/io/proto_stream.h"
 #include "cartographer/mapping/2d/grid_2d.h"
@@ -57,7 +60,29 @@ class MapBuilder {
     // string if it fails.
     std::string TryFileClose(std::ifstream &file, std::string filename);
 
-    void StartLidarTrajectoryBuilder();
+    // StartLidarTrajectoryBuilder starts a new trajectory, at the initial
+    // trajectory pose if one is provided & at the origin otherwise.
+    void StartLidarTrajectoryBuilder(
+        std::optional<cartographer::mapping::proto::InitialTrajectoryPose>
+            initial_trajectory_pose = std::nullopt);
+
+    // FinishLidarTrajectory finishes the current trajectory, so that no more
+    // sensor data is added to it.
+    void FinishLidarTrajectory();
+
+    // FreezeLidarTrajectory freezes the current trajectory, so that its nodes
+    // & submaps are kept fixed by the optimization & can be localized against.
+    // The trajectory should be finished first.
+    void FreezeLidarTrajectory();
+
+    // GetInitialTrajectoryPose returns the initial trajectory pose which
+    // starts a new trajectory at the given pose in the frame of the map. It
+    // returns false if there is no frozen trajectory with nodes to anchor the
+    // new trajectory to.
+    bool GetInitialTrajectoryPose(
+        const cartographer::transform::Rigid3d &pose,
+        cartographer::mapping::proto::InitialTrajectoryPose
+            *initial_trajectory_pose);
 
     // SetStartTime sets the start_time to the time stamp from the first sensor
     // file that is being read in.
@@ -75,8 +100,23 @@ class MapBuilder {
     // Currently assumes the sensor data is coming from the lidar
     void AddSensorData(cartographer::sensor::TimedPointCloudData measurement);
 
+    // GetConstraintResidual returns the sum of the squared errors of the
+    // constraints of the pose graph, weighted as in the optimization problem,
+    // for the current global poses of the submaps & nodes.
+    double GetConstraintResidual();
+
+    // GetLastOptimizationTimeUnixMilli returns the time at which the last
+    // global optimization of the pose graph finished, 0 if none has run yet.
+    int64_t GetLastOptimizationTimeUnixMilli();
+
+    // GetMapRevision returns the revision of the map, which is incremented
+    // whenever range data is inserted into the submaps or an optimization
+    // moves them, along with when it was last incremented.
+    std::pair<int64_t, int64_t> GetMapRevision();
+
     // GetLocalSlamResultCallback saves the local pose in the
-    // local_slam_result_poses array.
+    // local_slam_result_poses array & increments the revision of the map
+    // when the range data was inserted into the submaps.
     cartographer::mapping::MapBuilderInterface::LocalSlamResultCallback
     GetLocalSlamResultCallback();
 
@@ -121,6 +161,13 @@ class MapBuilder {
         cartographer::transform::Rigid3d();
     ;
     double start_time = -1;
+    std::atomic<int64_t> last_optimization_time_unix_milli{0};
+    // IncrementMapRevision increments the revision of the map & records the
+    // time at which it did.
+    void IncrementMapRevision();
+    std::mutex map_revision_mutex;
+    int64_t map_revision = 0;
+    int64_t map_changed_unix_milli = 0;
 };
 }  // namespace carto_facade
 }  // namespace viam
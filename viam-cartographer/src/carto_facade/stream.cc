@@ -0,0 +1,50 @@
+#include "stream.h"
+
+#include <algorithm>
+#include <cstdio>
+#include <stdexcept>
+
+namespace viam {
+namespace carto_facade {
+namespace stream {
+
+StringStream::StringStream(std::string data) : data(std::move(data)) {}
+
+bool StringStream::Next(size_t max_bytes, std::string &chunk) {
+    if (offset >= data.size()) {
+        return false;
+    }
+    size_t n = std::min(max_bytes, data.size() - offset);
+    chunk.append(data, offset, n);
+    offset += n;
+    return true;
+}
+
+FileStream::FileStream(std::string filename)
+    : file(filename, std::ios::in | std::ios::binary) {
+    if (!file.is_open()) {
+        throw std::runtime_error("Failed to open " + filename +
+                                 " as ifstream object.");
+    }
+    // The open ifstream keeps the contents readable after the file is
+    // removed.
+    if (std::remove(filename.c_str()) != 0) {
+        throw std::runtime_error("Failed to delete " + filename);
+    }
+}
+
+bool FileStream::Next(size_t max_bytes, std::string &chunk) {
+    size_t offset = chunk.size();
+    chunk.resize(offset + max_bytes);
+    file.read(&chunk[offset], max_bytes);
+    size_t n = file.gcount();
+    chunk.resize(offset + n);
+    if (file.bad()) {
+        throw std::runtime_error("Failed to read from ifstream object.");
+    }
+    return n > 0;
+}
+
+}  // namespace stream
+}  // namespace carto_facade
+}  // namespace viam
@@ -0,0 +1,49 @@
+// This is an experimental integration of cartographer into RDK.
+#ifndef VIAM_CARTO_FACADE_STREAM_H
+#define VIAM_CARTO_FACADE_STREAM_H
+
+#include <fstream>
+#include <string>
+
+namespace viam {
+namespace carto_facade {
+namespace stream {
+
+// Stream yields an export in chunks, so that the export never needs to be
+// held in memory as a whole, neither in C++ nor across cgo.
+class Stream {
+   public:
+    virtual ~Stream() = default;
+
+    // Next appends at most max_bytes bytes of the export to chunk. Returns
+    // false once the whole export has been read.
+    virtual bool Next(size_t max_bytes, std::string &chunk) = 0;
+};
+
+// StringStream yields an export which is already held in memory.
+class StringStream : public Stream {
+   public:
+    explicit StringStream(std::string data);
+    bool Next(size_t max_bytes, std::string &chunk) override;
+
+   private:
+    std::string data;
+    size_t offset = 0;
+};
+
+// FileStream yields the contents of a file. The file is removed as soon as
+// it has been opened, so that it never outlives the stream.
+class FileStream : public Stream {
+   public:
+    explicit FileStream(std::string filename);
+    bool Next(size_t max_bytes, std::string &chunk) override;
+
+   private:
+    std::ifstream file;
+};
+
+}  // namespace stream
+}  // namespace carto_facade
+}  // namespace viam
+
+#endif  // VIAM_CARTO_FACADE_STREAM_H
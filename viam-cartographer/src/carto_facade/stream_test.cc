@@ -0,0 +1,69 @@
+#include "stream.h"
+
+#include <boost/filesystem.hpp>
+#include <boost/test/unit_test.hpp>
+#include <fstream>
+#include <string>
+
+namespace fs = boost::filesystem;
+
+namespace viam {
+namespace carto_facade {
+namespace stream {
+
+BOOST_AUTO_TEST_SUITE(CartoFacade_stream)
+
+// read_all reads the stream in chunks of at most max_bytes, checking that no
+// chunk is larger than that.
+std::string read_all(Stream &s, size_t max_bytes) {
+    std::string all;
+    std::string chunk;
+    while (s.Next(max_bytes, chunk)) {
+        BOOST_TEST(chunk.size() > 0);
+        BOOST_TEST(chunk.size() <= max_bytes);
+        all += chunk;
+        chunk.clear();
+    }
+    return all;
+}
+
+BOOST_AUTO_TEST_CASE(StringStream_empty) {
+    StringStream s("");
+    std::string chunk;
+    BOOST_TEST(!s.Next(4, chunk));
+    BOOST_TEST(chunk.empty());
+}
+
+BOOST_AUTO_TEST_CASE(StringStream_chunks) {
+    StringStream s("0123456789");
+    BOOST_TEST(read_all(s, 3) == "0123456789");
+    std::string chunk;
+    BOOST_TEST(!s.Next(3, chunk));
+}
+
+BOOST_AUTO_TEST_CASE(FileStream_missing_file) {
+    fs::path filename = fs::temp_directory_path() / fs::unique_path();
+    BOOST_CHECK_THROW(FileStream s(filename.string()), std::runtime_error);
+}
+
+BOOST_AUTO_TEST_CASE(FileStream_chunks_and_removes_file) {
+    fs::path filename = fs::temp_directory_path() / fs::unique_path();
+    std::string data(1000, '\0');
+    for (size_t i = 0; i < data.size(); i++) {
+        data[i] = static_cast<char>(i % 256);
+    }
+    {
+        std::ofstream f(filename.string(), std::ios::out | std::ios::binary);
+        f << data;
+    }
+
+    FileStream s(filename.string());
+    BOOST_TEST(!fs::exists(filename));
+    BOOST_TEST(read_all(s, 64) == data);
+}
+
+BOOST_AUTO_TEST_SUITE_END()
+
+}  // namespace stream
+}  // namespace carto_facade
+}  // namespace viam
@@ -1,11 +1,14 @@
 #include "util.h"
 
 #include <pcl/console/time.h>  // pcl::console::TicToc
+#include <pcl/io/lzf.h>        // pcl::lzfCompress
 #include <pcl/io/pcd_io.h>     // pcl::PCDReader
 #include <pcl/point_types.h>
 
 #include <boost/format.hpp>
+#include <cstring>
 #include <sstream>  // std::istringstream
+#include <vector>
 
 namespace viam {
 namespace carto_facade {
@@ -68,6 +71,126 @@ void write_int_to_buffer_in_bytes(std::string &buffer, int d) {
     }
 }
 
+std::string point_cloud_header(PointCloudFormat format, int num_points) {
+    switch (format) {
+        case PointCloudFormat::PCD:
+            return pcd_header(num_points, true);
+        case PointCloudFormat::PCD_BINARY:
+            return str(boost::format(HEADERTEMPLATEPROBABILITY) % num_points %
+                       num_points % "binary");
+        case PointCloudFormat::PCD_BINARY_COMPRESSED:
+            return str(boost::format(HEADERTEMPLATEPROBABILITY) % num_points %
+                       num_points % "binary_compressed");
+        case PointCloudFormat::PCD_ASCII:
+            return str(boost::format(HEADERTEMPLATEPROBABILITY) % num_points %
+                       num_points % "ascii");
+        case PointCloudFormat::PLY:
+            return str(boost::format(PLYHEADERTEMPLATE) % num_points);
+    }
+    throw std::runtime_error("unknown pointcloud format");
+}
+
+void write_point(std::string &buffer, PointCloudFormat format, float x,
+                 float y, float z, int probability) {
+    switch (format) {
+        case PointCloudFormat::PCD:
+            write_float_to_buffer_in_bytes(buffer, x);
+            write_float_to_buffer_in_bytes(buffer, y);
+            write_float_to_buffer_in_bytes(buffer, z);
+            write_int_to_buffer_in_bytes(buffer, probability);
+            return;
+        case PointCloudFormat::PCD_ASCII:
+            buffer += str(boost::format("%.6f %.6f %.6f %d\n") % x % y % z %
+                          probability);
+            return;
+        case PointCloudFormat::PCD_BINARY:
+        case PointCloudFormat::PCD_BINARY_COMPRESSED:
+        case PointCloudFormat::PLY:
+            // binary pcds & binary little endian plys share the layout of
+            // their points
+            write_float_to_buffer_in_bytes(buffer, x);
+            write_float_to_buffer_in_bytes(buffer, y);
+            write_float_to_buffer_in_bytes(buffer, z);
+            buffer.push_back(static_cast<char>(probability));
+            return;
+    }
+    throw std::runtime_error("unknown pointcloud format");
+}
+
+std::string pcd_binary_compressed_data(const std::string &points,
+                                       int num_points) {
+    // binary_compressed pcds hold all values of a field before the values of
+    // the next field, rather than all fields of a point before the next point
+    const std::vector<std::size_t> field_sizes = {sizeof(float), sizeof(float),
+                                                  sizeof(float), 1};
+    const std::size_t point_size = 3 * sizeof(float) + 1;
+    std::string fields;
+    fields.reserve(points.size());
+    std::size_t offset = 0;
+    for (std::size_t field_size : field_sizes) {
+        for (int i = 0; i < num_points; i++) {
+            fields.append(points, i * point_size + offset, field_size);
+        }
+        offset += field_size;
+    }
+
+    // As in pcl::PCDWriter::writeBinaryCompressed, the buffer leaves room for
+    // data which doesn't compress
+    std::vector<char> compressed(fields.size() * 3 / 2 + 8);
+    unsigned int compressed_size = 0;
+    if (!fields.empty()) {
+        compressed_size =
+            pcl::lzfCompress(fields.data(), fields.size(), compressed.data(),
+                             compressed.size());
+        if (compressed_size == 0) {
+            throw std::runtime_error("failed to compress pcd");
+        }
+    }
+
+    std::string data;
+    write_int_to_buffer_in_bytes(data, compressed_size);
+    write_int_to_buffer_in_bytes(data, fields.size());
+    data.append(compressed.data(), compressed_size);
+    return data;
+}
+
+std::string convert_pcd(const std::string &pcd, PointCloudFormat format) {
+    if (format == PointCloudFormat::PCD) {
+        return pcd;
+    }
+    const std::string data_line = "DATA binary\n";
+    std::size_t data_index = pcd.find(data_line);
+    if (data_index == std::string::npos) {
+        throw std::runtime_error("pcd is not binary");
+    }
+    data_index += data_line.size();
+
+    const std::size_t point_size = 3 * sizeof(float) + sizeof(int);
+    int num_points = (pcd.size() - data_index) / point_size;
+    PointCloudFormat points_format =
+        format == PointCloudFormat::PCD_BINARY_COMPRESSED
+            ? PointCloudFormat::PCD_BINARY
+            : format;
+    std::string points;
+    for (int i = 0; i < num_points; i++) {
+        float values[3];
+        int probability;
+        const char *point = pcd.data() + data_index + i * point_size;
+        std::memcpy(values, point, sizeof(values));
+        std::memcpy(&probability, point + sizeof(values), sizeof(int));
+        write_point(points, points_format, values[0], values[1], values[2],
+                    probability);
+    }
+
+    std::string converted = point_cloud_header(format, num_points);
+    if (format == PointCloudFormat::PCD_BINARY_COMPRESSED) {
+        converted += pcd_binary_compressed_data(points, num_points);
+    } else {
+        converted += points;
+    }
+    return converted;
+}
+
 // based on pcl::PCDReader::read
 // https://pointclouds.org/documentation/classpcl_1_1_p_c_d_reader.html#ac9451748db653fd0901a0c4b6b750552
 // Doesn't implemented binary_compressed yet
@@ -41,6 +41,41 @@ const auto HEADERTEMPLATECOLOR =
     "VIEWPOINT 0 0 0 1 0 0 0\n"
     "POINTS %d\n"
     "DATA binary\n";
+
+const auto HEADERTEMPLATEPROBABILITY =
+    "VERSION .7\n"
+    "FIELDS x y z probability\n"
+    "SIZE 4 4 4 1\n"
+    "TYPE F F F U\n"
+    "COUNT 1 1 1 1\n"
+    "WIDTH %d\n"
+    "HEIGHT 1\n"
+    "VIEWPOINT 0 0 0 1 0 0 0\n"
+    "POINTS %d\n"
+    "DATA %s\n";
+
+const auto PLYHEADERTEMPLATE =
+    "ply\n"
+    "format binary_little_endian 1.0\n"
+    "element vertex %d\n"
+    "property float x\n"
+    "property float y\n"
+    "property float z\n"
+    "property uchar probability\n"
+    "end_header\n";
+
+// PointCloudFormat is the format a pointcloud map is written in, mirroring
+// the VIAM_CARTO_POINT_CLOUD_FORMAT_* constants. PCD writes the probability
+// that a point is occupied to the rgb field, the other formats write it to a
+// probability field.
+enum class PointCloudFormat {
+    PCD,
+    PCD_BINARY,
+    PCD_BINARY_COMPRESSED,
+    PCD_ASCII,
+    PLY
+};
+
 void read_and_delete_file(std::string filename, std::string *buffer);
 
 std::string pcd_header(int mapSize, bool hasColor);
@@ -49,6 +84,25 @@ void write_float_to_buffer_in_bytes(std::string &buffer, float f);
 
 void write_int_to_buffer_in_bytes(std::string &buffer, int d);
 
+// point_cloud_header returns the header of a pointcloud map with num_points
+// points written in the given format.
+std::string point_cloud_header(PointCloudFormat format, int num_points);
+
+// write_point appends a point with the probability (0 - 100) that it is
+// occupied to the buffer. PCD_BINARY_COMPRESSED points are written as
+// PCD_BINARY points, which pcd_binary_compressed_data then compresses.
+void write_point(std::string &buffer, PointCloudFormat format, float x,
+                 float y, float z, int probability);
+
+// pcd_binary_compressed_data returns the data of a binary_compressed pcd
+// holding the given num_points points, which were written as PCD_BINARY.
+std::string pcd_binary_compressed_data(const std::string &points,
+                                       int num_points);
+
+// convert_pcd returns the given pcd, which was written as PCD, written in the
+// given format.
+std::string convert_pcd(const std::string &pcd, PointCloudFormat format);
+
 std::tuple<bool, cartographer::sensor::TimedPointCloudData> carto_lidar_reading(
     std::string lidar_reading, int64_t lidar_reading_time_unix_milli);
 int read_pcd(std::string pcd, pcl::PCLPointCloud2 &blob);
@@ -1,12 +1,17 @@
 #include "util.h"
 
+#include <pcl/common/io.h>  // pcl::getFieldsList
+#include <pcl/io/pcd_io.h>  // pcl::PCDReader
+
 #include <boost/filesystem.hpp>
 #include <boost/filesystem/fstream.hpp>
 #include <boost/test/unit_test.hpp>
 #include <cstdio>
+#include <cstring>
 #include <exception>
 #include <iostream>
 #include <string>
+#include <vector>
 
 #include "io.h"
 #include "test_helpers.h"
@@ -206,6 +211,91 @@ BOOST_AUTO_TEST_CASE(carto_lidar_reading_ascii_success) {
                cartographer::common::FromUniversal(-1920816663374754544));
 }
 
+// read_probability_pcd_points returns the x, y, z & probability of the points
+// of a pcd with the fields of HEADERTEMPLATEPROBABILITY.
+std::vector<std::vector<double>> read_probability_pcd_points(
+    const pcl::PCLPointCloud2 &blob) {
+    BOOST_TEST(pcl::getFieldsList(blob) == "x y z probability");
+    std::vector<std::vector<double>> points;
+    for (std::size_t i = 0; i < blob.width * blob.height; i++) {
+        const std::uint8_t *point = blob.data.data() + i * blob.point_step;
+        float values[3];
+        std::memcpy(values, point, sizeof(values));
+        points.push_back(
+            {values[0], values[1], values[2],
+             static_cast<double>(point[blob.fields[3].offset])});
+    }
+    return points;
+}
+
+BOOST_AUTO_TEST_CASE(convert_pcd_success) {
+    std::string points;
+    write_point(points, PointCloudFormat::PCD, 0.5, -1.25, 0, 87);
+    write_point(points, PointCloudFormat::PCD, -2, 3.75, 0, 100);
+    std::string pcd = point_cloud_header(PointCloudFormat::PCD, 2) + points;
+    std::vector<std::vector<double>> expected = {{0.5, -1.25, 0, 87},
+                                                 {-2, 3.75, 0, 100}};
+
+    BOOST_TEST(convert_pcd(pcd, PointCloudFormat::PCD) == pcd);
+
+    BOOST_TEST(convert_pcd(pcd, PointCloudFormat::PCD_ASCII) ==
+               "VERSION .7\n"
+               "FIELDS x y z probability\n"
+               "SIZE 4 4 4 1\n"
+               "TYPE F F F U\n"
+               "COUNT 1 1 1 1\n"
+               "WIDTH 2\n"
+               "HEIGHT 1\n"
+               "VIEWPOINT 0 0 0 1 0 0 0\n"
+               "POINTS 2\n"
+               "DATA ascii\n"
+               "0.500000 -1.250000 0.000000 87\n"
+               "-2.000000 3.750000 0.000000 100\n");
+
+    pcl::PCLPointCloud2 ascii;
+    BOOST_TEST(
+        read_pcd(convert_pcd(pcd, PointCloudFormat::PCD_ASCII), ascii) == 0);
+    BOOST_TEST((read_probability_pcd_points(ascii) == expected));
+    pcl::PCLPointCloud2 binary;
+    BOOST_TEST(
+        read_pcd(convert_pcd(pcd, PointCloudFormat::PCD_BINARY), binary) == 0);
+    BOOST_TEST((read_probability_pcd_points(binary) == expected));
+
+    // read_pcd doesn't read binary_compressed pcds, pcl::PCDReader does
+    boost::filesystem::path tmp_file =
+        boost::filesystem::temp_directory_path() /
+        boost::filesystem::unique_path();
+    {
+        boost::filesystem::ofstream ofs(tmp_file, std::ios::binary);
+        ofs << convert_pcd(pcd, PointCloudFormat::PCD_BINARY_COMPRESSED);
+    }
+    pcl::PCDReader reader;
+    pcl::PCLPointCloud2 compressed;
+    BOOST_TEST(reader.read(tmp_file.string(), compressed) == 0);
+    boost::filesystem::remove(tmp_file);
+    BOOST_TEST((read_probability_pcd_points(compressed) == expected));
+
+    std::string ply = convert_pcd(pcd, PointCloudFormat::PLY);
+    std::string ply_header =
+        "ply\n"
+        "format binary_little_endian 1.0\n"
+        "element vertex 2\n"
+        "property float x\n"
+        "property float y\n"
+        "property float z\n"
+        "property uchar probability\n"
+        "end_header\n";
+    BOOST_TEST(ply.substr(0, ply_header.size()) == ply_header);
+    BOOST_TEST(ply.size() == ply_header.size() + 2 * 13);
+    BOOST_TEST(static_cast<int>(ply.back()) == 100);
+}
+
+BOOST_AUTO_TEST_CASE(convert_pcd_not_binary_failure) {
+    BOOST_CHECK_THROW(convert_pcd(help::ascii_pcd({{0, 0, 0, 0}}),
+                                  PointCloudFormat::PLY),
+                      std::runtime_error);
+}
+
 BOOST_AUTO_TEST_SUITE_END()
 
 }  // namespace util
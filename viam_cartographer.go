@@ -3,7 +3,6 @@
 package viamcartographer
 
 import (
-	"bytes"
 	"context"
 	"strconv"
 	"sync"
@@ -15,13 +14,13 @@ import (
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
 	"go.uber.org/zap/zapcore"
-	viamgrpc "go.viam.com/rdk/grpc"
 	"go.viam.com/rdk/resource"
 	"go.viam.com/rdk/services/slam"
 	"go.viam.com/rdk/spatialmath"
 
 	"github.com/viamrobotics/viam-cartographer/cartofacade"
 	vcConfig "github.com/viamrobotics/viam-cartographer/config"
+	"github.com/viamrobotics/viam-cartographer/maprender"
 	"github.com/viamrobotics/viam-cartographer/sensorprocess"
 	s "github.com/viamrobotics/viam-cartographer/sensors"
 )
@@ -48,7 +47,6 @@ const (
 	parsePortMaxTimeoutSec               = 60
 	localhost0                           = "localhost:0"
 	defaultCartoFacadeTimeout            = 5 * time.Second
-	chunkSizeBytes                       = 1 * 1024 * 1024
 )
 
 var defaultCartoAlgoCfg = cartofacade.CartoAlgoConfig{
@@ -65,6 +63,8 @@ var defaultCartoAlgoCfg = cartofacade.CartoAlgoConfig{
 	OccupiedSpaceWeight:  20.0,
 	TranslationWeight:    10.0,
 	RotationWeight:       1.0,
+	MapResolutionMeters:  cartofacade.DefaultMapResolutionMeters,
+	MaxMapPoints:         0,
 }
 
 // SubAlgo defines the cartographer specific sub-algorithms that we support.
@@ -127,6 +127,7 @@ func initSensorProcess(cancelCtx context.Context, cartoSvc *CartographerService)
 		LidarDataRateMsec: cartoSvc.lidar.dataRateMsec,
 		Timeout:           cartoSvc.cartoFacadeTimeout,
 		Logger:            cartoSvc.logger,
+		Stats:             cartoSvc.sensorProcessStats,
 	}
 
 	cartoSvc.sensorProcessWorkers.Add(1)
@@ -137,6 +138,7 @@ func initSensorProcess(cancelCtx context.Context, cartoSvc *CartographerService)
 			cartoSvc.cancelSensorProcessFunc()
 		}
 	}()
+	startMapImageProcess(cancelCtx, cartoSvc)
 }
 
 // New returns a new slam service for the given robot.
@@ -184,6 +186,30 @@ func New(
 		return nil, err
 	}
 
+	chunkSizeBytes := defaultChunkSizeBytes
+	if svcConfig.ChunkSizeBytes != nil {
+		chunkSizeBytes = *svcConfig.ChunkSizeBytes
+	}
+
+	var mapImageRateSec int
+	var mapImageOptions maprender.Options
+	if svcConfig.MapImage != nil {
+		mapImageRateSec = svcConfig.MapImage.RateSec
+		mapImageOptions.Scale = svcConfig.MapImage.Scale
+		if crop := svcConfig.MapImage.Crop; crop != nil {
+			mapImageOptions.Crop = &maprender.Bounds{MinX: crop.MinX, MinY: crop.MinY, MaxX: crop.MaxX, MaxY: crop.MaxY}
+		}
+	}
+
+	var initialPose *cartofacade.Pose2D
+	if svcConfig.InitialPose != nil {
+		initialPose = &cartofacade.Pose2D{
+			X:     svcConfig.InitialPose.X,
+			Y:     svcConfig.InitialPose.Y,
+			Theta: svcConfig.InitialPose.Theta,
+		}
+	}
+
 	// feature flag for new config
 	lidarName := ""
 	if svcConfig.IMUIntegrationEnabled {
@@ -249,10 +275,15 @@ func New(
 		sensorValidationMaxTimeoutSec: sensorValidationMaxTimeoutSec,
 		sensorValidationIntervalSec:   sensorValidationMaxTimeoutSec,
 		cartoFacadeTimeout:            cartoFacadeTimeout,
+		chunkSizeBytes:                chunkSizeBytes,
+		mapImageRateSec:               mapImageRateSec,
+		mapImageOptions:               mapImageOptions,
 		mapTimestamp:                  time.Now().UTC(),
 		cloudStoryEnabled:             svcConfig.CloudStoryEnabled,
 		enableMapping:                 optionalConfigParams.EnableMapping,
 		existingMap:                   optionalConfigParams.ExistingMap,
+		initialPose:                   initialPose,
+		sensorProcessStats:            sensorprocess.NewStats(),
 	}
 
 	defer func() {
@@ -397,6 +428,25 @@ func parseCartoAlgoConfig(configParams map[string]string, logger golog.Logger) (
 				return cartoAlgoCfg, err
 			}
 			cartoAlgoCfg.RotationWeight = fVal
+		case "map_resolution_meters":
+			fVal, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return cartoAlgoCfg, err
+			}
+			if fVal < cartofacade.MinMapResolutionMeters {
+				return cartoAlgoCfg, errors.Errorf("map_resolution_meters must be at least %v, got %v",
+					cartofacade.MinMapResolutionMeters, fVal)
+			}
+			cartoAlgoCfg.MapResolutionMeters = fVal
+		case "max_map_points":
+			iVal, err := strconv.Atoi(val)
+			if err != nil {
+				return cartoAlgoCfg, err
+			}
+			if iVal < 0 {
+				return cartoAlgoCfg, errors.Errorf("max_map_points must not be negative, got %d", iVal)
+			}
+			cartoAlgoCfg.MaxMapPoints = iVal
 			// ignore mode as it is a special case
 		case "mode":
 		default:
@@ -417,18 +467,23 @@ func initCartoFacade(ctx context.Context, cartoSvc *CartographerService) error {
 	}
 
 	cartoCfg := cartofacade.CartoConfig{
-		Camera:             cartoSvc.lidar.name,
-		MovementSensor:     cartoSvc.imu.name,
-		MapRateSecond:      cartoSvc.mapRateSec,
-		DataDir:            cartoSvc.dataDirectory,
-		ComponentReference: cartoSvc.lidar.name,
-		LidarConfig:        cartofacade.TwoD,
-		CloudStoryEnabled:  cartoSvc.cloudStoryEnabled,
-		EnableMapping:      cartoSvc.enableMapping,
-		ExistingMap:        cartoSvc.existingMap,
+		Camera:               cartoSvc.lidar.name,
+		MovementSensor:       cartoSvc.imu.name,
+		MapRateSecond:        cartoSvc.mapRateSec,
+		DataDir:              cartoSvc.dataDirectory,
+		ComponentReference:   cartoSvc.lidar.name,
+		LidarConfig:          cartofacade.TwoD,
+		CloudStoryEnabled:    cartoSvc.cloudStoryEnabled,
+		EnableMapping:        cartoSvc.enableMapping,
+		ExistingMap:          cartoSvc.existingMap,
+		InitialPose:          cartoSvc.initialPose,
+		InitialInternalState: cartoSvc.initialInternalState,
 	}
 
 	cf := cartofacade.New(&cartoLib, cartoCfg, cartoAlgoConfig)
+	cf.OnStateTransition(func(t cartofacade.StateTransition) {
+		cartoSvc.logger.Debugw("cartofacade state transition", "from", t.From, "to", t.To, "request", t.RequestType)
+	})
 	slamMode, err := cf.Initialize(ctx, cartoSvc.cartoFacadeTimeout, &cartoSvc.cartoFacadeWorkers)
 	if err != nil {
 		cartoSvc.logger.Errorw("cartofacade initialize failed", "error", err)
@@ -448,6 +503,8 @@ func initCartoFacade(ctx context.Context, cartoSvc *CartographerService) error {
 
 	cartoSvc.cartofacade = &cf
 	cartoSvc.SlamMode = slamMode
+	cartoSvc.switchedToLocalization = false
+	cartoSvc.cartoAlgoConfig = cartoAlgoConfig
 
 	return nil
 }
@@ -458,9 +515,18 @@ func terminateCartoFacade(ctx context.Context, cartoSvc *CartographerService) er
 		return nil
 	}
 
-	stopErr := cartoSvc.cartofacade.Stop(ctx, cartoSvc.cartoFacadeTimeout)
-	if stopErr != nil {
-		cartoSvc.logger.Errorw("cartofacade stop failed", "error", stopErr)
+	var stopErr error
+	switch state := cartoSvc.cartofacade.State(); state {
+	case cartofacade.TerminatedState:
+		cartoSvc.logger.Debug("terminateCartoFacade called when cartoSvc.cartofacade is already terminated")
+		return nil
+	case cartofacade.StartedState:
+		stopErr = cartoSvc.cartofacade.Stop(ctx, cartoSvc.cartoFacadeTimeout)
+		if stopErr != nil {
+			cartoSvc.logger.Errorw("cartofacade stop failed", "error", stopErr)
+		}
+	default:
+		cartoSvc.logger.Debugw("skipping cartofacade stop as it is not started", "state", state)
 	}
 
 	err := cartoSvc.cartofacade.Terminate(ctx, cartoSvc.cartoFacadeTimeout)
@@ -491,7 +557,9 @@ type IMU struct {
 type CartographerService struct {
 	resource.Named
 	resource.AlwaysRebuild
-	mu       sync.Mutex
+	// mu is held for writing by restart, reset_map & switch_mode, which swap the cartofacade & the state derived
+	// from it. Requests read them through facade, or otherwise hold mu for reading.
+	mu       sync.RWMutex
 	SlamMode cartofacade.SlamMode
 	closed   bool
 	lidar    Lidar
@@ -502,18 +570,30 @@ type CartographerService struct {
 
 	configParams  map[string]string
 	dataDirectory string
+	// cartoAlgoConfig is the config the current cartofacade was initialized with, resolved from configParams
+	cartoAlgoConfig cartofacade.CartoAlgoConfig
 
 	cartofacade        cartofacade.Interface
 	cartoFacadeTimeout time.Duration
+	chunkSizeBytes     int
 
 	mapRateSec int
+	// mapImageRateSec is how often the map image is written into the data directory, it is not written if 0
+	mapImageRateSec int
+	// mapImageOptions are the configured options the map image is rendered with
+	mapImageOptions maprender.Options
 
 	cancelSensorProcessFunc func()
 	cancelCartoFacadeFunc   func()
 	logger                  golog.Logger
 	sensorProcessWorkers    sync.WaitGroup
 	cartoFacadeWorkers      sync.WaitGroup
+	// sensorProcessStats is shared by every sensor process started by the service, so that it survives restarts
+	sensorProcessStats *sensorprocess.Stats
+	// pointCloudMapCache holds the last pcd exported in full
+	pointCloudMapCache pointCloudMapCache
 
+	// mapTimestamp is when the map was frozen, GetLatestMapInfo returns it in localizing mode
 	mapTimestamp                  time.Time
 	sensorValidationMaxTimeoutSec int
 	sensorValidationIntervalSec   int
@@ -522,6 +602,19 @@ type CartographerService struct {
 	cloudStoryEnabled bool
 	enableMapping     bool
 	existingMap       string
+	// initialInternalState is the snapshot a restart continues mapping from
+	initialInternalState string
+	// initialPose is where the localization trajectory starts, it is updated by set_pose
+	initialPose *cartofacade.Pose2D
+	// switchedToLocalization is set when switch_mode froze the map of the running cartofacade
+	switchedToLocalization bool
+}
+
+// facade returns the running cartofacade & the slam mode it runs in. It may not be called while holding mu.
+func (cartoSvc *CartographerService) facade() (cartofacade.Interface, cartofacade.SlamMode) {
+	cartoSvc.mu.RLock()
+	defer cartoSvc.mu.RUnlock()
+	return cartoSvc.cartofacade, cartoSvc.SlamMode
 }
 
 // GetPosition forwards the request for positional data to the slam library's gRPC service. Once a response is received,
@@ -538,7 +631,8 @@ func (cartoSvc *CartographerService) GetPosition(ctx context.Context) (spatialma
 		return nil, "", ErrClosed
 	}
 
-	pos, err := cartoSvc.cartofacade.GetPosition(ctx, cartoSvc.cartoFacadeTimeout)
+	cf, _ := cartoSvc.facade()
+	pos, err := cf.GetPosition(ctx, cartoSvc.cartoFacadeTimeout)
 	if err != nil {
 		return nil, "", err
 	}
@@ -556,7 +650,8 @@ func (cartoSvc *CartographerService) GetPosition(ctx context.Context) (spatialma
 }
 
 // GetPointCloudMap creates a request calls the slam algorithms GetPointCloudMap endpoint and returns a callback
-// function which will return the next chunk of the current pointcloud map.
+// function which will return the next chunk of the current pointcloud map. The pointcloud map is cached until the
+// revision of the map changes. The export_pointcloud_map DoCommand exports the map with other options.
 func (cartoSvc *CartographerService) GetPointCloudMap(ctx context.Context) (func() ([]byte, error), error) {
 	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::GetPointCloudMap")
 	defer span.End()
@@ -570,15 +665,20 @@ func (cartoSvc *CartographerService) GetPointCloudMap(ctx context.Context) (func
 		return nil, ErrClosed
 	}
 
-	pc, err := cartoSvc.cartofacade.GetPointCloudMap(ctx, cartoSvc.cartoFacadeTimeout)
+	opts, err := cartoSvc.resolveExportOptions(nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := cartoSvc.pointCloudMapReader(ctx, opts, cartofacade.MapOptions{})
 	if err != nil {
 		return nil, err
 	}
-	return toChunkedFunc(pc), nil
+	return toChunkedFunc(r, opts.chunkSizeBytes), nil
 }
 
 // GetInternalState creates a request, calls the slam algorithms GetInternalState endpoint and returns a callback
-// function which will return the next chunk of the current internal state of the slam algo.
+// function which will return the next chunk of the current internal state of the slam algo. The
+// export_internal_state DoCommand exports the internal state with other options.
 func (cartoSvc *CartographerService) GetInternalState(ctx context.Context) (func() ([]byte, error), error) {
 	ctx, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::GetInternalState")
 	defer span.End()
@@ -592,31 +692,20 @@ func (cartoSvc *CartographerService) GetInternalState(ctx context.Context) (func
 		return nil, ErrClosed
 	}
 
-	is, err := cartoSvc.cartofacade.GetInternalState(ctx, cartoSvc.cartoFacadeTimeout)
+	opts, err := cartoSvc.resolveExportOptions(nil)
 	if err != nil {
 		return nil, err
 	}
-
-	return toChunkedFunc(is), nil
-}
-
-func toChunkedFunc(b []byte) func() ([]byte, error) {
-	chunk := make([]byte, chunkSizeBytes)
-
-	reader := bytes.NewReader(b)
-
-	f := func() ([]byte, error) {
-		bytesRead, err := reader.Read(chunk)
-		if err != nil {
-			return nil, err
-		}
-		return chunk[:bytesRead], err
+	r, err := cartoSvc.internalStateReader(ctx, opts)
+	if err != nil {
+		return nil, err
 	}
-	return f
+	return toChunkedFunc(r, opts.chunkSizeBytes), nil
 }
 
-// GetLatestMapInfo returns a new timestamp every time it is called when in mapping mode, to signal
-// that the map should be updated. In localizing, the timestamp returned is the timestamp of the session.
+// GetLatestMapInfo returns when the map last changed in mapping mode, i.e. when cartographer last inserted a scan
+// into the submaps or finished optimizing them, so that clients only fetch the map once it changed. In localizing,
+// the timestamp returned is the timestamp of the session.
 func (cartoSvc *CartographerService) GetLatestMapInfo(ctx context.Context) (time.Time, error) {
 	_, span := trace.StartSpan(ctx, "viamcartographer::CartographerService::GetLatestMapInfo")
 	defer span.End()
@@ -630,14 +719,22 @@ func (cartoSvc *CartographerService) GetLatestMapInfo(ctx context.Context) (time
 		return time.Time{}, ErrClosed
 	}
 
-	if cartoSvc.SlamMode != cartofacade.LocalizingMode {
-		cartoSvc.mapTimestamp = time.Now().UTC()
+	cartoSvc.mu.RLock()
+	defer cartoSvc.mu.RUnlock()
+	if cartoSvc.SlamMode == cartofacade.LocalizingMode {
+		return cartoSvc.mapTimestamp, nil
 	}
 
-	return cartoSvc.mapTimestamp, nil
+	// a revision which failed to be read is logged rather than returned, as the last revision read is the
+	// best answer there is
+	revision, err := cartoSvc.cartofacade.MapRevision()
+	if err != nil {
+		cartoSvc.logger.Warnw("reading the map revision failed", "error", err)
+	}
+	return revision.ChangedAt, nil
 }
 
-// DoCommand receives arbitrary commands.
+// DoCommand runs the command of doCommands given in req, the help command lists them.
 func (cartoSvc *CartographerService) DoCommand(ctx context.Context, req map[string]interface{}) (map[string]interface{}, error) {
 	if cartoSvc.useCloudSlam {
 		cartoSvc.logger.Warn("DoCommand called with use_cloud_slam set to true")
@@ -648,11 +745,7 @@ func (cartoSvc *CartographerService) DoCommand(ctx context.Context, req map[stri
 		return nil, ErrClosed
 	}
 
-	if _, ok := req["job_done"]; ok {
-		return map[string]interface{}{"job_done": cartoSvc.jobDone.Load()}, nil
-	}
-
-	return nil, viamgrpc.UnimplementedError
+	return cartoSvc.runDoCommand(ctx, req)
 }
 
 // Close out of all slam related processes.
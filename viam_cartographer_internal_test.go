@@ -1,9 +1,16 @@
 package viamcartographer
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"image/png"
+	"io"
 	"math"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,13 +18,17 @@ import (
 	"github.com/golang/geo/r3"
 	"github.com/pkg/errors"
 	commonv1 "go.viam.com/api/common/v1"
+	viamgrpc "go.viam.com/rdk/grpc"
 	"go.viam.com/rdk/resource"
 	"go.viam.com/rdk/services/slam"
 	"go.viam.com/rdk/spatialmath"
 	"go.viam.com/test"
 	"go.viam.com/utils/artifact"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/viamrobotics/viam-cartographer/cartofacade"
+	"github.com/viamrobotics/viam-cartographer/densegrid"
+	"github.com/viamrobotics/viam-cartographer/maprender"
 )
 
 func makeQuaternionFromGenericMap(quat map[string]interface{}) spatialmath.Orientation {
@@ -134,15 +145,56 @@ func TestGetPositionEndpoint(t *testing.T) {
 	})
 }
 
+// testStream is a cartofacade.Stream which yields data in chunks.
+type testStream struct {
+	data    []byte
+	nextErr error
+	closed  bool
+}
+
+func (s *testStream) Next(ctx context.Context, timeout time.Duration, maxChunkSizeBytes int) ([]byte, error) {
+	if s.nextErr != nil {
+		return nil, s.nextErr
+	}
+	if s.closed || len(s.data) == 0 {
+		s.closed = true
+		return nil, io.EOF
+	}
+	n := maxChunkSizeBytes
+	if n > len(s.data) {
+		n = len(s.data)
+	}
+	chunk := make([]byte, n)
+	copy(chunk, s.data)
+	s.data = s.data[n:]
+	return chunk, nil
+}
+
+func (s *testStream) Close(ctx context.Context, timeout time.Duration) error {
+	s.closed = true
+	return nil
+}
+
+// setMockMapRevisionFunc makes every call of MapRevision return a new revision, so that no pcd is served from
+// the pointCloudMapCache.
+func setMockMapRevisionFunc(mock *cartofacade.Mock) {
+	var revision atomic.Int64
+	mock.MapRevisionFunc = func() (cartofacade.MapRevision, error) {
+		return cartofacade.MapRevision{Revision: int(revision.Add(1)), ChangedAt: time.Now().UTC()}, nil
+	}
+}
+
 func setMockGetPointCloudFunc(
 	mock *cartofacade.Mock,
 	pc []byte,
 ) {
-	mock.GetPointCloudMapFunc = func(
+	setMockMapRevisionFunc(mock)
+	mock.GetPointCloudMapStreamFunc = func(
 		ctx context.Context,
 		timeout time.Duration,
-	) ([]byte, error) {
-		return pc, nil
+		opts cartofacade.MapOptions,
+	) (cartofacade.Stream, error) {
+		return &testStream{data: pc}, nil
 	}
 }
 
@@ -245,10 +297,11 @@ func TestGetPointCloudMapEndpoint(t *testing.T) {
 	t.Run("cartofacade error", func(t *testing.T) {
 		setMockGetPointCloudFunc(mockCartoFacade, []byte{})
 
-		mockCartoFacade.GetPointCloudMapFunc = func(
+		mockCartoFacade.GetPointCloudMapStreamFunc = func(
 			ctx context.Context,
 			timeout time.Duration,
-		) ([]byte, error) {
+			opts cartofacade.MapOptions,
+		) (cartofacade.Stream, error) {
 			return nil, errors.New("test")
 		}
 
@@ -256,17 +309,34 @@ func TestGetPointCloudMapEndpoint(t *testing.T) {
 		test.That(t, callback, test.ShouldBeNil)
 		test.That(t, err, test.ShouldBeError, errors.New("test"))
 	})
+
+	t.Run("stream error closes the stream", func(t *testing.T) {
+		stream := &testStream{data: []byte("hello!"), nextErr: errors.New("test")}
+		mockCartoFacade.GetPointCloudMapStreamFunc = func(
+			ctx context.Context,
+			timeout time.Duration,
+			opts cartofacade.MapOptions,
+		) (cartofacade.Stream, error) {
+			return stream, nil
+		}
+
+		callback, err := svc.GetPointCloudMap(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		_, err = callback()
+		test.That(t, err, test.ShouldBeError, errors.New("test"))
+		test.That(t, stream.closed, test.ShouldBeTrue)
+	})
 }
 
 func setMockGetInternalStateFunc(
 	mock *cartofacade.Mock,
 	pc []byte,
 ) {
-	mock.GetInternalStateFunc = func(
+	mock.GetInternalStateStreamFunc = func(
 		ctx context.Context,
 		timeout time.Duration,
-	) ([]byte, error) {
-		return pc, nil
+	) (cartofacade.Stream, error) {
+		return &testStream{data: pc}, nil
 	}
 }
 
@@ -288,10 +358,10 @@ func TestGetInternalStateEndpoint(t *testing.T) {
 	t.Run("cartofacade error", func(t *testing.T) {
 		setMockGetInternalStateFunc(mockCartoFacade, []byte{})
 
-		mockCartoFacade.GetInternalStateFunc = func(
+		mockCartoFacade.GetInternalStateStreamFunc = func(
 			ctx context.Context,
 			timeout time.Duration,
-		) ([]byte, error) {
+		) (cartofacade.Stream, error) {
 			return nil, errors.New("test")
 		}
 
@@ -301,6 +371,427 @@ func TestGetInternalStateEndpoint(t *testing.T) {
 	})
 }
 
+func TestGetStatsCommand(t *testing.T) {
+	svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed()}
+	mockCartoFacade := &cartofacade.Mock{}
+	svc.cartofacade = mockCartoFacade
+	mockCartoFacade.QueueWaitFunc = func() cartofacade.QueueWait {
+		return cartofacade.QueueWait{
+			Count: 10,
+			P50:   500 * time.Microsecond,
+			P90:   2 * time.Millisecond,
+			P99:   5 * time.Millisecond,
+			Max:   8 * time.Millisecond,
+		}
+	}
+	noSensorProcessStats := map[string]interface{}{
+		"fetched":                     int64(0),
+		"fetch_failed":                int64(0),
+		"submitted":                   int64(0),
+		"skipped_lock_contention":     int64(0),
+		"failed":                      map[string]interface{}{},
+		"last_fetched_reading_time":   "",
+		"last_submitted_reading_time": "",
+		"fetched_per_sec":             0.0,
+		"submitted_per_sec":           0.0,
+	}
+	cartoFacadeStats := map[string]interface{}{
+		"queue_wait_ms": map[string]interface{}{
+			"count": 10,
+			"p50":   0.5,
+			"p90":   2.0,
+			"p99":   5.0,
+			"max":   8.0,
+		},
+	}
+
+	t.Run("returns the map builder stats", func(t *testing.T) {
+		lastOptimizationTime := time.Date(2021, 8, 15, 14, 30, 45, 0, time.UTC)
+		mockCartoFacade.GetStatsFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.GetStats, error) {
+			return cartofacade.GetStats{
+				Trajectories:         1,
+				SubmapsFinished:      4,
+				SubmapsActive:        2,
+				Nodes:                120,
+				Constraints:          900,
+				GridBytes:            1 << 20,
+				LastOptimizationTime: lastOptimizationTime,
+			}, nil
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_stats": true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{
+			"map_builder": map[string]interface{}{
+				"trajectories":           1,
+				"submaps_finished":       4,
+				"submaps_active":         2,
+				"nodes":                  120,
+				"constraints":            900,
+				"grid_bytes":             int64(1 << 20),
+				"last_optimization_time": "2021-08-15T14:30:45Z",
+			},
+			"sensor_process": noSensorProcessStats,
+			"cartofacade":    cartoFacadeStats,
+		})
+	})
+
+	t.Run("cartofacade error", func(t *testing.T) {
+		mockCartoFacade.GetStatsFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.GetStats, error) {
+			return cartofacade.GetStats{}, errors.New("test")
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_stats": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("test"))
+	})
+}
+
+func TestSaveMapCommand(t *testing.T) {
+	dataDir := t.TempDir()
+	svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed(), dataDirectory: dataDir}
+	mockCartoFacade := &cartofacade.Mock{}
+	svc.cartofacade = mockCartoFacade
+
+	var savedPath string
+	var savedWithFinalOptimization bool
+	var saveTimeout time.Duration
+	mockCartoFacade.SaveMapFunc = func(
+		ctx context.Context,
+		timeout time.Duration,
+		path string,
+		runFinalOptimization bool,
+	) (cartofacade.SaveMap, error) {
+		savedPath = path
+		savedWithFinalOptimization = runFinalOptimization
+		saveTimeout = timeout
+		return cartofacade.SaveMap{Nodes: 7}, os.WriteFile(path, []byte("pbstream"), 0o600)
+	}
+
+	t.Run("saves a named map with a final optimization", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"save_map": map[string]interface{}{"name": "kitchen.pbstream", "optimize": true},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(dataDir, "kitchen.pbstream")
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{
+			"path":       path,
+			"size_bytes": int64(len("pbstream")),
+			"nodes":      7,
+		})
+		test.That(t, savedPath, test.ShouldEqual, path)
+		test.That(t, savedWithFinalOptimization, test.ShouldBeTrue)
+		test.That(t, saveTimeout, test.ShouldEqual, finalOptimizationTimeout)
+	})
+
+	t.Run("names the map after the current time by default", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"save_map": true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, filepath.Dir(resp["path"].(string)), test.ShouldEqual, dataDir)
+		test.That(t, filepath.Base(resp["path"].(string)), test.ShouldStartWith, "map_data_")
+		test.That(t, savedWithFinalOptimization, test.ShouldBeFalse)
+		test.That(t, saveTimeout, test.ShouldEqual, svc.cartoFacadeTimeout)
+	})
+
+	t.Run("rejects invalid params", func(t *testing.T) {
+		for _, params := range []map[string]interface{}{
+			{"name": "../outside"},
+			{"name": "nested/map"},
+			{"name": ".."},
+			{"name": ""},
+			{"name": 1},
+			{"optimize": "yes"},
+		} {
+			resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"save_map": params})
+			test.That(t, resp, test.ShouldBeNil)
+			test.That(t, err, test.ShouldNotBeNil)
+		}
+	})
+
+	t.Run("cartofacade error", func(t *testing.T) {
+		mockCartoFacade.SaveMapFunc = func(
+			ctx context.Context,
+			timeout time.Duration,
+			path string,
+			runFinalOptimization bool,
+		) (cartofacade.SaveMap, error) {
+			return cartofacade.SaveMap{}, errors.New("test")
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"save_map": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("test"))
+	})
+
+	t.Run("requires a data directory", func(t *testing.T) {
+		svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed(), cartofacade: mockCartoFacade}
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"save_map": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("save_map requires data_dir to be configured"))
+	})
+}
+
+func TestSetPoseCommand(t *testing.T) {
+	svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed(), SlamMode: cartofacade.LocalizingMode}
+	mockCartoFacade := &cartofacade.Mock{}
+	svc.cartofacade = mockCartoFacade
+
+	var setPose cartofacade.Pose2D
+	mockCartoFacade.SetPoseFunc = func(ctx context.Context, timeout time.Duration, pose cartofacade.Pose2D) error {
+		setPose = pose
+		return nil
+	}
+
+	t.Run("sets the pose & keeps it as the initial pose", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"set_pose": map[string]interface{}{"x": 1500.0, "y": -250, "theta": 90.0},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{"set_pose": true})
+		test.That(t, setPose, test.ShouldResemble, cartofacade.Pose2D{X: 1500, Y: -250, Theta: 90})
+		test.That(t, svc.initialPose, test.ShouldResemble, &cartofacade.Pose2D{X: 1500, Y: -250, Theta: 90})
+	})
+
+	t.Run("theta defaults to zero", func(t *testing.T) {
+		_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"set_pose": map[string]interface{}{"x": 1.0, "y": 2.0},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, setPose, test.ShouldResemble, cartofacade.Pose2D{X: 1, Y: 2})
+	})
+
+	t.Run("rejects invalid params", func(t *testing.T) {
+		for _, params := range []interface{}{
+			true,
+			map[string]interface{}{"x": 1.0},
+			map[string]interface{}{"y": 1.0},
+			map[string]interface{}{"x": "1", "y": 1.0},
+			map[string]interface{}{"x": 1.0, "y": 1.0, "theta": "north"},
+		} {
+			resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"set_pose": params})
+			test.That(t, resp, test.ShouldBeNil)
+			test.That(t, err, test.ShouldNotBeNil)
+		}
+	})
+
+	t.Run("cartofacade error leaves the initial pose unchanged", func(t *testing.T) {
+		mockCartoFacade.SetPoseFunc = func(ctx context.Context, timeout time.Duration, pose cartofacade.Pose2D) error {
+			return errors.New("test")
+		}
+		initialPose := svc.initialPose
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"set_pose": map[string]interface{}{"x": 3.0, "y": 4.0},
+		})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("test"))
+		test.That(t, svc.initialPose, test.ShouldEqual, initialPose)
+	})
+
+	t.Run("is only supported in localizing mode", func(t *testing.T) {
+		svc := &CartographerService{
+			Named:       resource.NewName(slam.API, "test").AsNamed(),
+			SlamMode:    cartofacade.MappingMode,
+			cartofacade: mockCartoFacade,
+		}
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"set_pose": map[string]interface{}{"x": 1.0, "y": 2.0},
+		})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("set_pose is only supported in localizing mode, slam mode is mapping"))
+	})
+}
+
+func TestOptimizeCommand(t *testing.T) {
+	svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed(), cartoFacadeTimeout: time.Second}
+	mockCartoFacade := &cartofacade.Mock{}
+	svc.cartofacade = mockCartoFacade
+
+	t.Run("reports the duration & residual change", func(t *testing.T) {
+		var requestTimeout time.Duration
+		var deadline time.Time
+		mockCartoFacade.OptimizeFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.OptimizationStatus, error) {
+			requestTimeout = timeout
+			deadline, _ = ctx.Deadline()
+			return cartofacade.OptimizationStatus{
+				Duration:       1500 * time.Millisecond,
+				ResidualBefore: 12.5,
+				ResidualAfter:  3.25,
+			}, nil
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"optimize": true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{
+			"duration_ms":     int64(1500),
+			"residual_before": 12.5,
+			"residual_after":  3.25,
+			"residual_change": -9.25,
+		})
+		// each request is bounded by the cartofacade timeout, while the wait is bounded by the optimization timeout
+		test.That(t, requestTimeout, test.ShouldEqual, time.Second)
+		test.That(t, time.Until(deadline), test.ShouldBeGreaterThan, time.Second)
+		test.That(t, time.Until(deadline), test.ShouldBeLessThanOrEqualTo, finalOptimizationTimeout)
+	})
+
+	t.Run("cartofacade error", func(t *testing.T) {
+		mockCartoFacade.OptimizeFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.OptimizationStatus, error) {
+			return cartofacade.OptimizationStatus{}, errors.New("test")
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"optimize": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("test"))
+	})
+}
+
+func TestGetTrajectoryCommand(t *testing.T) {
+	svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed(), cartoFacadeTimeout: time.Second}
+	mockCartoFacade := &cartofacade.Mock{}
+	svc.cartofacade = mockCartoFacade
+
+	timestamp := time.Date(2021, 8, 15, 14, 30, 45, 100, time.UTC)
+	var requestedAllTrajectories bool
+	var requestedEveryNNodes int
+	mockCartoFacade.GetTrajectoryFunc = func(
+		ctx context.Context,
+		timeout time.Duration,
+		allTrajectories bool,
+		everyNNodes int,
+	) ([]cartofacade.TrajectoryNode, error) {
+		requestedAllTrajectories = allTrajectories
+		requestedEveryNNodes = everyNNodes
+		return []cartofacade.TrajectoryNode{
+			{TrajectoryID: 0, NodeIndex: 0, Time: timestamp, X: 100, Y: 200, Real: 1},
+			// rotated by 90 degrees around the z axis
+			{TrajectoryID: 1, NodeIndex: 3, Time: timestamp.Add(time.Second), X: 300, Y: 400, Real: math.Sqrt2 / 2, Kmag: math.Sqrt2 / 2},
+		}, nil
+	}
+
+	t.Run("returns the nodes of the active trajectory by default", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_trajectory": true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, requestedAllTrajectories, test.ShouldBeFalse)
+		test.That(t, requestedEveryNNodes, test.ShouldEqual, 1)
+
+		nodes, ok := resp["nodes"].([]interface{})
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, len(nodes), test.ShouldEqual, 2)
+		test.That(t, nodes[0], test.ShouldResemble, map[string]interface{}{
+			"trajectory_id": 0,
+			"node_index":    0,
+			"time":          "2021-08-15T14:30:45.0000001Z",
+			"x":             100.0,
+			"y":             200.0,
+			"z":             0.0,
+			"theta":         0.0,
+		})
+		second := nodes[1].(map[string]interface{})
+		test.That(t, second["trajectory_id"], test.ShouldEqual, 1)
+		test.That(t, second["node_index"], test.ShouldEqual, 3)
+		test.That(t, second["theta"], test.ShouldAlmostEqual, 90.0)
+	})
+
+	t.Run("returns all trajectories downsampled", func(t *testing.T) {
+		_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"get_trajectory": map[string]interface{}{"all_trajectories": true, "every_n_nodes": 5.0},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, requestedAllTrajectories, test.ShouldBeTrue)
+		test.That(t, requestedEveryNNodes, test.ShouldEqual, 5)
+	})
+
+	t.Run("rejects invalid params", func(t *testing.T) {
+		for _, params := range []map[string]interface{}{
+			{"all_trajectories": "yes"},
+			{"every_n_nodes": 0},
+			{"every_n_nodes": 1.5},
+			{"every_n_nodes": "2"},
+		} {
+			resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_trajectory": params})
+			test.That(t, resp, test.ShouldBeNil)
+			test.That(t, err, test.ShouldNotBeNil)
+		}
+	})
+
+	t.Run("cartofacade error", func(t *testing.T) {
+		mockCartoFacade.GetTrajectoryFunc = func(
+			ctx context.Context,
+			timeout time.Duration,
+			allTrajectories bool,
+			everyNNodes int,
+		) ([]cartofacade.TrajectoryNode, error) {
+			return nil, errors.New("test")
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_trajectory": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("test"))
+	})
+}
+
+func TestGetSubmapsCommand(t *testing.T) {
+	svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed(), cartoFacadeTimeout: time.Second}
+	mockCartoFacade := &cartofacade.Mock{}
+	svc.cartofacade = mockCartoFacade
+
+	mockCartoFacade.GetSubmapsFunc = func(ctx context.Context, timeout time.Duration) ([]cartofacade.Submap, error) {
+		return []cartofacade.Submap{
+			{
+				TrajectoryID:     1,
+				SubmapIndex:      2,
+				Version:          90,
+				Finished:         true,
+				ResolutionMeters: 0.05,
+				X:                100,
+				Y:                200,
+				// rotated by 90 degrees around the z axis
+				Real: math.Sqrt2 / 2,
+				Kmag: math.Sqrt2 / 2,
+				MinX: -900,
+				MinY: -800,
+				MaxX: 1100,
+				MaxY: 1200,
+			},
+		}, nil
+	}
+
+	t.Run("returns the submaps", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_submaps": true})
+		test.That(t, err, test.ShouldBeNil)
+		submaps, ok := resp["submaps"].([]interface{})
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, len(submaps), test.ShouldEqual, 1)
+
+		submap := submaps[0].(map[string]interface{})
+		test.That(t, submap["trajectory_id"], test.ShouldEqual, 1)
+		test.That(t, submap["submap_index"], test.ShouldEqual, 2)
+		test.That(t, submap["version"], test.ShouldEqual, 90)
+		test.That(t, submap["finished"], test.ShouldBeTrue)
+		test.That(t, submap["resolution_meters"], test.ShouldEqual, 0.05)
+		pose := submap["pose"].(map[string]interface{})
+		test.That(t, pose["x"], test.ShouldEqual, 100)
+		test.That(t, pose["y"], test.ShouldEqual, 200)
+		test.That(t, pose["theta"], test.ShouldAlmostEqual, 90)
+		test.That(t, submap["bounding_box"], test.ShouldResemble, map[string]interface{}{
+			"min_x": -900.0,
+			"min_y": -800.0,
+			"max_x": 1100.0,
+			"max_y": 1200.0,
+		})
+	})
+
+	t.Run("cartofacade error", func(t *testing.T) {
+		mockCartoFacade.GetSubmapsFunc = func(ctx context.Context, timeout time.Duration) ([]cartofacade.Submap, error) {
+			return nil, errors.New("test")
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_submaps": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("test"))
+	})
+}
+
 func TestParseCartoAlgoConfig(t *testing.T) {
 	logger := golog.NewTestLogger(t)
 
@@ -319,6 +810,7 @@ func TestParseCartoAlgoConfig(t *testing.T) {
 			OccupiedSpaceWeight:  20.0,
 			TranslationWeight:    10.0,
 			RotationWeight:       1.0,
+			MapResolutionMeters:  0.05,
 		}
 
 		configParams := map[string]string{}
@@ -342,6 +834,8 @@ func TestParseCartoAlgoConfig(t *testing.T) {
 			"occupied_space_weight":   "10.0",
 			"translation_weight":      "11.0",
 			"rotation_weight":         "12.0",
+			"map_resolution_meters":   "0.1",
+			"max_map_points":          "13",
 		}
 
 		overRidenCartoAlgoCfg := cartofacade.CartoAlgoConfig{
@@ -358,6 +852,8 @@ func TestParseCartoAlgoConfig(t *testing.T) {
 			OccupiedSpaceWeight:  10.0,
 			TranslationWeight:    11.0,
 			RotationWeight:       12.0,
+			MapResolutionMeters:  0.1,
+			MaxMapPoints:         13,
 		}
 
 		cartoAlgoConfig, err := parseCartoAlgoConfig(configParams, logger)
@@ -380,6 +876,8 @@ func TestParseCartoAlgoConfig(t *testing.T) {
 			"occupied_space_weight":           "10.0",
 			"translation_weight":              "11.0",
 			"rotation_weight":                 "12.0",
+			"map_resolution_meters":           "0.1",
+			"max_map_points":                  "13",
 		}
 
 		overRidenCartoAlgoCfg := cartofacade.CartoAlgoConfig{
@@ -396,6 +894,8 @@ func TestParseCartoAlgoConfig(t *testing.T) {
 			OccupiedSpaceWeight:  10.0,
 			TranslationWeight:    11.0,
 			RotationWeight:       12.0,
+			MapResolutionMeters:  0.1,
+			MaxMapPoints:         13,
 		}
 
 		cartoAlgoConfig, err := parseCartoAlgoConfig(configParams, logger)
@@ -422,6 +922,59 @@ func TestParseCartoAlgoConfig(t *testing.T) {
 		test.That(t, err, test.ShouldBeError, errors.New("strconv.Atoi: parsing \"hihi\": invalid syntax"))
 		test.That(t, cartoAlgoConfig, test.ShouldResemble, defaultCartoAlgoCfg)
 	})
+
+	t.Run("returns the map resolution & point budget", func(t *testing.T) {
+		configParams := map[string]string{
+			"map_resolution_meters": "0.1",
+			"max_map_points":        "100000",
+		}
+
+		cartoAlgoConfig, err := parseCartoAlgoConfig(configParams, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cartoAlgoConfig.MapResolutionMeters, test.ShouldEqual, 0.1)
+		test.That(t, cartoAlgoConfig.MaxMapPoints, test.ShouldEqual, 100000)
+
+		_, err = parseCartoAlgoConfig(map[string]string{"map_resolution_meters": "0.001"}, logger)
+		test.That(t, err, test.ShouldBeError, errors.New("map_resolution_meters must be at least 0.005, got 0.001"))
+		_, err = parseCartoAlgoConfig(map[string]string{"max_map_points": "-1"}, logger)
+		test.That(t, err, test.ShouldBeError, errors.New("max_map_points must not be negative, got -1"))
+	})
+}
+
+func TestRestartConfigParams(t *testing.T) {
+	current := map[string]string{"mode": "2d", "num_range_data": "30"}
+
+	t.Run("returns the current config params when none are given", func(t *testing.T) {
+		configParams, err := restartConfigParams(current, true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, configParams, test.ShouldResemble, current)
+	})
+
+	t.Run("overwrites the current config params without modifying them", func(t *testing.T) {
+		params := map[string]interface{}{
+			"config_params": map[string]interface{}{"num_range_data": 20.0, "optimize_on_start": true, "max_range": "10"},
+		}
+		configParams, err := restartConfigParams(current, params)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, configParams, test.ShouldResemble, map[string]string{
+			"mode":              "2d",
+			"num_range_data":    "20",
+			"optimize_on_start": "true",
+			"max_range":         "10",
+		})
+		test.That(t, current["num_range_data"], test.ShouldEqual, "30")
+	})
+
+	t.Run("errors when config_params is not a map", func(t *testing.T) {
+		_, err := restartConfigParams(current, map[string]interface{}{"config_params": "num_range_data"})
+		test.That(t, err, test.ShouldBeError, errors.New("config_params must be a map, got string"))
+	})
+
+	t.Run("errors when changing the mode", func(t *testing.T) {
+		params := map[string]interface{}{"config_params": map[string]interface{}{"mode": "3d"}}
+		_, err := restartConfigParams(current, params)
+		test.That(t, err, test.ShouldBeError, errors.New("config_params mode can't be changed by a restart"))
+	})
 }
 
 func TestBuiltinQuaternion(t *testing.T) {
@@ -475,3 +1028,1344 @@ func TestBuiltinQuaternion(t *testing.T) {
 		test.That(t, componentRef, test.ShouldBeEmpty)
 	})
 }
+
+func TestToChunkedFunc(t *testing.T) {
+	data := []byte("0123456789")
+
+	t.Run("chunks have their own buffers", func(t *testing.T) {
+		stream := &testStream{data: data}
+		f := toChunkedFunc(exportReader(stream, time.Second, exportOptions{chunkSizeBytes: 4}), 4)
+
+		var chunks [][]byte
+		for {
+			chunk, err := f()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			test.That(t, err, test.ShouldBeNil)
+			chunks = append(chunks, chunk)
+		}
+		test.That(t, chunks, test.ShouldResemble, [][]byte{[]byte("0123"), []byte("4567"), []byte("89")})
+		test.That(t, stream.closed, test.ShouldBeTrue)
+	})
+
+	t.Run("chunks are filled across stream chunks", func(t *testing.T) {
+		stream := &testStream{data: data}
+		// the stream yields chunks of 3 bytes, the callback chunks of 4 bytes
+		r := &streamReader{stream: stream, timeout: time.Second, maxChunkSizeBytes: 3}
+		full, err := slam.HelperConcatenateChunksToFull(toChunkedFunc(r, 4))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, full, test.ShouldResemble, data)
+	})
+
+	t.Run("gzip compressed chunks", func(t *testing.T) {
+		large := bytes.Repeat(data, 10000)
+		stream := &testStream{data: large}
+		f := toChunkedFunc(exportReader(stream, time.Second, exportOptions{chunkSizeBytes: 64, compression: gzipCompression}), 64)
+
+		var compressed []byte
+		for {
+			chunk, err := f()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, len(chunk), test.ShouldBeLessThanOrEqualTo, 64)
+			compressed = append(compressed, chunk...)
+		}
+		test.That(t, len(compressed), test.ShouldBeLessThan, len(large))
+
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		test.That(t, err, test.ShouldBeNil)
+		decompressed, err := io.ReadAll(zr)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, decompressed, test.ShouldResemble, large)
+	})
+}
+
+func TestExportOptions(t *testing.T) {
+	svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed()}
+
+	t.Run("defaults", func(t *testing.T) {
+		opts, err := svc.resolveExportOptions(nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, opts, test.ShouldResemble, exportOptions{chunkSizeBytes: defaultChunkSizeBytes, compression: noCompression})
+
+		svc.chunkSizeBytes = 1024
+		defer func() { svc.chunkSizeBytes = 0 }()
+		opts, err = svc.resolveExportOptions(map[string]interface{}{})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, opts, test.ShouldResemble, exportOptions{chunkSizeBytes: 1024, compression: noCompression})
+	})
+
+	t.Run("params select the compression", func(t *testing.T) {
+		opts, err := svc.resolveExportOptions(map[string]interface{}{"compression": "gzip"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, opts, test.ShouldResemble, exportOptions{chunkSizeBytes: defaultChunkSizeBytes, compression: gzipCompression})
+		test.That(t, opts.fileExt(".pcd"), test.ShouldEqual, ".pcd.gz")
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		_, err := svc.resolveExportOptions(map[string]interface{}{"compression": "zstd"})
+		test.That(t, err, test.ShouldBeError, errors.New(`compression must be one of "none" or "gzip", got zstd`))
+	})
+}
+
+// decodeExport returns the export returned base64 encoded by an export DoCommand.
+func decodeExport(t *testing.T, resp map[string]interface{}) []byte {
+	t.Helper()
+	encoded, ok := resp["data"].(string)
+	test.That(t, ok, test.ShouldBeTrue)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["size_bytes"], test.ShouldEqual, len(data))
+	return data
+}
+
+func gunzip(t *testing.T, compressed []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	test.That(t, err, test.ShouldBeNil)
+	decompressed, err := io.ReadAll(zr)
+	test.That(t, err, test.ShouldBeNil)
+	return decompressed
+}
+
+func TestExportCommands(t *testing.T) {
+	dataDir := t.TempDir()
+	mockCartoFacade := &cartofacade.Mock{}
+	svc := &CartographerService{
+		Named:              resource.NewName(slam.API, "test").AsNamed(),
+		cartoFacadeTimeout: time.Second,
+		cartofacade:        mockCartoFacade,
+		dataDirectory:      dataDir,
+	}
+	setMockMapRevisionFunc(mockCartoFacade)
+	pcd := []byte("pointcloud map")
+	pbstream := []byte("internal state")
+	var streams []*testStream
+	mockCartoFacade.GetPointCloudMapStreamFunc = func(
+		ctx context.Context,
+		timeout time.Duration,
+		opts cartofacade.MapOptions,
+	) (cartofacade.Stream, error) {
+		stream := &testStream{data: pcd}
+		streams = append(streams, stream)
+		return stream, nil
+	}
+	setMockGetInternalStateFunc(mockCartoFacade, pbstream)
+
+	t.Run("export_internal_state returns the pbstream base64 encoded", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{exportInternalStateCommand: true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, decodeExport(t, resp), test.ShouldResemble, pbstream)
+	})
+
+	t.Run("export_internal_state writes the compressed pbstream into the data directory", func(t *testing.T) {
+		setMockGetInternalStateFunc(mockCartoFacade, pbstream)
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			exportInternalStateCommand: map[string]interface{}{"name": "state.pbstream.gz", "compression": "gzip"},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(dataDir, "state.pbstream.gz")
+		test.That(t, resp["path"], test.ShouldEqual, path)
+		compressed, err := os.ReadFile(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["size_bytes"], test.ShouldEqual, int64(len(compressed)))
+		test.That(t, gunzip(t, compressed), test.ShouldResemble, pbstream)
+	})
+
+	t.Run("export_pointcloud_map returns the pcd base64 encoded", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{exportPointCloudMapCommand: true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, decodeExport(t, resp), test.ShouldResemble, pcd)
+
+		resp, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			exportPointCloudMapCommand: map[string]interface{}{"compression": "gzip"},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, gunzip(t, decodeExport(t, resp)), test.ShouldResemble, pcd)
+	})
+
+	t.Run("export_pointcloud_map writes the pcd into the data directory", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			exportPointCloudMapCommand: map[string]interface{}{"name": "map"},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(dataDir, "map.pcd")
+		test.That(t, resp["path"], test.ShouldEqual, path)
+		written, err := os.ReadFile(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, written, test.ShouldResemble, pcd)
+	})
+
+	t.Run("rejects invalid params before creating a stream", func(t *testing.T) {
+		created := len(streams)
+		for _, params := range []map[string]interface{}{
+			{"compression": "zstd"},
+			{"name": "../map"},
+			{"chunk_size_bytes": 16},
+		} {
+			resp, err := svc.DoCommand(context.Background(), map[string]interface{}{exportPointCloudMapCommand: params})
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, resp, test.ShouldBeNil)
+		}
+		_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			exportInternalStateCommand: map[string]interface{}{"chunk_size_bytes": 16},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New(
+			"export_internal_state got unknown arguments chunk_size_bytes, expected name, compression"))
+
+		svc.dataDirectory = ""
+		defer func() { svc.dataDirectory = dataDir }()
+		_, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			exportPointCloudMapCommand: map[string]interface{}{"name": "map"},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New("export_pointcloud_map requires data_dir to be configured to write name"))
+		test.That(t, len(streams), test.ShouldEqual, created)
+	})
+
+	t.Run("a failed export closes the stream & doesn't leave a file behind", func(t *testing.T) {
+		stream := &testStream{data: []byte("hello!"), nextErr: errors.New("test")}
+		mockCartoFacade.GetInternalStateStreamFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.Stream, error) {
+			return stream, nil
+		}
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			exportInternalStateCommand: map[string]interface{}{"name": "failed"},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New("test"))
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, stream.closed, test.ShouldBeTrue)
+		_, err = os.Stat(filepath.Join(dataDir, "failed.pbstream"))
+		test.That(t, os.IsNotExist(err), test.ShouldBeTrue)
+	})
+
+	t.Run("export_pointcloud_map rejects an invalid format before creating a stream", func(t *testing.T) {
+		created := len(streams)
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			exportPointCloudMapCommand: map[string]interface{}{"format": "occupancy_grid"},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New(`format must be one of "pcd", "pcd_binary", `+
+			`"pcd_binary_compressed", "pcd_ascii" or "ply", got occupancy_grid`))
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, len(streams), test.ShouldEqual, created)
+	})
+
+	t.Run("export_occupancy_grid exports the dense occupancy grid", func(t *testing.T) {
+		grid := cartofacade.OccupancyGrid{
+			Width:            2,
+			Height:           2,
+			ResolutionMeters: 0.05,
+			OriginX:          -0.05,
+			OriginY:          -0.05,
+			Cells:            []byte{100, 0, cartofacade.OccupancyGridUnknownCell, 0},
+		}
+		mockCartoFacade.GetOccupancyGridFunc = func(
+			ctx context.Context,
+			timeout time.Duration,
+			opts cartofacade.MapOptions,
+		) (cartofacade.OccupancyGrid, error) {
+			return grid, nil
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{exportOccupancyGridCommand: true})
+		test.That(t, err, test.ShouldBeNil)
+		decoded, err := densegrid.Decode(bytes.NewReader(decodeExport(t, resp)))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, decoded, test.ShouldResemble, grid)
+
+		resp, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			exportOccupancyGridCommand: map[string]interface{}{"compression": "gzip", "name": "grid"},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(dataDir, "grid.grid.gz")
+		test.That(t, resp["path"], test.ShouldEqual, path)
+		compressed, err := os.ReadFile(path)
+		test.That(t, err, test.ShouldBeNil)
+		decoded, err = densegrid.Decode(bytes.NewReader(gunzip(t, compressed)))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, decoded, test.ShouldResemble, grid)
+
+		svc.dataDirectory = ""
+		defer func() { svc.dataDirectory = dataDir }()
+		_, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			exportOccupancyGridCommand: map[string]interface{}{"name": "grid"},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New("export_occupancy_grid requires data_dir to be configured to write name"))
+	})
+
+	t.Run("export commands paint the map with the requested map options", func(t *testing.T) {
+		var streamOpts, gridOpts []cartofacade.MapOptions
+		mockCartoFacade.GetPointCloudMapStreamFunc = func(
+			ctx context.Context,
+			timeout time.Duration,
+			opts cartofacade.MapOptions,
+		) (cartofacade.Stream, error) {
+			streamOpts = append(streamOpts, opts)
+			return &testStream{}, nil
+		}
+		mockCartoFacade.GetOccupancyGridFunc = func(
+			ctx context.Context,
+			timeout time.Duration,
+			opts cartofacade.MapOptions,
+		) (cartofacade.OccupancyGrid, error) {
+			gridOpts = append(gridOpts, opts)
+			return cartofacade.OccupancyGrid{Width: 1, Height: 1, ResolutionMeters: 0.1, Cells: []byte{0}}, nil
+		}
+
+		_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			exportPointCloudMapCommand: map[string]interface{}{"resolution_meters": 0.1, "max_points": float64(1000)},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		_, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			exportOccupancyGridCommand: map[string]interface{}{"max_points": 500},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, streamOpts, test.ShouldResemble, []cartofacade.MapOptions{{ResolutionMeters: 0.1, MaxPoints: 1000}})
+		test.That(t, gridOpts, test.ShouldResemble, []cartofacade.MapOptions{{MaxPoints: 500}})
+
+		for _, params := range []map[string]interface{}{
+			{"resolution_meters": 0.001},
+			{"resolution_meters": "fine"},
+			{"max_points": 0},
+			{"max_points": 10.5},
+		} {
+			for _, cmd := range []string{exportPointCloudMapCommand, exportOccupancyGridCommand} {
+				resp, err := svc.DoCommand(context.Background(), map[string]interface{}{cmd: params})
+				test.That(t, err, test.ShouldNotBeNil)
+				test.That(t, resp, test.ShouldBeNil)
+			}
+		}
+		test.That(t, len(streamOpts), test.ShouldEqual, 1)
+		test.That(t, len(gridOpts), test.ShouldEqual, 1)
+	})
+
+	t.Run("export_pointcloud_map writes the pointcloud map in the requested format", func(t *testing.T) {
+		var formats []cartofacade.PointCloudFormat
+		mockCartoFacade.GetPointCloudMapStreamFunc = func(
+			ctx context.Context,
+			timeout time.Duration,
+			opts cartofacade.MapOptions,
+		) (cartofacade.Stream, error) {
+			formats = append(formats, opts.Format)
+			return &testStream{}, nil
+		}
+
+		for _, format := range []string{"pcd", "pcd_binary", "pcd_binary_compressed", "pcd_ascii", "ply"} {
+			_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+				exportPointCloudMapCommand: map[string]interface{}{"format": format},
+			})
+			test.That(t, err, test.ShouldBeNil)
+		}
+		test.That(t, formats, test.ShouldResemble, cartofacade.PointCloudFormats)
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			exportPointCloudMapCommand: map[string]interface{}{"format": "ply", "name": "map.ply"},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["path"], test.ShouldEqual, filepath.Join(dataDir, "map.ply"))
+		test.That(t, formats[len(formats)-1], test.ShouldEqual, cartofacade.PLYFormat)
+	})
+}
+
+func TestPointCloudMapCache(t *testing.T) {
+	mockCartoFacade := &cartofacade.Mock{}
+	svc := &CartographerService{
+		Named:              resource.NewName(slam.API, "test").AsNamed(),
+		cartoFacadeTimeout: time.Second,
+		cartofacade:        mockCartoFacade,
+		logger:             golog.NewTestLogger(t),
+	}
+	revision := cartofacade.MapRevision{Revision: 1, ChangedAt: time.Now().UTC()}
+	var revisionErr error
+	mockCartoFacade.MapRevisionFunc = func() (cartofacade.MapRevision, error) {
+		return revision, revisionErr
+	}
+	streams := 0
+	mockCartoFacade.GetPointCloudMapStreamFunc = func(
+		ctx context.Context,
+		timeout time.Duration,
+		opts cartofacade.MapOptions,
+	) (cartofacade.Stream, error) {
+		streams++
+		return &testStream{data: bytes.Repeat([]byte("pcd"), revision.Revision)}, nil
+	}
+
+	getPointCloudMap := func() []byte {
+		callback, err := svc.GetPointCloudMap(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		pcd, err := slam.HelperConcatenateChunksToFull(callback)
+		test.That(t, err, test.ShouldBeNil)
+		return pcd
+	}
+	export := func(params map[string]interface{}) []byte {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{exportPointCloudMapCommand: params})
+		test.That(t, err, test.ShouldBeNil)
+		return decodeExport(t, resp)
+	}
+
+	t.Run("serves an unchanged revision without repainting", func(t *testing.T) {
+		pcd := getPointCloudMap()
+		test.That(t, streams, test.ShouldEqual, 1)
+		test.That(t, getPointCloudMap(), test.ShouldResemble, pcd)
+		test.That(t, export(nil), test.ShouldResemble, pcd)
+		test.That(t, gunzip(t, export(map[string]interface{}{"compression": "gzip"})), test.ShouldResemble, pcd)
+		test.That(t, streams, test.ShouldEqual, 1)
+	})
+
+	t.Run("repaints a new revision", func(t *testing.T) {
+		pcd := getPointCloudMap()
+		revision = cartofacade.MapRevision{Revision: 2, ChangedAt: time.Now().UTC()}
+		test.That(t, getPointCloudMap(), test.ShouldNotResemble, pcd)
+		test.That(t, streams, test.ShouldEqual, 2)
+		getPointCloudMap()
+		test.That(t, streams, test.ShouldEqual, 2)
+	})
+
+	t.Run("repaints other map options", func(t *testing.T) {
+		export(map[string]interface{}{"resolution_meters": 0.1})
+		test.That(t, streams, test.ShouldEqual, 3)
+		getPointCloudMap()
+		test.That(t, streams, test.ShouldEqual, 4)
+		export(map[string]interface{}{"format": "ply"})
+		test.That(t, streams, test.ShouldEqual, 5)
+		export(map[string]interface{}{"format": "ply"})
+		test.That(t, streams, test.ShouldEqual, 5)
+	})
+
+	t.Run("doesn't cache a pcd which wasn't read to the end", func(t *testing.T) {
+		revision = cartofacade.MapRevision{Revision: 3, ChangedAt: time.Now().UTC()}
+		_, err := svc.GetPointCloudMap(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, streams, test.ShouldEqual, 6)
+		getPointCloudMap()
+		test.That(t, streams, test.ShouldEqual, 7)
+	})
+
+	t.Run("neither serves nor caches a pcd under a revision which failed to be read", func(t *testing.T) {
+		revisionErr = errors.New("test")
+		getPointCloudMap()
+		test.That(t, streams, test.ShouldEqual, 8)
+		revision = cartofacade.MapRevision{Revision: 4, ChangedAt: time.Now().UTC()}
+		getPointCloudMap()
+		test.That(t, streams, test.ShouldEqual, 9)
+
+		revisionErr = nil
+		getPointCloudMap()
+		test.That(t, streams, test.ShouldEqual, 10)
+		getPointCloudMap()
+		test.That(t, streams, test.ShouldEqual, 10)
+	})
+}
+
+func TestGetLatestMapInfo(t *testing.T) {
+	mockCartoFacade := &cartofacade.Mock{}
+	svc := &CartographerService{
+		Named:              resource.NewName(slam.API, "test").AsNamed(),
+		cartoFacadeTimeout: time.Second,
+		cartofacade:        mockCartoFacade,
+		SlamMode:           cartofacade.MappingMode,
+		logger:             golog.NewTestLogger(t),
+	}
+	revision := cartofacade.MapRevision{Revision: 1, ChangedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var revisionErr error
+	mockCartoFacade.MapRevisionFunc = func() (cartofacade.MapRevision, error) {
+		return revision, revisionErr
+	}
+
+	t.Run("returns when the map last changed while mapping", func(t *testing.T) {
+		timestamp, err := svc.GetLatestMapInfo(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, timestamp, test.ShouldEqual, revision.ChangedAt)
+		timestamp, err = svc.GetLatestMapInfo(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, timestamp, test.ShouldEqual, revision.ChangedAt)
+
+		revision = cartofacade.MapRevision{Revision: 2, ChangedAt: time.Date(2023, 1, 1, 0, 1, 0, 0, time.UTC)}
+		timestamp, err = svc.GetLatestMapInfo(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, timestamp, test.ShouldEqual, revision.ChangedAt)
+	})
+
+	t.Run("returns the last revision read if reading the revision fails", func(t *testing.T) {
+		revisionErr = errors.New("test")
+		defer func() { revisionErr = nil }()
+		timestamp, err := svc.GetLatestMapInfo(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, timestamp, test.ShouldEqual, revision.ChangedAt)
+	})
+
+	t.Run("returns when the map was frozen while localizing", func(t *testing.T) {
+		svc.SlamMode = cartofacade.LocalizingMode
+		svc.mapTimestamp = time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+		timestamp, err := svc.GetLatestMapInfo(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, timestamp, test.ShouldEqual, svc.mapTimestamp)
+	})
+}
+
+func TestGetConfigCommand(t *testing.T) {
+	configParams := map[string]string{"mode": "2d", "max_range": "12.5", "min_range_meters": "0.3"}
+	algoCfg, err := parseCartoAlgoConfig(configParams, golog.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	svc := &CartographerService{
+		Named:           resource.NewName(slam.API, "test").AsNamed(),
+		configParams:    configParams,
+		cartoAlgoConfig: algoCfg,
+	}
+	mockCartoFacade := &cartofacade.Mock{}
+	svc.cartofacade = mockCartoFacade
+
+	t.Run("returns the resolved config, its sources & the map builder config", func(t *testing.T) {
+		mockCartoFacade.GetConfigFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.GetConfig, error) {
+			return cartofacade.GetConfig{
+				ConfigurationDirectory: "/usr/local/share/cartographer/lua_files",
+				ConfigurationBasename:  "mapping_new_map.lua",
+				OptimizeEveryNNodes:    3,
+				NumRangeData:           30,
+				MissingDataRayLength:   25,
+				MaxRange:               12.5,
+				MinRange:               0.3,
+				OccupiedSpaceWeight:    20,
+				TranslationWeight:      10,
+				RotationWeight:         1,
+			}, nil
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_config": true})
+		test.That(t, err, test.ShouldBeNil)
+
+		resolved := resp["algo_config"].(map[string]interface{})
+		test.That(t, resolved["max_range_meters"], test.ShouldEqual, 12.5)
+		test.That(t, resolved["min_range_meters"], test.ShouldEqual, 0.3)
+		test.That(t, resolved["num_range_data"], test.ShouldEqual, defaultCartoAlgoCfg.NumRangeData)
+		test.That(t, len(resolved), test.ShouldEqual, len(cartoAlgoConfigParamKeys))
+
+		sources := resp["sources"].(map[string]interface{})
+		test.That(t, len(sources), test.ShouldEqual, len(cartoAlgoConfigParamKeys))
+		test.That(t, sources["max_range_meters"], test.ShouldResemble, map[string]interface{}{
+			"source": "config_params",
+			"keys":   []interface{}{"max_range"},
+		})
+		test.That(t, sources["min_range_meters"], test.ShouldResemble, map[string]interface{}{
+			"source": "config_params",
+			"keys":   []interface{}{"min_range_meters"},
+		})
+		test.That(t, sources["num_range_data"], test.ShouldResemble, map[string]interface{}{"source": "default"})
+
+		test.That(t, resp["lua"], test.ShouldResemble, map[string]interface{}{
+			"configuration_directory": "/usr/local/share/cartographer/lua_files",
+			"configuration_basename":  "mapping_new_map.lua",
+		})
+		mapBuilder := resp["map_builder"].(map[string]interface{})
+		test.That(t, mapBuilder["max_range_meters"], test.ShouldEqual, 12.5)
+		test.That(t, mapBuilder["min_range_meters"], test.ShouldEqual, 0.3)
+		test.That(t, mapBuilder["max_submaps_to_keep"], test.ShouldEqual, 0)
+		// the map builder isn't built with optimize_on_start nor the values the map is painted with
+		test.That(t, len(mapBuilder), test.ShouldEqual, len(cartoAlgoConfigParamKeys)-3)
+	})
+
+	t.Run("cartofacade error", func(t *testing.T) {
+		mockCartoFacade.GetConfigFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.GetConfig, error) {
+			return cartofacade.GetConfig{}, errors.New("test")
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_config": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("test"))
+	})
+}
+
+func TestResetMapCommand(t *testing.T) {
+	svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed(), SlamMode: cartofacade.UpdatingMode}
+
+	t.Run("rejects an invalid delete_snapshots param", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"reset_map": map[string]interface{}{"delete_snapshots": "yes"},
+		})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("delete_snapshots must be a bool, got string"))
+	})
+
+	t.Run("is rejected unless mapping", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"reset_map": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("reset_map is only supported in mapping mode, slam mode is updating"))
+	})
+}
+
+func TestClearInternalState(t *testing.T) {
+	writeSnapshots := func(t *testing.T, dataDir string) []string {
+		internalStateDir := filepath.Join(dataDir, "internal_state")
+		test.That(t, os.MkdirAll(internalStateDir, 0o755), test.ShouldBeNil)
+		names := []string{"map_data_2021-08-15T14:30:45.0000Z.pbstream", "map_data_2021-08-15T14:31:45.0000Z.pbstream"}
+		for _, name := range names {
+			test.That(t, os.WriteFile(filepath.Join(internalStateDir, name), []byte("pbstream"), 0o600), test.ShouldBeNil)
+		}
+		return names
+	}
+
+	t.Run("moves the snapshots into a subdirectory", func(t *testing.T) {
+		dataDir := t.TempDir()
+		names := writeSnapshots(t, dataDir)
+		svc := &CartographerService{dataDirectory: dataDir}
+
+		snapshots, archiveDir, err := svc.clearInternalState(false)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, snapshots, test.ShouldEqual, 2)
+		test.That(t, archiveDir, test.ShouldStartWith, filepath.Join(dataDir, "internal_state", "reset_"))
+		remaining, err := filepath.Glob(filepath.Join(dataDir, "internal_state", "*.pbstream"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, remaining, test.ShouldBeEmpty)
+		for _, name := range names {
+			_, err := os.Stat(filepath.Join(archiveDir, name))
+			test.That(t, err, test.ShouldBeNil)
+		}
+	})
+
+	t.Run("deletes the snapshots", func(t *testing.T) {
+		dataDir := t.TempDir()
+		writeSnapshots(t, dataDir)
+		svc := &CartographerService{dataDirectory: dataDir}
+
+		snapshots, archiveDir, err := svc.clearInternalState(true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, snapshots, test.ShouldEqual, 2)
+		test.That(t, archiveDir, test.ShouldBeEmpty)
+		entries, err := os.ReadDir(filepath.Join(dataDir, "internal_state"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, entries, test.ShouldBeEmpty)
+	})
+
+	t.Run("does nothing when there are no snapshots", func(t *testing.T) {
+		svc := &CartographerService{dataDirectory: t.TempDir()}
+		snapshots, archiveDir, err := svc.clearInternalState(false)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, snapshots, test.ShouldEqual, 0)
+		test.That(t, archiveDir, test.ShouldBeEmpty)
+	})
+
+	t.Run("forgets the existing map with the cloud story", func(t *testing.T) {
+		dataDir := t.TempDir()
+		writeSnapshots(t, dataDir)
+		svc := &CartographerService{dataDirectory: dataDir, cloudStoryEnabled: true, existingMap: "restart_snapshot.pbstream"}
+
+		snapshots, archiveDir, err := svc.clearInternalState(true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, snapshots, test.ShouldEqual, 0)
+		test.That(t, archiveDir, test.ShouldBeEmpty)
+		test.That(t, svc.existingMap, test.ShouldBeEmpty)
+	})
+}
+
+func TestUseSnapshot(t *testing.T) {
+	t.Run("continues mapping from the snapshot", func(t *testing.T) {
+		for _, cloudStoryEnabled := range []bool{false, true} {
+			svc := &CartographerService{SlamMode: cartofacade.MappingMode, cloudStoryEnabled: cloudStoryEnabled}
+			svc.useSnapshot("snapshot.pbstream")
+			test.That(t, svc.initialInternalState, test.ShouldEqual, "snapshot.pbstream")
+			test.That(t, svc.existingMap, test.ShouldBeEmpty)
+		}
+	})
+
+	t.Run("updates the snapshot as the existing map with the cloud story", func(t *testing.T) {
+		svc := &CartographerService{SlamMode: cartofacade.UpdatingMode, cloudStoryEnabled: true, existingMap: "map.pbstream"}
+		svc.useSnapshot("snapshot.pbstream")
+		test.That(t, svc.initialInternalState, test.ShouldBeEmpty)
+		test.That(t, svc.existingMap, test.ShouldEqual, "snapshot.pbstream")
+	})
+
+	t.Run("leaves loading the latest snapshot in the data directory to cartographer", func(t *testing.T) {
+		svc := &CartographerService{SlamMode: cartofacade.UpdatingMode}
+		svc.useSnapshot("snapshot.pbstream")
+		test.That(t, svc.initialInternalState, test.ShouldBeEmpty)
+		test.That(t, svc.existingMap, test.ShouldBeEmpty)
+	})
+
+	t.Run("keeps the map loaded when localizing", func(t *testing.T) {
+		svc := &CartographerService{SlamMode: cartofacade.LocalizingMode, cloudStoryEnabled: true, existingMap: "map.pbstream"}
+		svc.useSnapshot("")
+		test.That(t, svc.initialInternalState, test.ShouldBeEmpty)
+		test.That(t, svc.existingMap, test.ShouldEqual, "map.pbstream")
+	})
+}
+
+func TestSwitchModeCommand(t *testing.T) {
+	svc := &CartographerService{
+		Named:              resource.NewName(slam.API, "test").AsNamed(),
+		cartoFacadeTimeout: time.Second,
+		SlamMode:           cartofacade.MappingMode,
+		enableMapping:      true,
+	}
+	mockCartoFacade := &cartofacade.Mock{}
+	svc.cartofacade = mockCartoFacade
+
+	var calls []string
+	lockedWhileOptimizing := false
+	mockCartoFacade.OptimizeFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.OptimizationStatus, error) {
+		calls = append(calls, "optimize")
+		if svc.mu.TryLock() {
+			svc.mu.Unlock()
+		} else {
+			lockedWhileOptimizing = true
+		}
+		return cartofacade.OptimizationStatus{}, nil
+	}
+	mockCartoFacade.SwitchToLocalizationFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.SlamMode, error) {
+		calls = append(calls, "switch_to_localization")
+		return cartofacade.MappingMode, errors.New("VIAM_CARTO_FROZEN_MAP_EMPTY")
+	}
+
+	t.Run("rejects an invalid mode", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"switch_mode": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("switch_mode requires mode"))
+
+		resp, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			"switch_mode": map[string]interface{}{"mode": "mapping"},
+		})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New(`switch_mode only supports switching to localizing, got "mapping"`))
+		test.That(t, calls, test.ShouldBeEmpty)
+	})
+
+	t.Run("keeps mapping when the cartofacade fails to switch", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"switch_mode": map[string]interface{}{"mode": "localizing"},
+		})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("VIAM_CARTO_FROZEN_MAP_EMPTY"))
+		test.That(t, svc.SlamMode, test.ShouldEqual, cartofacade.MappingMode)
+		test.That(t, svc.switchedToLocalization, test.ShouldBeFalse)
+	})
+
+	t.Run("keeps mapping when the request never reached cartographer", func(t *testing.T) {
+		mockCartoFacade.SwitchToLocalizationFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.SlamMode, error) {
+			return cartofacade.UnknownMode, errors.New("timeout writing to cartographer")
+		}
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"switch_mode": map[string]interface{}{"mode": "localizing"},
+		})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("timeout writing to cartographer"))
+		test.That(t, svc.SlamMode, test.ShouldEqual, cartofacade.MappingMode)
+		test.That(t, svc.switchedToLocalization, test.ShouldBeFalse)
+	})
+
+	t.Run("optimizes then switches to localizing", func(t *testing.T) {
+		calls = nil
+		var switchTimeout time.Duration
+		mockCartoFacade.SwitchToLocalizationFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.SlamMode, error) {
+			calls = append(calls, "switch_to_localization")
+			switchTimeout = timeout
+			return cartofacade.LocalizingMode, nil
+		}
+
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"switch_mode": map[string]interface{}{"mode": "localizing"},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{"switch_mode": true, "slam_mode": "localizing"})
+		test.That(t, calls, test.ShouldResemble, []string{"optimize", "switch_to_localization"})
+		// the map is only optimized before switching, which then takes no longer than other requests
+		test.That(t, switchTimeout, test.ShouldEqual, svc.cartoFacadeTimeout)
+		test.That(t, lockedWhileOptimizing, test.ShouldBeFalse)
+		test.That(t, svc.SlamMode, test.ShouldEqual, cartofacade.LocalizingMode)
+		test.That(t, svc.switchedToLocalization, test.ShouldBeTrue)
+		test.That(t, svc.enableMapping, test.ShouldBeFalse)
+
+		// the map timestamp is fixed once localizing
+		mapTimestamp, err := svc.GetLatestMapInfo(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, mapTimestamp, test.ShouldEqual, svc.mapTimestamp)
+		time.Sleep(time.Millisecond)
+		nextMapTimestamp, err := svc.GetLatestMapInfo(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, nextMapTimestamp, test.ShouldEqual, mapTimestamp)
+	})
+
+	t.Run("is rejected when already localizing", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"switch_mode": map[string]interface{}{"mode": "localizing"},
+		})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("switch_mode is only supported in mapping or updating mode, slam mode is localizing"))
+	})
+
+	t.Run("localizes when cartographer froze the map but failed afterwards", func(t *testing.T) {
+		svc.SlamMode = cartofacade.MappingMode
+		svc.switchedToLocalization = false
+		svc.enableMapping = true
+		mockCartoFacade.SwitchToLocalizationFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.SlamMode, error) {
+			return cartofacade.LocalizingMode, errors.New("VIAM_CARTO_UNKNOWN_ERROR")
+		}
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"switch_mode": map[string]interface{}{"mode": "localizing"},
+		})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("VIAM_CARTO_UNKNOWN_ERROR"))
+		test.That(t, svc.SlamMode, test.ShouldEqual, cartofacade.LocalizingMode)
+		test.That(t, svc.switchedToLocalization, test.ShouldBeTrue)
+		test.That(t, svc.enableMapping, test.ShouldBeFalse)
+	})
+
+	t.Run("requests read the slam mode without racing switch_mode", func(t *testing.T) {
+		svc.SlamMode = cartofacade.MappingMode
+		setMockMapRevisionFunc(mockCartoFacade)
+		mockCartoFacade.SwitchToLocalizationFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.SlamMode, error) {
+			return cartofacade.LocalizingMode, nil
+		}
+		errs := make(chan error, 1)
+		go func() {
+			_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+				"switch_mode": map[string]interface{}{"mode": "localizing"},
+			})
+			errs <- err
+		}()
+		for i := 0; i < 100; i++ {
+			_, err := svc.GetLatestMapInfo(context.Background())
+			test.That(t, err, test.ShouldBeNil)
+		}
+		test.That(t, <-errs, test.ShouldBeNil)
+	})
+}
+
+func TestDoCommandRegistry(t *testing.T) {
+	svc := &CartographerService{Named: resource.NewName(slam.API, "test").AsNamed(), SlamMode: cartofacade.MappingMode}
+
+	t.Run("help lists every command & whether it is available in the current slam mode", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"help": true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["slam_mode"], test.ShouldEqual, "mapping")
+		// the response has to be convertible to a protobuf struct to be returned over grpc
+		_, err = structpb.NewStruct(resp)
+		test.That(t, err, test.ShouldBeNil)
+
+		commands := map[string]map[string]interface{}{}
+		for _, untyped := range resp["commands"].([]interface{}) {
+			cmd := untyped.(map[string]interface{})
+			commands[cmd["name"].(string)] = cmd
+		}
+		test.That(t, len(commands), test.ShouldEqual, len(doCommands))
+		for _, name := range []string{
+			"help", "job_done", "restart", "get_stats", "save_map", "set_pose", "optimize",
+			"get_trajectory", "get_submaps", "get_config", "reset_map", "switch_mode", "get_map_region", "get_map_updates",
+			"export_pointcloud_map", "export_occupancy_grid", "export_internal_state",
+		} {
+			test.That(t, commands, test.ShouldContainKey, name)
+			test.That(t, commands[name]["description"], test.ShouldNotBeEmpty)
+		}
+		test.That(t, commands["set_pose"]["available"], test.ShouldBeFalse)
+		test.That(t, commands["set_pose"]["slam_modes"], test.ShouldResemble, []interface{}{"localizing"})
+		test.That(t, commands["set_pose"]["args"], test.ShouldResemble, []interface{}{
+			map[string]interface{}{"name": "x", "type": "number", "required": true, "description": "x in millimeters"},
+			map[string]interface{}{"name": "y", "type": "number", "required": true, "description": "y in millimeters"},
+			map[string]interface{}{"name": "theta", "type": "number", "required": false, "description": "theta in degrees, defaults to 0"},
+		})
+		test.That(t, commands["reset_map"]["available"], test.ShouldBeTrue)
+		test.That(t, commands["optimize"]["available"], test.ShouldBeTrue)
+		test.That(t, commands["optimize"]["slam_modes"], test.ShouldBeEmpty)
+	})
+
+	t.Run("rejects invalid arguments before running the command", func(t *testing.T) {
+		for _, tc := range []struct {
+			req         map[string]interface{}
+			expectedErr error
+		}{
+			{
+				map[string]interface{}{"save_map": map[string]interface{}{"name": "map", "compress": true}},
+				errors.New("save_map got unknown arguments compress, expected name, optimize"),
+			},
+			{
+				map[string]interface{}{"get_stats": map[string]interface{}{"verbose": true}},
+				errors.New("get_stats got unknown arguments verbose, expected none"),
+			},
+			{
+				map[string]interface{}{"save_map": map[string]interface{}{"optimize": "yes"}},
+				errors.New("optimize must be a bool, got string"),
+			},
+			{
+				map[string]interface{}{"get_trajectory": map[string]interface{}{"every_n_nodes": "2"}},
+				errors.New("every_n_nodes must be a number, got string"),
+			},
+			{
+				map[string]interface{}{"restart": map[string]interface{}{"config_params": "num_range_data=20"}},
+				errors.New("config_params must be a map, got string"),
+			},
+			{
+				map[string]interface{}{"set_pose": map[string]interface{}{"x": 1.0}},
+				errors.New("set_pose requires y"),
+			},
+		} {
+			resp, err := svc.DoCommand(context.Background(), tc.req)
+			test.That(t, resp, test.ShouldBeNil)
+			test.That(t, err, test.ShouldBeError, tc.expectedErr)
+		}
+	})
+
+	t.Run("rejects commands not allowed in the current slam mode", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"set_pose": map[string]interface{}{"x": 1.0, "y": 2.0},
+		})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldBeError, errors.New("set_pose is only supported in localizing mode, slam mode is mapping"))
+	})
+
+	t.Run("returns UnimplementedError for unknown commands", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"make_coffee": true})
+		test.That(t, resp, test.ShouldBeNil)
+		test.That(t, err, test.ShouldEqual, viamgrpc.UnimplementedError)
+	})
+}
+
+func TestExportROSMapCommand(t *testing.T) {
+	grid := cartofacade.OccupancyGrid{
+		Width:            2,
+		Height:           1,
+		ResolutionMeters: 0.05,
+		OriginX:          -0.05,
+		OriginY:          -0.025,
+		Cells:            []byte{100, cartofacade.OccupancyGridUnknownCell},
+	}
+	mockCartoFacade := &cartofacade.Mock{}
+	mockCartoFacade.GetOccupancyGridFunc = func(
+		ctx context.Context,
+		timeout time.Duration,
+		opts cartofacade.MapOptions,
+	) (cartofacade.OccupancyGrid, error) {
+		return grid, nil
+	}
+	newSvc := func(dataDir string) *CartographerService {
+		return &CartographerService{
+			Named:              resource.NewName(slam.API, "test").AsNamed(),
+			cartoFacadeTimeout: time.Second,
+			cartofacade:        mockCartoFacade,
+			dataDirectory:      dataDir,
+		}
+	}
+
+	t.Run("writes the pgm & yaml into the data directory", func(t *testing.T) {
+		dataDir := t.TempDir()
+		resp, err := newSvc(dataDir).DoCommand(context.Background(), map[string]interface{}{
+			"export_ros_map": map[string]interface{}{"name": "office.pgm", "free_thresh": 0.25, "occupied_thresh": 0.7},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{
+			"pgm":               filepath.Join(dataDir, "office.pgm"),
+			"yaml":              filepath.Join(dataDir, "office.yaml"),
+			"width":             2,
+			"height":            1,
+			"resolution_meters": 0.05,
+			"origin":            []interface{}{-0.05, -0.025, 0.0},
+		})
+		yaml, err := os.ReadFile(filepath.Join(dataDir, "office.yaml"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(yaml), test.ShouldContainSubstring, "free_thresh: 0.25\n")
+	})
+
+	t.Run("names the files after a timestamp by default", func(t *testing.T) {
+		dataDir := t.TempDir()
+		resp, err := newSvc(dataDir).DoCommand(context.Background(), map[string]interface{}{"export_ros_map": true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["pgm"], test.ShouldStartWith, filepath.Join(dataDir, "ros_map_"))
+	})
+
+	t.Run("rejects invalid params", func(t *testing.T) {
+		svc := newSvc(t.TempDir())
+		_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"export_ros_map": map[string]interface{}{"name": "../office"},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New(`name must be a file name without a directory, got "../office"`))
+
+		_, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			"export_ros_map": map[string]interface{}{"free_thresh": 0.7},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New("thresholds must satisfy 0 < free_thresh < occupied_thresh < 1, "+
+			"got free_thresh 0.7 & occupied_thresh 0.65"))
+	})
+
+	t.Run("requires a data directory", func(t *testing.T) {
+		_, err := newSvc("").DoCommand(context.Background(), map[string]interface{}{"export_ros_map": true})
+		test.That(t, err, test.ShouldBeError, errors.New("export_ros_map requires data_dir to be configured"))
+	})
+}
+
+func TestRenderMapCommand(t *testing.T) {
+	grid := cartofacade.OccupancyGrid{
+		Width:            2,
+		Height:           1,
+		ResolutionMeters: 0.05,
+		OriginX:          -0.05,
+		OriginY:          -0.025,
+		Cells:            []byte{100, 0},
+	}
+	var gridErr error
+	mockCartoFacade := &cartofacade.Mock{}
+	mockCartoFacade.GetOccupancyGridFunc = func(
+		ctx context.Context,
+		timeout time.Duration,
+		opts cartofacade.MapOptions,
+	) (cartofacade.OccupancyGrid, error) {
+		return grid, gridErr
+	}
+	mockCartoFacade.GetTrajectoryFunc = func(
+		ctx context.Context,
+		timeout time.Duration,
+		allTrajectories bool,
+		everyNNodes int,
+	) ([]cartofacade.TrajectoryNode, error) {
+		test.That(t, allTrajectories, test.ShouldBeTrue)
+		return []cartofacade.TrajectoryNode{{X: -25}, {X: 25}}, nil
+	}
+	mockCartoFacade.GetSubmapsFunc = func(ctx context.Context, timeout time.Duration) ([]cartofacade.Submap, error) {
+		return []cartofacade.Submap{{MinX: -50, MinY: -25, MaxX: 50, MaxY: 25}}, nil
+	}
+	mockCartoFacade.GetPositionFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.GetPosition, error) {
+		return cartofacade.GetPosition{Real: 1}, nil
+	}
+	newSvc := func(dataDir string) *CartographerService {
+		return &CartographerService{
+			Named:              resource.NewName(slam.API, "test").AsNamed(),
+			cartoFacadeTimeout: time.Second,
+			cartofacade:        mockCartoFacade,
+			dataDirectory:      dataDir,
+			mapImageOptions:    maprender.Options{Scale: 4},
+		}
+	}
+
+	t.Run("returns the png base64 encoded with the configured options", func(t *testing.T) {
+		resp, err := newSvc("").DoCommand(context.Background(), map[string]interface{}{"render_map": true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["width"], test.ShouldEqual, 8)
+		test.That(t, resp["height"], test.ShouldEqual, 4)
+
+		b, err := base64.StdEncoding.DecodeString(resp["png"].(string))
+		test.That(t, err, test.ShouldBeNil)
+		img, err := png.Decode(bytes.NewReader(b))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, img.Bounds().Dx(), test.ShouldEqual, 8)
+	})
+
+	t.Run("writes the png into the data directory with the given scale & crop", func(t *testing.T) {
+		dataDir := t.TempDir()
+		resp, err := newSvc(dataDir).DoCommand(context.Background(), map[string]interface{}{
+			"render_map": map[string]interface{}{
+				"name":  "office.png",
+				"scale": 2,
+				"crop":  map[string]interface{}{"min_x": 0, "min_y": -25, "max_x": 50, "max_y": 25},
+			},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{
+			"width":  2,
+			"height": 2,
+			"path":   filepath.Join(dataDir, "office.png"),
+		})
+		_, err = os.Stat(filepath.Join(dataDir, "office.png"))
+		test.That(t, err, test.ShouldBeNil)
+	})
+
+	t.Run("rejects invalid params", func(t *testing.T) {
+		svc := newSvc("")
+		_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"render_map": map[string]interface{}{"crop": map[string]interface{}{"min_x": 0}},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New("crop requires min_y"))
+
+		_, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			"render_map": map[string]interface{}{"scale": -1},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New("scale must not be negative"))
+
+		_, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			"render_map": map[string]interface{}{"name": "office"},
+		})
+		test.That(t, err, test.ShouldBeError, errors.New("render_map requires data_dir to be configured to write name"))
+	})
+
+	t.Run("writes the map image into the data directory", func(t *testing.T) {
+		dataDir := t.TempDir()
+		svc := newSvc(dataDir)
+		test.That(t, svc.writeMapImage(context.Background()), test.ShouldBeNil)
+		_, err := os.Stat(filepath.Join(dataDir, mapImageFile))
+		test.That(t, err, test.ShouldBeNil)
+	})
+
+	t.Run("writes no map image until something is mapped", func(t *testing.T) {
+		gridErr = cartofacade.ErrOccupancyGridEmpty
+		defer func() { gridErr = nil }()
+		dataDir := t.TempDir()
+		test.That(t, newSvc(dataDir).writeMapImage(context.Background()), test.ShouldBeNil)
+		_, err := os.Stat(filepath.Join(dataDir, mapImageFile))
+		test.That(t, os.IsNotExist(err), test.ShouldBeTrue)
+	})
+}
+
+func TestGetMapRegionCommand(t *testing.T) {
+	grid := cartofacade.OccupancyGrid{
+		Width:            2,
+		Height:           1,
+		ResolutionMeters: 0.05,
+		OriginX:          0.95,
+		OriginY:          1.975,
+		Cells:            []byte{100, cartofacade.OccupancyGridUnknownCell},
+	}
+	var gridOpts cartofacade.MapOptions
+	mockCartoFacade := &cartofacade.Mock{}
+	mockCartoFacade.GetOccupancyGridFunc = func(
+		ctx context.Context,
+		timeout time.Duration,
+		opts cartofacade.MapOptions,
+	) (cartofacade.OccupancyGrid, error) {
+		gridOpts = opts
+		return grid, nil
+	}
+	mockCartoFacade.GetSubmapsFunc = func(ctx context.Context, timeout time.Duration) ([]cartofacade.Submap, error) {
+		return []cartofacade.Submap{
+			{SubmapIndex: 0, Real: 1, MinX: -5000, MinY: -5000, MaxX: 0, MaxY: 0},
+			{SubmapIndex: 1, Real: 1, MinX: 500, MinY: 1500, MaxX: 5000, MaxY: 5000},
+		}, nil
+	}
+	mockCartoFacade.GetPositionFunc = func(ctx context.Context, timeout time.Duration) (cartofacade.GetPosition, error) {
+		return cartofacade.GetPosition{X: 1000, Y: 2000, Real: 1}, nil
+	}
+	svc := &CartographerService{
+		Named:              resource.NewName(slam.API, "test").AsNamed(),
+		cartoFacadeTimeout: time.Second,
+		cartofacade:        mockCartoFacade,
+	}
+
+	t.Run("returns the submaps & cells within a radius around the current pose", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"get_map_region": map[string]interface{}{"radius_mm": 500, "resolution_meters": 0.1},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		expectedRegion := cartofacade.MapRegion{MinX: 500, MinY: 1500, MaxX: 1500, MaxY: 2500}
+		test.That(t, gridOpts, test.ShouldResemble, cartofacade.MapOptions{ResolutionMeters: 0.1, Region: &expectedRegion})
+		test.That(t, resp["region"], test.ShouldResemble, map[string]interface{}{
+			"min_x": 500.0, "min_y": 1500.0, "max_x": 1500.0, "max_y": 2500.0,
+		})
+
+		submaps := resp["submaps"].([]interface{})
+		test.That(t, len(submaps), test.ShouldEqual, 1)
+		test.That(t, submaps[0].(map[string]interface{})["submap_index"], test.ShouldEqual, 1)
+
+		occupancyGrid := resp["occupancy_grid"].(map[string]interface{})
+		test.That(t, occupancyGrid["width"], test.ShouldEqual, 2)
+		test.That(t, occupancyGrid["height"], test.ShouldEqual, 1)
+		test.That(t, occupancyGrid["origin_x"], test.ShouldEqual, 0.95)
+		cells, err := base64.StdEncoding.DecodeString(occupancyGrid["cells"].(string))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cells, test.ShouldResemble, grid.Cells)
+	})
+
+	t.Run("returns the submaps & cells within a bounding box", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"get_map_region": map[string]interface{}{
+				"region": map[string]interface{}{"min_x": -1000, "min_y": -1000, "max_x": 1000.0, "max_y": 1000.0},
+			},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, gridOpts.Region, test.ShouldResemble, &cartofacade.MapRegion{MinX: -1000, MinY: -1000, MaxX: 1000, MaxY: 1000})
+		submaps := resp["submaps"].([]interface{})
+		test.That(t, len(submaps), test.ShouldEqual, 1)
+		test.That(t, submaps[0].(map[string]interface{})["submap_index"], test.ShouldEqual, 0)
+	})
+
+	t.Run("rejects invalid params", func(t *testing.T) {
+		for params, expectedErr := range map[string]map[string]interface{}{
+			"get_map_region requires region or radius_mm": {},
+			"only one of region & radius_mm may be set": {
+				"radius_mm": 1,
+				"region":    map[string]interface{}{"min_x": 0, "min_y": 0, "max_x": 1, "max_y": 1},
+			},
+			"region requires max_y": {
+				"region": map[string]interface{}{"min_x": 0, "min_y": 0, "max_x": 1},
+			},
+			"region must satisfy min_x < max_x & min_y < max_y": {
+				"region": map[string]interface{}{"min_x": 1, "min_y": 0, "max_x": 1, "max_y": 1},
+			},
+			"radius_mm must be greater than zero, got -1": {"radius_mm": -1},
+		} {
+			_, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_map_region": expectedErr})
+			test.That(t, err, test.ShouldBeError, errors.New(params))
+		}
+	})
+
+	t.Run("limits the export commands to the region", func(t *testing.T) {
+		setMockMapRevisionFunc(mockCartoFacade)
+		var streamOpts cartofacade.MapOptions
+		mockCartoFacade.GetPointCloudMapStreamFunc = func(
+			ctx context.Context,
+			timeout time.Duration,
+			opts cartofacade.MapOptions,
+		) (cartofacade.Stream, error) {
+			streamOpts = opts
+			return &testStream{}, nil
+		}
+		_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			exportPointCloudMapCommand: map[string]interface{}{"radius_mm": 5000},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, streamOpts.Region, test.ShouldResemble, &cartofacade.MapRegion{MinX: -4000, MinY: -3000, MaxX: 6000, MaxY: 7000})
+
+		_, err = svc.DoCommand(context.Background(), map[string]interface{}{
+			exportOccupancyGridCommand: map[string]interface{}{
+				"region": map[string]interface{}{"min_x": 0, "min_y": 0, "max_x": 1, "max_y": 1},
+			},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, gridOpts.Region, test.ShouldResemble, &cartofacade.MapRegion{MaxX: 1, MaxY: 1})
+	})
+}
+
+func TestGetMapUpdatesCommand(t *testing.T) {
+	submaps := []cartofacade.Submap{
+		{TrajectoryID: 0, SubmapIndex: 0, Version: 90, Finished: true, X: 0, Y: 0, Real: 1},
+		{TrajectoryID: 0, SubmapIndex: 1, Version: 40, X: 1000, Y: 500, Real: 1},
+		{TrajectoryID: 1, SubmapIndex: 0, Version: 5, X: 2000, Y: 500, Real: 1},
+	}
+	grid := cartofacade.OccupancyGrid{
+		Width:            2,
+		Height:           1,
+		ResolutionMeters: 0.05,
+		OriginX:          -0.05,
+		OriginY:          -0.025,
+		Cells:            []byte{100, cartofacade.OccupancyGridUnknownCell},
+	}
+	var requestedIDs []cartofacade.SubmapID
+	mockCartoFacade := &cartofacade.Mock{}
+	mockCartoFacade.GetSubmapsFunc = func(ctx context.Context, timeout time.Duration) ([]cartofacade.Submap, error) {
+		return submaps, nil
+	}
+	mockCartoFacade.GetSubmapGridsFunc = func(
+		ctx context.Context,
+		timeout time.Duration,
+		ids []cartofacade.SubmapID,
+	) ([]cartofacade.SubmapGrid, error) {
+		requestedIDs = ids
+		grids := []cartofacade.SubmapGrid{}
+		for _, id := range ids {
+			// the submap of the second trajectory is trimmed after it was listed
+			if id.TrajectoryID == 1 {
+				continue
+			}
+			grids = append(grids, cartofacade.SubmapGrid{
+				TrajectoryID: id.TrajectoryID,
+				SubmapIndex:  id.SubmapIndex,
+				Version:      submaps[id.SubmapIndex].Version + 1,
+				Grid:         grid,
+			})
+		}
+		return grids, nil
+	}
+	svc := &CartographerService{
+		Named:              resource.NewName(slam.API, "test").AsNamed(),
+		cartoFacadeTimeout: time.Second,
+		cartofacade:        mockCartoFacade,
+	}
+	submapIndices := func(untyped interface{}) []int {
+		indices := []int{}
+		for _, submap := range untyped.([]interface{}) {
+			indices = append(indices, submap.(map[string]interface{})["submap_index"].(int))
+		}
+		return indices
+	}
+
+	t.Run("returns every submap along with its grid without submaps", func(t *testing.T) {
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{"get_map_updates": true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, requestedIDs, test.ShouldResemble, []cartofacade.SubmapID{
+			{TrajectoryID: 0, SubmapIndex: 0},
+			{TrajectoryID: 0, SubmapIndex: 1},
+			{TrajectoryID: 1, SubmapIndex: 0},
+		})
+		test.That(t, submapIndices(resp["updated"]), test.ShouldResemble, []int{0, 1})
+		test.That(t, resp["moved"], test.ShouldResemble, []interface{}{})
+		test.That(t, resp["removed"], test.ShouldResemble, []interface{}{})
+
+		updated := resp["updated"].([]interface{})[1].(map[string]interface{})
+		// the version is the one the grid was painted at
+		test.That(t, updated["version"], test.ShouldEqual, 41)
+		test.That(t, updated["pose"].(map[string]interface{})["x"], test.ShouldEqual, 1000.0)
+		updatedGrid := updated["grid"].(map[string]interface{})
+		test.That(t, updatedGrid["width"], test.ShouldEqual, 2)
+		test.That(t, updatedGrid["origin_x"], test.ShouldEqual, -0.05)
+		cells, err := base64.StdEncoding.DecodeString(updatedGrid["cells"].(string))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cells, test.ShouldResemble, grid.Cells)
+	})
+
+	t.Run("only returns the grids of changed submaps & the poses of moved ones", func(t *testing.T) {
+		requestedIDs = nil
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"get_map_updates": map[string]interface{}{
+				"submaps": []interface{}{
+					// unchanged
+					map[string]interface{}{
+						"trajectory_id": 0, "submap_index": 0, "version": 90,
+						"pose": map[string]interface{}{"x": 0.5, "y": 0, "theta": 0.001},
+					},
+					// changed
+					map[string]interface{}{"trajectory_id": 0, "submap_index": 1, "version": 30.0},
+					// removed
+					map[string]interface{}{"trajectory_id": 0, "submap_index": 2, "version": 10},
+					map[string]interface{}{"trajectory_id": 1, "submap_index": 0, "version": 4},
+				},
+			},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, requestedIDs, test.ShouldResemble, []cartofacade.SubmapID{
+			{TrajectoryID: 0, SubmapIndex: 1},
+			{TrajectoryID: 1, SubmapIndex: 0},
+		})
+		test.That(t, submapIndices(resp["updated"]), test.ShouldResemble, []int{1})
+		test.That(t, resp["moved"], test.ShouldResemble, []interface{}{})
+		test.That(t, resp["removed"], test.ShouldResemble, []interface{}{
+			map[string]interface{}{"trajectory_id": 0, "submap_index": 2},
+			map[string]interface{}{"trajectory_id": 1, "submap_index": 0},
+		})
+	})
+
+	t.Run("returns the poses of submaps which were moved or held without a pose", func(t *testing.T) {
+		requestedIDs = nil
+		resp, err := svc.DoCommand(context.Background(), map[string]interface{}{
+			"get_map_updates": map[string]interface{}{
+				"submaps": []interface{}{
+					map[string]interface{}{
+						"trajectory_id": 0, "submap_index": 0, "version": 90,
+						"pose": map[string]interface{}{"x": 0, "y": 0, "theta": 359.5},
+					},
+					map[string]interface{}{"trajectory_id": 0, "submap_index": 1, "version": 40},
+					map[string]interface{}{
+						"trajectory_id": 1, "submap_index": 0, "version": 5,
+						"pose": map[string]interface{}{"x": 2000, "y": 500},
+					},
+				},
+			},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, requestedIDs, test.ShouldBeNil)
+		test.That(t, resp["updated"], test.ShouldResemble, []interface{}{})
+		test.That(t, submapIndices(resp["moved"]), test.ShouldResemble, []int{0, 1})
+		test.That(t, resp["removed"], test.ShouldResemble, []interface{}{})
+	})
+
+	t.Run("rejects invalid submaps", func(t *testing.T) {
+		for expectedErr, submaps := range map[string]interface{}{
+			"submaps must be a list, got string": "all",
+			"submaps[0] must be a map, got int":  []interface{}{1},
+			"submaps[0] requires version": []interface{}{
+				map[string]interface{}{"trajectory_id": 0, "submap_index": 0},
+			},
+			"submaps[0] pose requires y": []interface{}{
+				map[string]interface{}{"trajectory_id": 0, "submap_index": 0, "version": 1, "pose": map[string]interface{}{"x": 0}},
+			},
+		} {
+			_, err := svc.DoCommand(context.Background(), map[string]interface{}{
+				"get_map_updates": map[string]interface{}{"submaps": submaps},
+			})
+			test.That(t, err, test.ShouldBeError, errors.New(expectedErr))
+		}
+	})
+}
@@ -8,6 +8,7 @@ package viamcartographer_test
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -315,7 +316,7 @@ func TestNew(t *testing.T) {
 		test.That(t, err, test.ShouldBeNil)
 
 		test.That(t, timestamp1.After(_zeroTime), test.ShouldBeTrue)
-		test.That(t, timestamp2.After(timestamp1), test.ShouldBeTrue)
+		test.That(t, timestamp2.Before(timestamp1), test.ShouldBeFalse)
 
 		test.That(t, svc.Close(context.Background()), test.ShouldBeNil)
 	})
@@ -539,7 +540,7 @@ func TestNewFeatureFlag(t *testing.T) {
 		test.That(t, err, test.ShouldBeNil)
 
 		test.That(t, timestamp1.After(_zeroTime), test.ShouldBeTrue)
-		test.That(t, timestamp2.After(timestamp1), test.ShouldBeTrue)
+		test.That(t, timestamp2.Before(timestamp1), test.ShouldBeFalse)
 
 		test.That(t, svc.Close(context.Background()), test.ShouldBeNil)
 	})
@@ -653,5 +654,74 @@ func TestDoCommand(t *testing.T) {
 		test.That(t, err, test.ShouldEqual, viamgrpc.UnimplementedError)
 		test.That(t, resp, test.ShouldBeNil)
 	})
+	t.Run("help lists the commands available in the current slam mode", func(t *testing.T) {
+		cmd := map[string]interface{}{"help": true}
+		resp, err := svc.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["slam_mode"], test.ShouldEqual, "mapping")
+		available := map[string]bool{}
+		for _, untyped := range resp["commands"].([]interface{}) {
+			command := untyped.(map[string]interface{})
+			available[command["name"].(string)] = command["available"].(bool)
+		}
+		test.That(t, available["reset_map"], test.ShouldBeTrue)
+		test.That(t, available["set_pose"], test.ShouldBeFalse)
+	})
+	t.Run("reset_map starts mapping again & moves the snapshots of the previous map out of the way", func(t *testing.T) {
+		cmd := map[string]interface{}{"reset_map": true}
+		resp, err := svc.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["reset_map"], test.ShouldBeTrue)
+		test.That(t, resp["slam_mode"], test.ShouldEqual, "mapping")
+		test.That(t, resp["snapshots"], test.ShouldBeGreaterThan, 0)
+		test.That(t, resp["archive_dir"], test.ShouldStartWith, filepath.Join(dataDirectory, "internal_state", "reset_"))
+
+		_, componentRef, err := svc.GetPosition(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, componentRef, test.ShouldEqual, "good_lidar")
+	})
+	t.Run("restart rejects invalid config_params without restarting", func(t *testing.T) {
+		cmd := map[string]interface{}{"restart": map[string]interface{}{
+			"config_params": map[string]interface{}{"num_range_data": "not a number"},
+		}}
+		resp, err := svc.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "invalid config_params")
+		test.That(t, resp, test.ShouldBeNil)
+	})
+	t.Run("restart reloads a snapshot of the map with the new config_params", func(t *testing.T) {
+		cmd := map[string]interface{}{"restart": map[string]interface{}{
+			"config_params": map[string]interface{}{"num_range_data": 20},
+		}}
+		resp, err := svc.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["restart"], test.ShouldBeTrue)
+		test.That(t, resp["slam_mode"], test.ShouldEqual, "updating")
+		test.That(t, resp["snapshot"], test.ShouldStartWith, filepath.Join(dataDirectory, "internal_state"))
+
+		_, componentRef, err := svc.GetPosition(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, componentRef, test.ShouldEqual, "good_lidar")
+	})
+	t.Run("reset_map is rejected once a map has been loaded", func(t *testing.T) {
+		cmd := map[string]interface{}{"reset_map": map[string]interface{}{"delete_snapshots": true}}
+		resp, err := svc.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldBeError, errors.New("reset_map is only supported in mapping mode, slam mode is updating"))
+		test.That(t, resp, test.ShouldBeNil)
+	})
+	t.Run("switch_mode freezes the map & starts localizing against it", func(t *testing.T) {
+		cmd := map[string]interface{}{"switch_mode": map[string]interface{}{"mode": "localizing"}}
+		resp, err := svc.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{"switch_mode": true, "slam_mode": "localizing"})
+
+		resp, err = svc.DoCommand(context.Background(), map[string]interface{}{"set_pose": map[string]interface{}{"x": 0, "y": 0}})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{"set_pose": true})
+
+		resp, err = svc.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldBeError, errors.New("switch_mode is only supported in mapping or updating mode, slam mode is localizing"))
+		test.That(t, resp, test.ShouldBeNil)
+	})
 	test.That(t, svc.Close(context.Background()), test.ShouldBeNil)
 }